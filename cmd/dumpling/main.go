@@ -27,9 +27,16 @@ import (
 	"github.com/pingcap/dumpling/v4/export"
 )
 
+// exitCodePartialSuccess means some tables are skipped due to errors with --continue-on-error
+const exitCodePartialSuccess = 3
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == verifyCommand {
+		os.Exit(runVerify(os.Args[2:]))
+	}
+
 	pflag.Usage = func() {
-		fmt.Fprint(os.Stderr, "Dumpling is a CLI tool that helps you dump MySQL/TiDB data\n\nUsage:\n  dumpling [flags]\n\nFlags:\n")
+		fmt.Fprint(os.Stderr, "Dumpling is a CLI tool that helps you dump MySQL/TiDB data\n\nUsage:\n  dumpling [flags]\n  dumpling verify [flags] <dir>\n\nFlags:\n")
 		pflag.PrintDefaults()
 	}
 	printVersion := pflag.BoolP("version", "V", false, "Print Dumpling version")
@@ -40,7 +47,7 @@ func main() {
 	pflag.Parse()
 	if printHelp, err := pflag.CommandLine.GetBool(export.FlagHelp); printHelp || err != nil {
 		if err != nil {
-			fmt.Printf("\nGet help flag error: %s\n", err)
+			fmt.Fprintf(os.Stderr, "\nGet help flag error: %s\n", err)
 		}
 		pflag.Usage()
 		return
@@ -52,11 +59,11 @@ func main() {
 
 	err := conf.ParseFromFlags(pflag.CommandLine)
 	if err != nil {
-		fmt.Printf("\nparse arguments failed: %+v\n", err)
+		fmt.Fprintf(os.Stderr, "\nparse arguments failed: %+v\n", err)
 		os.Exit(1)
 	}
 	if pflag.NArg() > 0 {
-		fmt.Printf("\nmeet some unparsed arguments, please check again: %+v\n", pflag.Args())
+		fmt.Fprintf(os.Stderr, "\nmeet some unparsed arguments, please check again: %+v\n", pflag.Args())
 		os.Exit(1)
 	}
 
@@ -68,14 +75,19 @@ func main() {
 	prometheus.DefaultGatherer = registry
 	dumper, err := export.NewDumper(context.Background(), conf)
 	if err != nil {
-		fmt.Printf("\ncreate dumper failed: %s\n", err.Error())
+		fmt.Fprintf(os.Stderr, "\ncreate dumper failed: %s\n", err.Error())
 		os.Exit(1)
 	}
 	err = dumper.Dump()
 	dumper.Close()
+	if partialErr, ok := err.(*export.PartialDumpError); ok {
+		dumper.L().Warn("dump finished with failed tables", zap.Error(partialErr))
+		fmt.Fprintf(os.Stderr, "\ndump partially succeeded: %s\n", partialErr.Error())
+		os.Exit(exitCodePartialSuccess)
+	}
 	if err != nil {
 		dumper.L().Error("dump failed error stack info", zap.Error(err))
-		fmt.Printf("\ndump failed: %s\n", err.Error())
+		fmt.Fprintf(os.Stderr, "\ndump failed: %s\n", err.Error())
 		os.Exit(1)
 	}
 	dumper.L().Info("dump data successfully, dumpling will exit now")
@@ -0,0 +1,55 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/pflag"
+
+	"github.com/pingcap/dumpling/v4/export"
+)
+
+const verifyCommand = "verify"
+
+// runVerify runs `dumpling verify <dir>` and returns the exit code
+func runVerify(args []string) int {
+	flags := pflag.NewFlagSet(verifyCommand, pflag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, "Verify a dumped directory offline\n\nUsage:\n  dumpling verify [flags] <dir>\n\nFlags:\n")
+		flags.PrintDefaults()
+	}
+	conf := export.DefaultConfig()
+	conf.DefineVerifyFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+	if printHelp, err := flags.GetBool(export.FlagHelp); printHelp || err != nil {
+		flags.Usage()
+		return 0
+	}
+
+	if err := conf.ParseVerifyFlags(flags); err != nil {
+		fmt.Fprintf(os.Stderr, "\nparse arguments failed: %+v\n", err)
+		return 1
+	}
+	report, err := export.VerifyDump(context.Background(), conf)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "\nverify failed: %s\n", err.Error())
+		return 1
+	}
+	out, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "\nverify failed: %s\n", err.Error())
+		return 1
+	}
+	fmt.Println(string(out))
+	if report.HasErrors() {
+		fmt.Fprintf(os.Stderr, "\ndumped directory %s is broken, please check the problems above\n", conf.OutputDirPath)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,104 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package export
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	tcontext "github.com/pingcap/dumpling/v4/context"
+
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	// tempFileSuffix is appended to the name of the file being written
+	tempFileSuffix = ".dumpling-tmp"
+	// successMarkerPath is the empty file written after metadata, which tells the loaders that the dump is complete
+	successMarkerPath = "_SUCCESS"
+)
+
+// atomicLocalStorage writes every file under a temporary name and renames it to the final name after it's closed
+// successfully, so that a consumer polling the output directory never sees a half-written file.
+// Object stores like S3 and GCS don't need it, because an uploaded object only becomes visible after the upload completes.
+type atomicLocalStorage struct {
+	storage.ExternalStorage
+	base string
+}
+
+func newAtomicLocalStorage(s storage.ExternalStorage, base string) *atomicLocalStorage {
+	return &atomicLocalStorage{ExternalStorage: s, base: base}
+}
+
+// WriteFile implements storage.ExternalStorage.WriteFile
+func (s *atomicLocalStorage) WriteFile(ctx context.Context, name string, data []byte) error {
+	tempName := name + tempFileSuffix
+	if err := s.ExternalStorage.WriteFile(ctx, tempName, data); err != nil {
+		s.remove(tempName)
+		return errors.Trace(err)
+	}
+	return s.publish(tempName, name)
+}
+
+// Create implements storage.ExternalStorage.Create
+func (s *atomicLocalStorage) Create(ctx context.Context, name string) (storage.ExternalFileWriter, error) {
+	tempName := name + tempFileSuffix
+	w, err := s.ExternalStorage.Create(ctx, tempName)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &atomicFileWriter{ExternalFileWriter: w, s: s, tempName: tempName, name: name}, nil
+}
+
+func (s *atomicLocalStorage) publish(tempName, name string) error {
+	err := os.Rename(filepath.Join(s.base, tempName), filepath.Join(s.base, name))
+	return errors.Annotatef(err, "fail to publish %s", name)
+}
+
+// removeFile implements fileRemover.removeFile
+func (s *atomicLocalStorage) removeFile(name string) error {
+	err := os.Remove(filepath.Join(s.base, name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return errors.Trace(err)
+}
+
+func (s *atomicLocalStorage) remove(name string) {
+	_ = os.Remove(filepath.Join(s.base, name))
+}
+
+// fileRemover is implemented by the storages which can remove the written files
+type fileRemover interface {
+	removeFile(name string) error
+}
+
+type atomicFileWriter struct {
+	storage.ExternalFileWriter
+	s        *atomicLocalStorage
+	tempName string
+	name     string
+}
+
+// Close implements storage.ExternalFileWriter.Close. The file is published only if all the content is flushed
+func (w *atomicFileWriter) Close(ctx context.Context) error {
+	if err := w.ExternalFileWriter.Close(ctx); err != nil {
+		w.s.remove(w.tempName)
+		return errors.Trace(err)
+	}
+	return w.s.publish(w.tempName, w.name)
+}
+
+func writeSuccessMarker(tctx *tcontext.Context, s storage.ExternalStorage) error {
+	if _, ok := s.(*stdoutStorage); ok {
+		return nil
+	}
+	err := s.WriteFile(tctx, successMarkerPath, nil)
+	if err != nil {
+		tctx.L().Error("fail to write success marker", zap.Error(err))
+	}
+	return errors.Trace(err)
+}
@@ -0,0 +1,70 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package export
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	tcontext "github.com/pingcap/dumpling/v4/context"
+
+	"github.com/pingcap/br/pkg/storage"
+	. "github.com/pingcap/check"
+)
+
+var _ = Suite(&testAtomicStorageSuite{})
+
+type testAtomicStorageSuite struct{}
+
+func (s *testAtomicStorageSuite) TestAtomicLocalStorage(c *C) {
+	dir := c.MkDir()
+	ctx := context.Background()
+	localStore, err := storage.NewLocalStorage(dir)
+	c.Assert(err, IsNil)
+	extStore := newAtomicLocalStorage(localStore, dir)
+
+	fileWriter, tearDown := buildInterceptFileWriter(tcontext.Background(), extStore, "test.t.000000000.sql", storage.Gzip)
+	_, err = fileWriter.Write(ctx, []byte("INSERT INTO `t` VALUES (1);\n"))
+	c.Assert(err, IsNil)
+	// the file is invisible before it's closed
+	_, err = os.Stat(filepath.Join(dir, "test.t.000000000.sql.gz"))
+	c.Assert(os.IsNotExist(err), IsTrue)
+	_, err = os.Stat(filepath.Join(dir, "test.t.000000000.sql.gz"+tempFileSuffix))
+	c.Assert(err, IsNil)
+
+	c.Assert(tearDown(ctx), IsNil)
+	_, err = os.Stat(filepath.Join(dir, "test.t.000000000.sql.gz"))
+	c.Assert(err, IsNil)
+	_, err = os.Stat(filepath.Join(dir, "test.t.000000000.sql.gz"+tempFileSuffix))
+	c.Assert(os.IsNotExist(err), IsTrue)
+
+	m := newGlobalMetadata(tcontext.Background(), extStore, "")
+	m.recordStartTime(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+	c.Assert(m.writeGlobalMetaData(), IsNil)
+	c.Assert(writeSuccessMarker(tcontext.Background(), extStore), IsNil)
+	files, err := ioutil.ReadDir(dir)
+	c.Assert(err, IsNil)
+	names := make([]string, 0, len(files))
+	for _, f := range files {
+		names = append(names, f.Name())
+	}
+	c.Assert(names, DeepEquals, []string{successMarkerPath, "metadata", "test.t.000000000.sql.gz"})
+	content, err := ioutil.ReadFile(filepath.Join(dir, metadataPath))
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, m.String())
+}
+
+func (s *testAtomicStorageSuite) TestPublishSchemaFileFailed(c *C) {
+	dir := c.MkDir()
+	localStore, err := storage.NewLocalStorage(dir)
+	c.Assert(err, IsNil)
+	extStore := newAtomicLocalStorage(localStore, dir)
+
+	// the temporary file can't be renamed to a non-empty directory
+	c.Assert(os.MkdirAll(filepath.Join(dir, "test.t-schema.sql", "x"), 0755), IsNil)
+	err = writeMetaToFile(tcontext.Background(), "test", "CREATE TABLE t (a INT)", nil, extStore, "test.t-schema.sql", storage.NoCompression)
+	c.Assert(err, NotNil)
+}
@@ -7,6 +7,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"os"
 	"regexp"
 	"strconv"
 	"strings"
@@ -21,6 +22,7 @@ import (
 	"github.com/pingcap/br/pkg/storage"
 	"github.com/pingcap/errors"
 	filter "github.com/pingcap/tidb-tools/pkg/table-filter"
+	router "github.com/pingcap/tidb-tools/pkg/table-router"
 	"github.com/pingcap/tidb-tools/pkg/utils"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/spf13/pflag"
@@ -70,6 +72,39 @@ const (
 	flagReadTimeout              = "read-timeout"
 	flagTransactionalConsistency = "transactional-consistency"
 	flagCompress                 = "compress"
+	flagPipeCommand              = "pipe-command"
+	flagLongQueryGuard           = "long-query-guard"
+	flagKillLongQueries          = "kill-long-queries"
+	flagLockWaitTimeout          = "lock-wait-timeout"
+	flagWriteRetryAttempts       = "write-retry-attempts"
+	flagContinueOnError          = "continue-on-error"
+	flagWriteRetryBackoff        = "write-retry-backoff"
+	flagWriteRetryMaxBackoff     = "write-retry-max-backoff"
+	flagWriteRetryErrors         = "write-retry-errors"
+	flagDumpOrder                = "dump-order"
+	flagPriorityTables           = "priority-tables"
+	flagSchemaThreads            = "schema-threads"
+	flagProducerThreads          = "producer-threads"
+	flagSplitPartitions          = "split-partitions"
+	flagWatermarkColumn          = "watermark-column"
+	flagIncrementalFrom          = "incremental-from"
+	flagSamplePercent            = "sample-percent"
+	flagSampleRows               = "sample-rows"
+	flagSampleSeed               = "sample-seed"
+	flagSubsetRoot               = "subset-root"
+	flagSubsetChildren           = "subset-children"
+	flagDisableForeignKeyChecks  = "disable-foreign-key-checks"
+	flagNoLoadOrder              = "no-load-order"
+	flagDisableUniqueChecks      = "disable-unique-checks"
+	flagStripDefiner             = "strip-definer"
+	flagDefiner                  = "definer"
+	flagRoute                    = "route"
+	flagRouteSourceColumn        = "route-source-column"
+	flagSchemaRewrite            = "schema-rewrite"
+	flagSingleFile               = "single-file"
+	flagInsertMode               = "insert-mode"
+	flagSQLDialect               = "sql-dialect"
+	flagLoadDataScripts          = "load-data-scripts"
 
 	// FlagHelp represents the help flag
 	FlagHelp = "help"
@@ -90,6 +125,16 @@ type Config struct {
 	EscapeBackslash          bool
 	DumpEmptyDatabase        bool
 	PosAfterConnect          bool
+	KillLongQueries          bool
+	ContinueOnError          bool
+	SplitPartitions          bool
+	SubsetChildren           bool
+	DisableForeignKeyChecks  bool
+	NoLoadOrder              bool
+	DisableUniqueChecks      bool
+	StripDefiner             bool
+	SingleFile               bool
+	LoadDataScripts          bool
 	CompressType             storage.CompressType
 
 	Host     string
@@ -114,16 +159,41 @@ type Config struct {
 	SQL           string
 	CsvSeparator  string
 	CsvDelimiter  string
+	PipeCommand   string
+	DumpOrder     string
 	Databases     []string
 
-	TableFilter        filter.Filter `json:"-"`
+	WriteRetryAttempts   int
+	WriteRetryBackoff    time.Duration
+	WriteRetryMaxBackoff time.Duration
+	WriteRetryErrors     []string
+
+	TableFilter        filter.Filter    `json:"-"`
+	PriorityTables     []filter.Filter  `json:"-"`
+	PartitionFilter    *PartitionFilter `json:"-"`
 	Where              string
+	IncrementalFrom    string
+	Definer            string
+	RouteSourceColumn  string
+	InsertMode         string
+	SQLDialect         string
 	FileType           string
 	ServerInfo         ServerInfo
 	Logger             *zap.Logger        `json:"-"`
 	OutputFileTemplate *template.Template `json:"-"`
+	WatermarkColumns   *WatermarkColumns  `json:"-"`
+	SubsetRoots        *SubsetRoots       `json:"-"`
+	TableRouter        *router.Table      `json:"-"`
+	SchemaRewrites     *SchemaRewrites    `json:"-"`
 	Rows               uint64
+	SchemaThreads      int
+	ProducerThreads    int
+	SamplePercent      float64
+	SampleRows         uint64
+	SampleSeed         int64
 	ReadTimeout        time.Duration
+	LongQueryGuard     time.Duration
+	LockWaitTimeout    time.Duration
 	TiDBMemQuotaQuery  uint64
 	FileSize           uint64
 	StatementSize      uint64
@@ -142,6 +212,8 @@ func DefaultConfig() *Config {
 		Port:               3306,
 		Password:           "",
 		Threads:            4,
+		SchemaThreads:      defaultSchemaThreads,
+		ProducerThreads:    defaultProducerThreads,
 		Logger:             nil,
 		StatusAddr:         ":8281",
 		FileSize:           UnspecifiedSize,
@@ -166,6 +238,14 @@ func DefaultConfig() *Config {
 		SessionParams:      make(map[string]interface{}),
 		OutputFileTemplate: DefaultOutputFileTemplate,
 		PosAfterConnect:    false,
+		DumpOrder:          dumpOrderAlphabetical,
+		InsertMode:         insertModeInsert,
+		SQLDialect:         sqlDialectMySQL,
+
+		WriteRetryAttempts:   defaultWriteRetryAttempts,
+		WriteRetryBackoff:    defaultWriteRetryBackoff,
+		WriteRetryMaxBackoff: defaultWriteRetryMaxBackoff,
+		WriteRetryErrors:     []string{writeRetryErrorNetwork, writeRetryErrorThrottle, writeRetryErrorNoSpace},
 	}
 }
 
@@ -210,25 +290,26 @@ func (conf *Config) DefineFlags(flags *pflag.FlagSet) {
 	flags.IntP(flagThreads, "t", 4, "Number of goroutines to use, default 4")
 	flags.StringP(flagFilesize, "F", "", "The approximate size of output file")
 	flags.Uint64P(flagStatementSize, "s", DefaultStatementSize, "Attempted size of INSERT statement in bytes")
-	flags.StringP(flagOutput, "o", timestampDirName(), "Output directory")
+	flags.StringP(flagOutput, "o", timestampDirName(), "Output directory, or '-' to stream a single-file output to stdout")
 	flags.String(flagLoglevel, "info", "Log level: {debug|info|warn|error|dpanic|panic|fatal}")
 	flags.StringP(flagLogfile, "L", "", "Log file `path`, leave empty to write to console")
 	flags.String(flagLogfmt, "text", "Log `format`: {text|json}")
-	flags.String(flagConsistency, consistencyTypeAuto, "Consistency level during dumping: {auto|none|flush|lock|snapshot}")
+	flags.String(flagConsistency, consistencyTypeAuto, "Consistency level during dumping: {auto|none|flush|lock|snapshot|backup-lock|backup-stage|replica}")
 	flags.String(flagSnapshot, "", "Snapshot position (uint64 from pd timestamp for TiDB). Valid only when consistency=snapshot")
 	flags.BoolP(flagNoViews, "W", true, "Do not dump views")
 	flags.String(flagStatusAddr, ":8281", "dumpling API server and pprof addr")
 	flags.Uint64P(flagRows, "r", UnspecifiedSize, "Split table into chunks of this many rows, default unlimited")
 	flags.String(flagWhere, "", "Dump only selected records")
 	flags.Bool(flagEscapeBackslash, true, "use backslash to escape special characters")
-	flags.String(flagFiletype, "", "The type of export file (sql/csv)")
+	flags.String(flagFiletype, "", "The type of export file (sql/csv/copy/sqlite), copy writes the COPY statements of --sql-dialect postgres, sqlite writes all the tables into one SQLite database "+sqliteFileName)
 	flags.Bool(flagNoHeader, false, "whether not to dump CSV table header")
 	flags.BoolP(flagNoSchemas, "m", false, "Do not dump table schemas with the data")
 	flags.BoolP(flagNoData, "d", false, "Do not dump table data")
 	flags.String(flagCsvNullValue, "\\N", "The null value used when export to csv")
 	flags.StringP(flagSQL, "S", "", "Dump data with given sql. This argument doesn't support concurrent dump")
 	_ = flags.MarkHidden(flagSQL)
-	flags.StringSliceP(flagFilter, "f", []string{"*.*", DefaultTableFilter}, "filter to select which tables to dump")
+	flags.StringSliceP(flagFilter, "f", []string{"*.*", DefaultTableFilter}, "filter to select which tables to dump, a rule like 'db.tbl.p*' selects the partitions of tables")
+	flags.Bool(flagSplitPartitions, false, "Dump each partition of partitioned tables as a task by 'SELECT ... PARTITION (p)', whose data files are named with the partition")
 	flags.Bool(flagCaseSensitive, false, "whether the filter should be case-sensitive")
 	flags.Bool(flagDumpEmptyDatabase, true, "whether to dump empty database")
 	flags.Uint64(flagTidbMemQuotaQuery, UnspecifiedSize, "The maximum memory limit for a single SQL statement, in bytes.")
@@ -246,6 +327,39 @@ func (conf *Config) DefineFlags(flags *pflag.FlagSet) {
 	flags.Bool(flagTransactionalConsistency, true, "Only support transactional consistency")
 	_ = flags.MarkHidden(flagTransactionalConsistency)
 	flags.StringP(flagCompress, "c", "", "Compress output file type, support 'gzip', 'no-compression' now")
+	flags.String(flagPipeCommand, "", "Spawn this shell command for each output file and stream the file into its stdin, the file name is passed by env "+pipeCommandFileEnv)
+	flags.Duration(flagLongQueryGuard, 0, "Abort the dump if a query has been running longer than this before 'flush table with read lock', 0 means no check. Valid only when consistency=flush")
+	flags.Bool(flagKillLongQueries, false, "Kill the queries caught by --long-query-guard instead of aborting the dump")
+	flags.Bool(flagContinueOnError, false, "Skip the tables failed to dump and continue, the failures are written to "+errorReportPath+" and dumpling exits with code 3")
+	flags.Int(flagWriteRetryAttempts, defaultWriteRetryAttempts, "Max attempts to write a data file when meeting the errors in --write-retry-errors, the chunk is queried again on the same snapshot. 1 means no retry")
+	flags.Duration(flagWriteRetryBackoff, defaultWriteRetryBackoff, "The backoff before the first retry of writing a data file, which is doubled on each retry")
+	flags.Duration(flagWriteRetryMaxBackoff, defaultWriteRetryMaxBackoff, "The max backoff between the retries of writing a data file")
+	flags.StringSlice(flagWriteRetryErrors, []string{writeRetryErrorNetwork, writeRetryErrorThrottle, writeRetryErrorNoSpace},
+		"The kinds of storage errors to retry writing a data file: {network|throttle|no-space|all}")
+	flags.String(flagDumpOrder, dumpOrderAlphabetical, "The order of dumping tables: {alphabetical|size}, 'size' dumps the largest tables first by information_schema.TABLES.DATA_LENGTH")
+	flags.Int(flagSchemaThreads, defaultSchemaThreads, "Number of connections to query the schemas of tables concurrently, each of them shares the same snapshot with the dumping ones. They're opened while the consistency lock is held, 0 queries the schemas on the main connection")
+	flags.Int(flagProducerThreads, defaultProducerThreads, "Number of connections to split tables into chunks concurrently, each of them shares the same snapshot with the dumping ones. They're opened while the consistency lock is held, 0 splits the tables on the main connection")
+	flags.Float64(flagSamplePercent, 0, "Dump only about this percent of rows of each table, picked by TABLESAMPLE on TiDB or by primary key ranges on MySQL")
+	flags.Uint64(flagSampleRows, 0, "Dump only about this number of rows of each table, picked like --sample-percent")
+	flags.Int64(flagSampleSeed, 0, "The seed of picking the sample of --sample-percent or --sample-rows, which is recorded in metadata. 0 means a random seed")
+	flags.StringArray(flagSubsetRoot, nil, "The root tables of a subset dump and the conditions of their rows, like 'db.orders:created_at > \"2021-01-01\"'. The parent rows referenced by foreign keys are dumped too. Can be specified multiple times")
+	flags.Bool(flagSubsetChildren, false, "Also dump the child rows referencing the rows in a subset dump by foreign keys, transitively")
+	flags.Bool(flagDisableForeignKeyChecks, false, "Add 'SET FOREIGN_KEY_CHECKS=0' to the head of SQL data files, so the tables can be loaded in any order")
+	flags.Bool(flagNoLoadOrder, false, "Don't read the foreign keys to record the load order of tables in metadata, which can't be used with --subset-root")
+	flags.Bool(flagDisableUniqueChecks, false, "Add 'SET UNIQUE_CHECKS=0' to the head of SQL data files to speed up loading")
+	flags.Bool(flagStripDefiner, false, "Remove the DEFINER clause from the dumped views, so the account loading them becomes the definer")
+	flags.String(flagDefiner, "", "Replace the DEFINER of the dumped views with the account, like 'user'@'host' or CURRENT_USER")
+	flags.StringArray(flagRoute, nil, "Route the dumped databases and tables to the target ones, like 'shard_*.orders=orders_all.orders'. Can be specified multiple times")
+	flags.StringSlice(flagSchemaRewrite, nil, "The transforms of the dumped table schemas, can be drop-auto-increment, engine=<name>, charset=<name>, collation=<name>, drop-shard-row-id-bits, drop-tidb-specific and drop-partitions")
+	flags.String(flagRouteSourceColumn, "", "Add a column with the name to the tables routed to other ones, whose value is the source database and table of the row")
+	flags.String(flagInsertMode, insertModeInsert, "The statements of the SQL data files: {insert|replace|ignore|on-duplicate-update}, 'on-duplicate-update' updates all the columns of the rows with the duplicate keys")
+	flags.String(flagSQLDialect, sqlDialectMySQL, "The SQL dialect of the schemas and the SQL data files: {mysql|postgres}")
+	flags.Bool(flagSingleFile, false, "Write the schemas and data into one SQL file "+singleFileName+" in the layout of mysqldump")
+	flags.Bool(flagLoadDataScripts, false, "Write a script of the LOAD DATA LOCAL INFILE statements loading the CSV files of each table")
+	flags.StringSlice(flagWatermarkColumn, nil, "The watermark columns of tables, like 'db.orders=updated_at,logs.*=id'. The max value of the column in the snapshot is recorded in metadata")
+	flags.String(flagIncrementalFrom, "", "The path or storage URL of a previous dump, only the rows whose watermark column is beyond the watermark recorded in its metadata are dumped")
+	flags.StringSlice(flagPriorityTables, nil, "Table filter patterns of the tables to dump before the others, in the order of the patterns, e.g. 'db.big_table,logs.*'")
+	flags.Duration(flagLockWaitTimeout, 0, "Give up 'flush table with read lock' after waiting for this long and retry later, 0 means waiting forever. Valid only when consistency=flush")
 }
 
 // ParseFromFlags parses dumpling's export.Config from flags
@@ -392,13 +506,143 @@ func (conf *Config) ParseFromFlags(flags *pflag.FlagSet) error {
 	if err != nil {
 		return errors.Trace(err)
 	}
+	conf.PipeCommand, err = flags.GetString(flagPipeCommand)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	conf.LongQueryGuard, err = flags.GetDuration(flagLongQueryGuard)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	conf.KillLongQueries, err = flags.GetBool(flagKillLongQueries)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	conf.LockWaitTimeout, err = flags.GetDuration(flagLockWaitTimeout)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	conf.ContinueOnError, err = flags.GetBool(flagContinueOnError)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	conf.SplitPartitions, err = flags.GetBool(flagSplitPartitions)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	conf.SubsetChildren, err = flags.GetBool(flagSubsetChildren)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	conf.DisableForeignKeyChecks, err = flags.GetBool(flagDisableForeignKeyChecks)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	conf.NoLoadOrder, err = flags.GetBool(flagNoLoadOrder)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	conf.DisableUniqueChecks, err = flags.GetBool(flagDisableUniqueChecks)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	conf.StripDefiner, err = flags.GetBool(flagStripDefiner)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	conf.SingleFile, err = flags.GetBool(flagSingleFile)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	conf.LoadDataScripts, err = flags.GetBool(flagLoadDataScripts)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	conf.InsertMode, err = flags.GetString(flagInsertMode)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	conf.SQLDialect, err = flags.GetString(flagSQLDialect)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	conf.Definer, err = flags.GetString(flagDefiner)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	conf.RouteSourceColumn, err = flags.GetString(flagRouteSourceColumn)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	conf.WriteRetryAttempts, err = flags.GetInt(flagWriteRetryAttempts)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	conf.WriteRetryBackoff, err = flags.GetDuration(flagWriteRetryBackoff)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	conf.WriteRetryMaxBackoff, err = flags.GetDuration(flagWriteRetryMaxBackoff)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	conf.WriteRetryErrors, err = flags.GetStringSlice(flagWriteRetryErrors)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	conf.DumpOrder, err = flags.GetString(flagDumpOrder)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	conf.SchemaThreads, err = flags.GetInt(flagSchemaThreads)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	conf.ProducerThreads, err = flags.GetInt(flagProducerThreads)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	conf.IncrementalFrom, err = flags.GetString(flagIncrementalFrom)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	conf.SamplePercent, err = flags.GetFloat64(flagSamplePercent)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	conf.SampleRows, err = flags.GetUint64(flagSampleRows)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	conf.SampleSeed, err = flags.GetInt64(flagSampleSeed)
+	if err != nil {
+		return errors.Trace(err)
+	}
 
 	if conf.Threads <= 0 {
 		return errors.Errorf("--threads is set to %d. It should be greater than 0", conf.Threads)
 	}
+	if conf.SchemaThreads < 0 {
+		return errors.Errorf("--schema-threads is set to %d. It should be greater than or equal to 0", conf.SchemaThreads)
+	}
+	if conf.ProducerThreads < 0 {
+		return errors.Errorf("--producer-threads is set to %d. It should be greater than or equal to 0", conf.ProducerThreads)
+	}
 	if len(conf.CsvSeparator) == 0 {
 		return errors.New("--csv-separator is set to \"\". It must not be an empty string")
 	}
+	if conf.WriteRetryAttempts <= 0 {
+		return errors.Errorf("--write-retry-attempts is set to %d. It should be greater than 0", conf.WriteRetryAttempts)
+	}
+	if err = validateWriteRetryErrors(conf.WriteRetryErrors); err != nil {
+		return err
+	}
+	if conf.KillLongQueries && conf.LongQueryGuard <= 0 {
+		return errors.New("--kill-long-queries must be used together with a positive --long-query-guard")
+	}
+	if err = validateDumpOrder(conf.DumpOrder); err != nil {
+		return err
+	}
 
 	if conf.SessionParams == nil {
 		conf.SessionParams = make(map[string]interface{})
@@ -428,7 +672,35 @@ func (conf *Config) ParseFromFlags(flags *pflag.FlagSet) error {
 	if err != nil {
 		return errors.Trace(err)
 	}
+	priorityTables, err := flags.GetStringSlice(flagPriorityTables)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	watermarkColumns, err := flags.GetStringSlice(flagWatermarkColumn)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	subsetRoots, err := flags.GetStringArray(flagSubsetRoot)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	routes, err := flags.GetStringArray(flagRoute)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	schemaRewrites, err := flags.GetStringSlice(flagSchemaRewrite)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	conf.SchemaRewrites, err = ParseSchemaRewrites(schemaRewrites)
+	if err != nil {
+		return errors.Trace(err)
+	}
 
+	filters, conf.PartitionFilter, err = ParsePartitionFilter(filters, caseSensitive)
+	if err != nil {
+		return errors.Trace(err)
+	}
 	conf.TableFilter, err = ParseTableFilter(tablesList, filters)
 	if err != nil {
 		return errors.Errorf("failed to parse filter: %s", err)
@@ -438,6 +710,23 @@ func (conf *Config) ParseFromFlags(flags *pflag.FlagSet) error {
 		conf.TableFilter = filter.CaseInsensitive(conf.TableFilter)
 	}
 
+	conf.PriorityTables, err = ParsePriorityTables(priorityTables, caseSensitive)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	conf.WatermarkColumns, err = ParseWatermarkColumns(watermarkColumns, caseSensitive)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	conf.SubsetRoots, err = ParseSubsetRoots(subsetRoots, caseSensitive)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	conf.TableRouter, err = ParseTableRoutes(routes, caseSensitive)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
 	conf.FileSize, err = ParseFileSize(fileSizeStr)
 	if err != nil {
 		return errors.Trace(err)
@@ -473,12 +762,63 @@ func (conf *Config) ParseFromFlags(flags *pflag.FlagSet) error {
 	return nil
 }
 
+// DefineVerifyFlags defines flags of dumpling's verify command, which should be the same with the dumping ones
+func (conf *Config) DefineVerifyFlags(flags *pflag.FlagSet) {
+	storage.DefineFlags(flags)
+	flags.Bool(flagEscapeBackslash, true, "Whether backslash is used to escape special characters in the dumped files")
+	flags.Bool(flagNoHeader, false, "Whether CSV files are dumped without table header")
+	flags.String(flagCsvSeparator, ",", "The separator for csv files, default ','")
+	flags.String(flagCsvDelimiter, "\"", "The delimiter for values in csv files, default '\"'")
+	flags.String(flagOutputFilenameTemplate, "", "The output filename template (without file extension) used while dumping")
+	flags.Bool(FlagHelp, false, "Print help message and quit")
+}
+
+// ParseVerifyFlags parses dumpling's export.Config for the verify command from flags
+func (conf *Config) ParseVerifyFlags(flags *pflag.FlagSet) error {
+	var err error
+	conf.EscapeBackslash, err = flags.GetBool(flagEscapeBackslash)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	conf.NoHeader, err = flags.GetBool(flagNoHeader)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	conf.CsvSeparator, err = flags.GetString(flagCsvSeparator)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	conf.CsvDelimiter, err = flags.GetString(flagCsvDelimiter)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(conf.CsvSeparator) == 0 {
+		return errors.New("--csv-separator is set to \"\". It must not be an empty string")
+	}
+	if flags.NArg() != 1 {
+		return errors.Errorf("verify accepts exactly one dumped directory, but got %d: %v", flags.NArg(), flags.Args())
+	}
+	conf.OutputDirPath = flags.Arg(0)
+
+	outputFilenameFormat, err := flags.GetString(flagOutputFilenameTemplate)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	tmpl, err := ParseOutputFileTemplate(outputFilenameFormat)
+	if err != nil {
+		return errors.Errorf("failed to parse output filename template (--output-filename-template '%s')\n", outputFilenameFormat)
+	}
+	conf.OutputFileTemplate = tmpl
+
+	return errors.Trace(conf.BackendOptions.ParseFromFlags(flags))
+}
+
 // ParseFileSize parses file size from tables-list and filter arguments
 func ParseFileSize(fileSizeStr string) (uint64, error) {
 	if len(fileSizeStr) == 0 {
 		return UnspecifiedSize, nil
 	} else if fileSizeMB, err := strconv.ParseUint(fileSizeStr, 10, 64); err == nil {
-		fmt.Printf("Warning: -F without unit is not recommended, try using `-F '%dMiB'` in the future\n", fileSizeMB)
+		fmt.Fprintf(os.Stderr, "Warning: -F without unit is not recommended, try using `-F '%dMiB'` in the future\n", fileSizeMB)
 		return fileSizeMB * units.MiB, nil
 	} else if size, err := units.RAMInBytes(fileSizeStr); err == nil {
 		return uint64(size), nil
@@ -486,6 +826,22 @@ func ParseFileSize(fileSizeStr string) (uint64, error) {
 	return 0, errors.Errorf("failed to parse filesize (-F '%s')", fileSizeStr)
 }
 
+// ParsePriorityTables parses the patterns of --priority-tables, each pattern is a table filter rule
+func ParsePriorityTables(patterns []string, caseSensitive bool) ([]filter.Filter, error) {
+	filters := make([]filter.Filter, 0, len(patterns))
+	for _, pattern := range patterns {
+		f, err := filter.Parse([]string{pattern})
+		if err != nil {
+			return nil, errors.Errorf("failed to parse --priority-tables '%s': %s", pattern, err)
+		}
+		if !caseSensitive {
+			f = filter.CaseInsensitive(f)
+		}
+		filters = append(filters, f)
+	}
+	return filters, nil
+}
+
 // ParseTableFilter parses table filter from tables-list and filter arguments
 func ParseTableFilter(tablesList, filters []string) (filter.Filter, error) {
 	if len(tablesList) == 0 {
@@ -552,15 +908,27 @@ const (
 	DefaultTableFilter = "!/^(mysql|sys|INFORMATION_SCHEMA|PERFORMANCE_SCHEMA|METRICS_SCHEMA|INSPECTION_SCHEMA)$/.*"
 
 	defaultDumpThreads        = 128
+	defaultSchemaThreads      = 0
+	defaultProducerThreads    = 0
 	defaultDumpGCSafePointTTL = 5 * 60
 	defaultEtcdDialTimeOut    = 3 * time.Second
 
+	defaultWriteRetryAttempts   = 3
+	defaultWriteRetryBackoff    = time.Second
+	defaultWriteRetryMaxBackoff = 30 * time.Second
+
 	dumplingServiceSafePointPrefix = "dumpling"
 )
 
 var (
 	gcSafePointVersion = semver.New("4.0.0")
 	tableSampleVersion = semver.New("5.0.0")
+	// backupLockInstanceVersion is the first version supports `LOCK INSTANCE FOR BACKUP`
+	backupLockInstanceVersion = semver.New("8.0.0")
+	// backupStageVersion is the first MariaDB version supports BACKUP STAGE
+	backupStageVersion = semver.New("10.4.0")
+	// replicaStatementVersion is the first MySQL version supports `STOP REPLICA`/`START REPLICA`
+	replicaStatementVersion = semver.New("8.0.22")
 )
 
 // ServerInfo is the combination of ServerType and ServerInfo
@@ -683,6 +1051,22 @@ func validateSpecifiedSQL(conf *Config) error {
 	return nil
 }
 
+func adjustOutputStream(conf *Config) error {
+	if conf.OutputDirPath != outputToStdout {
+		return nil
+	}
+	if conf.PipeCommand != "" {
+		return errors.New("can't specify both --output '-' and --pipe-command at the same time")
+	}
+	// tasks must be written one by one in order, otherwise files of different tables are mixed up in stdout.
+	// The producers also wait for each other to dump the tables in order, so more producers only take more connections
+	conf.Threads = 1
+	if conf.ProducerThreads > 1 {
+		conf.ProducerThreads = 1
+	}
+	return nil
+}
+
 func adjustFileFormat(conf *Config) error {
 	conf.FileType = strings.ToLower(conf.FileType)
 	switch conf.FileType {
@@ -697,6 +1081,10 @@ func adjustFileFormat(conf *Config) error {
 			return errors.Errorf("unsupported config.FileType '%s' when we specify --sql, please unset --filetype or set it to 'csv'", conf.FileType)
 		}
 	case FileFormatCSVString:
+	case FileFormatPostgresCopyString, FileFormatSQLiteString:
+		if conf.SQL != "" {
+			return errors.Errorf("unsupported config.FileType '%s' when we specify --sql, please unset --filetype or set it to 'csv'", conf.FileType)
+		}
 	default:
 		return errors.Errorf("unknown config.FileType '%s'", conf.FileType)
 	}
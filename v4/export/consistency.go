@@ -5,11 +5,16 @@ package export
 import (
 	"context"
 	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
 	tcontext "github.com/pingcap/dumpling/v4/context"
 
 	"github.com/pingcap/br/pkg/utils"
 	"github.com/pingcap/errors"
+	"go.uber.org/zap"
 )
 
 const (
@@ -18,6 +23,15 @@ const (
 	consistencyTypeLock     = "lock"
 	consistencyTypeSnapshot = "snapshot"
 	consistencyTypeNone     = "none"
+	// consistencyTypeBackupLock uses backup lock of MySQL 8.0 or Percona Server, which blocks DDL but lets DML flow
+	consistencyTypeBackupLock = "backup-lock"
+	// consistencyTypeBackupStage uses BACKUP STAGE of MariaDB 10.4+, which only blocks commits for a short time
+	consistencyTypeBackupStage = "backup-stage"
+	// consistencyTypeReplica stops the replication SQL thread of a replica, which locks nothing
+	consistencyTypeReplica = "replica"
+
+	replicaStopCheckInterval = 100 * time.Millisecond
+	replicaStopTimeout       = time.Minute
 )
 
 // NewConsistencyController returns a new consistency controller
@@ -29,9 +43,27 @@ func NewConsistencyController(ctx context.Context, conf *Config, session *sql.DB
 	switch conf.Consistency {
 	case consistencyTypeFlush:
 		return &ConsistencyFlushTableWithReadLock{
+			serverType:      conf.ServerInfo.ServerType,
+			conn:            conn,
+			longQueryGuard:  conf.LongQueryGuard,
+			killLongQueries: conf.KillLongQueries,
+			lockWaitTimeout: conf.LockWaitTimeout,
+		}, nil
+	case consistencyTypeBackupLock:
+		return &ConsistencyBackupLock{
+			serverInfo: conf.ServerInfo,
+			conn:       conn,
+		}, nil
+	case consistencyTypeBackupStage:
+		return &ConsistencyBackupStage{
 			serverType: conf.ServerInfo.ServerType,
 			conn:       conn,
 		}, nil
+	case consistencyTypeReplica:
+		return &ConsistencyReplica{
+			serverInfo: conf.ServerInfo,
+			conn:       conn,
+		}, nil
 	case consistencyTypeLock:
 		return &ConsistencyLockDumpingTables{
 			conn:      conn,
@@ -78,6 +110,12 @@ func (c *ConsistencyNone) PingContext(_ context.Context) error {
 type ConsistencyFlushTableWithReadLock struct {
 	serverType ServerType
 	conn       *sql.Conn
+	// longQueryGuard aborts the dump, or kills the queries if killLongQueries is set,
+	// when some queries have been running longer than it before flushing tables
+	longQueryGuard  time.Duration
+	killLongQueries bool
+	// lockWaitTimeout makes FTWRL give up and retry instead of blocking all the writes on the server
+	lockWaitTimeout time.Duration
 }
 
 // Setup implements ConsistencyController.Setup
@@ -85,7 +123,56 @@ func (c *ConsistencyFlushTableWithReadLock) Setup(tctx *tcontext.Context) error
 	if c.serverType == ServerTypeTiDB {
 		return errors.New("'flush table with read lock' cannot be used to ensure the consistency in TiDB")
 	}
-	return FlushTableWithReadLock(tctx, c.conn)
+	if c.lockWaitTimeout > 0 {
+		if err := SetLockWaitTimeout(tctx, c.conn, ceilSeconds(c.lockWaitTimeout)); err != nil {
+			return err
+		}
+	}
+	return utils.WithRetry(tctx, func() error {
+		if err := c.guardLongQueries(tctx); err != nil {
+			return err
+		}
+		err := FlushTableWithReadLock(tctx, c.conn)
+		if err != nil {
+			tctx.L().Warn("fail to flush table with read lock", zap.Error(err))
+		}
+		return err
+	}, newFlushTablesBackoffer(c.lockWaitTimeout > 0))
+}
+
+// guardLongQueries checks the long queries which FTWRL will wait for, and kills them if killLongQueries is set
+func (c *ConsistencyFlushTableWithReadLock) guardLongQueries(tctx *tcontext.Context) error {
+	if c.longQueryGuard <= 0 {
+		return nil
+	}
+	queries, err := selectLongQueries(tctx, c.conn, ceilSeconds(c.longQueryGuard))
+	if err != nil {
+		return err
+	}
+	if len(queries) == 0 {
+		return nil
+	}
+	if !c.killLongQueries {
+		ids := make([]string, 0, len(queries))
+		for _, q := range queries {
+			ids = append(ids, strconv.FormatUint(q.id, 10))
+		}
+		return errors.Errorf("there are %d queries running longer than %s (process id: %s), "+
+			"please wait for them to finish or use --kill-long-queries to kill them", len(queries), c.longQueryGuard, strings.Join(ids, ","))
+	}
+	for _, q := range queries {
+		tctx.L().Warn("kill long query before flush table with read lock",
+			zap.Uint64("id", q.id), zap.String("user", q.user), zap.Uint64("time", q.time), zap.String("query", q.info))
+		if err = KillQuery(tctx, c.conn, q.id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ceilSeconds converts the duration to seconds which MySQL accepts, rounding up so that a short duration doesn't become 0
+func ceilSeconds(d time.Duration) uint64 {
+	return uint64((d + time.Second - 1) / time.Second)
 }
 
 // TearDown implements ConsistencyController.TearDown
@@ -108,6 +195,330 @@ func (c *ConsistencyFlushTableWithReadLock) PingContext(ctx context.Context) err
 	return c.conn.PingContext(ctx)
 }
 
+// binlogPositionProvider is implemented by the consistency controllers which get the binlog position
+// in the locked period by themselves. The position is recorded in metadata instead of SHOW MASTER STATUS
+type binlogPositionProvider interface {
+	BinlogPosition() *binlogPosition
+}
+
+// snapshotsStartedNotifier is implemented by the consistency controllers which need to know when all the dumping
+// transactions have started, because their binlog position only matches the snapshots opened before it.
+// If SnapshotsStarted returns a *binlogPositionMovedError, the dumping transactions should be given up,
+// and they are started again after the controller is released by Release and set up again
+type snapshotsStartedNotifier interface {
+	SnapshotsStarted(context.Context) error
+	Release(context.Context) error
+}
+
+// binlogPositionMovedError means some transactions commit while the dumping transactions are starting,
+// so the snapshots of them don't match the recorded binlog position
+type binlogPositionMovedError struct {
+	from, to *binlogPosition
+}
+
+// Error implements error.Error
+func (e *binlogPositionMovedError) Error() string {
+	return fmt.Sprintf("binlog position moves from %s:%s to %s:%s while the dumping transactions are starting",
+		e.from.file, e.from.pos, e.to.file, e.to.pos)
+}
+
+// ConsistencyBackupLock uses `LOCK INSTANCE FOR BACKUP` (MySQL 8.0) or `LOCK TABLES FOR BACKUP` (Percona Server) before the dump.
+// DDL is blocked while DML keeps flowing, so the binlog position must be tied to the snapshots of the dumping transactions:
+// Percona Server holds `LOCK BINLOG FOR BACKUP` until all of them have started, which only blocks the commits for a short time,
+// while MySQL 8.0 reads `performance_schema.log_status` before and after they start, and is set up again if any transaction commits in between.
+type ConsistencyBackupLock struct {
+	serverInfo   ServerInfo
+	conn         *sql.Conn
+	unlockSQL    string
+	binlogLocked bool
+	pos          *binlogPosition
+}
+
+// Setup implements ConsistencyController.Setup
+func (c *ConsistencyBackupLock) Setup(tctx *tcontext.Context) error {
+	if c.serverInfo.ServerType != ServerTypeMySQL {
+		return errors.Errorf("backup lock cannot be used to ensure the consistency in %s", c.serverInfo.ServerType.String())
+	}
+	lockSQL, unlockSQL := "LOCK INSTANCE FOR BACKUP", "UNLOCK INSTANCE"
+	perconaBackupLock := c.serverInfo.ServerVersion != nil && c.serverInfo.ServerVersion.LessThan(*backupLockInstanceVersion)
+	if perconaBackupLock {
+		// Percona Server 5.6/5.7
+		lockSQL, unlockSQL = "LOCK TABLES FOR BACKUP", "UNLOCK TABLES"
+	}
+	if _, err := c.conn.ExecContext(tctx, lockSQL); err != nil {
+		return errors.Annotatef(err, "sql: %s", lockSQL)
+	}
+	c.unlockSQL = unlockSQL
+
+	var err error
+	if perconaBackupLock {
+		c.binlogLocked = true
+		c.pos, err = showMasterStatusWithBinlogLock(tctx, c.conn)
+	} else {
+		c.pos, err = showLogStatus(tctx, c.conn)
+	}
+	return err
+}
+
+// SnapshotsStarted implements snapshotsStartedNotifier.SnapshotsStarted
+func (c *ConsistencyBackupLock) SnapshotsStarted(ctx context.Context) error {
+	if c.conn == nil {
+		return errors.New("consistency connection has already been closed")
+	}
+	if c.binlogLocked {
+		c.binlogLocked = false
+		return unlockBinlog(ctx, c.conn)
+	}
+	pos, err := showLogStatus(ctx, c.conn)
+	if err != nil {
+		return err
+	}
+	if *pos != *c.pos {
+		return &binlogPositionMovedError{from: c.pos, to: pos}
+	}
+	return nil
+}
+
+// Release implements snapshotsStartedNotifier.Release. It releases the locks but keeps the connection, so that Setup can be called again
+func (c *ConsistencyBackupLock) Release(ctx context.Context) error {
+	if c.conn == nil {
+		return nil
+	}
+	var err error
+	if c.binlogLocked {
+		c.binlogLocked = false
+		err = unlockBinlog(ctx, c.conn)
+	}
+	if c.unlockSQL == "" {
+		return err
+	}
+	unlockSQL := c.unlockSQL
+	c.unlockSQL = ""
+	if _, err1 := c.conn.ExecContext(ctx, unlockSQL); err1 != nil {
+		return errors.Annotatef(err1, "sql: %s", unlockSQL)
+	}
+	return err
+}
+
+// TearDown implements ConsistencyController.TearDown
+func (c *ConsistencyBackupLock) TearDown(ctx context.Context) error {
+	if c.conn == nil {
+		return nil
+	}
+	defer func() {
+		c.conn.Close()
+		c.conn = nil
+	}()
+	return c.Release(ctx)
+}
+
+// PingContext implements ConsistencyController.PingContext
+func (c *ConsistencyBackupLock) PingContext(ctx context.Context) error {
+	if c.conn == nil {
+		return errors.New("consistency connection has already been closed")
+	}
+	return c.conn.PingContext(ctx)
+}
+
+// BinlogPosition implements binlogPositionProvider.BinlogPosition
+func (c *ConsistencyBackupLock) BinlogPosition() *binlogPosition {
+	return c.pos
+}
+
+// ConsistencyBackupStage walks MariaDB's BACKUP STAGE to BLOCK_COMMIT before the dump, and ends the backup stage
+// after all the dumping transactions have started. The binlog position is read at BLOCK_COMMIT.
+type ConsistencyBackupStage struct {
+	serverType ServerType
+	conn       *sql.Conn
+	started    bool
+	pos        *binlogPosition
+}
+
+// Setup implements ConsistencyController.Setup
+func (c *ConsistencyBackupStage) Setup(tctx *tcontext.Context) error {
+	if c.serverType != ServerTypeMariaDB {
+		return errors.Errorf("backup stage cannot be used to ensure the consistency in %s", c.serverType.String())
+	}
+	// START: prepare for the backup
+	// FLUSH: flush all non-transactional tables which are not in use
+	// BLOCK_DDL: wait for all the running DDLs and block new ones
+	// BLOCK_COMMIT: block new commits, only reads and uncommitted writes are allowed
+	for _, stage := range []string{"START", "FLUSH", "BLOCK_DDL", "BLOCK_COMMIT"} {
+		query := "BACKUP STAGE " + stage
+		if _, err := c.conn.ExecContext(tctx, query); err != nil {
+			return errors.Annotatef(err, "sql: %s", query)
+		}
+		c.started = true
+	}
+	str, err := ShowMasterStatus(c.conn)
+	if err != nil {
+		return err
+	}
+	pos := &binlogPosition{
+		file: getValidStr(str, fileFieldIndex),
+		pos:  getValidStr(str, posFieldIndex),
+	}
+	const gtidQuery = "SELECT @@global.gtid_binlog_pos"
+	if err = c.conn.QueryRowContext(tctx, gtidQuery).Scan(&pos.gtidSet); err != nil {
+		return errors.Annotatef(err, "sql: %s", gtidQuery)
+	}
+	c.pos = pos
+	return nil
+}
+
+// TearDown implements ConsistencyController.TearDown
+func (c *ConsistencyBackupStage) TearDown(ctx context.Context) error {
+	if c.conn == nil {
+		return nil
+	}
+	defer func() {
+		c.conn.Close()
+		c.conn = nil
+	}()
+	if !c.started {
+		return nil
+	}
+	const endQuery = "BACKUP STAGE END"
+	_, err := c.conn.ExecContext(ctx, endQuery)
+	return errors.Annotatef(err, "sql: %s", endQuery)
+}
+
+// PingContext implements ConsistencyController.PingContext
+func (c *ConsistencyBackupStage) PingContext(ctx context.Context) error {
+	if c.conn == nil {
+		return errors.New("consistency connection has already been closed")
+	}
+	return c.conn.PingContext(ctx)
+}
+
+// BinlogPosition implements binlogPositionProvider.BinlogPosition
+func (c *ConsistencyBackupStage) BinlogPosition() *binlogPosition {
+	return c.pos
+}
+
+// slaveStatusProvider is implemented by the consistency controllers which get the replication status
+// in the consistent period by themselves. The status is recorded in metadata instead of SHOW SLAVE STATUS
+type slaveStatusProvider interface {
+	SlaveStatus() []*slaveStatus
+}
+
+// ConsistencyReplica stops the replication SQL thread of a replica before the dump, so the data stays unchanged
+// without locking anything. The replication is resumed after all the dumping transactions have started.
+type ConsistencyReplica struct {
+	serverInfo ServerInfo
+	conn       *sql.Conn
+	startSQL   string
+	statuses   []*slaveStatus
+}
+
+// Setup implements ConsistencyController.Setup
+func (c *ConsistencyReplica) Setup(tctx *tcontext.Context) error {
+	var stopSQL, startSQL string
+	switch {
+	case c.serverInfo.ServerType == ServerTypeMariaDB:
+		// stop all the connections of multi-source replication
+		stopSQL, startSQL = "STOP ALL SLAVES SQL_THREAD", "START ALL SLAVES SQL_THREAD"
+	case c.serverInfo.ServerType != ServerTypeMySQL:
+		return errors.Errorf("replica consistency cannot be used to ensure the consistency in %s", c.serverInfo.ServerType.String())
+	case c.serverInfo.ServerVersion != nil && !c.serverInfo.ServerVersion.LessThan(*replicaStatementVersion):
+		stopSQL, startSQL = "STOP REPLICA SQL_THREAD", "START REPLICA SQL_THREAD"
+	default:
+		stopSQL, startSQL = "STOP SLAVE SQL_THREAD", "START SLAVE SQL_THREAD"
+	}
+
+	statuses, err := showSlaveStatus(c.conn)
+	if err != nil {
+		return err
+	}
+	if len(statuses) == 0 {
+		return errors.New("replica consistency can only be used on a replica, but SHOW SLAVE STATUS returns nothing")
+	}
+	running := false
+	for _, status := range statuses {
+		running = running || status.sqlRunning
+	}
+	if !running {
+		tctx.L().Warn("replication SQL thread isn't running, dumpling won't start it after dumping")
+		c.statuses = statuses
+		return nil
+	}
+
+	// set before stopping, so that TearDown always restarts the thread even if the statement fails halfway
+	c.startSQL = startSQL
+	if _, err = c.conn.ExecContext(tctx, stopSQL); err != nil {
+		return errors.Annotatef(err, "sql: %s", stopSQL)
+	}
+	c.statuses, err = c.waitReplicaStopped(tctx)
+	return err
+}
+
+// waitReplicaStopped waits until all the SQL threads have stopped and the executed positions don't move any more
+func (c *ConsistencyReplica) waitReplicaStopped(tctx *tcontext.Context) ([]*slaveStatus, error) {
+	ticker := time.NewTicker(replicaStopCheckInterval)
+	defer ticker.Stop()
+	timeout := time.After(replicaStopTimeout)
+	var last []*slaveStatus
+	for {
+		statuses, err := showSlaveStatus(c.conn)
+		if err != nil {
+			return nil, err
+		}
+		if isReplicaSettled(last, statuses) {
+			return statuses, nil
+		}
+		last = statuses
+		select {
+		case <-tctx.Done():
+			return nil, tctx.Err()
+		case <-timeout:
+			return nil, errors.Errorf("replication SQL thread doesn't stop in %s", replicaStopTimeout)
+		case <-ticker.C:
+		}
+	}
+}
+
+func isReplicaSettled(last, current []*slaveStatus) bool {
+	if len(last) != len(current) {
+		return false
+	}
+	for i, status := range current {
+		if status.sqlRunning || last[i].connName != status.connName || last[i].binlogPosition != status.binlogPosition {
+			return false
+		}
+	}
+	return true
+}
+
+// TearDown implements ConsistencyController.TearDown
+func (c *ConsistencyReplica) TearDown(_ context.Context) error {
+	if c.conn == nil {
+		return nil
+	}
+	defer func() {
+		c.conn.Close()
+		c.conn = nil
+	}()
+	if c.startSQL == "" {
+		return nil
+	}
+	// the replication must be resumed even if the dump is canceled
+	_, err := c.conn.ExecContext(context.Background(), c.startSQL)
+	return errors.Annotatef(err, "sql: %s", c.startSQL)
+}
+
+// PingContext implements ConsistencyController.PingContext
+func (c *ConsistencyReplica) PingContext(ctx context.Context) error {
+	if c.conn == nil {
+		return errors.New("consistency connection has already been closed")
+	}
+	return c.conn.PingContext(ctx)
+}
+
+// SlaveStatus implements slaveStatusProvider.SlaveStatus
+func (c *ConsistencyReplica) SlaveStatus() []*slaveStatus {
+	return c.statuses
+}
+
 // ConsistencyLockDumpingTables execute lock tables read on all tables before dump
 type ConsistencyLockDumpingTables struct {
 	conn      *sql.Conn
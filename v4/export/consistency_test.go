@@ -5,11 +5,14 @@ package export
 import (
 	"context"
 	"errors"
+	"fmt"
 	"strings"
+	"time"
 
 	tcontext "github.com/pingcap/dumpling/v4/context"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/coreos/go-semver/semver"
 	"github.com/go-sql-driver/mysql"
 	. "github.com/pingcap/check"
 )
@@ -105,6 +108,97 @@ func (s *testConsistencySuite) TestConsistencyLockControllerRetry(c *C) {
 	}
 }
 
+func (s *testConsistencySuite) TestConsistencyBackupLock(c *C) {
+	db, mock, err := sqlmock.New()
+	c.Assert(err, IsNil)
+	defer db.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tctx := tcontext.Background().WithContext(ctx)
+	conf := defaultConfigForTest(c)
+	resultOk := sqlmock.NewResult(0, 1)
+
+	// MySQL 8.0 reads position from performance_schema.log_status, which must not move until all the snapshots have started
+	conf.Consistency = consistencyTypeBackupLock
+	conf.ServerInfo = ServerInfo{ServerType: ServerTypeMySQL, ServerVersion: semver.New("8.0.22")}
+	logStatus := func(pos int) *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"LOCAL"}).
+			AddRow(fmt.Sprintf(`{"gtid_executed": "6ce40be3-e359-11e9-87e0-36933cb0ca5a:1-29", "binary_log_file": "binlog.000002", "binary_log_position": %d}`, pos))
+	}
+	mock.ExpectExec("LOCK INSTANCE FOR BACKUP").WillReturnResult(resultOk)
+	mock.ExpectQuery("SELECT LOCAL FROM performance_schema.log_status").WillReturnRows(logStatus(7502))
+	mock.ExpectQuery("SELECT LOCAL FROM performance_schema.log_status").WillReturnRows(logStatus(7502))
+	mock.ExpectExec("UNLOCK INSTANCE").WillReturnResult(resultOk)
+	ctrl, _ := NewConsistencyController(ctx, conf, db)
+	backupLock, ok := ctrl.(*ConsistencyBackupLock)
+	c.Assert(ok, IsTrue)
+	s.assertNil(ctrl.Setup(tctx), c)
+	c.Assert(backupLock.BinlogPosition(), DeepEquals, &binlogPosition{
+		file:    "binlog.000002",
+		pos:     "7502",
+		gtidSet: "6ce40be3-e359-11e9-87e0-36933cb0ca5a:1-29",
+	})
+	s.assertNil(backupLock.SnapshotsStarted(tctx), c)
+	s.assertNil(ctrl.TearDown(tctx), c)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+
+	// the snapshots don't match the position if some transactions commit while they are starting,
+	// then the lock is released, and the controller can be set up again on the same connection
+	mock.ExpectExec("LOCK INSTANCE FOR BACKUP").WillReturnResult(resultOk)
+	mock.ExpectQuery("SELECT LOCAL FROM performance_schema.log_status").WillReturnRows(logStatus(7502))
+	mock.ExpectQuery("SELECT LOCAL FROM performance_schema.log_status").WillReturnRows(logStatus(7840))
+	mock.ExpectExec("UNLOCK INSTANCE").WillReturnResult(resultOk)
+	mock.ExpectExec("LOCK INSTANCE FOR BACKUP").WillReturnResult(resultOk)
+	mock.ExpectQuery("SELECT LOCAL FROM performance_schema.log_status").WillReturnRows(logStatus(7840))
+	mock.ExpectExec("UNLOCK INSTANCE").WillReturnResult(resultOk)
+	ctrl, _ = NewConsistencyController(ctx, conf, db)
+	s.assertNil(ctrl.Setup(tctx), c)
+	err = ctrl.(snapshotsStartedNotifier).SnapshotsStarted(tctx)
+	c.Assert(err, ErrorMatches, "binlog position moves from binlog.000002:7502 to binlog.000002:7840 while the dumping transactions are starting")
+	_, ok = err.(*binlogPositionMovedError)
+	c.Assert(ok, IsTrue)
+	s.assertNil(ctrl.(snapshotsStartedNotifier).Release(tctx), c)
+	s.assertNil(ctrl.Setup(tctx), c)
+	s.assertNil(ctrl.TearDown(tctx), c)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+
+	// Percona Server 5.7 holds the binlog lock until all the snapshots have started
+	conf.ServerInfo = ServerInfo{ServerType: ServerTypeMySQL, ServerVersion: semver.New("5.7.31")}
+	mock.ExpectExec("LOCK TABLES FOR BACKUP").WillReturnResult(resultOk)
+	mock.ExpectExec("LOCK BINLOG FOR BACKUP").WillReturnResult(resultOk)
+	mock.ExpectQuery("SHOW MASTER STATUS").WillReturnRows(
+		sqlmock.NewRows([]string{"File", "Position", "Binlog_Do_DB", "Binlog_Ignore_DB", "Executed_Gtid_Set"}).
+			AddRow("mysql-bin.000003", "154", "", "", ""))
+	mock.ExpectExec("UNLOCK BINLOG").WillReturnResult(resultOk)
+	mock.ExpectExec("UNLOCK TABLES").WillReturnResult(resultOk)
+	ctrl, _ = NewConsistencyController(ctx, conf, db)
+	s.assertNil(ctrl.Setup(tctx), c)
+	c.Assert(ctrl.(binlogPositionProvider).BinlogPosition(), DeepEquals, &binlogPosition{
+		file: "mysql-bin.000003",
+		pos:  "154",
+	})
+	s.assertNil(ctrl.(snapshotsStartedNotifier).SnapshotsStarted(tctx), c)
+	s.assertNil(ctrl.TearDown(tctx), c)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+
+	// the binlog lock is released by TearDown if the dump fails before all the snapshots have started
+	mock.ExpectExec("LOCK TABLES FOR BACKUP").WillReturnResult(resultOk)
+	mock.ExpectExec("LOCK BINLOG FOR BACKUP").WillReturnResult(resultOk)
+	mock.ExpectQuery("SHOW MASTER STATUS").WillReturnError(errors.New("access denied"))
+	mock.ExpectExec("UNLOCK BINLOG").WillReturnResult(resultOk)
+	mock.ExpectExec("UNLOCK TABLES").WillReturnResult(resultOk)
+	ctrl, _ = NewConsistencyController(ctx, conf, db)
+	c.Assert(ctrl.Setup(tctx), NotNil)
+	s.assertNil(ctrl.TearDown(tctx), c)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+
+	// backup lock is unavailable in other servers
+	conf.ServerInfo = ServerInfo{ServerType: ServerTypeTiDB}
+	ctrl, _ = NewConsistencyController(ctx, conf, db)
+	c.Assert(ctrl.Setup(tctx), ErrorMatches, "backup lock cannot be used to ensure the consistency in TiDB")
+	c.Assert(ctrl.TearDown(tctx), IsNil)
+}
+
 func (s *testConsistencySuite) TestResolveAutoConsistency(c *C) {
 	conf := defaultConfigForTest(c)
 	cases := []struct {
@@ -125,6 +219,186 @@ func (s *testConsistencySuite) TestResolveAutoConsistency(c *C) {
 		cmt := Commentf("server type %s", x.serverTp.String())
 		c.Assert(conf.Consistency, Equals, x.resolvedConsistency, cmt)
 	}
+
+	// MariaDB 10.4+ supports BACKUP STAGE
+	for ver, resolved := range map[string]string{"10.3.27": consistencyTypeFlush, "10.4.0": consistencyTypeBackupStage, "10.5.8": consistencyTypeBackupStage} {
+		conf.Consistency = consistencyTypeAuto
+		conf.ServerInfo = ServerInfo{ServerType: ServerTypeMariaDB, ServerVersion: semver.New(ver)}
+		d := &Dumper{conf: conf}
+		c.Assert(resolveAutoConsistency(d), IsNil)
+		c.Assert(conf.Consistency, Equals, resolved, Commentf("MariaDB %s", ver))
+	}
+}
+
+func (s *testConsistencySuite) TestConsistencyBackupStage(c *C) {
+	db, mock, err := sqlmock.New()
+	c.Assert(err, IsNil)
+	defer db.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tctx := tcontext.Background().WithContext(ctx)
+	conf := defaultConfigForTest(c)
+	resultOk := sqlmock.NewResult(0, 1)
+
+	conf.Consistency = consistencyTypeBackupStage
+	conf.ServerInfo = ServerInfo{ServerType: ServerTypeMariaDB, ServerVersion: semver.New("10.5.8")}
+	mock.ExpectExec("BACKUP STAGE START").WillReturnResult(resultOk)
+	mock.ExpectExec("BACKUP STAGE FLUSH").WillReturnResult(resultOk)
+	mock.ExpectExec("BACKUP STAGE BLOCK_DDL").WillReturnResult(resultOk)
+	mock.ExpectExec("BACKUP STAGE BLOCK_COMMIT").WillReturnResult(resultOk)
+	mock.ExpectQuery("SHOW MASTER STATUS").WillReturnRows(
+		sqlmock.NewRows([]string{"File", "Position", "Binlog_Do_DB", "Binlog_Ignore_DB"}).
+			AddRow("mariadb-bin.000016", "475", "", ""))
+	mock.ExpectQuery("SELECT @@global.gtid_binlog_pos").WillReturnRows(
+		sqlmock.NewRows([]string{"@@global.gtid_binlog_pos"}).AddRow("0-1-2"))
+	mock.ExpectExec("BACKUP STAGE END").WillReturnResult(resultOk)
+	ctrl, _ := NewConsistencyController(ctx, conf, db)
+	_, ok := ctrl.(*ConsistencyBackupStage)
+	c.Assert(ok, IsTrue)
+	s.assertNil(ctrl.Setup(tctx), c)
+	c.Assert(ctrl.(binlogPositionProvider).BinlogPosition(), DeepEquals, &binlogPosition{
+		file:    "mariadb-bin.000016",
+		pos:     "475",
+		gtidSet: "0-1-2",
+	})
+	s.assertNil(ctrl.TearDown(tctx), c)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+
+	// the backup stage is ended even if setup fails halfway
+	mock.ExpectExec("BACKUP STAGE START").WillReturnResult(resultOk)
+	mock.ExpectExec("BACKUP STAGE FLUSH").WillReturnError(errors.New("mock error"))
+	mock.ExpectExec("BACKUP STAGE END").WillReturnResult(resultOk)
+	ctrl, _ = NewConsistencyController(ctx, conf, db)
+	c.Assert(ctrl.Setup(tctx), NotNil)
+	s.assertNil(ctrl.TearDown(tctx), c)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+
+	// backup stage is unavailable in other servers
+	conf.ServerInfo = ServerInfo{ServerType: ServerTypeMySQL}
+	ctrl, _ = NewConsistencyController(ctx, conf, db)
+	c.Assert(ctrl.Setup(tctx), ErrorMatches, "backup stage cannot be used to ensure the consistency in MySQL")
+	c.Assert(ctrl.TearDown(tctx), IsNil)
+}
+
+func (s *testConsistencySuite) TestConsistencyReplica(c *C) {
+	db, mock, err := sqlmock.New()
+	c.Assert(err, IsNil)
+	defer db.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tctx := tcontext.Background().WithContext(ctx)
+	conf := defaultConfigForTest(c)
+	resultOk := sqlmock.NewResult(0, 1)
+	statusColumns := []string{"Source_Host", "Relay_Source_Log_File", "Exec_Source_Log_Pos", "Replica_SQL_Running", "Executed_Gtid_Set"}
+	expectStatus := func(running, pos string) {
+		mock.ExpectQuery("SELECT @@default_master_connection").WillReturnError(errors.New("mock error"))
+		mock.ExpectQuery("SHOW SLAVE STATUS").WillReturnRows(sqlmock.NewRows(statusColumns).
+			AddRow("192.168.1.100", "mysql-bin.001821", pos, running, "6ce40be3-e359-11e9-87e0-36933cb0ca5a:1-29"))
+	}
+
+	conf.Consistency = consistencyTypeReplica
+	conf.ServerInfo = ServerInfo{ServerType: ServerTypeMySQL, ServerVersion: semver.New("8.0.23")}
+	expectStatus("Yes", "100")
+	mock.ExpectExec("STOP REPLICA SQL_THREAD").WillReturnResult(resultOk)
+	expectStatus("Yes", "200")
+	expectStatus("No", "300")
+	expectStatus("No", "300")
+	mock.ExpectExec("START REPLICA SQL_THREAD").WillReturnResult(resultOk)
+	ctrl, _ := NewConsistencyController(ctx, conf, db)
+	_, ok := ctrl.(*ConsistencyReplica)
+	c.Assert(ok, IsTrue)
+	s.assertNil(ctrl.Setup(tctx), c)
+	statuses := ctrl.(slaveStatusProvider).SlaveStatus()
+	c.Assert(statuses, HasLen, 1)
+	c.Assert(statuses[0].host, Equals, "192.168.1.100")
+	c.Assert(statuses[0].binlogPosition, Equals, binlogPosition{
+		file:    "mysql-bin.001821",
+		pos:     "300",
+		gtidSet: "6ce40be3-e359-11e9-87e0-36933cb0ca5a:1-29",
+	})
+	s.assertNil(ctrl.TearDown(tctx), c)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+
+	// the replication is restarted even if setup fails after stopping it
+	conf.ServerInfo = ServerInfo{ServerType: ServerTypeMySQL, ServerVersion: semver.New("5.7.25")}
+	expectStatus("Yes", "100")
+	mock.ExpectExec("STOP SLAVE SQL_THREAD").WillReturnResult(resultOk)
+	mock.ExpectQuery("SELECT @@default_master_connection").WillReturnError(errors.New("mock error"))
+	mock.ExpectQuery("SHOW SLAVE STATUS").WillReturnError(errors.New("mock error"))
+	mock.ExpectExec("START SLAVE SQL_THREAD").WillReturnResult(resultOk)
+	ctrl, _ = NewConsistencyController(ctx, conf, db)
+	c.Assert(ctrl.Setup(tctx), NotNil)
+	s.assertNil(ctrl.TearDown(tctx), c)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+
+	// the stopped replication is left untouched
+	expectStatus("No", "100")
+	ctrl, _ = NewConsistencyController(ctx, conf, db)
+	s.assertLifetimeErrNil(tctx, ctrl, c)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+
+	// fail on a server without replication
+	mock.ExpectQuery("SELECT @@default_master_connection").WillReturnError(errors.New("mock error"))
+	mock.ExpectQuery("SHOW SLAVE STATUS").WillReturnRows(sqlmock.NewRows(statusColumns))
+	ctrl, _ = NewConsistencyController(ctx, conf, db)
+	c.Assert(ctrl.Setup(tctx), ErrorMatches, "replica consistency can only be used on a replica.*")
+	s.assertNil(ctrl.TearDown(tctx), c)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
+
+func (s *testConsistencySuite) TestConsistencyFlushLongQueryGuard(c *C) {
+	db, mock, err := sqlmock.New()
+	c.Assert(err, IsNil)
+	defer db.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tctx := tcontext.Background().WithContext(ctx)
+	conf := defaultConfigForTest(c)
+	resultOk := sqlmock.NewResult(0, 1)
+	processListColumns := []string{"ID", "USER", "TIME", "INFO"}
+
+	conf.Consistency = consistencyTypeFlush
+	conf.ServerInfo = ServerInfo{ServerType: ServerTypeMySQL}
+	conf.LongQueryGuard = 1500 * time.Millisecond
+
+	// abort the dump if there are long queries
+	mock.ExpectQuery("SELECT ID, USER, TIME, INFO FROM information_schema.PROCESSLIST").WithArgs(2).
+		WillReturnRows(sqlmock.NewRows(processListColumns).AddRow(10, "root", 100, "SELECT SLEEP(1000)").AddRow(12, "u", 3, nil))
+	mock.ExpectExec("UNLOCK TABLES").WillReturnResult(resultOk)
+	ctrl, _ := NewConsistencyController(ctx, conf, db)
+	c.Assert(ctrl.Setup(tctx), ErrorMatches, "there are 2 queries running longer than 1.5s \\(process id: 10,12\\).*")
+	s.assertNil(ctrl.TearDown(tctx), c)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+
+	// kill the long queries, and retry FTWRL after lock wait timeout
+	conf.KillLongQueries = true
+	conf.LockWaitTimeout = 10 * time.Second
+	mock.ExpectExec("SET SESSION lock_wait_timeout = 10").WillReturnResult(resultOk)
+	mock.ExpectQuery("SELECT ID, USER, TIME, INFO FROM information_schema.PROCESSLIST").WithArgs(2).
+		WillReturnRows(sqlmock.NewRows(processListColumns).AddRow(10, "root", 100, "SELECT SLEEP(1000)"))
+	mock.ExpectExec("KILL QUERY 10").WillReturnResult(resultOk)
+	mock.ExpectExec("FLUSH TABLES WITH READ LOCK").WillReturnError(&mysql.MySQLError{
+		Number:  ErrLockWaitTimeout,
+		Message: "Lock wait timeout exceeded; try restarting transaction",
+	})
+	mock.ExpectQuery("SELECT ID, USER, TIME, INFO FROM information_schema.PROCESSLIST").WithArgs(2).
+		WillReturnRows(sqlmock.NewRows(processListColumns))
+	mock.ExpectExec("FLUSH TABLES WITH READ LOCK").WillReturnResult(resultOk)
+	mock.ExpectExec("UNLOCK TABLES").WillReturnResult(resultOk)
+	ctrl, _ = NewConsistencyController(ctx, conf, db)
+	s.assertLifetimeErrNil(tctx, ctrl, c)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+
+	// other errors are not retried
+	mock.ExpectExec("SET SESSION lock_wait_timeout = 10").WillReturnResult(resultOk)
+	mock.ExpectQuery("SELECT ID, USER, TIME, INFO FROM information_schema.PROCESSLIST").WithArgs(2).
+		WillReturnRows(sqlmock.NewRows(processListColumns))
+	mock.ExpectExec("FLUSH TABLES WITH READ LOCK").WillReturnError(errors.New("mock error"))
+	mock.ExpectExec("UNLOCK TABLES").WillReturnResult(resultOk)
+	ctrl, _ = NewConsistencyController(ctx, conf, db)
+	c.Assert(ctrl.Setup(tctx), ErrorMatches, ".*mock error")
+	s.assertNil(ctrl.TearDown(tctx), c)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
 }
 
 func (s *testConsistencySuite) TestConsistencyControllerError(c *C) {
@@ -8,6 +8,7 @@ import (
 	"database/sql"
 	"fmt"
 	"math/big"
+	"os"
 	"strings"
 	"time"
 
@@ -19,6 +20,7 @@ import (
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/pingcap/br/pkg/storage"
 	"github.com/pingcap/br/pkg/summary"
+	"github.com/pingcap/br/pkg/utils"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/failpoint"
 	pd "github.com/tikv/pd/client"
@@ -36,6 +38,26 @@ type Dumper struct {
 	dbHandle *sql.DB
 
 	tidbPDClientForGC pd.Client
+	// failures records the skipped tables with --continue-on-error, it's nil otherwise
+	failures *failureRecorder
+	// schemas caches the INFORMATION_SCHEMA metadata of tables for generating the meta and chunks of tables
+	schemas *schemaCache
+	// watermarks records the watermarks of tables with --watermark-column, it's nil otherwise
+	watermarks *watermarkRecorder
+	// subset is the rows of tables to dump with --subset-root, it's nil otherwise
+	subset *tableSubset
+	// views records the dumped views to resolve the order of creating them, it's nil if views aren't dumped
+	views *viewOrderRecorder
+	// routes is the targets of the tables routed by --route, it's nil otherwise
+	routes *tableRoutes
+	// rewrittenSchemas records the tables whose schemas are changed by --schema-rewrite
+	rewrittenSchemas []rewrittenSchema
+	// singleFile collects the dump written into one file with --single-file
+	singleFile *singleFileOutput
+	// sqlite writes the dump into one SQLite database with --filetype sqlite
+	sqlite *sqliteOutput
+	// loadData collects the CSV files for the LOAD DATA scripts with --load-data-scripts
+	loadData *loadDataScripts
 }
 
 // NewDumper returns a new Dumper
@@ -49,13 +71,29 @@ func NewDumper(ctx context.Context, conf *Config) (*Dumper, error) {
 	err := adjustConfig(conf,
 		registerTLSConfig,
 		validateSpecifiedSQL,
-		adjustFileFormat)
+		validateIncrementalDump,
+		adjustSampleOptions,
+		validateSubsetDump,
+		adjustOutputStream,
+		adjustFileFormat,
+		validateSQLDialect,
+		validateSQLiteOutput,
+		validateLoadDataScripts,
+		validateSingleFile,
+		validateInsertMode,
+		adjustDefiner,
+		validateTableRoutes)
 	if err != nil {
 		return nil, err
 	}
+	d.schemas = newSchemaCache(conf.SplitPartitions || conf.PartitionFilter.hasAnyRule())
+	if !conf.NoViews && !conf.NoSchemas {
+		d.views = newViewOrderRecorder()
+	}
 	err = runSteps(d,
 		initLogger,
 		createExternalStore,
+		loadPreviousWatermarks,
 		startHTTPService,
 		openSQLDB,
 		detectServerInfo,
@@ -81,10 +119,17 @@ func (d *Dumper) Dump() (dumpErr error) {
 	tctx, conf, pool := d.tctx, d.conf, d.dbHandle
 	m := newGlobalMetadata(tctx, d.extStore, conf.Snapshot)
 	defer func() {
-		if dumpErr == nil {
-			_ = m.writeGlobalMetaData()
+		// the successfully dumped tables are still usable in a partial dump
+		if _, partial := dumpErr.(*PartialDumpError); dumpErr == nil || partial {
+			// the success marker is written at last, so the loaders can be triggered by it safely
+			if err := m.writeGlobalMetaData(); err == nil && dumpErr == nil {
+				_ = writeSuccessMarker(tctx, d.extStore)
+			}
 		}
 	}()
+	if conf.ContinueOnError {
+		d.failures = newFailureRecorder()
+	}
 
 	// for consistency lock, we should get table list at first to generate the lock tables SQL
 	if conf.Consistency == consistencyTypeLock {
@@ -103,9 +148,21 @@ func (d *Dumper) Dump() (dumpErr error) {
 	if err != nil {
 		return err
 	}
-	if err = conCtrl.Setup(tctx); err != nil {
+	conns, err := d.setupConsistency(tctx, conCtrl)
+	if err != nil {
+		// release what has been locked before the failure
+		if err1 := conCtrl.TearDown(tctx); err1 != nil {
+			tctx.L().Error("fail to tear down consistency controller", zap.Error(err1))
+		}
 		return errors.Trace(err)
 	}
+	defer conns.close()
+	if p, ok := conCtrl.(binlogPositionProvider); ok {
+		m.setBinlogPosition(p.BinlogPosition())
+	}
+	if p, ok := conCtrl.(slaveStatusProvider); ok {
+		m.setSlaveStatus(p.SlaveStatus())
+	}
 	// To avoid lock is not released
 	defer func() {
 		err = conCtrl.TearDown(tctx)
@@ -114,11 +171,7 @@ func (d *Dumper) Dump() (dumpErr error) {
 		}
 	}()
 
-	metaConn, err := createConnWithConsistency(tctx, pool)
-	if err != nil {
-		return err
-	}
-	defer metaConn.Close()
+	metaConn := conns.meta
 	m.recordStartTime(time.Now())
 	// for consistency lock, we can write snapshot info after all tables are locked.
 	// the binlog pos may changed because there is still possible write between we lock tables and write master status.
@@ -130,6 +183,12 @@ func (d *Dumper) Dump() (dumpErr error) {
 	if err != nil {
 		tctx.L().Info("get global metadata failed", zap.Error(err))
 	}
+	if conf.sampling() {
+		m.recordSampleOptions(conf)
+	}
+	if conf.postgresDialect() {
+		m.recordSQLDialect(conf.SQLDialect)
+	}
 
 	// for other consistencies, we should get table list after consistency is set up and GlobalMetaData is cached
 	if conf.Consistency != consistencyTypeLock {
@@ -137,6 +196,26 @@ func (d *Dumper) Dump() (dumpErr error) {
 			return err
 		}
 	}
+	if conf.TableRouter != nil {
+		if d.routes, err = newTableRoutes(conf); err != nil {
+			return err
+		}
+	}
+	if conf.SingleFile {
+		if d.singleFile, err = newSingleFileOutput(conf); err != nil {
+			return err
+		}
+		defer d.singleFile.close()
+	}
+	if conf.LoadDataScripts {
+		d.loadData = newLoadDataScripts()
+	}
+	if conf.FileType == FileFormatSQLiteString {
+		if d.sqlite, err = newSQLiteOutput(conf); err != nil {
+			return err
+		}
+		defer d.sqlite.close()
+	}
 
 	rebuildConn := func(conn *sql.Conn) (*sql.Conn, error) {
 		// make sure that the lock connection is still alive
@@ -164,15 +243,15 @@ func (d *Dumper) Dump() (dumpErr error) {
 	taskChan := make(chan Task, defaultDumpThreads)
 	AddGauge(taskChannelCapacity, conf.Labels, defaultDumpThreads)
 	wg, writingCtx := errgroup.WithContext(tctx)
-	writers, tearDownWriters, err := d.startWriters(tctx.WithContext(writingCtx), wg, taskChan, rebuildConn)
-	if err != nil {
-		return err
-	}
+	writers, tearDownWriters := d.startWriters(tctx.WithContext(writingCtx), wg, taskChan, conns.takeWriters(), rebuildConn)
 	defer tearDownWriters()
 
 	if conf.TransactionalConsistency {
-		if conf.Consistency == consistencyTypeFlush || conf.Consistency == consistencyTypeLock {
+		switch conf.Consistency {
+		case consistencyTypeFlush, consistencyTypeLock, consistencyTypeBackupLock, consistencyTypeBackupStage:
 			tctx.L().Info("All the dumping transactions have started. Start to unlock tables")
+		case consistencyTypeReplica:
+			tctx.L().Info("All the dumping transactions have started. Start to resume replication")
 		}
 		if err = conCtrl.TearDown(tctx); err != nil {
 			return errors.Trace(err)
@@ -189,6 +268,25 @@ func (d *Dumper) Dump() (dumpErr error) {
 		}
 	}
 
+	// the foreign keys are collected on metaConn after the consistency controller is torn down, so the traversal of
+	// a subset dump doesn't block the writes on the server, while it still reads the snapshot of the dumping connections
+	if conf.SQL == "" && !conf.NoLoadOrder {
+		fks, err := SelectForeignKeys(metaConn, conf.Tables)
+		switch {
+		case err == nil:
+			d.recordLoadOrder(m, fks)
+		case conf.SubsetRoots.hasAnyRule():
+			return err
+		default:
+			tctx.L().Warn("get foreign keys failed, the load order isn't recorded in metadata", zap.Error(err))
+		}
+		if conf.SubsetRoots.hasAnyRule() && !conf.NoData {
+			if d.subset, err = d.buildSubset(metaConn, fks); err != nil {
+				return err
+			}
+		}
+	}
+
 	summary.SetLogCollector(summary.NewLogCollector(tctx.L().Info))
 	summary.SetUnit(summary.BackupUnit)
 	defer summary.Summary(summary.BackupUnit)
@@ -211,7 +309,7 @@ func (d *Dumper) Dump() (dumpErr error) {
 	})
 
 	if conf.SQL == "" {
-		if err = d.dumpDatabases(metaConn, taskChan); err != nil {
+		if err = d.dumpDatabases(metaConn, conns.schemas, conns.producers, taskChan); err != nil {
 			return err
 		}
 	} else {
@@ -222,24 +320,163 @@ func (d *Dumper) Dump() (dumpErr error) {
 		summary.CollectFailureUnit("dump table data", err)
 		return errors.Trace(err)
 	}
+	if d.singleFile != nil {
+		if err = d.writeSingleFile(); err != nil {
+			return err
+		}
+	}
+	if d.sqlite != nil {
+		if err = d.sqlite.close(); err != nil {
+			return err
+		}
+	}
+	if d.loadData != nil {
+		if err = d.writeLoadDataScripts(); err != nil {
+			return err
+		}
+	}
 	summary.CollectSuccessUnit("dump cost", countTotalTask(writers), time.Since(tableDataStartTime))
 
+	if d.failures != nil {
+		d.failures.removeFailedTableFiles(tctx, d.extStore)
+		if partialErr := d.failures.partialDumpError(); partialErr != nil {
+			summary.CollectFailureUnit("dump table data", partialErr)
+			if d.watermarks != nil {
+				m.recordWatermarks(d.watermarks.watermarks(partialErr.Failures))
+			}
+			if d.views != nil {
+				m.recordViewOrder(d.views.order())
+			}
+			m.recordSchemaRewrites(d.rewrittenSchemas)
+			m.recordFinishTime(time.Now())
+			if err = writeErrorReport(tctx, d.extStore, partialErr); err != nil {
+				return err
+			}
+			return partialErr
+		}
+	}
+
 	summary.SetSuccessStatus(true)
+	if d.watermarks != nil {
+		m.recordWatermarks(d.watermarks.watermarks(nil))
+	}
+	if d.views != nil {
+		m.recordViewOrder(d.views.order())
+	}
+	m.recordSchemaRewrites(d.rewrittenSchemas)
 	m.recordFinishTime(time.Now())
 	return nil
 }
 
-func (d *Dumper) startWriters(tctx *tcontext.Context, wg *errgroup.Group, taskChan <-chan Task,
-	rebuildConnFn func(*sql.Conn) (*sql.Conn, error)) ([]*Writer, func(), error) {
+// snapshotConns are the connections of the dump sharing the same snapshot,
+// they must be created before the consistency controller is torn down
+type snapshotConns struct {
+	meta      *sql.Conn
+	schemas   []*sql.Conn
+	producers []*sql.Conn
+	writers   []*sql.Conn
+}
+
+func (s *snapshotConns) close() {
+	if s.meta != nil {
+		s.meta.Close()
+	}
+	closeConns(s.schemas)
+	closeConns(s.producers)
+	closeConns(s.writers)
+}
+
+// takeWriters hands over the writer connections, which are closed by the writers instead
+func (s *snapshotConns) takeWriters() []*sql.Conn {
+	conns := s.writers
+	s.writers = nil
+	return conns
+}
+
+// createSnapshotConns creates all the connections of the dump on the same consistent snapshot. No connection is returned on error
+func (d *Dumper) createSnapshotConns(tctx *tcontext.Context) (conns *snapshotConns, err error) {
 	conf, pool := d.conf, d.dbHandle
-	writers := make([]*Writer, conf.Threads)
-	for i := 0; i < conf.Threads; i++ {
-		conn, err := createConnWithConsistency(tctx, pool)
+	s := &snapshotConns{}
+	defer func() {
 		if err != nil {
-			return nil, func() {}, err
+			s.close()
+		}
+	}()
+	if s.meta, err = createConnWithConsistency(tctx, pool); err != nil {
+		return nil, err
+	}
+	if conf.SQL == "" {
+		if s.schemas, err = createConnsWithConsistency(tctx, pool, conf.SchemaThreads); err != nil {
+			return nil, err
 		}
+		if s.producers, err = createConnsWithConsistency(tctx, pool, conf.ProducerThreads); err != nil {
+			return nil, err
+		}
+	}
+	if s.writers, err = createConnsWithConsistency(tctx, pool, conf.Threads); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// setupConsistency sets up the consistency controller and starts all the dumping transactions. If the controller can't tie
+// its binlog position to their snapshots because of the concurrent commits, the transactions are given up, and they are
+// started again after the controller is set up again
+func (d *Dumper) setupConsistency(tctx *tcontext.Context, conCtrl ConsistencyController) (*snapshotConns, error) {
+	var (
+		conns   *snapshotConns
+		lastErr error
+	)
+	notifier, needNotify := conCtrl.(snapshotsStartedNotifier)
+	err := utils.WithRetry(tctx, func() (err error) {
+		defer func() {
+			lastErr = err
+		}()
+		if err = conCtrl.Setup(tctx); err != nil {
+			return err
+		}
+		if conns, err = d.createSnapshotConns(tctx); err != nil || !needNotify {
+			return err
+		}
+		if err = notifier.SnapshotsStarted(tctx); err == nil {
+			return nil
+		}
+		conns.close()
+		conns = nil
+		if _, ok := errors.Cause(err).(*binlogPositionMovedError); ok {
+			tctx.L().Warn("binlog position moves while the dumping transactions are starting, set up the consistency again", zap.Error(err))
+			if err1 := notifier.Release(tctx); err1 != nil {
+				return err1
+			}
+		}
+		return err
+	}, newSnapshotsBackoffer())
+	switch {
+	case err == nil:
+		return conns, nil
+	case tctx.Err() != nil:
+		return nil, errors.Trace(tctx.Err())
+	}
+	if _, ok := errors.Cause(lastErr).(*binlogPositionMovedError); ok {
+		return nil, errors.Annotatef(lastErr, "the dumped data can't match any binlog position after %d attempts. "+
+			"Please retry when there are fewer writes, or use --consistency flush", snapshotsRetryTime)
+	}
+	return nil, lastErr
+}
+
+// startWriters starts a writer on each of the connections, the connections are closed by the returned tearDown function
+func (d *Dumper) startWriters(tctx *tcontext.Context, wg *errgroup.Group, taskChan <-chan Task, conns []*sql.Conn,
+	rebuildConnFn func(*sql.Conn) (*sql.Conn, error)) ([]*Writer, func()) {
+	conf := d.conf
+	writers := make([]*Writer, len(conns))
+	for i, conn := range conns {
 		writer := NewWriter(tctx, int64(i), conf, conn, d.extStore)
 		writer.rebuildConnFn = rebuildConnFn
+		writer.routes = d.routes
+		writer.singleFile = d.singleFile
+		writer.sqlite = d.sqlite
+		writer.loadData = d.loadData
+		writer.failures = d.failures
 		writer.setFinishTableCallBack(func(task Task) {
 			if td, ok := task.(*TaskTableData); ok {
 				IncCounter(finishedTablesCounter, conf.Labels)
@@ -251,6 +488,11 @@ func (d *Dumper) startWriters(tctx *tcontext.Context, wg *errgroup.Group, taskCh
 		writer.setFinishTaskCallBack(func(task Task) {
 			IncGauge(taskChannelCapacity, conf.Labels)
 		})
+		if d.failures != nil {
+			writer.setTaskFailedCallBack(func(task Task, err error) {
+				d.failures.recordTask(tctx, task, err)
+			})
+		}
 		wg.Go(func() error {
 			return writer.run(taskChan)
 		})
@@ -261,43 +503,200 @@ func (d *Dumper) startWriters(tctx *tcontext.Context, wg *errgroup.Group, taskCh
 			w.conn.Close()
 		}
 	}
-	return writers, tearDown, nil
+	return writers, tearDown
 }
 
-func (d *Dumper) dumpDatabases(metaConn *sql.Conn, taskChan chan<- Task) error {
+func (d *Dumper) dumpDatabases(metaConn *sql.Conn, schemaConns, producerConns []*sql.Conn, taskChan chan<- Task) error {
 	conf := d.conf
-	allTables := conf.Tables
-	for dbName, tables := range allTables {
-		createDatabaseSQL, err := ShowCreateDatabase(metaConn, dbName)
+	var sizes map[string]map[string]uint64
+	if conf.DumpOrder == dumpOrderSize {
+		var err error
+		sizes, err = SelectTablesDataLength(metaConn, conf.Tables.sortedDatabaseNames())
+		if err != nil {
+			d.L().Warn("fail to get the data length of tables, fallback to alphabetical dump order", zap.Error(err))
+		}
+	}
+
+	tables := orderTablesToDump(conf, sizes)
+	metas := d.prefetchTableMeta(metaConn, schemaConns, tables)
+	defer metas.close()
+	producers := d.startProducers(metaConn, producerConns, taskChan)
+	err := d.dumpTablesInOrder(metaConn, tables, metas, producers, taskChan)
+	if err1 := producers.wait(); err == nil {
+		err = err1
+	}
+	return err
+}
+
+// dumpTablesInOrder sends the meta tasks of databases and tables in the dumping order,
+// while the data of tables are split by the producers concurrently
+func (d *Dumper) dumpTablesInOrder(metaConn *sql.Conn, tables []tableToDump, metas *tableMetaPrefetcher,
+	producers *taskProducers, taskChan chan<- Task) error {
+	// a database is dumped right before its first table in the dumping order, so a prioritized table doesn't wait for the others
+	dumpedDatabases := make(map[string]bool, len(d.conf.Tables))
+	for i, t := range tables {
+		dbName, table := t.db, t.table
+		dumped, ok := dumpedDatabases[dbName]
+		if !ok {
+			createDatabaseSQL, err := ShowCreateDatabase(metaConn, dbName)
+			if err != nil {
+				if !d.skipFailedTable(dbName, "", err) {
+					return err
+				}
+			} else {
+				task := NewTaskDatabaseMeta(dbName, createDatabaseSQL)
+				if err = d.sendMetaTaskToChan(task, taskChan); err != nil {
+					return err
+				}
+			}
+			dumped = err == nil
+			dumpedDatabases[dbName] = dumped
+		}
+		if table == nil {
+			continue
+		}
+		if !dumped {
+			metas.skip(i)
+			continue
+		}
+
+		d.L().Debug("start dumping table...", zap.String("database", dbName),
+			zap.String("table", table.Name))
+		meta, err := metas.get(i)
 		if err != nil {
+			if d.skipFailedTable(dbName, table.Name, err) {
+				continue
+			}
 			return err
 		}
-		task := NewTaskDatabaseMeta(dbName, createDatabaseSQL)
-		d.sendTaskToChan(task, taskChan)
 
-		for _, table := range tables {
-			d.L().Debug("start dumping table...", zap.String("database", dbName),
-				zap.String("table", table.Name))
-			meta, err := dumpTableMeta(conf, metaConn, dbName, table)
+		if table.Type == TableTypeView {
+			if d.views != nil {
+				if err = d.views.record(dbName, table.Name, meta.ShowCreateView()); err != nil {
+					d.L().Warn("can't parse the view to resolve its dependencies", zap.String("database", dbName),
+						zap.String("view", table.Name), zap.Error(err))
+				}
+			}
+			task := NewTaskViewMeta(dbName, table.Name, meta.ShowCreateTable(), meta.ShowCreateView())
+			if err = d.sendMetaTaskToChan(task, taskChan); err != nil {
+				return err
+			}
+		} else {
+			createTableSQL, err := d.rewriteSchema(dbName, table.Name, meta.ShowCreateTable())
 			if err != nil {
+				if d.skipFailedTable(dbName, table.Name, err) {
+					continue
+				}
+				return err
+			}
+			task := NewTaskTableMeta(dbName, table.Name, createTableSQL)
+			if err = d.sendMetaTaskToChan(task, taskChan); err != nil {
 				return err
 			}
+			if !producers.dumpTableData(meta) {
+				// stop sending the other tables, the error is returned by the producers
+				return nil
+			}
+		}
+	}
 
-			if table.Type == TableTypeView {
-				task := NewTaskViewMeta(dbName, table.Name, meta.ShowCreateTable(), meta.ShowCreateView())
-				d.sendTaskToChan(task, taskChan)
-			} else {
-				task := NewTaskTableMeta(dbName, table.Name, meta.ShowCreateTable())
-				d.sendTaskToChan(task, taskChan)
-				err = d.dumpTableData(metaConn, meta, taskChan)
-				if err != nil {
+	return nil
+}
+
+// taskProducers split the data of tables into tasks concurrently, each producer on its own snapshot connection.
+// The shared task channel gives the backpressure, so the producers can't be too ahead of the writers
+type taskProducers struct {
+	tables chan TableMeta
+	// done is signaled after the data of each table is split if the tables must be dumped one by one, it's nil otherwise
+	done chan struct{}
+	eg   *errgroup.Group
+	ctx  context.Context
+	// dump splits the table on the meta connection if there is no producer, it's nil otherwise
+	dump func(TableMeta) error
+	err  error
+}
+
+func (d *Dumper) startProducers(metaConn *sql.Conn, conns []*sql.Conn, taskChan chan<- Task) *taskProducers {
+	eg, ctx := errgroup.WithContext(d.tctx)
+	p := &taskProducers{tables: make(chan TableMeta), eg: eg, ctx: ctx}
+	dump := func(conn *sql.Conn, meta TableMeta) error {
+		if err := d.dumpTableData(conn, meta, taskChan); err != nil {
+			if !d.skipFailedTable(meta.DatabaseName(), meta.TableName(), err) {
+				return err
+			}
+		}
+		return nil
+	}
+	if len(conns) == 0 {
+		// the tables are split one by one in the dumping order, so no more connection is opened while the consistency lock is held
+		p.dump = func(meta TableMeta) error {
+			return dump(metaConn, meta)
+		}
+		return p
+	}
+	if d.conf.OutputDirPath == outputToStdout {
+		// the data tasks of a table can't be mixed with the tasks of the next tables in the stream
+		p.done = make(chan struct{}, 1)
+	}
+	for _, conn := range conns {
+		conn := conn
+		eg.Go(func() error {
+			for meta := range p.tables {
+				if err := dump(conn, meta); err != nil {
 					return err
 				}
+				if p.done != nil {
+					p.done <- struct{}{}
+				}
 			}
-		}
+			return nil
+		})
+	}
+	return p
+}
+
+// dumpTableData waits for an idle producer to dump the data of the table. It returns false if any producer fails.
+// If the tables must be dumped one by one, it also waits for the producer to split all the data of the table
+func (p *taskProducers) dumpTableData(meta TableMeta) bool {
+	if p.ctx.Err() != nil || p.err != nil {
+		return false
+	}
+	if p.dump != nil {
+		p.err = p.dump(meta)
+		return p.err == nil
+	}
+	select {
+	case <-p.ctx.Done():
+		return false
+	case p.tables <- meta:
+	}
+	if p.done == nil {
+		return true
 	}
+	select {
+	case <-p.ctx.Done():
+		return false
+	case <-p.done:
+		return true
+	}
+}
 
-	return nil
+// wait waits for the producers to finish all the tables
+func (p *taskProducers) wait() error {
+	close(p.tables)
+	if err := p.eg.Wait(); err != nil {
+		return err
+	}
+	return p.err
+}
+
+// skipFailedTable records the failure and returns true if the failed table can be skipped with --continue-on-error
+func (d *Dumper) skipFailedTable(db, table string, err error) bool {
+	if d.failures == nil || d.tctx.Err() != nil || isConnectionError(err) {
+		return false
+	}
+	d.failures.record(d.tctx, TableFailure{Database: db, Table: table, Chunk: -1, Error: err.Error()})
+	return true
 }
 
 func (d *Dumper) dumpTableData(conn *sql.Conn, meta TableMeta, taskChan chan<- Task) error {
@@ -305,36 +704,62 @@ func (d *Dumper) dumpTableData(conn *sql.Conn, meta TableMeta, taskChan chan<- T
 	if conf.NoData {
 		return nil
 	}
+	db, tbl := meta.DatabaseName(), meta.TableName()
+	if err := d.recordTableWatermark(conn, db, tbl); err != nil {
+		return err
+	}
+	if d.subset != nil {
+		return d.dumpSubsetTable(conn, meta, taskChan)
+	}
+	if conf.sampling() {
+		return d.sampleTable(conn, meta, taskChan)
+	}
+	if conf.SplitPartitions || conf.PartitionFilter.HasRules(db, tbl) {
+		partitions, err := d.selectTablePartitions(conn, db, tbl)
+		if err != nil {
+			return err
+		}
+		if len(partitions) > 0 {
+			return d.dumpTablePartitions(conn, meta, partitions, taskChan)
+		}
+	}
 	if conf.Rows == UnspecifiedSize {
 		return d.sequentialDumpTable(conn, meta, taskChan)
 	}
 	return d.concurrentDumpTable(conn, meta, taskChan)
 }
 
+// sequentialDumpTable dumps the table, or the partition if meta is a partition, as one chunk
 func (d *Dumper) sequentialDumpTable(conn *sql.Conn, meta TableMeta, taskChan chan<- Task) error {
-	conf := d.conf
 	db, tbl := meta.DatabaseName(), meta.TableName()
-	tableIR, err := SelectAllFromTable(conf, conn, db, tbl)
+	selectedField, selectLen, err := d.buildSelectField(conn, db, tbl)
+	if err != nil {
+		return err
+	}
+	orderByClause, err := d.buildOrderByClause(conn, db, tbl)
 	if err != nil {
 		return err
 	}
-	task := NewTaskTableData(meta, tableIR, 0, 1)
+	query := buildSelectPartitionQuery(db, tbl, partitionOf(meta), selectedField, buildWhereCondition(d.tableWhere(db, tbl), ""), orderByClause)
+	task := NewTaskTableData(meta, newTableData(query, selectLen, false), 0, 1)
 	d.sendTaskToChan(task, taskChan)
 
 	return nil
 }
 
+// concurrentDumpTable splits the table, or the partition if meta is a partition, into chunks of about --rows rows
 func (d *Dumper) concurrentDumpTable(conn *sql.Conn, meta TableMeta, taskChan chan<- Task) error {
 	conf := d.conf
-	db, tbl := meta.DatabaseName(), meta.TableName()
-	if conf.ServerInfo.ServerType == ServerTypeTiDB &&
+	db, tbl, partition := meta.DatabaseName(), meta.TableName(), partitionOf(meta)
+	// TABLESAMPLE samples the regions of the whole table, so the partitions are split by the integer field
+	if partition == "" && conf.ServerInfo.ServerType == ServerTypeTiDB &&
 		conf.ServerInfo.ServerVersion != nil &&
 		conf.ServerInfo.ServerVersion.Compare(*tableSampleVersion) >= 0 {
 		d.L().Debug("dumping TiDB tables with TABLESAMPLE",
 			zap.String("database", db), zap.String("table", tbl))
 		return d.concurrentDumpTiDBTables(conn, meta, taskChan)
 	}
-	field, err := pickupPossibleField(db, tbl, conn, conf)
+	field, err := d.pickupPossibleField(conn, db, tbl)
 	if err != nil {
 		return nil
 	}
@@ -345,7 +770,7 @@ func (d *Dumper) concurrentDumpTable(conn *sql.Conn, meta TableMeta, taskChan ch
 		return d.sequentialDumpTable(conn, meta, taskChan)
 	}
 
-	min, max, err := d.selectMinAndMaxIntValue(conn, db, tbl, field)
+	min, max, err := d.selectMinAndMaxIntValue(conn, db, tbl, partition, field)
 	if err != nil {
 		return err
 	}
@@ -353,10 +778,12 @@ func (d *Dumper) concurrentDumpTable(conn *sql.Conn, meta TableMeta, taskChan ch
 		zap.String("lower", min.String()),
 		zap.String("upper", max.String()))
 
-	count := estimateCount(d.tctx, db, tbl, conn, field, conf)
+	tableWhere := d.tableWhere(db, tbl)
+	count := estimateCount(d.tctx, db, tbl, partition, conn, field, tableWhere)
 	d.L().Info("get estimated rows count",
 		zap.String("database", db),
 		zap.String("table", tbl),
+		zap.String("partition", partition),
 		zap.Uint64("estimateCount", count))
 	if count < conf.Rows {
 		// skip chunk logic if estimates are low
@@ -378,12 +805,12 @@ func (d *Dumper) concurrentDumpTable(conn *sql.Conn, meta TableMeta, taskChan ch
 		totalChunks = new(big.Int).Sub(max, min).Uint64()
 	}
 
-	selectField, selectLen, err := buildSelectField(conn, db, tbl, conf.CompleteInsert)
+	selectField, selectLen, err := d.buildSelectField(conn, db, tbl)
 	if err != nil {
 		return err
 	}
 
-	orderByClause, err := buildOrderByClause(conf, conn, db, tbl)
+	orderByClause, err := d.buildOrderByClause(conn, db, tbl)
 	if err != nil {
 		return err
 	}
@@ -393,7 +820,7 @@ func (d *Dumper) concurrentDumpTable(conn *sql.Conn, meta TableMeta, taskChan ch
 	for max.Cmp(cutoff) >= 0 {
 		nextCutOff := new(big.Int).Add(cutoff, bigEstimatedStep)
 		where := fmt.Sprintf("%s(`%s` >= %d AND `%s` < %d)", nullValueCondition, escapeString(field), cutoff, escapeString(field), nextCutOff)
-		query := buildSelectQuery(db, tbl, selectField, buildWhereCondition(conf, where), orderByClause)
+		query := buildSelectPartitionQuery(db, tbl, partition, selectField, buildWhereCondition(tableWhere, where), orderByClause)
 		if len(nullValueCondition) > 0 {
 			nullValueCondition = ""
 		}
@@ -421,13 +848,15 @@ func (d *Dumper) sendTaskToChan(task Task, taskChan chan<- Task) (ctxDone bool)
 	}
 }
 
-func (d *Dumper) selectMinAndMaxIntValue(conn *sql.Conn, db, tbl, field string) (*big.Int, *big.Int, error) {
-	tctx, conf, zero := d.tctx, d.conf, &big.Int{}
-	query := fmt.Sprintf("SELECT MIN(`%s`),MAX(`%s`) FROM `%s`.`%s`",
-		escapeString(field), escapeString(field), escapeString(db), escapeString(tbl))
-	if conf.Where != "" {
-		query = fmt.Sprintf("%s WHERE %s", query, conf.Where)
+// selectMinAndMaxIntValue selects the bounding values of the field in the partition of the table, or the whole table if partition is empty
+func (d *Dumper) selectMinAndMaxIntValue(conn *sql.Conn, db, tbl, partition, field string) (*big.Int, *big.Int, error) {
+	tctx, zero := d.tctx, &big.Int{}
+	fields := fmt.Sprintf("MIN(`%s`),MAX(`%s`)", escapeString(field), escapeString(field))
+	var where string
+	if tableWhere := d.tableWhere(db, tbl); tableWhere != "" {
+		where = "WHERE " + tableWhere
 	}
+	query := buildSelectPartitionQuery(db, tbl, partition, fields, where, "")
 	tctx.L().Debug("split chunks", zap.String("query", query))
 
 	var smin sql.NullString
@@ -457,25 +886,25 @@ func (d *Dumper) selectMinAndMaxIntValue(conn *sql.Conn, db, tbl, field string)
 }
 
 func (d *Dumper) concurrentDumpTiDBTables(conn *sql.Conn, meta TableMeta, taskChan chan<- Task) error {
-	conf := d.conf
 	db, tbl := meta.DatabaseName(), meta.TableName()
 
-	handleColNames, handleVals, err := selectTiDBTableSample(conn, db, tbl)
+	handleColNames, handleVals, err := d.selectTiDBTableSample(conn, db, tbl)
 	if err != nil {
 		return err
 	}
 	if len(handleVals) == 0 {
 		return nil
 	}
-	selectField, selectLen, err := buildSelectField(conn, db, tbl, conf.CompleteInsert)
+	selectField, selectLen, err := d.buildSelectField(conn, db, tbl)
 	if err != nil {
 		return err
 	}
 	where := buildWhereClauses(handleColNames, handleVals)
 	orderByClause := buildOrderByClauseString(handleColNames)
+	tableWhere := d.tableWhere(db, tbl)
 
 	for i, w := range where {
-		query := buildSelectQuery(db, tbl, selectField, buildWhereCondition(conf, w), orderByClause)
+		query := buildSelectQuery(db, tbl, selectField, buildWhereCondition(tableWhere, w), orderByClause)
 		task := NewTaskTableData(meta, newTableData(query, selectLen, false), i, len(where))
 		ctxDone := d.sendTaskToChan(task, taskChan)
 		if ctxDone {
@@ -490,12 +919,12 @@ func (d *Dumper) L() log.Logger {
 	return d.tctx.L()
 }
 
-func selectTiDBTableSample(conn *sql.Conn, dbName, tableName string) (pkFields []string, pkVals []string, err error) {
-	pkFields, pkColTypes, err := GetPrimaryKeyAndColumnTypes(conn, dbName, tableName)
+func (d *Dumper) selectTiDBTableSample(conn *sql.Conn, dbName, tableName string) (pkFields []string, pkVals []string, err error) {
+	pkFields, pkColTypes, err := d.getPrimaryKeyAndColumnTypes(conn, dbName, tableName)
 	if err != nil {
 		return nil, nil, errors.Trace(err)
 	}
-	hasImplicitRowID, err := SelectTiDBRowID(conn, dbName, tableName)
+	hasImplicitRowID, err := d.selectTiDBRowID(conn, dbName, tableName)
 	if err != nil {
 		return nil, nil, errors.Trace(err)
 	}
@@ -557,9 +986,10 @@ func prepareTableListToDump(tctx *tcontext.Context, conf *Config, db *sql.Conn)
 	return nil
 }
 
-func dumpTableMeta(conf *Config, conn *sql.Conn, db string, table *TableInfo) (TableMeta, error) {
+func (d *Dumper) dumpTableMeta(conn *sql.Conn, db string, table *TableInfo) (TableMeta, error) {
+	conf := d.conf
 	tbl := table.Name
-	selectField, _, err := buildSelectField(conn, db, tbl, conf.CompleteInsert)
+	selectField, _, err := d.buildSelectField(conn, db, tbl)
 	if err != nil {
 		return nil, err
 	}
@@ -579,10 +1009,8 @@ func dumpTableMeta(conf *Config, conn *sql.Conn, db string, table *TableInfo) (T
 		database:      db,
 		table:         tbl,
 		colTypes:      colTypes,
-		selectedField: selectField,
-		specCmts: []string{
-			"/*!40101 SET NAMES binary*/;",
-		},
+		selectedField: d.routes.insertField(db, tbl, selectField),
+		specCmts:      tableDataSpecialComments(conf),
 	}
 
 	if conf.NoSchemas {
@@ -595,7 +1023,7 @@ func dumpTableMeta(conf *Config, conn *sql.Conn, db string, table *TableInfo) (T
 			return meta, err1
 		}
 		meta.showCreateTable = createTableSQL
-		meta.showCreateView = createViewSQL
+		meta.showCreateView = rewriteDefiner(conf, createViewSQL)
 		return meta, nil
 	}
 	createTableSQL, err := ShowCreateTable(conn, db, tbl)
@@ -616,8 +1044,11 @@ func (d *Dumper) dumpSQL(taskChan chan<- Task) {
 
 func canRebuildConn(consistency string, trxConsistencyOnly bool) bool {
 	switch consistency {
-	case consistencyTypeLock, consistencyTypeFlush:
+	case consistencyTypeLock, consistencyTypeFlush, consistencyTypeBackupStage, consistencyTypeReplica:
 		return !trxConsistencyOnly
+	case consistencyTypeBackupLock:
+		// DML isn't blocked by the backup lock, a rebuilt connection would get another snapshot
+		return false
 	case consistencyTypeSnapshot, consistencyTypeNone:
 		return true
 	default:
@@ -652,6 +1083,7 @@ func initLogger(d *Dumper) error {
 			Level:  conf.LogLevel,
 			File:   conf.LogFile,
 			Format: conf.LogFormat,
+			Stderr: conf.OutputDirPath == outputToStdout,
 		})
 		if err != nil {
 			return errors.Trace(err)
@@ -665,6 +1097,14 @@ func initLogger(d *Dumper) error {
 // createExternalStore is an initialization step of Dumper.
 func createExternalStore(d *Dumper) error {
 	tctx, conf := d.tctx, d.conf
+	switch {
+	case conf.PipeCommand != "":
+		d.extStore = newPipeCommandStorage(conf.PipeCommand)
+		return nil
+	case conf.OutputDirPath == outputToStdout:
+		d.extStore = newStdoutStorage(os.Stdout)
+		return nil
+	}
 	b, err := storage.ParseBackend(conf.OutputDirPath, &conf.BackendOptions)
 	if err != nil {
 		return errors.Trace(err)
@@ -673,6 +1113,9 @@ func createExternalStore(d *Dumper) error {
 	if err != nil {
 		return errors.Trace(err)
 	}
+	if local := b.GetLocal(); local != nil {
+		extStore = newAtomicLocalStorage(extStore, local.Path)
+	}
 	d.extStore = extStore
 	return nil
 }
@@ -723,7 +1166,13 @@ func resolveAutoConsistency(d *Dumper) error {
 	switch conf.ServerInfo.ServerType {
 	case ServerTypeTiDB:
 		conf.Consistency = "snapshot"
-	case ServerTypeMySQL, ServerTypeMariaDB:
+	case ServerTypeMariaDB:
+		if conf.ServerInfo.ServerVersion != nil && !conf.ServerInfo.ServerVersion.LessThan(*backupStageVersion) {
+			conf.Consistency = consistencyTypeBackupStage
+		} else {
+			conf.Consistency = "flush"
+		}
+	case ServerTypeMySQL:
 		conf.Consistency = "flush"
 	default:
 		conf.Consistency = "none"
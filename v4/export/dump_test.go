@@ -0,0 +1,164 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package export
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/coreos/go-semver/semver"
+	. "github.com/pingcap/check"
+)
+
+var _ = Suite(&testDumpSuite{})
+
+type testDumpSuite struct{}
+
+// cacheTestSchemas fills the schema cache so that the tables can be split without any query
+func cacheTestSchemas(d *Dumper, db string, tables ...string) {
+	s := &databaseSchema{loaded: make(chan struct{}), tables: make(map[string]*tableSchema)}
+	close(s.loaded)
+	for _, t := range tables {
+		s.tables[t] = &tableSchema{columns: []columnInfo{{name: "id", dataType: "int", key: "PRI"}}, pkColumns: []string{"id"}}
+	}
+	d.schemas.dbs[db] = s
+}
+
+func (s *testDumpSuite) TestTaskProducers(c *C) {
+	conns := make([]*sql.Conn, 0, 2)
+	for i := 0; i < 2; i++ {
+		db, _, err := sqlmock.New()
+		c.Assert(err, IsNil)
+		defer db.Close()
+		conn, err := db.Conn(context.Background())
+		c.Assert(err, IsNil)
+		conns = append(conns, conn)
+	}
+	d := newDumperForSchemaTest(c)
+	cacheTestSchemas(d, "test", "t1", "t2", "t3", "t4")
+
+	taskChan := make(chan Task, 1)
+	tables := make(chan []string)
+	go func() {
+		var names []string
+		for task := range taskChan {
+			td := task.(*TaskTableData)
+			names = append(names, td.Meta.TableName()+": "+td.Data.(*tableData).query)
+		}
+		sort.Strings(names)
+		tables <- names
+	}()
+	producers := d.startProducers(nil, conns, taskChan)
+	for _, t := range []string{"t1", "t2", "t3", "t4"} {
+		c.Assert(producers.dumpTableData(&tableMeta{database: "test", table: t}), IsTrue)
+	}
+	c.Assert(producers.wait(), IsNil)
+	close(taskChan)
+	c.Assert(<-tables, DeepEquals, []string{
+		"t1: SELECT * FROM `test`.`t1` ORDER BY `id`",
+		"t2: SELECT * FROM `test`.`t2` ORDER BY `id`",
+		"t3: SELECT * FROM `test`.`t3` ORDER BY `id`",
+		"t4: SELECT * FROM `test`.`t4` ORDER BY `id`",
+	})
+
+	// the failed table is skipped with --continue-on-error
+	d.failures = newFailureRecorder()
+	taskChan = make(chan Task, 4)
+	producers = d.startProducers(nil, conns, taskChan)
+	c.Assert(producers.dumpTableData(&tableMeta{database: "unknown", table: "t"}), IsTrue)
+	c.Assert(producers.dumpTableData(&tableMeta{database: "test", table: "t1"}), IsTrue)
+	c.Assert(producers.wait(), IsNil)
+	c.Assert(taskChan, HasLen, 1)
+	c.Assert(d.failures.partialDumpError().Failures, HasLen, 1)
+
+	// otherwise the producers stop at the first failure
+	d.failures = nil
+	producers = d.startProducers(nil, conns, make(chan Task, 4))
+	c.Assert(producers.dumpTableData(&tableMeta{database: "unknown", table: "t"}), IsTrue)
+	<-producers.ctx.Done()
+	c.Assert(producers.dumpTableData(&tableMeta{database: "test", table: "t1"}), IsFalse)
+	c.Assert(producers.wait(), NotNil)
+
+	// the tables are dumped one by one if the output is a stream
+	d.conf.OutputDirPath = outputToStdout
+	taskChan = make(chan Task, 4)
+	producers = d.startProducers(nil, conns, taskChan)
+	for i, t := range []string{"t1", "t2", "t3", "t4"} {
+		c.Assert(producers.dumpTableData(&tableMeta{database: "test", table: t}), IsTrue)
+		c.Assert(taskChan, HasLen, i+1)
+	}
+	c.Assert(producers.wait(), IsNil)
+	close(taskChan)
+	for _, t := range []string{"t1", "t2", "t3", "t4"} {
+		c.Assert((<-taskChan).(*TaskTableData).Meta.TableName(), Equals, t)
+	}
+
+	// without producers, the tables are split on the meta connection one by one
+	d.conf.OutputDirPath = ""
+	taskChan = make(chan Task, 4)
+	producers = d.startProducers(conns[0], nil, taskChan)
+	for i, t := range []string{"t1", "t2"} {
+		c.Assert(producers.dumpTableData(&tableMeta{database: "test", table: t}), IsTrue)
+		c.Assert(taskChan, HasLen, i+1)
+	}
+	c.Assert(producers.dumpTableData(&tableMeta{database: "unknown", table: "t"}), IsFalse)
+	c.Assert(producers.dumpTableData(&tableMeta{database: "test", table: "t3"}), IsFalse)
+	c.Assert(producers.wait(), NotNil)
+	c.Assert(taskChan, HasLen, 2)
+}
+
+func (s *testDumpSuite) TestSetupConsistencyRetry(c *C) {
+	db, mock, err := sqlmock.New()
+	c.Assert(err, IsNil)
+	defer db.Close()
+	d := newDumperForSchemaTest(c)
+	d.dbHandle = db
+	d.conf.Consistency = consistencyTypeBackupLock
+	d.conf.ServerInfo = ServerInfo{ServerType: ServerTypeMySQL, ServerVersion: semver.New("8.0.22")}
+	d.conf.Threads, d.conf.SchemaThreads, d.conf.ProducerThreads = 1, 0, 0
+	resultOk := sqlmock.NewResult(0, 1)
+	logStatus := func(pos int) *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"LOCAL"}).
+			AddRow(fmt.Sprintf(`{"gtid_executed": "", "binary_log_file": "binlog.000002", "binary_log_position": %d}`, pos))
+	}
+	expectSetup := func(from, to int) {
+		mock.ExpectExec("LOCK INSTANCE FOR BACKUP").WillReturnResult(resultOk)
+		mock.ExpectQuery("SELECT LOCAL FROM performance_schema.log_status").WillReturnRows(logStatus(from))
+		// the meta connection and the writer connection
+		for i := 0; i < 2; i++ {
+			mock.ExpectExec("SET SESSION TRANSACTION ISOLATION LEVEL REPEATABLE READ").WillReturnResult(resultOk)
+			mock.ExpectExec("START TRANSACTION").WillReturnResult(resultOk)
+		}
+		mock.ExpectQuery("SELECT LOCAL FROM performance_schema.log_status").WillReturnRows(logStatus(to))
+	}
+
+	// the transactions are started again if the binlog position moves while they are starting
+	expectSetup(7502, 7840)
+	mock.ExpectExec("UNLOCK INSTANCE").WillReturnResult(resultOk)
+	expectSetup(7840, 7840)
+	conCtrl, err := NewConsistencyController(d.tctx, d.conf, db)
+	c.Assert(err, IsNil)
+	conns, err := d.setupConsistency(d.tctx, conCtrl)
+	c.Assert(err, IsNil)
+	c.Assert(conns.writers, HasLen, 1)
+	c.Assert(conCtrl.(binlogPositionProvider).BinlogPosition().pos, Equals, "7840")
+	conns.close()
+	mock.ExpectExec("UNLOCK INSTANCE").WillReturnResult(resultOk)
+	c.Assert(conCtrl.TearDown(d.tctx), IsNil)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+
+	// the dump fails if the position keeps moving
+	conCtrl, err = NewConsistencyController(d.tctx, d.conf, db)
+	c.Assert(err, IsNil)
+	for i := 0; i < snapshotsRetryTime; i++ {
+		expectSetup(7840+i, 7841+i)
+		mock.ExpectExec("UNLOCK INSTANCE").WillReturnResult(resultOk)
+	}
+	_, err = d.setupConsistency(d.tctx, conCtrl)
+	c.Assert(err, ErrorMatches, "the dumped data can't match any binlog position after 5 attempts.*")
+	c.Assert(conCtrl.TearDown(d.tctx), IsNil)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
@@ -0,0 +1,212 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package export
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+
+	tcontext "github.com/pingcap/dumpling/v4/context"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/errors"
+	"go.uber.org/zap"
+)
+
+const errorReportPath = "error-report.json"
+
+// TableFailure is a failure of dumping a table, which is skipped with --continue-on-error
+type TableFailure struct {
+	Database string `json:"database"`
+	// Table is empty if the whole database is skipped
+	Table string `json:"table,omitempty"`
+	// Chunk is the index of the failed chunk of table data, or -1 if the failure isn't about the table data.
+	// The whole table is failed if any chunk fails, all the files written for it are removed
+	Chunk int    `json:"chunk"`
+	SQL   string `json:"sql,omitempty"`
+	Error string `json:"error"`
+	// Files is the files of the failed table which can't be removed from the storage, they shouldn't be loaded
+	Files []string `json:"files,omitempty"`
+}
+
+// PartialDumpError is returned by Dumper.Dump if some tables fail to dump with --continue-on-error,
+// while all the other tables are dumped successfully
+type PartialDumpError struct {
+	Failures []TableFailure
+}
+
+// Error implements error.Error
+func (e *PartialDumpError) Error() string {
+	databases := make(map[string]struct{})
+	tables := make(map[tableKey]struct{}, len(e.Failures))
+	for _, f := range e.Failures {
+		if f.Table == "" {
+			databases[f.Database] = struct{}{}
+		} else {
+			tables[tableKey{db: f.Database, table: f.Table}] = struct{}{}
+		}
+	}
+	var failed string
+	switch {
+	case len(databases) == 0:
+		failed = fmt.Sprintf("%d tables", len(tables))
+	case len(tables) == 0:
+		failed = fmt.Sprintf("%d databases", len(databases))
+	default:
+		failed = fmt.Sprintf("%d databases and %d tables", len(databases), len(tables))
+	}
+	return fmt.Sprintf("%s failed to dump, see %s for details", failed, errorReportPath)
+}
+
+// isConnectionError returns true if the error is caused by a broken connection. The connection can't dump the other tables,
+// so the error stops the dump instead of being skipped with --continue-on-error
+func isConnectionError(err error) bool {
+	if isWriterError(err) {
+		return false
+	}
+	err = errors.Cause(err)
+	if err == driver.ErrBadConn || err == mysql.ErrInvalidConn {
+		return true
+	}
+	_, ok := err.(net.Error)
+	return ok
+}
+
+// failureRecorder records the failures of the tables skipped with --continue-on-error
+type failureRecorder struct {
+	mu       sync.Mutex
+	failures []TableFailure
+	// files is the files written for the tables, which are removed if the table fails.
+	// The schema files of the databases are recorded with an empty table
+	files map[tableKey][]string
+}
+
+func newFailureRecorder() *failureRecorder {
+	return &failureRecorder{files: make(map[tableKey][]string)}
+}
+
+// addFile records the data file of the table before it's written, meta is the source table if the table is routed
+func (r *failureRecorder) addFile(meta TableMeta, name string) {
+	if rt, ok := meta.(*routedTableMeta); ok {
+		meta = rt.TableMeta
+	}
+	r.addTableFile(meta.DatabaseName(), meta.TableName(), name)
+}
+
+// addTableFile records the file of the table before it's written, table is empty for the schema file of the database
+func (r *failureRecorder) addTableFile(db, table, name string) {
+	key := tableKey{db: db, table: table}
+	r.mu.Lock()
+	r.files[key] = append(r.files[key], name)
+	r.mu.Unlock()
+}
+
+// removeFailedTableFiles removes the files of the failed tables, so a table missing some chunks or its schema
+// doesn't look loadable. All the files in a failed database are removed. The files which can't be removed are
+// recorded in the failures
+func (r *failureRecorder) removeFailedTableFiles(tctx *tcontext.Context, s storage.ExternalStorage) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	remover, canRemove := s.(fileRemover)
+	for i, f := range r.failures {
+		var tables []tableKey
+		if f.Table == "" {
+			for table := range r.files {
+				if table.db == f.Database {
+					tables = append(tables, table)
+				}
+			}
+			sort.Slice(tables, func(i, j int) bool { return tables[i].table < tables[j].table })
+		} else {
+			tables = []tableKey{{db: f.Database, table: f.Table}}
+		}
+		for _, table := range tables {
+			files := r.files[table]
+			delete(r.files, table)
+			for _, name := range files {
+				if canRemove {
+					err := remover.removeFile(name)
+					if err == nil {
+						continue
+					}
+					tctx.L().Warn("fail to remove the file of the failed table", zap.String("file", name), zap.Error(err))
+				}
+				r.failures[i].Files = append(r.failures[i].Files, name)
+			}
+		}
+	}
+}
+
+func (r *failureRecorder) record(tctx *tcontext.Context, failure TableFailure) {
+	tctx.L().Warn("skip the failed table",
+		zap.String("database", failure.Database),
+		zap.String("table", failure.Table),
+		zap.Int("chunk", failure.Chunk),
+		zap.String("error", failure.Error))
+	r.mu.Lock()
+	r.failures = append(r.failures, failure)
+	r.mu.Unlock()
+}
+
+func (r *failureRecorder) recordTask(tctx *tcontext.Context, task Task, err error) {
+	failure := TableFailure{Chunk: -1, Error: err.Error()}
+	switch t := task.(type) {
+	case *TaskDatabaseMeta:
+		failure.Database = t.DatabaseName
+	case *TaskTableMeta:
+		failure.Database, failure.Table = t.DatabaseName, t.TableName
+	case *TaskViewMeta:
+		failure.Database, failure.Table = t.DatabaseName, t.ViewName
+	case *TaskTableData:
+		failure.Database, failure.Table, failure.Chunk = t.Meta.DatabaseName(), t.Meta.TableName(), t.ChunkIndex
+		if td, ok := t.Data.(*tableData); ok {
+			failure.SQL = td.query
+		}
+	}
+	r.record(tctx, failure)
+}
+
+// partialDumpError returns nil if no table fails
+func (r *failureRecorder) partialDumpError() *PartialDumpError {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.failures) == 0 {
+		return nil
+	}
+	failures := append([]TableFailure(nil), r.failures...)
+	sort.SliceStable(failures, func(i, j int) bool {
+		if failures[i].Database != failures[j].Database {
+			return failures[i].Database < failures[j].Database
+		}
+		if failures[i].Table != failures[j].Table {
+			return failures[i].Table < failures[j].Table
+		}
+		return failures[i].Chunk < failures[j].Chunk
+	})
+	return &PartialDumpError{Failures: failures}
+}
+
+func writeErrorReport(tctx *tcontext.Context, s storage.ExternalStorage, e *PartialDumpError) error {
+	report, err := json.MarshalIndent(e.Failures, "", "  ")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if _, ok := s.(*stdoutStorage); ok {
+		tctx.L().Warn("error report", zap.ByteString("report", report))
+		return nil
+	}
+	fileWriter, tearDown, err := buildFileWriter(tctx, s, errorReportPath, storage.NoCompression)
+	if err != nil {
+		return err
+	}
+	err = writeBytes(tctx, fileWriter, append(report, '\n'))
+	if err1 := tearDown(tctx); err == nil {
+		err = err1
+	}
+	return err
+}
@@ -0,0 +1,183 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package export
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"path"
+	"regexp"
+
+	tcontext "github.com/pingcap/dumpling/v4/context"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/pingcap/br/pkg/storage"
+	. "github.com/pingcap/check"
+)
+
+var _ = Suite(&testErrorReportSuite{})
+
+type testErrorReportSuite struct{}
+
+func (s *testErrorReportSuite) TestSkipFailedTasks(c *C) {
+	dir := c.MkDir()
+	tctx := tcontext.Background()
+	conf := defaultConfigForTest(c)
+	conf.OutputDirPath = dir
+
+	db, mock, err := sqlmock.New()
+	c.Assert(err, IsNil)
+	defer db.Close()
+	conn, err := db.Conn(context.Background())
+	c.Assert(err, IsNil)
+	b, err := storage.ParseBackend(dir, &conf.BackendOptions)
+	c.Assert(err, IsNil)
+	extStore, err := storage.Create(context.Background(), b, false)
+	c.Assert(err, IsNil)
+
+	failures := newFailureRecorder()
+	writer := NewWriter(tctx, 0, conf, conn, extStore)
+	writer.setTaskFailedCallBack(func(task Task, err error) {
+		failures.recordTask(tctx, task, err)
+	})
+
+	const failedQuery = "SELECT * FROM `test`.`t1`"
+	mock.ExpectQuery(regexp.QuoteMeta(failedQuery)).WillReturnError(errors.New("SELECT command denied"))
+	failedMeta := newMockTableIR("test", "t1", nil, nil, []string{"INT"})
+	okIR := newMockTableIR("test", "t2", [][]driver.Value{{"1"}}, nil, []string{"INT"})
+	taskChan := make(chan Task, 3)
+	taskChan <- NewTaskTableData(failedMeta, newTableData(failedQuery, 1, false), 0, 1)
+	taskChan <- NewTaskTableData(okIR, okIR, 0, 1)
+	close(taskChan)
+	c.Assert(writer.run(taskChan), IsNil)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+
+	content, err := ioutil.ReadFile(path.Join(dir, "test.t2.000000000.sql"))
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "INSERT INTO `t2` VALUES\n(1);\n")
+
+	failures.record(tctx, TableFailure{Database: "test", Table: "t0", Chunk: -1, Error: "mock error"})
+	failures.record(tctx, TableFailure{Database: "db0", Chunk: -1, Error: "mock error"})
+	partialErr := failures.partialDumpError()
+	c.Assert(partialErr, NotNil)
+	c.Assert(partialErr.Error(), Equals, "1 databases and 2 tables failed to dump, see error-report.json for details")
+	partialErr.Failures = partialErr.Failures[1:]
+	c.Assert(partialErr.Failures[0].Table, Equals, "t0")
+	c.Assert(partialErr.Failures[1], DeepEquals, TableFailure{
+		Database: "test",
+		Table:    "t1",
+		Chunk:    0,
+		SQL:      failedQuery,
+		Error:    partialErr.Failures[1].Error,
+	})
+	c.Assert(partialErr.Failures[1].Error, Matches, ".*SELECT command denied.*")
+
+	c.Assert(writeErrorReport(tctx, extStore, partialErr), IsNil)
+	content, err = ioutil.ReadFile(path.Join(dir, errorReportPath))
+	c.Assert(err, IsNil)
+	var report []TableFailure
+	c.Assert(json.Unmarshal(content, &report), IsNil)
+	c.Assert(report, DeepEquals, partialErr.Failures)
+
+	c.Assert(newFailureRecorder().partialDumpError(), IsNil)
+}
+
+func (s *testErrorReportSuite) TestConnectionErrorStopsWriter(c *C) {
+	tctx := tcontext.Background()
+	conf := defaultConfigForTest(c)
+	db, mock, err := sqlmock.New()
+	c.Assert(err, IsNil)
+	defer db.Close()
+	conn, err := db.Conn(context.Background())
+	c.Assert(err, IsNil)
+
+	failures := newFailureRecorder()
+	extStore, err := storage.NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+	writer := NewWriter(tctx, 0, conf, conn, extStore)
+	writer.setTaskFailedCallBack(func(task Task, err error) {
+		failures.recordTask(tctx, task, err)
+	})
+
+	// the broken connection can't dump the other tables, so the table isn't skipped
+	const query = "SELECT * FROM `test`.`t1`"
+	mock.ExpectQuery(regexp.QuoteMeta(query)).WillReturnError(mysql.ErrInvalidConn)
+	meta := newMockTableIR("test", "t1", nil, nil, []string{"INT"})
+	taskChan := make(chan Task, 1)
+	taskChan <- NewTaskTableData(meta, newTableData(query, 1, false), 0, 1)
+	close(taskChan)
+	c.Assert(writer.run(taskChan), ErrorMatches, ".*invalid connection.*")
+	c.Assert(failures.partialDumpError(), IsNil)
+
+	c.Assert(isConnectionError(driver.ErrBadConn), IsTrue)
+	c.Assert(isConnectionError(&net.OpError{Op: "read", Err: errors.New("connection reset by peer")}), IsTrue)
+	c.Assert(isConnectionError(&mysql.MySQLError{Number: 1142, Message: "SELECT command denied"}), IsFalse)
+	c.Assert(isConnectionError(newWriterError(&net.OpError{Op: "write", Err: errors.New("broken pipe")})), IsFalse)
+}
+
+func (s *testErrorReportSuite) TestRemoveFailedTableFiles(c *C) {
+	dir := c.MkDir()
+	tctx := tcontext.Background()
+	local, err := storage.NewLocalStorage(dir)
+	c.Assert(err, IsNil)
+	extStore := newAtomicLocalStorage(local, dir)
+	for _, name := range []string{"test.t1.000000000.sql", "test.t1.000000001.sql", "test.t2.000000000.sql"} {
+		c.Assert(extStore.WriteFile(context.Background(), name, []byte("INSERT INTO t VALUES (1);\n")), IsNil)
+	}
+
+	failures := newFailureRecorder()
+	t1 := newMockTableIR("test", "t1", nil, nil, []string{"INT"})
+	t2 := newMockTableIR("test", "t2", nil, nil, []string{"INT"})
+	failures.addFile(t1, "test.t1.000000000.sql")
+	failures.addFile(t1, "test.t1.000000001.sql")
+	failures.addFile(t1, "test.t1.000000002.sql")
+	failures.addFile(t2, "test.t2.000000000.sql")
+	failures.record(tctx, TableFailure{Database: "test", Table: "t1", Chunk: 2, Error: "mock error"})
+
+	// the other chunks of the failed table are removed, while the other tables are kept
+	failures.removeFailedTableFiles(tctx, extStore)
+	files, err := ioutil.ReadDir(dir)
+	c.Assert(err, IsNil)
+	c.Assert(files, HasLen, 1)
+	c.Assert(files[0].Name(), Equals, "test.t2.000000000.sql")
+	c.Assert(failures.partialDumpError().Failures[0].Files, IsNil)
+
+	// the files are recorded in the report if the storage can't remove them
+	failures = newFailureRecorder()
+	failures.addFile(t2, "test.t2.000000000.sql")
+	failures.record(tctx, TableFailure{Database: "test", Table: "t2", Chunk: 1, Error: "mock error"})
+	failures.removeFailedTableFiles(tctx, local)
+	c.Assert(failures.partialDumpError().Failures[0].Files, DeepEquals, []string{"test.t2.000000000.sql"})
+	c.Assert(failures.partialDumpError().Error(), Equals, "1 tables failed to dump, see error-report.json for details")
+
+	// the files written before the table fails to split or write its schema are removed too,
+	// and a failed database removes the files of all its tables
+	for _, name := range []string{"test-schema-create.sql", "test.t1-schema.sql", "test.t1.000000000.sql", "test.t2-schema.sql", "test.t2.000000000.sql"} {
+		c.Assert(extStore.WriteFile(context.Background(), name, []byte("mock\n")), IsNil)
+	}
+	failures = newFailureRecorder()
+	failures.addTableFile("test", "t1", "test.t1-schema.sql")
+	failures.addFile(t1, "test.t1.000000000.sql")
+	failures.record(tctx, TableFailure{Database: "test", Table: "t1", Chunk: -1, Error: "split table error"})
+	failures.removeFailedTableFiles(tctx, extStore)
+	files, err = ioutil.ReadDir(dir)
+	c.Assert(err, IsNil)
+	c.Assert(files, HasLen, 3)
+
+	failures = newFailureRecorder()
+	failures.addTableFile("test", "", "test-schema-create.sql")
+	failures.addTableFile("test", "t2", "test.t2-schema.sql")
+	failures.addFile(t2, "test.t2.000000000.sql")
+	failures.record(tctx, TableFailure{Database: "test", Chunk: -1, Error: "mock error"})
+	failures.removeFailedTableFiles(tctx, extStore)
+	files, err = ioutil.ReadDir(dir)
+	c.Assert(err, IsNil)
+	c.Assert(files, HasLen, 0)
+	c.Assert(failures.partialDumpError().Failures[0].Files, IsNil)
+	c.Assert(failures.partialDumpError().Error(), Equals, "1 databases failed to dump, see error-report.json for details")
+}
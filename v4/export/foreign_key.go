@@ -0,0 +1,254 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package export
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// tableKey identifies a table by its database and name
+type tableKey struct {
+	db, table string
+}
+
+func (k tableKey) String() string {
+	return fmt.Sprintf("`%s`.`%s`", escapeString(k.db), escapeString(k.table))
+}
+
+// foreignKey is a foreign key constraint from the columns of the child table to the referenced columns of the parent table
+type foreignKey struct {
+	name       string
+	child      tableKey
+	columns    []string
+	parent     tableKey
+	refColumns []string
+}
+
+// joinColumns returns the quoted column list like "`a`,`b`"
+func joinColumns(columns []string) string {
+	quoted := make([]string, len(columns))
+	for i, col := range columns {
+		quoted[i] = fmt.Sprintf("`%s`", escapeString(col))
+	}
+	return strings.Join(quoted, ",")
+}
+
+// quoteColumns returns the quoted column list like "(`a`,`b`)", which works with a row constructor of the same columns
+func quoteColumns(columns []string) string {
+	return "(" + joinColumns(columns) + ")"
+}
+
+// loadOrder is the order of loading the dumped tables, in which the parent tables come before their child tables
+type loadOrder struct {
+	tables []tableKey
+	// cycles are the groups of tables referencing each other by foreign keys, which can't be loaded in any order
+	// with the foreign key checks. The tables of a cycle are next to each other in tables
+	cycles [][]tableKey
+}
+
+func tableKeyNames(keys []tableKey) []string {
+	names := make([]string, len(keys))
+	for i, k := range keys {
+		names[i] = k.String()
+	}
+	return names
+}
+
+func sortTableKeys(keys []tableKey) {
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].db != keys[j].db {
+			return keys[i].db < keys[j].db
+		}
+		return keys[i].table < keys[j].table
+	})
+}
+
+// buildLoadOrder sorts the base tables topologically by the foreign keys. The tables without any dependency between them
+// are sorted by the names, so the same tables always get the same order. A foreign key referencing its own table is ignored
+func buildLoadOrder(tables DatabaseTables, fks []*foreignKey) *loadOrder {
+	nodes := make([]tableKey, 0, calculateTableCount(tables))
+	for dbName, infos := range tables {
+		for _, info := range infos {
+			if info.Type == TableTypeBase {
+				nodes = append(nodes, tableKey{db: dbName, table: info.Name})
+			}
+		}
+	}
+	sortTableKeys(nodes)
+	edges := make(map[tableKey][]tableKey)
+	for _, fk := range fks {
+		if fk.child != fk.parent {
+			edges[fk.parent] = append(edges[fk.parent], fk.child)
+		}
+	}
+	return sortTablesTopologically(nodes, edges)
+}
+
+// sortTablesTopologically sorts the nodes so that each node comes before the nodes of its edges. The nodes without any
+// dependency between them are sorted by the names. The nodes in a cycle are next to each other and reported in cycles
+func sortTablesTopologically(nodes []tableKey, edges map[tableKey][]tableKey) *loadOrder {
+	for _, next := range edges {
+		sortTableKeys(next)
+	}
+
+	// the strongly connected components are the cycles, and the order of them is a DAG
+	components := stronglyConnectedComponents(nodes, edges)
+	componentOf := make(map[tableKey]int, len(nodes))
+	for i, c := range components {
+		sortTableKeys(c)
+		for _, t := range c {
+			componentOf[t] = i
+		}
+	}
+	inDegree := make([]int, len(components))
+	next := make([]map[int]struct{}, len(components))
+	for i, c := range components {
+		next[i] = make(map[int]struct{})
+		for _, t := range c {
+			for _, child := range edges[t] {
+				j := componentOf[child]
+				if _, ok := next[i][j]; j != i && !ok {
+					next[i][j] = struct{}{}
+					inDegree[j]++
+				}
+			}
+		}
+	}
+	order := &loadOrder{tables: make([]tableKey, 0, len(nodes))}
+	var ready []int
+	for i := range components {
+		if inDegree[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+	for len(ready) > 0 {
+		sort.Slice(ready, func(i, j int) bool {
+			a, b := components[ready[i]][0], components[ready[j]][0]
+			return a.db < b.db || (a.db == b.db && a.table < b.table)
+		})
+		var layer []int
+		for _, i := range ready {
+			order.tables = append(order.tables, components[i]...)
+			if len(components[i]) > 1 {
+				order.cycles = append(order.cycles, components[i])
+			}
+			for j := range next[i] {
+				inDegree[j]--
+				if inDegree[j] == 0 {
+					layer = append(layer, j)
+				}
+			}
+		}
+		ready = layer
+	}
+	return order
+}
+
+// stronglyConnectedComponents finds the strongly connected components of the graph by Tarjan's algorithm
+func stronglyConnectedComponents(nodes []tableKey, edges map[tableKey][]tableKey) [][]tableKey {
+	var (
+		index      int
+		indices    = make(map[tableKey]int, len(nodes))
+		lowLinks   = make(map[tableKey]int, len(nodes))
+		onStack    = make(map[tableKey]bool, len(nodes))
+		stack      []tableKey
+		components [][]tableKey
+		connect    func(v tableKey)
+	)
+	connect = func(v tableKey) {
+		indices[v], lowLinks[v] = index, index
+		index++
+		stack = append(stack, v)
+		onStack[v] = true
+		for _, w := range edges[v] {
+			if _, visited := indices[w]; !visited {
+				connect(w)
+				if lowLinks[w] < lowLinks[v] {
+					lowLinks[v] = lowLinks[w]
+				}
+			} else if onStack[w] && indices[w] < lowLinks[v] {
+				lowLinks[v] = indices[w]
+			}
+		}
+		if lowLinks[v] != indices[v] {
+			return
+		}
+		var component []tableKey
+		for {
+			w := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[w] = false
+			component = append(component, w)
+			if w == v {
+				break
+			}
+		}
+		components = append(components, component)
+	}
+	for _, v := range nodes {
+		if _, visited := indices[v]; !visited {
+			connect(v)
+		}
+	}
+	return components
+}
+
+// writeLoadOrder writes the load order and the foreign key cycles in metadata
+func writeLoadOrder(buffer *bytes.Buffer, order *loadOrder) {
+	buffer.WriteString("LOAD ORDER:\n")
+	for _, t := range order.tables {
+		fmt.Fprintf(buffer, "\t%s\n", t)
+	}
+	buffer.WriteString("\n")
+	if len(order.cycles) == 0 {
+		return
+	}
+	buffer.WriteString("FOREIGN KEY CYCLES:\n")
+	for _, cycle := range order.cycles {
+		fmt.Fprintf(buffer, "\t%s\n", strings.Join(tableKeyNames(cycle), ","))
+	}
+	buffer.WriteString("\n")
+}
+
+// recordLoadOrder records the load order of the dumped tables in metadata if there are any foreign keys between them,
+// and reports the foreign key cycles, whose tables can only be loaded with the foreign key checks disabled
+func (d *Dumper) recordLoadOrder(m *globalMetadata, fks []*foreignKey) {
+	if len(fks) == 0 {
+		return
+	}
+	order := buildLoadOrder(d.conf.Tables, fks)
+	for _, cycle := range order.cycles {
+		d.L().Warn("found tables referencing each other by foreign keys, please load them with the foreign key checks disabled",
+			zap.Strings("tables", tableKeyNames(cycle)))
+	}
+	m.recordLoadOrder(order)
+}
+
+// tableDataSpecialComments returns the special comments in the head of the SQL data files
+func tableDataSpecialComments(conf *Config) []string {
+	if conf.SingleFile {
+		// the session variables are set in the header of the single file
+		return nil
+	}
+	if conf.postgresDialect() {
+		specCmts := postgresSpecialComments()
+		if conf.DisableForeignKeyChecks {
+			// the triggers checking the foreign keys aren't fired in the replica role
+			specCmts = append(specCmts, "SET session_replication_role = replica;")
+		}
+		return specCmts
+	}
+	specCmts := []string{"/*!40101 SET NAMES binary*/;"}
+	if conf.DisableForeignKeyChecks {
+		specCmts = append(specCmts, "/*!40014 SET FOREIGN_KEY_CHECKS=0*/;")
+	}
+	if conf.DisableUniqueChecks {
+		specCmts = append(specCmts, "/*!40014 SET UNIQUE_CHECKS=0*/;")
+	}
+	return specCmts
+}
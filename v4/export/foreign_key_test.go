@@ -0,0 +1,63 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package export
+
+import (
+	"bytes"
+
+	. "github.com/pingcap/check"
+)
+
+var _ = Suite(&testForeignKeySuite{})
+
+type testForeignKeySuite struct{}
+
+func (s *testForeignKeySuite) TestBuildLoadOrder(c *C) {
+	key := func(table string) tableKey {
+		return tableKey{db: "test", table: table}
+	}
+	fk := func(child, parent string) *foreignKey {
+		return &foreignKey{child: key(child), parent: key(parent)}
+	}
+	tables := NewDatabaseTables().
+		AppendTables("test", "items", "orders", "users", "a", "b", "c", "s").
+		AppendViews("test", "v")
+	order := buildLoadOrder(tables, []*foreignKey{
+		fk("items", "orders"), fk("orders", "users"),
+		fk("a", "b"), fk("b", "a"), fk("c", "a"),
+		// a table referencing itself doesn't affect the order
+		fk("s", "s"),
+	})
+	c.Assert(order.tables, DeepEquals, []tableKey{
+		key("a"), key("b"), key("s"), key("users"),
+		key("c"), key("orders"),
+		key("items"),
+	})
+	c.Assert(order.cycles, DeepEquals, [][]tableKey{{key("a"), key("b")}})
+
+	var buffer bytes.Buffer
+	writeLoadOrder(&buffer, order)
+	c.Assert(buffer.String(), Equals, "LOAD ORDER:\n"+
+		"\t`test`.`a`\n\t`test`.`b`\n\t`test`.`s`\n\t`test`.`users`\n\t`test`.`c`\n\t`test`.`orders`\n\t`test`.`items`\n\n"+
+		"FOREIGN KEY CYCLES:\n"+
+		"\t`test`.`a`,`test`.`b`\n\n")
+
+	order = buildLoadOrder(tables, []*foreignKey{fk("items", "orders")})
+	c.Assert(order.tables[len(order.tables)-1], Equals, key("items"))
+	c.Assert(order.cycles, HasLen, 0)
+	buffer.Reset()
+	writeLoadOrder(&buffer, &loadOrder{tables: []tableKey{key("orders"), key("items")}})
+	c.Assert(buffer.String(), Equals, "LOAD ORDER:\n\t`test`.`orders`\n\t`test`.`items`\n\n")
+}
+
+func (s *testForeignKeySuite) TestTableDataSpecialComments(c *C) {
+	conf := DefaultConfig()
+	c.Assert(tableDataSpecialComments(conf), DeepEquals, []string{"/*!40101 SET NAMES binary*/;"})
+	conf.DisableForeignKeyChecks = true
+	conf.DisableUniqueChecks = true
+	c.Assert(tableDataSpecialComments(conf), DeepEquals, []string{
+		"/*!40101 SET NAMES binary*/;",
+		"/*!40014 SET FOREIGN_KEY_CHECKS=0*/;",
+		"/*!40014 SET UNIQUE_CHECKS=0*/;",
+	})
+}
@@ -49,10 +49,12 @@ type RowReceiverStringer interface {
 	Stringer
 }
 
-// Stringer is an interface which represents sql types that support writing to buffer in sql/csv type
+// Stringer is an interface which represents sql types that support writing to buffer in sql/csv/PostgreSQL type
 type Stringer interface {
 	WriteToBuffer(*bytes.Buffer, bool)
 	WriteToBufferInCsv(*bytes.Buffer, bool, *csvOption)
+	WriteToBufferInPostgres(*bytes.Buffer)
+	WriteToBufferInCopy(*bytes.Buffer)
 }
 
 // RowReceiver is an interface which represents sql types that support bind address for *sql.Rows
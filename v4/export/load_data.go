@@ -0,0 +1,147 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package export
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/errors"
+	"go.uber.org/zap"
+)
+
+// loadDataScripts collects the CSV files of the tables, and writes a LOAD DATA script of each table after they're dumped
+type loadDataScripts struct {
+	mu     sync.Mutex
+	tables map[tableKey]*loadDataTable
+}
+
+type loadDataTable struct {
+	meta  TableMeta
+	files map[string]struct{}
+}
+
+func newLoadDataScripts() *loadDataScripts {
+	return &loadDataScripts{tables: make(map[tableKey]*loadDataTable)}
+}
+
+func validateLoadDataScripts(conf *Config) error {
+	if !conf.LoadDataScripts {
+		return nil
+	}
+	if conf.FileType != FileFormatCSVString {
+		return errors.Errorf("--load-data-scripts only supports --filetype csv, but got %s", conf.FileType)
+	}
+	if conf.SQL != "" {
+		return errors.New("can't specify both --sql and --load-data-scripts at the same time")
+	}
+	if conf.CompressType != storage.NoCompression {
+		return errors.New("--load-data-scripts can't be used together with --compress, LOAD DATA only reads the plain files")
+	}
+	if len(conf.CsvDelimiter) > 1 {
+		return errors.Errorf("--load-data-scripts needs the --csv-delimiter of at most one character, but got '%s'", conf.CsvDelimiter)
+	}
+	return nil
+}
+
+// addFiles records the CSV files written for the chunk of the table, the files of the retried chunks are recorded once
+func (s *loadDataScripts) addFiles(meta TableMeta, files []string) {
+	if len(files) == 0 || meta.SelectedField() == "" {
+		return
+	}
+	key := tableKey{db: meta.DatabaseName(), table: meta.TableName()}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tables[key]
+	if !ok {
+		t = &loadDataTable{meta: meta, files: make(map[string]struct{})}
+		s.tables[key] = t
+	}
+	for _, file := range files {
+		t.files[file] = struct{}{}
+	}
+}
+
+// writeLoadDataScripts writes the LOAD DATA script of each table with the CSV files
+func (d *Dumper) writeLoadDataScripts() error {
+	tctx, conf, s := d.tctx, d.conf, d.loadData
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]tableKey, 0, len(s.tables))
+	for key := range s.tables {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].db != keys[j].db {
+			return keys[i].db < keys[j].db
+		}
+		return keys[i].table < keys[j].table
+	})
+	for _, key := range keys {
+		t := s.tables[key]
+		files := make([]string, 0, len(t.files))
+		for file := range t.files {
+			files = append(files, file)
+		}
+		// the indexes in the names are padded, so the files are in the dumping order
+		sort.Strings(files)
+		fileName, err := (&outputFileNamer{DB: key.db, Table: key.table}).render(conf.OutputFileTemplate, outputFileTemplateLoadData)
+		if err != nil {
+			return err
+		}
+		fileName += "." + FileFormatSQLTextString
+		if err = d.extStore.WriteFile(tctx, fileName, []byte(buildLoadDataScript(conf, t.meta, files))); err != nil {
+			return errors.Annotatef(err, "fail to write %s", fileName)
+		}
+		tctx.L().Debug("the LOAD DATA script is written", zap.String("file", fileName), zap.Stringer("table", key))
+	}
+	return nil
+}
+
+// buildLoadDataScript returns the LOAD DATA LOCAL INFILE statements loading the CSV files in the format of csvOption.
+// The NULL values are understood by LOAD DATA if they're \N escaped by the backslash, or the unenclosed word NULL,
+// otherwise the fields are read into the user variables, and set by NULLIF.
+func buildLoadDataScript(conf *Config, meta TableMeta, files []string) string {
+	var options strings.Builder
+	fmt.Fprintf(&options, "CHARACTER SET binary\nFIELDS TERMINATED BY %s", quoteSQLString(conf.CsvSeparator))
+	if conf.CsvDelimiter != "" {
+		fmt.Fprintf(&options, " OPTIONALLY ENCLOSED BY %s", quoteSQLString(conf.CsvDelimiter))
+	}
+	if conf.EscapeBackslash {
+		options.WriteString(` ESCAPED BY '\\'`)
+	} else {
+		options.WriteString(" ESCAPED BY ''")
+	}
+	options.WriteString("\nLINES TERMINATED BY '\\n'")
+	if !conf.NoHeader && len(meta.ColumnNames()) != 0 && meta.SelectedField() != "" {
+		options.WriteString("\nIGNORE 1 LINES")
+	}
+
+	nativeNull := (conf.EscapeBackslash && conf.CsvNullValue == `\N`) || (conf.CsvDelimiter != "" && conf.CsvNullValue == nullValue)
+	switch selectedField := meta.SelectedField(); {
+	case nativeNull && selectedField != "*":
+		// the generated columns aren't selected, so they're computed by the server
+		fmt.Fprintf(&options, "\n(%s)", selectedField)
+	case nativeNull:
+	default:
+		columns := meta.ColumnNames()
+		variables := make([]string, 0, len(columns))
+		assignments := make([]string, 0, len(columns))
+		for i, col := range columns {
+			variable := fmt.Sprintf("@c%d", i+1)
+			variables = append(variables, variable)
+			assignments = append(assignments, fmt.Sprintf("%s = NULLIF(%s, %s)", quoteIdentifier(col), variable, quoteSQLString(conf.CsvNullValue)))
+		}
+		fmt.Fprintf(&options, "\n(%s)\nSET %s", strings.Join(variables, ","), strings.Join(assignments, ",\n    "))
+	}
+
+	var b strings.Builder
+	table := quoteIdentifier(meta.DatabaseName()) + "." + quoteIdentifier(meta.TableName())
+	for _, file := range files {
+		fmt.Fprintf(&b, "LOAD DATA LOCAL INFILE %s INTO TABLE %s\n%s;\n", quoteSQLString(file), table, options.String())
+	}
+	return b.String()
+}
@@ -0,0 +1,114 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package export
+
+import (
+	"database/sql/driver"
+	"io/ioutil"
+	"path"
+
+	"github.com/pingcap/br/pkg/storage"
+	. "github.com/pingcap/check"
+)
+
+var _ = Suite(&testLoadDataSuite{})
+
+type testLoadDataSuite struct{}
+
+func (s *testLoadDataSuite) TestValidateLoadDataScripts(c *C) {
+	conf := defaultConfigForTest(c)
+	c.Assert(validateLoadDataScripts(conf), IsNil)
+	conf.LoadDataScripts = true
+	c.Assert(validateLoadDataScripts(conf), ErrorMatches, "--load-data-scripts only supports --filetype csv.*")
+	conf.FileType = FileFormatCSVString
+	c.Assert(validateLoadDataScripts(conf), IsNil)
+
+	conf.CsvDelimiter = "''"
+	c.Assert(validateLoadDataScripts(conf), ErrorMatches, "--load-data-scripts needs the --csv-delimiter of at most one character.*")
+	conf.CsvDelimiter = ""
+	c.Assert(validateLoadDataScripts(conf), IsNil)
+	conf.CompressType = storage.Gzip
+	c.Assert(validateLoadDataScripts(conf), ErrorMatches, "--load-data-scripts can't be used together with --compress.*")
+	conf.CompressType = storage.NoCompression
+	conf.SQL = "SELECT 1"
+	c.Assert(validateLoadDataScripts(conf), ErrorMatches, "can't specify both --sql and --load-data-scripts at the same time")
+}
+
+func (s *testLoadDataSuite) TestBuildLoadDataScript(c *C) {
+	conf := defaultConfigForTest(c)
+	conf.FileType = FileFormatCSVString
+	conf.EscapeBackslash = true
+	conf.CsvSeparator, conf.CsvDelimiter = ",", "\""
+	tableIR := newMockTableIR("test", "t", nil, nil, []string{"INT", "VARCHAR"})
+	tableIR.selectedField = "`id`,`name`"
+	tableIR.colNames = []string{"id", "name"}
+	files := []string{"test.t.000000000.csv", "test.t.000000001.csv"}
+
+	// the generated columns are left out by the column list
+	c.Assert(buildLoadDataScript(conf, tableIR, files[:1]), Equals, "LOAD DATA LOCAL INFILE 'test.t.000000000.csv' INTO TABLE `test`.`t`\n"+
+		"CHARACTER SET binary\n"+
+		"FIELDS TERMINATED BY ',' OPTIONALLY ENCLOSED BY '\\\"' ESCAPED BY '\\\\'\n"+
+		"LINES TERMINATED BY '\\n'\n"+
+		"IGNORE 1 LINES\n"+
+		"(`id`,`name`);\n")
+
+	// all the columns are selected
+	tableIR.selectedField = "*"
+	conf.NoHeader = true
+	c.Assert(buildLoadDataScript(conf, tableIR, files), Equals, "LOAD DATA LOCAL INFILE 'test.t.000000000.csv' INTO TABLE `test`.`t`\n"+
+		"CHARACTER SET binary\n"+
+		"FIELDS TERMINATED BY ',' OPTIONALLY ENCLOSED BY '\\\"' ESCAPED BY '\\\\'\n"+
+		"LINES TERMINATED BY '\\n';\n"+
+		"LOAD DATA LOCAL INFILE 'test.t.000000001.csv' INTO TABLE `test`.`t`\n"+
+		"CHARACTER SET binary\n"+
+		"FIELDS TERMINATED BY ',' OPTIONALLY ENCLOSED BY '\\\"' ESCAPED BY '\\\\'\n"+
+		"LINES TERMINATED BY '\\n';\n")
+
+	// the NULL values unknown to LOAD DATA are set by NULLIF
+	conf.EscapeBackslash = false
+	conf.CsvDelimiter = ""
+	conf.CsvSeparator = "|"
+	c.Assert(buildLoadDataScript(conf, tableIR, files[:1]), Equals, "LOAD DATA LOCAL INFILE 'test.t.000000000.csv' INTO TABLE `test`.`t`\n"+
+		"CHARACTER SET binary\n"+
+		"FIELDS TERMINATED BY '|' ESCAPED BY ''\n"+
+		"LINES TERMINATED BY '\\n'\n"+
+		"(@c1,@c2)\n"+
+		"SET `id` = NULLIF(@c1, '\\\\N'),\n"+
+		"    `name` = NULLIF(@c2, '\\\\N');\n")
+
+	// the enclosed fields make the unenclosed NULL understood
+	conf.CsvDelimiter = "'"
+	conf.CsvNullValue = nullValue
+	c.Assert(buildLoadDataScript(conf, tableIR, files[:1]), Equals, "LOAD DATA LOCAL INFILE 'test.t.000000000.csv' INTO TABLE `test`.`t`\n"+
+		"CHARACTER SET binary\n"+
+		"FIELDS TERMINATED BY '|' OPTIONALLY ENCLOSED BY '\\'' ESCAPED BY ''\n"+
+		"LINES TERMINATED BY '\\n';\n")
+}
+
+func (s *testLoadDataSuite) TestWriteLoadDataScripts(c *C) {
+	dir := c.MkDir()
+	conf := defaultConfigForTest(c)
+	conf.OutputDirPath = dir
+	conf.FileType = FileFormatCSVString
+	c.Assert(adjustFileFormat(conf), IsNil)
+	conf.FileSize = 10
+	conf.LoadDataScripts = true
+
+	writer := (&testWriterSuite{}).newWriter(conf, c)
+	writer.loadData = newLoadDataScripts()
+	data := [][]driver.Value{
+		{"1", "male"},
+		{"2", "female"},
+	}
+	tableIR := newMockTableIR("test", "employee", data, nil, []string{"INT", "VARCHAR"})
+	tableIR.selectedField = "`id`,`gender`"
+	tableIR.colNames = []string{"id", "gender"}
+	c.Assert(writer.handleTask(NewTaskTableData(tableIR, tableIR, 0, 1)), IsNil)
+
+	d := &Dumper{tctx: writer.tctx, conf: conf, extStore: writer.extStorage, loadData: writer.loadData}
+	c.Assert(d.writeLoadDataScripts(), IsNil)
+	script, err := ioutil.ReadFile(path.Join(dir, "test.employee-load-data.sql"))
+	c.Assert(err, IsNil)
+	c.Assert(string(script), Equals, buildLoadDataScript(conf, tableIR,
+		[]string{"test.employee.000000000.csv", "test.employee.000000001.csv"}))
+}
@@ -22,6 +22,8 @@ type globalMetadata struct {
 	buffer          bytes.Buffer
 	afterConnBuffer bytes.Buffer
 	snapshot        string
+	binlogPos       *binlogPosition
+	slaveStatus     []*slaveStatus
 
 	storage storage.ExternalStorage
 }
@@ -35,6 +37,13 @@ const (
 	gtidSetFieldIndex = 4
 )
 
+// binlogPosition is the binlog position and GTID set of the dumped snapshot
+type binlogPosition struct {
+	file    string
+	pos     string
+	gtidSet string
+}
+
 func newGlobalMetadata(tctx *tcontext.Context, s storage.ExternalStorage, snapshot string) *globalMetadata {
 	return &globalMetadata{
 		tctx:     tctx,
@@ -57,23 +66,94 @@ func (m *globalMetadata) recordFinishTime(t time.Time) {
 	m.buffer.WriteString("Finished dump at: " + t.Format(metadataTimeLayout) + "\n")
 }
 
+// recordWatermarks records the watermarks of tables reached by the dump
+func (m *globalMetadata) recordWatermarks(watermarks []tableWatermark) {
+	writeWatermarks(&m.buffer, watermarks)
+}
+
+// recordLoadOrder records the order of loading the dumped tables by the foreign keys
+func (m *globalMetadata) recordLoadOrder(order *loadOrder) {
+	writeLoadOrder(&m.buffer, order)
+}
+
+// recordViewOrder records the order of creating the dumped views
+func (m *globalMetadata) recordViewOrder(order *loadOrder) {
+	writeViewOrder(&m.buffer, order)
+}
+
+// recordSchemaRewrites records the tables whose schemas are changed by --schema-rewrite
+func (m *globalMetadata) recordSchemaRewrites(schemas []rewrittenSchema) {
+	writeSchemaRewrites(&m.buffer, schemas)
+}
+
+// recordSampleOptions records the options of dumping a sample of tables
+func (m *globalMetadata) recordSampleOptions(conf *Config) {
+	writeSampleOptions(&m.buffer, conf)
+}
+
+// recordSQLDialect records the SQL dialect of the schemas and the SQL data files
+func (m *globalMetadata) recordSQLDialect(dialect string) {
+	writeSQLDialect(&m.buffer, dialect)
+}
+
+// setBinlogPosition sets the binlog position got by the consistency controller,
+// which is recorded instead of the result of SHOW MASTER STATUS
+func (m *globalMetadata) setBinlogPosition(pos *binlogPosition) {
+	m.binlogPos = pos
+}
+
+// setSlaveStatus sets the replication status got by the consistency controller,
+// which is recorded instead of the result of SHOW SLAVE STATUS
+func (m *globalMetadata) setSlaveStatus(statuses []*slaveStatus) {
+	m.slaveStatus = statuses
+}
+
 func (m *globalMetadata) recordGlobalMetaData(db *sql.Conn, serverType ServerType, afterConn bool) error { // revive:disable-line:flag-parameter
 	if afterConn {
 		m.afterConnBuffer.Reset()
 		return recordGlobalMetaData(m.tctx, db, &m.afterConnBuffer, serverType, afterConn, m.snapshot)
 	}
-	return recordGlobalMetaData(m.tctx, db, &m.buffer, serverType, afterConn, m.snapshot)
+	if m.binlogPos == nil && m.slaveStatus == nil {
+		return recordGlobalMetaData(m.tctx, db, &m.buffer, serverType, afterConn, m.snapshot)
+	}
+	if m.binlogPos != nil {
+		writeMasterStatus(&m.buffer, m.binlogPos, false)
+		m.buffer.WriteString("\n")
+	} else if err := recordMasterStatus(m.tctx, db, &m.buffer, serverType, afterConn, m.snapshot); err != nil {
+		return err
+	}
+	if m.slaveStatus != nil {
+		writeSlaveStatus(&m.buffer, m.slaveStatus)
+		return nil
+	}
+	return recordSlaveStatus(db, &m.buffer)
+}
+
+func writeMasterStatus(buffer *bytes.Buffer, pos *binlogPosition, afterConn bool) { // revive:disable-line:flag-parameter
+	buffer.WriteString("SHOW MASTER STATUS:")
+	if afterConn {
+		buffer.WriteString(" /* AFTER CONNECTION POOL ESTABLISHED */")
+	}
+	buffer.WriteString("\n")
+	fmt.Fprintf(buffer, "\tLog: %s\n\tPos: %s\n\tGTID:%s\n", pos.file, pos.pos, pos.gtidSet)
 }
 
 func recordGlobalMetaData(tctx *tcontext.Context, db *sql.Conn, buffer *bytes.Buffer, serverType ServerType, afterConn bool, snapshot string) error { // revive:disable-line:flag-parameter
-	writeMasterStatusHeader := func() {
-		buffer.WriteString("SHOW MASTER STATUS:")
-		if afterConn {
-			buffer.WriteString(" /* AFTER CONNECTION POOL ESTABLISHED */")
-		}
-		buffer.WriteString("\n")
+	if err := recordMasterStatus(tctx, db, buffer, serverType, afterConn, snapshot); err != nil {
+		return err
 	}
+	if serverType == ServerTypeTiDB {
+		return nil
+	}
+
+	// omit follower status if called after connection pool established
+	if afterConn {
+		return nil
+	}
+	return recordSlaveStatus(db, buffer)
+}
 
+func recordMasterStatus(tctx *tcontext.Context, db *sql.Conn, buffer *bytes.Buffer, serverType ServerType, afterConn bool, snapshot string) error { // revive:disable-line:flag-parameter
 	switch serverType {
 	// For MySQL:
 	// mysql 5.6+
@@ -109,8 +189,7 @@ func recordGlobalMetaData(tctx *tcontext.Context, db *sql.Conn, buffer *bytes.Bu
 		gtidSet := getValidStr(str, gtidSetFieldIndex)
 
 		if logFile != "" {
-			writeMasterStatusHeader()
-			fmt.Fprintf(buffer, "\tLog: %s\n\tPos: %s\n\tGTID:%s\n", logFile, pos, gtidSet)
+			writeMasterStatus(buffer, &binlogPosition{file: logFile, pos: pos, gtidSet: gtidSet}, afterConn)
 		}
 	// For MariaDB:
 	// SHOW MASTER STATUS;
@@ -140,22 +219,48 @@ func recordGlobalMetaData(tctx *tcontext.Context, db *sql.Conn, buffer *bytes.Bu
 		}
 
 		if logFile != "" {
-			writeMasterStatusHeader()
-			fmt.Fprintf(buffer, "\tLog: %s\n\tPos: %s\n\tGTID:%s\n", logFile, pos, gtidSet)
+			writeMasterStatus(buffer, &binlogPosition{file: logFile, pos: pos, gtidSet: gtidSet}, afterConn)
 		}
 	default:
 		return errors.Errorf("unsupported serverType %s for recordGlobalMetaData", serverType.String())
 	}
 	buffer.WriteString("\n")
-	if serverType == ServerTypeTiDB {
-		return nil
+	return nil
+}
+
+// slaveStatus is the replication status of one channel (or connection in MariaDB) from SHOW SLAVE STATUS
+type slaveStatus struct {
+	binlogPosition
+	// connName is the MariaDB multi-source connection name, which is only recorded if multiSource is set
+	connName    string
+	multiSource bool
+	host        string
+	sqlRunning  bool
+}
+
+func recordSlaveStatus(db *sql.Conn, buffer *bytes.Buffer) error {
+	statuses, err := showSlaveStatus(db)
+	if err != nil {
+		return err
 	}
+	writeSlaveStatus(buffer, statuses)
+	return nil
+}
 
-	// omit follower status if called after connection pool established
-	if afterConn {
-		return nil
+func writeSlaveStatus(buffer *bytes.Buffer, statuses []*slaveStatus) {
+	for _, status := range statuses {
+		if len(status.host) > 0 {
+			buffer.WriteString("SHOW SLAVE STATUS:\n")
+			if status.multiSource {
+				buffer.WriteString("\tConnection name: " + status.connName + "\n")
+			}
+			fmt.Fprintf(buffer, "\tHost: %s\n\tLog: %s\n\tPos: %s\n\tGTID:%s\n\n", status.host, status.file, status.pos, status.gtidSet)
+		}
 	}
-	// get follower status info
+}
+
+// showSlaveStatus gets the follower status info. MySQL 8.0.22+ names the columns with `source`/`replica` instead of `master`/`slave`
+func showSlaveStatus(db *sql.Conn) ([]*slaveStatus, error) {
 	var (
 		isms  bool
 		query string
@@ -171,7 +276,8 @@ func recordGlobalMetaData(tctx *tcontext.Context, db *sql.Conn, buffer *bytes.Bu
 	} else {
 		query = "SHOW SLAVE STATUS"
 	}
-	return simpleQuery(db, query, func(rows *sql.Rows) error {
+	var statuses []*slaveStatus
+	err := simpleQuery(db, query, func(rows *sql.Rows) error {
 		cols, err := rows.Columns()
 		if err != nil {
 			return errors.Trace(err)
@@ -184,44 +290,44 @@ func recordGlobalMetaData(tctx *tcontext.Context, db *sql.Conn, buffer *bytes.Bu
 		if err := rows.Scan(args...); err != nil {
 			return errors.Trace(err)
 		}
-		var connName, pos, logFile, host, gtidSet string
+		status := &slaveStatus{multiSource: isms}
 		for i, col := range cols {
 			if data[i].Valid {
 				col = strings.ToLower(col)
 				switch col {
 				case "connection_name":
-					connName = data[i].String
-				case "exec_master_log_pos":
-					pos = data[i].String
-				case "relay_master_log_file":
-					logFile = data[i].String
-				case "master_host":
-					host = data[i].String
+					status.connName = data[i].String
+				case "exec_master_log_pos", "exec_source_log_pos":
+					status.pos = data[i].String
+				case "relay_master_log_file", "relay_source_log_file":
+					status.file = data[i].String
+				case "master_host", "source_host":
+					status.host = data[i].String
 				case "executed_gtid_set":
-					gtidSet = data[i].String
+					status.gtidSet = data[i].String
+				case "slave_sql_running", "replica_sql_running":
+					status.sqlRunning = strings.EqualFold(data[i].String, "Yes")
 				}
 			}
 		}
-		if len(host) > 0 {
-			buffer.WriteString("SHOW SLAVE STATUS:\n")
-			if isms {
-				buffer.WriteString("\tConnection name: " + connName + "\n")
-			}
-			fmt.Fprintf(buffer, "\tHost: %s\n\tLog: %s\n\tPos: %s\n\tGTID:%s\n\n", host, logFile, pos, gtidSet)
-		}
+		statuses = append(statuses, status)
 		return nil
 	})
+	return statuses, err
 }
 
 func (m *globalMetadata) writeGlobalMetaData() error {
+	if _, ok := m.storage.(*stdoutStorage); ok {
+		// don't mix metadata into the dumped SQL stream
+		m.tctx.L().Info("global metadata", zap.String("metadata", m.String()))
+		return nil
+	}
 	// keep consistent with mydumper. Never compress metadata
-	fileWriter, tearDown, err := buildFileWriter(m.tctx, m.storage, metadataPath, storage.NoCompression)
+	err := m.storage.WriteFile(m.tctx, metadataPath, m.buffer.Bytes())
 	if err != nil {
-		return err
+		m.tctx.L().Error("fail to write global metadata", zap.Error(err))
 	}
-	defer tearDown(m.tctx)
-
-	return write(m.tctx, fileWriter, m.String())
+	return errors.Trace(err)
 }
 
 func getValidStr(str []string, idx int) string {
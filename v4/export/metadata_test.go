@@ -48,6 +48,35 @@ func (s *testMetaDataSuite) TestMysqlMetaData(c *C) {
 	c.Assert(mock.ExpectationsWereMet(), IsNil)
 }
 
+func (s *testMetaDataSuite) TestMetaDataWithBinlogPosition(c *C) {
+	db, mock, err := sqlmock.New()
+	c.Assert(err, IsNil)
+	defer db.Close()
+	conn, err := db.Conn(context.Background())
+	c.Assert(err, IsNil)
+
+	// the position got by consistency controller is used instead of SHOW MASTER STATUS
+	mock.ExpectQuery("SELECT @@default_master_connection").WillReturnError(fmt.Errorf("mock error"))
+	mock.ExpectQuery("SHOW SLAVE STATUS").WillReturnRows(
+		sqlmock.NewRows([]string{"exec_master_log_pos", "relay_master_log_file", "master_host", "Executed_Gtid_Set", "Seconds_Behind_Master"}).
+			AddRow("4", "mysql-bin.000010", "192.168.1.2", "", "0"))
+
+	m := newGlobalMetadata(tcontext.Background(), s.createStorage(c), "")
+	m.setBinlogPosition(&binlogPosition{file: logFile, pos: pos, gtidSet: gtidSet})
+	c.Assert(m.recordGlobalMetaData(conn, ServerTypeMySQL, false), IsNil)
+
+	c.Assert(m.buffer.String(), Equals, "SHOW MASTER STATUS:\n"+
+		"\tLog: ON.000001\n"+
+		"\tPos: 7502\n"+
+		"\tGTID:6ce40be3-e359-11e9-87e0-36933cb0ca5a:1-29\n\n"+
+		"SHOW SLAVE STATUS:\n"+
+		"\tHost: 192.168.1.2\n"+
+		"\tLog: mysql-bin.000010\n"+
+		"\tPos: 4\n"+
+		"\tGTID:\n\n")
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
+
 func (s *testMetaDataSuite) createStorage(c *C) storage.ExternalStorage {
 	backend, err := storage.ParseBackend("file:///"+c.MkDir(), nil)
 	c.Assert(err, IsNil)
@@ -278,3 +307,33 @@ func (s *testMetaDataSuite) TestNoPrivilege(c *C) {
 	c.Assert(m.recordGlobalMetaData(conn, ServerTypeTiDB, false), NotNil)
 	c.Assert(m.buffer.String(), Equals, "")
 }
+
+func (s *testMetaDataSuite) TestMetaDataWithSlaveStatus(c *C) {
+	db, mock, err := sqlmock.New()
+	c.Assert(err, IsNil)
+	defer db.Close()
+	conn, err := db.Conn(context.Background())
+	c.Assert(err, IsNil)
+
+	rows := sqlmock.NewRows([]string{"File", "Position", "Binlog_Do_DB", "Binlog_Ignore_DB", "Executed_Gtid_Set"}).
+		AddRow(logFile, pos, "", "", gtidSet)
+	mock.ExpectQuery("SHOW MASTER STATUS").WillReturnRows(rows)
+
+	m := newGlobalMetadata(tcontext.Background(), s.createStorage(c), "")
+	m.setSlaveStatus([]*slaveStatus{{
+		binlogPosition: binlogPosition{file: "mysql-bin.001821", pos: "256529431", gtidSet: gtidSet},
+		host:           "192.168.1.100",
+	}})
+	c.Assert(m.recordGlobalMetaData(conn, ServerTypeMySQL, false), IsNil)
+
+	c.Assert(m.buffer.String(), Equals, "SHOW MASTER STATUS:\n"+
+		"\tLog: ON.000001\n"+
+		"\tPos: 7502\n"+
+		"\tGTID:6ce40be3-e359-11e9-87e0-36933cb0ca5a:1-29\n\n"+
+		"SHOW SLAVE STATUS:\n"+
+		"\tHost: 192.168.1.100\n"+
+		"\tLog: mysql-bin.001821\n"+
+		"\tPos: 256529431\n"+
+		"\tGTID:6ce40be3-e359-11e9-87e0-36933cb0ca5a:1-29\n\n")
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
@@ -0,0 +1,193 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package export
+
+import (
+	"database/sql"
+	"path/filepath"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"go.uber.org/zap"
+)
+
+// partitionRule is a rule of --filter with a partition part, like `db.tbl.p2020*` or `!db.tbl.p0`
+type partitionRule struct {
+	positive  bool
+	schema    string
+	table     string
+	partition string
+}
+
+// PartitionFilter selects the partitions of tables to dump by the partition rules of --filter
+type PartitionFilter struct {
+	rules         []partitionRule
+	caseSensitive bool
+}
+
+// ParsePartitionFilter extracts the partition rules from the rules of --filter, and returns the other rules for the table filter.
+// A positive partition rule like `db.tbl.p*` is replaced by `db.tbl`, so that the table is selected too.
+// The partition rules only support wildcards, the rules with regular expressions, quotes or file imports are always table rules
+func ParsePartitionFilter(filters []string, caseSensitive bool) ([]string, *PartitionFilter, error) {
+	tableRules := make([]string, 0, len(filters))
+	f := &PartitionFilter{caseSensitive: caseSensitive}
+	for _, rule := range filters {
+		text := strings.TrimSpace(rule)
+		positive := !strings.HasPrefix(text, "!")
+		text = strings.TrimPrefix(text, "!")
+		parts := splitFilterRule(text)
+		if len(parts) != 3 {
+			tableRules = append(tableRules, rule)
+			continue
+		}
+		for _, part := range parts {
+			if _, err := filepath.Match(part, ""); err != nil {
+				return nil, nil, errors.Errorf("failed to parse partition filter '%s': %s", rule, err)
+			}
+		}
+		f.rules = append(f.rules, partitionRule{
+			positive:  positive,
+			schema:    unescapeFilterPart(parts[0]),
+			table:     unescapeFilterPart(parts[1]),
+			partition: unescapeFilterPart(parts[2]),
+		})
+		if positive {
+			tableRules = append(tableRules, parts[0]+"."+parts[1])
+		}
+	}
+	return tableRules, f, nil
+}
+
+// splitFilterRule splits a filter rule by the unescaped dots. It returns nil if the rule isn't a plain wildcard rule
+func splitFilterRule(rule string) []string {
+	if rule == "" || strings.HasPrefix(rule, "@") || strings.ContainsAny(rule, "/`\"") {
+		return nil
+	}
+	var (
+		parts   []string
+		start   int
+		escaped bool
+	)
+	for i := 0; i < len(rule); i++ {
+		switch {
+		case escaped:
+			escaped = false
+		case rule[i] == '\\':
+			escaped = true
+		case rule[i] == '.':
+			parts = append(parts, rule[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, rule[start:])
+}
+
+func unescapeFilterPart(part string) string {
+	return strings.ReplaceAll(part, `\.`, ".")
+}
+
+func (f *PartitionFilter) match(pattern, name string) bool {
+	if !f.caseSensitive {
+		pattern, name = strings.ToLower(pattern), strings.ToLower(name)
+	}
+	ok, _ := filepath.Match(pattern, name)
+	return ok
+}
+
+func (f *PartitionFilter) hasAnyRule() bool {
+	return f != nil && len(f.rules) > 0
+}
+
+// HasRules returns true if any partition rule matches the table, then the table is dumped by partitions
+func (f *PartitionFilter) HasRules(db, table string) bool {
+	if f == nil {
+		return false
+	}
+	for _, r := range f.rules {
+		if f.match(r.schema, db) && f.match(r.table, table) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchPartition returns true if the partition of the table should be dumped. Like the table filter, the last matching rule wins.
+// A partition matching no rule is dumped only if there isn't any positive rule for its table
+func (f *PartitionFilter) MatchPartition(db, table, partition string) bool {
+	if f == nil {
+		return true
+	}
+	matched, hasPositive := false, false
+	selected := true
+	for _, r := range f.rules {
+		if !f.match(r.schema, db) || !f.match(r.table, table) {
+			continue
+		}
+		hasPositive = hasPositive || r.positive
+		if f.match(r.partition, partition) {
+			matched, selected = true, r.positive
+		}
+	}
+	if !matched {
+		return !hasPositive
+	}
+	return selected
+}
+
+// partitionNameProvider is implemented by the TableMeta of a partition
+type partitionNameProvider interface {
+	PartitionName() string
+}
+
+// partitionMeta is the TableMeta of a partition, whose data files are named with the partition
+type partitionMeta struct {
+	TableMeta
+	partition string
+	// last is true for the last selected partition of the table, whose last chunk finishes the table
+	last bool
+}
+
+// PartitionName implements partitionNameProvider.PartitionName
+func (m *partitionMeta) PartitionName() string {
+	return m.partition
+}
+
+// partitionOf returns the partition name of the TableMeta, or empty if it's the whole table
+func partitionOf(meta TableMeta) string {
+	if p, ok := meta.(partitionNameProvider); ok {
+		return p.PartitionName()
+	}
+	return ""
+}
+
+// dumpTablePartitions dumps each selected partition of a partitioned table like a table by `SELECT ... PARTITION (p)`,
+// so a large partition is split into chunks by --rows too. The chunks are numbered within each partition
+func (d *Dumper) dumpTablePartitions(conn *sql.Conn, meta TableMeta, partitions []string, taskChan chan<- Task) error {
+	conf := d.conf
+	db, tbl := meta.DatabaseName(), meta.TableName()
+	selected := make([]string, 0, len(partitions))
+	for _, p := range partitions {
+		if conf.PartitionFilter.MatchPartition(db, tbl, p) {
+			selected = append(selected, p)
+		}
+	}
+	d.L().Info("dump table by partitions", zap.String("database", db), zap.String("table", tbl),
+		zap.Strings("partitions", selected), zap.Int("skipped", len(partitions)-len(selected)))
+
+	for i, p := range selected {
+		pm := &partitionMeta{TableMeta: meta, partition: p, last: i == len(selected)-1}
+		var err error
+		if conf.Rows == UnspecifiedSize {
+			err = d.sequentialDumpTable(conn, pm, taskChan)
+		} else {
+			err = d.concurrentDumpTable(conn, pm, taskChan)
+		}
+		if err != nil {
+			return err
+		}
+		if d.tctx.Err() != nil {
+			break
+		}
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package export
+
+import (
+	"context"
+	"regexp"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	. "github.com/pingcap/check"
+)
+
+var _ = Suite(&testPartitionSuite{})
+
+type testPartitionSuite struct{}
+
+func (s *testPartitionSuite) TestParsePartitionFilter(c *C) {
+	tableRules, f, err := ParsePartitionFilter([]string{"*.*", DefaultTableFilter, "db.log.p2020*", "!db.log.p202012", "!db.hist.p0", "a\\.b.t"}, false)
+	c.Assert(err, IsNil)
+	c.Assert(tableRules, DeepEquals, []string{"*.*", DefaultTableFilter, "db.log", "a\\.b.t"})
+	c.Assert(f.HasRules("db", "log"), IsTrue)
+	c.Assert(f.HasRules("DB", "hist"), IsTrue)
+	c.Assert(f.HasRules("db", "other"), IsFalse)
+
+	// only the partitions selected by the positive rules are dumped
+	c.Assert(f.MatchPartition("db", "log", "p202001"), IsTrue)
+	c.Assert(f.MatchPartition("db", "log", "P202011"), IsTrue)
+	c.Assert(f.MatchPartition("db", "log", "p202012"), IsFalse)
+	c.Assert(f.MatchPartition("db", "log", "p2019"), IsFalse)
+	// the other partitions are dumped if there are only negative rules
+	c.Assert(f.MatchPartition("db", "hist", "p0"), IsFalse)
+	c.Assert(f.MatchPartition("db", "hist", "p1"), IsTrue)
+	c.Assert(f.MatchPartition("db", "other", "p0"), IsTrue)
+
+	_, f, err = ParsePartitionFilter([]string{"db.log.p2020*"}, true)
+	c.Assert(err, IsNil)
+	c.Assert(f.MatchPartition("db", "log", "P202001"), IsFalse)
+	c.Assert(f.HasRules("DB", "log"), IsFalse)
+
+	_, _, err = ParsePartitionFilter([]string{"db.log.p[0"}, false)
+	c.Assert(err, ErrorMatches, "failed to parse partition filter 'db.log.p\\[0'.*")
+
+	var nilFilter *PartitionFilter
+	c.Assert(nilFilter.HasRules("db", "log"), IsFalse)
+	c.Assert(nilFilter.MatchPartition("db", "log", "p0"), IsTrue)
+}
+
+func (s *testPartitionSuite) TestDumpTablePartitions(c *C) {
+	db, mock, err := sqlmock.New()
+	c.Assert(err, IsNil)
+	defer db.Close()
+	conn, err := db.Conn(context.Background())
+	c.Assert(err, IsNil)
+
+	d := newDumperForSchemaTest(c)
+	d.schemas.withPartitions = true
+	cacheTestSchemas(d, "test", "t", "n")
+	d.schemas.dbs["test"].tables["t"].partitions = []string{"p0", "p1", "p2"}
+	_, d.conf.PartitionFilter, err = ParsePartitionFilter([]string{"!test.t.p1"}, false)
+	c.Assert(err, IsNil)
+
+	taskChan := make(chan Task, 4)
+	c.Assert(d.dumpTableData(conn, &tableMeta{database: "test", table: "t"}, taskChan), IsNil)
+	// the table which isn't partitioned is dumped as usual
+	c.Assert(d.dumpTableData(conn, &tableMeta{database: "test", table: "n"}, taskChan), IsNil)
+	close(taskChan)
+
+	var queries, fileNames []string
+	for task := range taskChan {
+		td := task.(*TaskTableData)
+		queries = append(queries, td.Data.(*tableData).query)
+		fileName, err := newOutputFileNamer(td.Meta, td.ChunkIndex, false, false).NextName(DefaultOutputFileTemplate, "sql")
+		c.Assert(err, IsNil)
+		fileNames = append(fileNames, fileName)
+	}
+	c.Assert(queries, DeepEquals, []string{
+		"SELECT * FROM `test`.`t` PARTITION (`p0`) ORDER BY `id`",
+		"SELECT * FROM `test`.`t` PARTITION (`p2`) ORDER BY `id`",
+		"SELECT * FROM `test`.`n` ORDER BY `id`",
+	})
+	// the chunks are numbered within each partition
+	c.Assert(fileNames, DeepEquals, []string{"test.t.p0.000000000.sql", "test.t.p2.000000000.sql", "test.n.000000000.sql"})
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+
+	// the data files of partitions are verified as the data files of their tables
+	m, err := newDumpFileMatcher(nil)
+	c.Assert(err, IsNil)
+	for _, name := range fileNames {
+		f := m.classify(name)
+		c.Assert(f.kind, Equals, dumpFileData)
+		c.Assert(f.db, Equals, "test")
+		c.Assert(f.table, Equals, name[5:6])
+	}
+}
+
+func (s *testPartitionSuite) TestSplitTablePartitions(c *C) {
+	db, mock, err := sqlmock.New()
+	c.Assert(err, IsNil)
+	defer db.Close()
+	conn, err := db.Conn(context.Background())
+	c.Assert(err, IsNil)
+
+	d := newDumperForSchemaTest(c)
+	d.schemas.withPartitions = true
+	d.conf.SplitPartitions = true
+	d.conf.Rows = 50
+	cacheTestSchemas(d, "test", "t")
+	d.schemas.dbs["test"].tables["t"].partitions = []string{"p0", "p1"}
+
+	// the large partition is split into chunks like a table, while the small one is dumped as one chunk
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT MIN(`id`),MAX(`id`) FROM `test`.`t` PARTITION (`p0`)")).
+		WillReturnRows(sqlmock.NewRows([]string{"MIN(`id`)", "MAX(`id`)"}).AddRow(1, 100))
+	mock.ExpectQuery(regexp.QuoteMeta("EXPLAIN SELECT `id` FROM `test`.`t` PARTITION (`p0`)")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "rows"}).AddRow(1, 100))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT MIN(`id`),MAX(`id`) FROM `test`.`t` PARTITION (`p1`)")).
+		WillReturnRows(sqlmock.NewRows([]string{"MIN(`id`)", "MAX(`id`)"}).AddRow(101, 110))
+	mock.ExpectQuery(regexp.QuoteMeta("EXPLAIN SELECT `id` FROM `test`.`t` PARTITION (`p1`)")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "rows"}).AddRow(1, 10))
+
+	taskChan := make(chan Task, 4)
+	c.Assert(d.dumpTableData(conn, &tableMeta{database: "test", table: "t"}, taskChan), IsNil)
+	close(taskChan)
+	var queries, fileNames []string
+	for task := range taskChan {
+		td := task.(*TaskTableData)
+		queries = append(queries, td.Data.(*tableData).query)
+		fileName, err := newOutputFileNamer(td.Meta, td.ChunkIndex, false, false).NextName(DefaultOutputFileTemplate, "sql")
+		c.Assert(err, IsNil)
+		fileNames = append(fileNames, fileName)
+	}
+	c.Assert(queries, DeepEquals, []string{
+		"SELECT * FROM `test`.`t` PARTITION (`p0`)  WHERE `id` IS NULL OR (`id` >= 1 AND `id` < 51) ORDER BY `id`",
+		"SELECT * FROM `test`.`t` PARTITION (`p0`)  WHERE (`id` >= 51 AND `id` < 101) ORDER BY `id`",
+		"SELECT * FROM `test`.`t` PARTITION (`p1`) ORDER BY `id`",
+	})
+	c.Assert(fileNames, DeepEquals, []string{"test.t.p0.000000000.sql", "test.t.p0.000000001.sql", "test.t.p1.000000000.sql"})
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
+
+func (s *testPartitionSuite) TestSelectTablePartitions(c *C) {
+	db, mock, err := sqlmock.New()
+	c.Assert(err, IsNil)
+	defer db.Close()
+	conn, err := db.Conn(context.Background())
+	c.Assert(err, IsNil)
+
+	mock.ExpectQuery("SELECT PARTITION_NAME FROM INFORMATION_SCHEMA.PARTITIONS").
+		WithArgs("test", "t").
+		WillReturnRows(sqlmock.NewRows([]string{"PARTITION_NAME"}).AddRow("p0").AddRow("p1"))
+	partitions, err := SelectTablePartitions(conn, "test", "t")
+	c.Assert(err, IsNil)
+	c.Assert(partitions, DeepEquals, []string{"p0", "p1"})
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
@@ -0,0 +1,452 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package export
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/parser"
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/charset"
+	"github.com/pingcap/parser/format"
+	"github.com/pingcap/parser/mysql"
+	"github.com/pingcap/parser/types"
+)
+
+const (
+	// sqlDialectMySQL writes the schemas and the data in MySQL syntax
+	sqlDialectMySQL = "mysql"
+	// sqlDialectPostgres writes the schemas and the data in PostgreSQL syntax
+	sqlDialectPostgres = "postgres"
+	// sqlDialectSQLite translates the schemas for --filetype sqlite, it isn't a value of --sql-dialect
+	sqlDialectSQLite = "sqlite"
+
+	postgresRestoreFlags = format.RestoreStringSingleQuotes | format.RestoreKeyWordUppercase | format.RestoreNameDoubleQuotes |
+		format.RestoreStringWithoutDefaultCharset
+)
+
+// sqlDialectSection is the header of the SQL dialect in metadata, it's only written if the dialect isn't MySQL
+const sqlDialectSection = "SQL DIALECT:"
+
+// writeSQLDialect writes the SQL dialect of the dump in metadata, with which verify knows how to read the SQL files
+func writeSQLDialect(buffer *bytes.Buffer, dialect string) {
+	fmt.Fprintf(buffer, "%s\n\tDialect: %s\n\n", sqlDialectSection, dialect)
+}
+
+// parseSQLDialect parses the SQL dialect in metadata, the dump is in MySQL if no dialect is recorded
+func parseSQLDialect(metadata []byte) string {
+	inSection := false
+	scanner := bufio.NewScanner(bytes.NewReader(metadata))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !inSection {
+			inSection = line == sqlDialectSection
+			continue
+		}
+		if dialect := strings.TrimPrefix(line, "\tDialect: "); dialect != line {
+			return strings.TrimSpace(dialect)
+		}
+		break
+	}
+	return sqlDialectMySQL
+}
+
+func (conf *Config) postgresDialect() bool {
+	return conf.SQLDialect == sqlDialectPostgres
+}
+
+// translatedDialect returns the dialect which the schemas are translated into, or "" if they're kept in MySQL
+func (conf *Config) translatedDialect() string {
+	switch {
+	case conf.postgresDialect():
+		return sqlDialectPostgres
+	case conf.FileType == FileFormatSQLiteString:
+		return sqlDialectSQLite
+	default:
+		return ""
+	}
+}
+
+func validateSQLDialect(conf *Config) error {
+	conf.SQLDialect = strings.ToLower(conf.SQLDialect)
+	switch conf.SQLDialect {
+	case "", sqlDialectMySQL:
+		if conf.FileType == FileFormatPostgresCopyString {
+			return errors.New("--filetype copy only applies to --sql-dialect postgres")
+		}
+		return nil
+	case sqlDialectPostgres:
+	default:
+		return errors.Errorf("--sql-dialect is set to '%s'. It should be one of {%s|%s}", conf.SQLDialect, sqlDialectMySQL, sqlDialectPostgres)
+	}
+	if conf.SingleFile {
+		return errors.New("--single-file only supports --sql-dialect mysql")
+	}
+	switch conf.InsertMode {
+	case "", insertModeInsert, insertModeIgnore:
+		return nil
+	default:
+		return errors.Errorf("--insert-mode %s isn't supported by --sql-dialect postgres", conf.InsertMode)
+	}
+}
+
+// postgresSpecialComments returns the session settings at the head of the files written in --sql-dialect postgres
+func postgresSpecialComments() []string {
+	return []string{
+		"SET client_encoding = 'UTF8';",
+		"SET standard_conforming_strings = on;",
+	}
+}
+
+func quotePostgresIdentifier(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+func quotePostgresString(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+// postgresTableName returns the table name qualified by the schema, as the databases are dumped as the schemas
+func postgresTableName(db, table string) string {
+	return quotePostgresIdentifier(db) + "." + quotePostgresIdentifier(table)
+}
+
+// postgresColumnList returns the quoted column list of the table, or "" if all the columns are selected
+func postgresColumnList(meta TableMeta) string {
+	if selectedField := meta.SelectedField(); selectedField == "" || selectedField == "*" {
+		return ""
+	}
+	columns := make([]string, 0, len(meta.ColumnNames()))
+	for _, col := range meta.ColumnNames() {
+		columns = append(columns, quotePostgresIdentifier(col))
+	}
+	return "(" + strings.Join(columns, ",") + ")"
+}
+
+// translateMetaTask translates the schemas in the meta task from MySQL to the dialect.
+// The databases and the views aren't written into SQLite, so they're kept as they are for it.
+func translateMetaTask(dialect string, task Task) (Task, error) {
+	switch t := task.(type) {
+	case *TaskDatabaseMeta:
+		if dialect == sqlDialectSQLite {
+			return t, nil
+		}
+		return NewTaskDatabaseMeta(t.DatabaseName, fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %s;\n", quotePostgresIdentifier(t.DatabaseName))), nil
+	case *TaskTableMeta:
+		if t.CreateTableSQL == "" {
+			return t, nil
+		}
+		createTableSQL, err := translateCreateTable(dialect, t.DatabaseName, t.TableName, t.CreateTableSQL)
+		if err != nil {
+			return nil, errors.Annotatef(err, "failed to translate the schema of `%s`.`%s`", t.DatabaseName, t.TableName)
+		}
+		return NewTaskTableMeta(t.DatabaseName, t.TableName, createTableSQL), nil
+	case *TaskViewMeta:
+		if dialect == sqlDialectSQLite {
+			return t, nil
+		}
+		createTableSQL, err := translateCreateTable(dialect, t.DatabaseName, t.ViewName, t.CreateTableSQL)
+		if err != nil {
+			return nil, errors.Annotatef(err, "failed to translate the schema of `%s`.`%s`", t.DatabaseName, t.ViewName)
+		}
+		createViewSQL, err := translateCreateViewToPostgres(t.DatabaseName, t.ViewName, t.CreateViewSQL)
+		if err != nil {
+			return nil, errors.Annotatef(err, "failed to translate the schema of `%s`.`%s`", t.DatabaseName, t.ViewName)
+		}
+		return NewTaskViewMeta(t.DatabaseName, t.ViewName, createTableSQL, createViewSQL), nil
+	}
+	return task, nil
+}
+
+// translateCreateTable translates the CREATE TABLE statement of MySQL into the statements of PostgreSQL or SQLite.
+// The non-unique indexes are created by the following CREATE INDEX statements, and the comments by the COMMENT ON statements
+// in PostgreSQL. The foreign keys, the fulltext indexes, the table options and the partitions have no translations and are left out.
+// SQLite has no schemas, so the tables are only named by the table names in it.
+func translateCreateTable(dialect, db, table, createSQL string) (string, error) {
+	stmt, err := parseCreateTable(createSQL)
+	if err != nil {
+		return "", err
+	}
+	name := postgresTableName(db, table)
+	if dialect == sqlDialectSQLite {
+		name = quotePostgresIdentifier(table)
+	}
+	defs := make([]string, 0, len(stmt.Cols)+len(stmt.Constraints))
+	var trailers []string
+	for _, col := range stmt.Cols {
+		def, comment, err := translateColumn(dialect, col)
+		if err != nil {
+			return "", err
+		}
+		defs = append(defs, def)
+		if comment != "" && dialect == sqlDialectPostgres {
+			trailers = append(trailers, fmt.Sprintf("COMMENT ON COLUMN %s.%s IS %s;",
+				name, quotePostgresIdentifier(col.Name.Name.O), quotePostgresString(comment)))
+		}
+	}
+	for _, constraint := range stmt.Constraints {
+		var keys string
+		if keys, err = translateIndexKeys(constraint.Keys); err != nil {
+			return "", err
+		}
+		switch constraint.Tp {
+		case ast.ConstraintPrimaryKey:
+			defs = append(defs, fmt.Sprintf("PRIMARY KEY (%s)", keys))
+		case ast.ConstraintUniq, ast.ConstraintUniqKey, ast.ConstraintUniqIndex:
+			defs = append(defs, fmt.Sprintf("UNIQUE (%s)", keys))
+		case ast.ConstraintKey, ast.ConstraintIndex:
+			// the index names are only unique in the table in MySQL, but in the schema in PostgreSQL and SQLite
+			if dialect == sqlDialectSQLite {
+				// SQLite can't generate the index names
+				index := quotePostgresIdentifier(table + "_" + constraint.Name)
+				trailers = append(trailers, fmt.Sprintf("CREATE INDEX %s ON %s (%s);", index, name, keys))
+			} else {
+				trailers = append(trailers, fmt.Sprintf("CREATE INDEX ON %s (%s);", name, keys))
+			}
+		case ast.ConstraintCheck:
+			var expr string
+			if expr, err = restorePostgresNode(constraint.Expr); err != nil {
+				return "", err
+			}
+			defs = append(defs, fmt.Sprintf("CHECK (%s)", expr))
+		}
+	}
+	for _, opt := range stmt.Options {
+		if opt.Tp == ast.TableOptionComment && opt.StrValue != "" && dialect == sqlDialectPostgres {
+			trailers = append([]string{fmt.Sprintf("COMMENT ON TABLE %s IS %s;", name, quotePostgresString(opt.StrValue))}, trailers...)
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "CREATE TABLE %s (\n  %s\n);\n", name, strings.Join(defs, ",\n  "))
+	for _, trailer := range trailers {
+		b.WriteString(trailer)
+		b.WriteByte('\n')
+	}
+	return b.String(), nil
+}
+
+func translateColumn(dialect string, col *ast.ColumnDef) (def, comment string, err error) {
+	autoIncrement := false
+	for _, opt := range col.Options {
+		if opt.Tp == ast.ColumnOptionAutoIncrement {
+			autoIncrement = true
+		}
+	}
+	name := quotePostgresIdentifier(col.Name.Name.O)
+	var parts []string
+	if dialect == sqlDialectSQLite {
+		parts = []string{name, sqliteColumnType(col.Tp)}
+	} else {
+		parts = []string{name, postgresColumnType(col.Tp, autoIncrement)}
+	}
+	for _, opt := range col.Options {
+		switch opt.Tp {
+		case ast.ColumnOptionNotNull:
+			parts = append(parts, "NOT NULL")
+		case ast.ColumnOptionNull:
+			parts = append(parts, "NULL")
+		case ast.ColumnOptionAutoIncrement:
+			// the values are dumped, and SQLite has no identity columns but the rowid
+			if dialect == sqlDialectPostgres {
+				// the sequence of the identity column should be restarted from the max value after the data are loaded
+				parts = append(parts, "GENERATED BY DEFAULT AS IDENTITY")
+			}
+		case ast.ColumnOptionPrimaryKey:
+			parts = append(parts, "PRIMARY KEY")
+		case ast.ColumnOptionUniqKey:
+			parts = append(parts, "UNIQUE")
+		case ast.ColumnOptionDefaultValue:
+			var expr string
+			if expr, err = translateDefaultValue(dialect, opt.Expr); err != nil {
+				return "", "", err
+			}
+			parts = append(parts, "DEFAULT "+expr)
+		case ast.ColumnOptionGenerated:
+			var expr string
+			if expr, err = restorePostgresNode(opt.Expr); err != nil {
+				return "", "", err
+			}
+			// PostgreSQL only supports the stored generated columns, and SQLite supports both
+			parts = append(parts, fmt.Sprintf("GENERATED ALWAYS AS (%s) STORED", expr))
+		case ast.ColumnOptionCheck:
+			var expr string
+			if expr, err = restorePostgresNode(opt.Expr); err != nil {
+				return "", "", err
+			}
+			parts = append(parts, fmt.Sprintf("CHECK (%s)", expr))
+		case ast.ColumnOptionComment:
+			if v, ok := opt.Expr.(ast.ValueExpr); ok {
+				comment = v.GetString()
+			}
+		}
+	}
+	if col.Tp.Tp == mysql.TypeEnum {
+		elems := make([]string, 0, len(col.Tp.Elems))
+		for _, elem := range col.Tp.Elems {
+			elems = append(elems, quotePostgresString(elem))
+		}
+		parts = append(parts, fmt.Sprintf("CHECK (%s IN (%s))", name, strings.Join(elems, ",")))
+	}
+	return strings.Join(parts, " "), comment, nil
+}
+
+// postgresColumnType translates the MySQL column type into the PostgreSQL type which can hold all of its values
+func postgresColumnType(tp *types.FieldType, autoIncrement bool) string {
+	unsigned := mysql.HasUnsignedFlag(tp.Flag)
+	binary := tp.Charset == charset.CharsetBin
+	switch tp.Tp {
+	case mysql.TypeTiny, mysql.TypeYear:
+		return "smallint"
+	case mysql.TypeShort:
+		if unsigned {
+			return "integer"
+		}
+		return "smallint"
+	case mysql.TypeInt24:
+		return "integer"
+	case mysql.TypeLong:
+		if unsigned {
+			return "bigint"
+		}
+		return "integer"
+	case mysql.TypeLonglong:
+		// the identity columns must be integers
+		if unsigned && !autoIncrement {
+			return "numeric(20)"
+		}
+		return "bigint"
+	case mysql.TypeFloat:
+		return "real"
+	case mysql.TypeDouble:
+		return "double precision"
+	case mysql.TypeNewDecimal:
+		switch {
+		case tp.Flen <= 0:
+			return "numeric"
+		case tp.Decimal <= 0:
+			return fmt.Sprintf("numeric(%d)", tp.Flen)
+		default:
+			return fmt.Sprintf("numeric(%d,%d)", tp.Flen, tp.Decimal)
+		}
+	case mysql.TypeDate:
+		return "date"
+	case mysql.TypeDatetime, mysql.TypeTimestamp:
+		if tp.Decimal > 0 {
+			return fmt.Sprintf("timestamp(%d)", tp.Decimal)
+		}
+		return "timestamp"
+	case mysql.TypeDuration:
+		if tp.Decimal > 0 {
+			return fmt.Sprintf("time(%d)", tp.Decimal)
+		}
+		return "time"
+	case mysql.TypeVarchar, mysql.TypeVarString:
+		if binary {
+			return "bytea"
+		}
+		if tp.Flen > 0 {
+			return fmt.Sprintf("varchar(%d)", tp.Flen)
+		}
+		return "varchar"
+	case mysql.TypeString:
+		if binary {
+			return "bytea"
+		}
+		if tp.Flen > 0 {
+			return fmt.Sprintf("char(%d)", tp.Flen)
+		}
+		return "char"
+	case mysql.TypeTinyBlob, mysql.TypeBlob, mysql.TypeMediumBlob, mysql.TypeLongBlob:
+		if binary {
+			return "bytea"
+		}
+		return "text"
+	case mysql.TypeBit:
+		return "bytea"
+	case mysql.TypeJSON:
+		return "jsonb"
+	default:
+		// ENUM is checked by the constraint, and SET, GEOMETRY are kept in text
+		return "text"
+	}
+}
+
+func translateIndexKeys(keys []*ast.IndexPartSpecification) (string, error) {
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if key.Column != nil {
+			// PostgreSQL and SQLite have no prefix index, so the whole column is indexed
+			parts = append(parts, quotePostgresIdentifier(key.Column.Name.O))
+			continue
+		}
+		expr, err := restorePostgresNode(key.Expr)
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, "("+expr+")")
+	}
+	return strings.Join(parts, ","), nil
+}
+
+func translateDefaultValue(dialect string, expr ast.ExprNode) (string, error) {
+	if fn, ok := expr.(*ast.FuncCallExpr); ok {
+		switch fn.FnName.L {
+		case ast.CurrentTimestamp, ast.Now, ast.LocalTime, ast.LocalTimestamp:
+			// SQLite doesn't support the fractional seconds precision
+			if len(fn.Args) == 0 || dialect == sqlDialectSQLite {
+				return "CURRENT_TIMESTAMP", nil
+			}
+			fsp, err := restorePostgresNode(fn.Args[0])
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("CURRENT_TIMESTAMP(%s)", fsp), nil
+		}
+	}
+	return restorePostgresNode(expr)
+}
+
+// translateCreateViewToPostgres translates the view schema written by ShowCreateView. Like MySQL, the placeholder table of
+// the view is dropped before the view is created, so the views should be created in the order recorded in the metadata.
+func translateCreateViewToPostgres(db, view, createViewSQL string) (string, error) {
+	stmts, _, err := parser.New().Parse(createViewSQL, "", "")
+	if err != nil {
+		return "", errors.Annotate(err, "failed to parse the view schema")
+	}
+	for _, stmt := range stmts {
+		createView, ok := stmt.(*ast.CreateViewStmt)
+		if !ok {
+			continue
+		}
+		name := postgresTableName(db, view)
+		var columns string
+		if len(createView.Cols) > 0 {
+			cols := make([]string, 0, len(createView.Cols))
+			for _, col := range createView.Cols {
+				cols = append(cols, quotePostgresIdentifier(col.O))
+			}
+			columns = " (" + strings.Join(cols, ",") + ")"
+		}
+		selectSQL, err := restorePostgresNode(createView.Select)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("DROP TABLE IF EXISTS %s;\nCREATE VIEW %s%s AS %s;\n", name, name, columns, selectSQL), nil
+	}
+	return "", errors.New("can't find the CREATE VIEW statement in the view schema")
+}
+
+// restorePostgresNode restores the expression in the common syntax of MySQL and PostgreSQL.
+// The MySQL specific functions and operators are kept as they are.
+func restorePostgresNode(node ast.Node) (string, error) {
+	var b strings.Builder
+	if err := node.Restore(format.NewRestoreCtx(postgresRestoreFlags, &b)); err != nil {
+		return "", errors.Annotate(err, "failed to restore the expression")
+	}
+	return b.String(), nil
+}
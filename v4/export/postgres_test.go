@@ -0,0 +1,139 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package export
+
+import (
+	"database/sql/driver"
+
+	tcontext "github.com/pingcap/dumpling/v4/context"
+
+	"github.com/pingcap/br/pkg/storage"
+	. "github.com/pingcap/check"
+)
+
+var _ = Suite(&testPostgresSuite{})
+
+type testPostgresSuite struct{}
+
+func (s *testPostgresSuite) TestValidateSQLDialect(c *C) {
+	conf := defaultConfigForTest(c)
+	c.Assert(validateSQLDialect(conf), IsNil)
+	conf.FileType = FileFormatPostgresCopyString
+	c.Assert(validateSQLDialect(conf), ErrorMatches, "--filetype copy only applies to --sql-dialect postgres")
+
+	conf.SQLDialect = "Postgres"
+	c.Assert(validateSQLDialect(conf), IsNil)
+	c.Assert(conf.SQLDialect, Equals, sqlDialectPostgres)
+	conf.InsertMode = insertModeIgnore
+	c.Assert(validateSQLDialect(conf), IsNil)
+	conf.InsertMode = insertModeReplace
+	c.Assert(validateSQLDialect(conf), ErrorMatches, "--insert-mode replace isn't supported by --sql-dialect postgres")
+	conf.InsertMode = insertModeInsert
+	conf.SingleFile = true
+	c.Assert(validateSQLDialect(conf), ErrorMatches, "--single-file only supports --sql-dialect mysql")
+
+	conf.SQLDialect = "oracle"
+	c.Assert(validateSQLDialect(conf), ErrorMatches, "--sql-dialect is set to 'oracle'.*")
+}
+
+func (s *testPostgresSuite) TestTranslateCreateTable(c *C) {
+	createSQL := "CREATE TABLE `t` (\n" +
+		"  `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,\n" +
+		"  `uid` int(10) unsigned DEFAULT NULL,\n" +
+		"  `name` varchar(64) COLLATE utf8mb4_bin NOT NULL DEFAULT 'it''s' COMMENT 'the \"name\"',\n" +
+		"  `flag` tinyint(1) DEFAULT '0',\n" +
+		"  `price` decimal(10,2) DEFAULT NULL,\n" +
+		"  `data` blob,\n" +
+		"  `hash` varbinary(32) DEFAULT NULL,\n" +
+		"  `doc` json DEFAULT NULL,\n" +
+		"  `state` enum('on','off') DEFAULT 'on',\n" +
+		"  `created` datetime(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),\n" +
+		"  `updated` timestamp NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,\n" +
+		"  `total` int(11) GENERATED ALWAYS AS (`uid` + 1) VIRTUAL,\n" +
+		"  PRIMARY KEY (`id`),\n" +
+		"  UNIQUE KEY `uk_name` (`name`(10)),\n" +
+		"  KEY `idx_uid` (`uid`,`created`),\n" +
+		"  CONSTRAINT `fk` FOREIGN KEY (`uid`) REFERENCES `users` (`id`)\n" +
+		") ENGINE=InnoDB AUTO_INCREMENT=10 DEFAULT CHARSET=utf8mb4 COMMENT='orders'"
+	translated, err := translateCreateTable(sqlDialectPostgres, "test", "t", createSQL)
+	c.Assert(err, IsNil)
+	c.Assert(translated, Equals, "CREATE TABLE \"test\".\"t\" (\n"+
+		"  \"id\" bigint NOT NULL GENERATED BY DEFAULT AS IDENTITY,\n"+
+		"  \"uid\" bigint DEFAULT NULL,\n"+
+		"  \"name\" varchar(64) NOT NULL DEFAULT 'it''s',\n"+
+		"  \"flag\" smallint DEFAULT '0',\n"+
+		"  \"price\" numeric(10,2) DEFAULT NULL,\n"+
+		"  \"data\" bytea,\n"+
+		"  \"hash\" bytea DEFAULT NULL,\n"+
+		"  \"doc\" jsonb DEFAULT NULL,\n"+
+		"  \"state\" text DEFAULT 'on' CHECK (\"state\" IN ('on','off')),\n"+
+		"  \"created\" timestamp(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),\n"+
+		"  \"updated\" timestamp NULL DEFAULT CURRENT_TIMESTAMP,\n"+
+		"  \"total\" integer GENERATED ALWAYS AS (\"uid\"+1) STORED,\n"+
+		"  PRIMARY KEY (\"id\"),\n"+
+		"  UNIQUE (\"name\")\n"+
+		");\n"+
+		"COMMENT ON TABLE \"test\".\"t\" IS 'orders';\n"+
+		"COMMENT ON COLUMN \"test\".\"t\".\"name\" IS 'the \"name\"';\n"+
+		"CREATE INDEX ON \"test\".\"t\" (\"uid\",\"created\");\n")
+
+	_, err = translateCreateTable(sqlDialectPostgres, "test", "t", "CREATE TABLE `t` (")
+	c.Assert(err, ErrorMatches, "failed to parse the table schema.*")
+}
+
+func (s *testPostgresSuite) TestTranslateMetaTasks(c *C) {
+	task, err := translateMetaTask(sqlDialectPostgres, NewTaskDatabaseMeta("te\"st", "CREATE DATABASE `te\"st`"))
+	c.Assert(err, IsNil)
+	c.Assert(task.(*TaskDatabaseMeta).CreateDatabaseSQL, Equals, "CREATE SCHEMA IF NOT EXISTS \"te\"\"st\";\n")
+
+	createViewSQL := "DROP TABLE IF EXISTS `v`;\nDROP VIEW IF EXISTS `v`;\n" +
+		"SET @PREV_CHARACTER_SET_CLIENT=@@CHARACTER_SET_CLIENT;\nSET character_set_client = utf8;\n" +
+		"CREATE ALGORITHM=UNDEFINED DEFINER=`root`@`localhost` SQL SECURITY DEFINER VIEW `v` (`a`) AS " +
+		"SELECT `t`.`a` AS `a` FROM `test`.`t` WHERE `t`.`b` = 'x';\n" +
+		"SET character_set_client = @PREV_CHARACTER_SET_CLIENT;\n"
+	task, err = translateMetaTask(sqlDialectPostgres, NewTaskViewMeta("test", "v", "CREATE TABLE `v`(\n`a` int\n)ENGINE=MyISAM;\n", createViewSQL))
+	c.Assert(err, IsNil)
+	view := task.(*TaskViewMeta)
+	c.Assert(view.CreateTableSQL, Equals, "CREATE TABLE \"test\".\"v\" (\n  \"a\" integer\n);\n")
+	c.Assert(view.CreateViewSQL, Equals, "DROP TABLE IF EXISTS \"test\".\"v\";\n"+
+		"CREATE VIEW \"test\".\"v\" (\"a\") AS SELECT \"t\".\"a\" AS \"a\" FROM \"test\".\"t\" WHERE \"t\".\"b\"='x';\n")
+
+	_, err = translateMetaTask(sqlDialectPostgres, NewTaskViewMeta("test", "v", "CREATE TABLE `v`(\n`a` int\n)ENGINE=MyISAM;\n", "SELECT 1;"))
+	c.Assert(err, ErrorMatches, "failed to translate the schema of `test`.`v`: can't find the CREATE VIEW statement.*")
+}
+
+func (s *testPostgresSuite) TestWritePostgresData(c *C) {
+	data := [][]driver.Value{
+		{"1", "it's \\ a\ttab", []byte{0x00, 0xff}},
+		{"2", nil, nil},
+	}
+	colTypes := []string{"INT", "VARCHAR", "BLOB"}
+	specCmts := postgresSpecialComments()
+	newTableIR := func() *mockTableIR {
+		tableIR := newMockTableIR("test", "employee", data, specCmts, colTypes)
+		tableIR.selectedField = "`id`,`name`,`photo`"
+		tableIR.colNames = []string{"id", "name", "photo"}
+		return tableIR
+	}
+	conf := configForWriteSQL(UnspecifiedSize, UnspecifiedSize)
+	conf.SQLDialect = sqlDialectPostgres
+	conf.InsertMode = insertModeIgnore
+
+	tableIR := newTableIR()
+	bf := storage.NewBufferWriter()
+	c.Assert(WriteInsert(tcontext.Background(), conf, tableIR, tableIR, bf), IsNil)
+	c.Assert(bf.String(), Equals, "SET client_encoding = 'UTF8';\nSET standard_conforming_strings = on;\n"+
+		"INSERT INTO \"test\".\"employee\"(\"id\",\"name\",\"photo\") VALUES\n"+
+		"(1,'it''s \\ a\ttab','\\x00ff'::bytea),\n"+
+		"(2,NULL,NULL)\n"+
+		"ON CONFLICT DO NOTHING;\n")
+
+	tableIR = newTableIR()
+	bf = storage.NewBufferWriter()
+	c.Assert(WriteInsertInPostgresCopy(tcontext.Background(), conf, tableIR, tableIR, bf), IsNil)
+	c.Assert(bf.String(), Equals, "SET client_encoding = 'UTF8';\nSET standard_conforming_strings = on;\n"+
+		"COPY \"test\".\"employee\"(\"id\",\"name\",\"photo\") FROM stdin;\n"+
+		"1\tit's \\\\ a\\ttab\t\\\\x00ff\n"+
+		"2\t\\N\t\\N\n"+
+		"\\.\n")
+}
@@ -6,10 +6,12 @@ import (
 	"database/sql"
 	"fmt"
 	"regexp"
+	"sort"
 	"strings"
 	"text/template"
 
 	"github.com/pingcap/errors"
+	filter "github.com/pingcap/tidb-tools/pkg/table-filter"
 )
 
 const (
@@ -17,6 +19,8 @@ const (
 	outputFileTemplateTable  = "table"
 	outputFileTemplateView   = "view"
 	outputFileTemplateData   = "data"
+	// outputFileTemplateLoadData names the LOAD DATA scripts of --load-data-scripts
+	outputFileTemplateLoadData = "load"
 
 	defaultOutputFileTemplateBase = `
 		{{- define "objectName" -}}
@@ -46,8 +50,11 @@ const (
 		{{- define "table" -}}
 			{{template "objectName" .}}-schema
 		{{- end -}}
+		{{- define "load" -}}
+			{{template "objectName" .}}-load-data
+		{{- end -}}
 		{{- define "data" -}}
-			{{template "objectName" .}}.{{.Index}}
+			{{template "objectName" .}}{{if .Partition}}.{{fn .Partition}}{{end}}.{{.Index}}
 		{{- end -}}
 	`
 
@@ -170,7 +177,8 @@ func (d DatabaseTables) Literal() string {
 	b.WriteString("tables list\n")
 	b.WriteString("\n")
 
-	for dbName, tables := range d {
+	for _, dbName := range d.sortedDatabaseNames() {
+		tables := d[dbName]
 		b.WriteString("schema ")
 		b.WriteString(dbName)
 		b.WriteString(" :[")
@@ -183,3 +191,90 @@ func (d DatabaseTables) Literal() string {
 
 	return b.String()
 }
+
+// sortedDatabaseNames returns the database names in alphabetical order
+func (d DatabaseTables) sortedDatabaseNames() []string {
+	dbNames := make([]string, 0, len(d))
+	for dbName := range d {
+		dbNames = append(dbNames, dbName)
+	}
+	sort.Strings(dbNames)
+	return dbNames
+}
+
+const (
+	// dumpOrderAlphabetical dumps the tables in the alphabetical order of database and table names
+	dumpOrderAlphabetical = "alphabetical"
+	// dumpOrderSize dumps the largest tables first, so that they won't be the long tail of the dump
+	dumpOrderSize = "size"
+)
+
+func validateDumpOrder(order string) error {
+	switch order {
+	case dumpOrderAlphabetical, dumpOrderSize:
+		return nil
+	default:
+		return errors.Errorf("--dump-order is set to '%s'. It should be one of {%s|%s}", order, dumpOrderAlphabetical, dumpOrderSize)
+	}
+}
+
+// tableToDump is a table in the dumping order. table is nil for a database without any table,
+// which is still dumped if --dump-empty-database is set
+type tableToDump struct {
+	db       string
+	table    *TableInfo
+	priority int
+	size     uint64
+}
+
+// orderTablesToDump sorts the tables to dump. The tables matching --priority-tables come first in the order of the patterns,
+// then the others are sorted by --dump-order. sizes is the data length of tables, which is only used by dumpOrderSize.
+// The same input always gets the same order, so that the dumps are reproducible
+func orderTablesToDump(conf *Config, sizes map[string]map[string]uint64) []tableToDump {
+	tables := make([]tableToDump, 0, calculateTableCount(conf.Tables))
+	for dbName, infos := range conf.Tables {
+		if len(infos) == 0 {
+			tables = append(tables, tableToDump{db: dbName, priority: len(conf.PriorityTables)})
+			continue
+		}
+		for _, info := range infos {
+			t := tableToDump{db: dbName, table: info, priority: tablePriority(conf.PriorityTables, dbName, info.Name)}
+			if info.Type == TableTypeBase {
+				t.size = sizes[dbName][info.Name]
+			}
+			tables = append(tables, t)
+		}
+	}
+	bySize := conf.DumpOrder == dumpOrderSize
+	sort.Slice(tables, func(i, j int) bool {
+		a, b := tables[i], tables[j]
+		if a.priority != b.priority {
+			return a.priority < b.priority
+		}
+		if bySize && a.size != b.size {
+			return a.size > b.size
+		}
+		if a.db != b.db {
+			return a.db < b.db
+		}
+		return a.tableName() < b.tableName()
+	})
+	return tables
+}
+
+func (t tableToDump) tableName() string {
+	if t.table == nil {
+		return ""
+	}
+	return t.table.Name
+}
+
+// tablePriority returns the index of the first pattern matching the table, or len(patterns) if none matches
+func tablePriority(patterns []filter.Filter, db, table string) int {
+	for i, f := range patterns {
+		if f.MatchTable(db, table) {
+			return i
+		}
+	}
+	return len(patterns)
+}
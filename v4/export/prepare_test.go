@@ -5,6 +5,7 @@ package export
 import (
 	"context"
 	"fmt"
+	"regexp"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	. "github.com/pingcap/check"
@@ -137,3 +138,71 @@ func (s *testPrepareSuite) TestConfigValidation(c *C) {
 	conf.FileType = "rand_str"
 	c.Assert(adjustFileFormat(conf), ErrorMatches, "unknown config.FileType 'rand_str'")
 }
+
+func (s *testPrepareSuite) TestOrderTablesToDump(c *C) {
+	conf := defaultConfigForTest(c)
+	conf.Tables = NewDatabaseTables().
+		AppendTables("db2", "t3", "t1").
+		AppendViews("db2", "v1").
+		AppendTables("db1", "t2", "big")
+	conf.Tables["empty"] = make([]*TableInfo, 0)
+	literal := conf.Tables.Literal()
+	for i := 0; i < 10; i++ {
+		c.Assert(conf.Tables.Literal(), Equals, literal)
+	}
+	c.Assert(literal, Equals, "tables list\n\nschema db1 :[t2, big, ]schema db2 :[t3, t1, v1, ]schema empty :[]")
+
+	order := func(sizes map[string]map[string]uint64) []string {
+		var names []string
+		for _, t := range orderTablesToDump(conf, sizes) {
+			names = append(names, t.db+"."+t.tableName())
+		}
+		return names
+	}
+	c.Assert(order(nil), DeepEquals, []string{"db1.big", "db1.t2", "db2.t1", "db2.t3", "db2.v1", "empty."})
+
+	sizes := map[string]map[string]uint64{
+		"db1": {"big": 1000, "t2": 10},
+		"db2": {"t1": 10, "t3": 500, "v1": 9999},
+	}
+	// the order is alphabetical if the sizes fail to be queried
+	conf.DumpOrder = dumpOrderSize
+	c.Assert(order(nil), DeepEquals, []string{"db1.big", "db1.t2", "db2.t1", "db2.t3", "db2.v1", "empty."})
+	c.Assert(order(sizes), DeepEquals, []string{"db1.big", "db2.t3", "db1.t2", "db2.t1", "db2.v1", "empty."})
+
+	var err error
+	conf.PriorityTables, err = ParsePriorityTables([]string{"DB2.T1", "db1.*"}, false)
+	c.Assert(err, IsNil)
+	c.Assert(order(sizes), DeepEquals, []string{"db2.t1", "db1.big", "db1.t2", "db2.t3", "db2.v1", "empty."})
+	conf.DumpOrder = dumpOrderAlphabetical
+	c.Assert(order(sizes), DeepEquals, []string{"db2.t1", "db1.big", "db1.t2", "db2.t3", "db2.v1", "empty."})
+
+	_, err = ParsePriorityTables([]string{"db1.[a"}, true)
+	c.Assert(err, ErrorMatches, "failed to parse --priority-tables 'db1.\\[a'.*")
+	c.Assert(validateDumpOrder("random"), ErrorMatches, "--dump-order is set to 'random'.*")
+}
+
+func (s *testPrepareSuite) TestSelectTablesDataLength(c *C) {
+	db, mock, err := sqlmock.New()
+	c.Assert(err, IsNil)
+	defer db.Close()
+	conn, err := db.Conn(context.Background())
+	c.Assert(err, IsNil)
+
+	rows := sqlmock.NewRows([]string{"TABLE_SCHEMA", "TABLE_NAME", "DATA_LENGTH"}).
+		AddRow("db1", "t1", 16384).
+		AddRow("db1", "t2", nil).
+		AddRow("db2", "t3", 32768)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT TABLE_SCHEMA,TABLE_NAME,DATA_LENGTH FROM INFORMATION_SCHEMA.TABLES "+
+		"WHERE TABLE_TYPE = 'BASE TABLE' AND TABLE_SCHEMA IN (?,?)")).
+		WithArgs("db1", "db2").WillReturnRows(rows)
+	sizes, err := SelectTablesDataLength(conn, []string{"db1", "db2"})
+	c.Assert(err, IsNil)
+	c.Assert(sizes, DeepEquals, map[string]map[string]uint64{"db1": {"t1": 16384}, "db2": {"t3": 32768}})
+
+	// nothing is queried without databases
+	sizes, err = SelectTablesDataLength(conn, nil)
+	c.Assert(err, IsNil)
+	c.Assert(sizes, HasLen, 0)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
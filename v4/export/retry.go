@@ -3,43 +3,73 @@
 package export
 
 import (
+	stderrors "errors"
+	"io"
+	"net"
+	"net/http"
 	"strings"
+	"syscall"
 	"time"
 
 	tcontext "github.com/pingcap/dumpling/v4/context"
 
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/go-sql-driver/mysql"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/tidb-tools/pkg/dbutil"
 	"go.uber.org/zap"
+	"google.golang.org/api/googleapi"
 )
 
 const (
-	dumpChunkRetryTime       = 3
-	lockTablesRetryTime      = 5
-	dumpChunkWaitInterval    = 50 * time.Millisecond
-	dumpChunkMaxWaitInterval = 200 * time.Millisecond
+	dumpChunkRetryTime         = 3
+	lockTablesRetryTime        = 5
+	dumpChunkWaitInterval      = 50 * time.Millisecond
+	dumpChunkMaxWaitInterval   = 200 * time.Millisecond
+	flushTablesRetryTime       = 5
+	flushTablesWaitInterval    = 500 * time.Millisecond
+	flushTablesMaxWaitInterval = 8 * time.Second
+	snapshotsRetryTime         = 5
+	snapshotsWaitInterval      = 100 * time.Millisecond
+	snapshotsMaxWaitInterval   = 2 * time.Second
 	// ErrNoSuchTable is the error code no such table in MySQL/TiDB
 	ErrNoSuchTable uint16 = 1146
+	// ErrLockWaitTimeout is the error code lock wait timeout exceeded in MySQL
+	ErrLockWaitTimeout uint16 = 1205
 )
 
-func newDumpChunkBackoffer(shouldRetry bool) *dumpChunkBackoffer { // revive:disable-line:flag-parameter
-	if !shouldRetry {
-		return &dumpChunkBackoffer{
-			attempt: 1,
-		}
+// newDumpChunkBackoffer creates the backoffer for dumping a chunk. The errors from database are retried if shouldRetry is set,
+// the errors from storage are retried according to writeRetry, which is nil if the written files can't be rewritten
+func newDumpChunkBackoffer(shouldRetry bool, writeRetry *writeRetryPolicy) *dumpChunkBackoffer { // revive:disable-line:flag-parameter
+	b := &dumpChunkBackoffer{
+		attempt:      1,
+		queryAttempt: 1,
+		writeRetry:   writeRetry,
 	}
-	return &dumpChunkBackoffer{
-		attempt:      dumpChunkRetryTime,
-		delayTime:    dumpChunkWaitInterval,
-		maxDelayTime: dumpChunkMaxWaitInterval,
+	if shouldRetry {
+		b.attempt = dumpChunkRetryTime
+		b.queryAttempt = dumpChunkRetryTime
+		b.delayTime = dumpChunkWaitInterval
+		b.maxDelayTime = dumpChunkMaxWaitInterval
 	}
+	if writeRetry != nil {
+		b.writeAttempt = writeRetry.attempts
+		b.writeDelayTime = writeRetry.backoff
+	}
+	return b
 }
 
+// dumpChunkBackoffer counts the attempts for the errors from database and storage separately,
+// attempt is the remaining attempts of the last met kind of error
 type dumpChunkBackoffer struct {
 	attempt      int
+	queryAttempt int
 	delayTime    time.Duration
 	maxDelayTime time.Duration
+
+	writeRetry     *writeRetryPolicy
+	writeAttempt   int
+	writeDelayTime time.Duration
 }
 
 func (b *dumpChunkBackoffer) NextBackoff(err error) time.Duration {
@@ -48,12 +78,22 @@ func (b *dumpChunkBackoffer) NextBackoff(err error) time.Duration {
 		b.attempt = 0
 		return 0
 	} else if _, ok := err.(*writerError); ok {
-		// the uploader writer's retry logic is already done in aws client. needn't retry here
-		b.attempt = 0
-		return 0
+		if b.writeRetry == nil || !b.writeRetry.isRetryable(err) {
+			b.attempt = 0
+			return 0
+		}
+		b.writeAttempt--
+		b.attempt = b.writeAttempt
+		delay := b.writeDelayTime
+		b.writeDelayTime = 2 * b.writeDelayTime
+		if b.writeDelayTime > b.writeRetry.maxBackoff {
+			b.writeDelayTime = b.writeRetry.maxBackoff
+		}
+		return delay
 	}
 	b.delayTime = 2 * b.delayTime
-	b.attempt--
+	b.queryAttempt--
+	b.attempt = b.queryAttempt
 	if b.delayTime > b.maxDelayTime {
 		return b.maxDelayTime
 	}
@@ -64,6 +104,129 @@ func (b *dumpChunkBackoffer) Attempt() int {
 	return b.attempt
 }
 
+const (
+	// writeRetryErrorNetwork contains the network errors such as timeout and connection reset
+	writeRetryErrorNetwork = "network"
+	// writeRetryErrorThrottle contains the throttling and server errors of S3 and GCS
+	writeRetryErrorThrottle = "throttle"
+	// writeRetryErrorNoSpace contains the errors of no space left on device
+	writeRetryErrorNoSpace = "no-space"
+	// writeRetryErrorAll contains all the errors met when writing to storage
+	writeRetryErrorAll = "all"
+)
+
+var writeRetryErrorClasses = []string{writeRetryErrorNetwork, writeRetryErrorThrottle, writeRetryErrorNoSpace, writeRetryErrorAll}
+
+// writeRetryPolicy decides whether a data file is rewritten after failing to write it to storage
+type writeRetryPolicy struct {
+	attempts   int
+	backoff    time.Duration
+	maxBackoff time.Duration
+	classes    map[string]struct{}
+}
+
+// newWriteRetryPolicy returns nil if the configuration disables retrying writes
+func newWriteRetryPolicy(conf *Config) *writeRetryPolicy {
+	if conf.WriteRetryAttempts <= 1 {
+		return nil
+	}
+	p := &writeRetryPolicy{
+		attempts:   conf.WriteRetryAttempts,
+		backoff:    conf.WriteRetryBackoff,
+		maxBackoff: conf.WriteRetryMaxBackoff,
+		classes:    make(map[string]struct{}, len(conf.WriteRetryErrors)),
+	}
+	if p.maxBackoff < p.backoff {
+		p.maxBackoff = p.backoff
+	}
+	for _, class := range conf.WriteRetryErrors {
+		p.classes[strings.ToLower(class)] = struct{}{}
+	}
+	return p
+}
+
+func validateWriteRetryErrors(classes []string) error {
+	for _, class := range classes {
+		valid := false
+		for _, c := range writeRetryErrorClasses {
+			valid = valid || strings.EqualFold(class, c)
+		}
+		if !valid {
+			return errors.Errorf("unknown error class %s, supported error classes are %s", class, strings.Join(writeRetryErrorClasses, ","))
+		}
+	}
+	return nil
+}
+
+func (p *writeRetryPolicy) isRetryable(err error) bool {
+	if _, ok := p.classes[writeRetryErrorAll]; ok {
+		return true
+	}
+	_, ok := p.classes[classifyWriteError(err)]
+	return ok
+}
+
+// classifyWriteError returns the error class of the error met when writing to storage, or "" if the error isn't classified
+func classifyWriteError(err error) string {
+	for err != nil {
+		if stderrors.Is(err, syscall.ENOSPC) {
+			return writeRetryErrorNoSpace
+		}
+		if err == io.ErrUnexpectedEOF || stderrors.Is(err, syscall.ECONNRESET) || stderrors.Is(err, syscall.EPIPE) {
+			return writeRetryErrorNetwork
+		}
+		switch e := err.(type) {
+		case net.Error:
+			return writeRetryErrorNetwork
+		case *googleapi.Error:
+			if isThrottleStatusCode(e.Code) {
+				return writeRetryErrorThrottle
+			}
+		case awserr.RequestFailure:
+			// S3 sends the timeouts and the throttles with a status code 400 or 503, so the code is checked first
+			if class := classifyAWSErrorCode(e.Code()); class != "" {
+				return class
+			}
+			if isThrottleStatusCode(e.StatusCode()) {
+				return writeRetryErrorThrottle
+			}
+		case awserr.Error:
+			if class := classifyAWSErrorCode(e.Code()); class != "" {
+				return class
+			}
+		}
+		err = unwrapWriteError(err)
+	}
+	return ""
+}
+
+func classifyAWSErrorCode(code string) string {
+	switch code {
+	case "SlowDown", "Throttling", "ThrottlingException", "RequestLimitExceeded":
+		return writeRetryErrorThrottle
+	case "RequestError", "RequestTimeout":
+		return writeRetryErrorNetwork
+	}
+	return ""
+}
+
+func isThrottleStatusCode(code int) bool {
+	return code == http.StatusTooManyRequests || code >= http.StatusInternalServerError
+}
+
+func unwrapWriteError(err error) error {
+	if cause := errors.Cause(err); cause != err {
+		return cause
+	}
+	switch e := err.(type) {
+	case *writerError:
+		return e.error
+	case awserr.Error:
+		return e.OrigErr()
+	}
+	return stderrors.Unwrap(err)
+}
+
 func newLockTablesBackoffer(tctx *tcontext.Context, blockList map[string]map[string]interface{}) *lockTablesBackoffer {
 	return &lockTablesBackoffer{
 		tctx:      tctx,
@@ -102,6 +265,79 @@ func (b *lockTablesBackoffer) Attempt() int {
 	return b.attempt
 }
 
+func newFlushTablesBackoffer(shouldRetry bool) *flushTablesBackoffer { // revive:disable-line:flag-parameter
+	if !shouldRetry {
+		return &flushTablesBackoffer{
+			attempt: 1,
+		}
+	}
+	return &flushTablesBackoffer{
+		attempt:      flushTablesRetryTime,
+		delayTime:    flushTablesWaitInterval,
+		maxDelayTime: flushTablesMaxWaitInterval,
+	}
+}
+
+// flushTablesBackoffer retries 'flush table with read lock' which gives up due to lock_wait_timeout.
+// The server isn't blocked during the backoff, so the long queries get a chance to finish
+type flushTablesBackoffer struct {
+	attempt      int
+	delayTime    time.Duration
+	maxDelayTime time.Duration
+}
+
+func (b *flushTablesBackoffer) NextBackoff(err error) time.Duration {
+	err = errors.Cause(err)
+	if mysqlErr, ok := err.(*mysql.MySQLError); !ok || mysqlErr.Number != ErrLockWaitTimeout {
+		b.attempt = 0
+		return 0
+	}
+	b.delayTime = 2 * b.delayTime
+	b.attempt--
+	if b.delayTime > b.maxDelayTime {
+		return b.maxDelayTime
+	}
+	return b.delayTime
+}
+
+func (b *flushTablesBackoffer) Attempt() int {
+	return b.attempt
+}
+
+func newSnapshotsBackoffer() *snapshotsBackoffer {
+	return &snapshotsBackoffer{
+		attempt:      snapshotsRetryTime,
+		delayTime:    snapshotsWaitInterval,
+		maxDelayTime: snapshotsMaxWaitInterval,
+	}
+}
+
+// snapshotsBackoffer retries starting the dumping transactions when the binlog position moves while they are starting.
+// The locks are released during the backoff, so the writes on the server aren't blocked
+type snapshotsBackoffer struct {
+	attempt      int
+	delayTime    time.Duration
+	maxDelayTime time.Duration
+}
+
+func (b *snapshotsBackoffer) NextBackoff(err error) time.Duration {
+	if _, ok := errors.Cause(err).(*binlogPositionMovedError); !ok {
+		b.attempt = 0
+		return 0
+	}
+	delay := b.delayTime
+	b.delayTime = 2 * b.delayTime
+	if b.delayTime > b.maxDelayTime {
+		b.delayTime = b.maxDelayTime
+	}
+	b.attempt--
+	return delay
+}
+
+func (b *snapshotsBackoffer) Attempt() int {
+	return b.attempt
+}
+
 func getTableFromMySQLError(msg string) (db, table string, err error) {
 	// examples of the error msg:
 	// Error 1146: Table 'pingcap.t1' doesn't exist
@@ -0,0 +1,82 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package export
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+	"google.golang.org/api/googleapi"
+)
+
+var _ = Suite(&testRetrySuite{})
+
+type testRetrySuite struct{}
+
+func (s *testRetrySuite) TestClassifyWriteError(c *C) {
+	cases := []struct {
+		err   error
+		class string
+	}{
+		{&os.PathError{Op: "write", Path: "a.sql", Err: syscall.ENOSPC}, writeRetryErrorNoSpace},
+		{errors.Annotate(newWriterError(errors.Trace(&os.PathError{Op: "write", Path: "a.sql", Err: syscall.ENOSPC})), "open file error"), writeRetryErrorNoSpace},
+		{newWriterError(&net.OpError{Op: "dial", Err: context.DeadlineExceeded}), writeRetryErrorNetwork},
+		{newWriterError(io.ErrUnexpectedEOF), writeRetryErrorNetwork},
+		{newWriterError(awserr.New("RequestError", "send request failed", syscall.ECONNRESET)), writeRetryErrorNetwork},
+		{newWriterError(awserr.NewRequestFailure(awserr.New("SlowDown", "reduce your request rate", nil), 503, "id")), writeRetryErrorThrottle},
+		{newWriterError(awserr.NewRequestFailure(awserr.New("RequestTimeout", "idle connection timed out", nil), 400, "id")), writeRetryErrorNetwork},
+		{newWriterError(awserr.NewRequestFailure(awserr.New("SlowDown", "reduce your request rate", nil), 400, "id")), writeRetryErrorThrottle},
+		{newWriterError(awserr.NewRequestFailure(awserr.New("InternalError", "internal error", nil), 500, "id")), writeRetryErrorThrottle},
+		{newWriterError(awserr.NewRequestFailure(awserr.New("AccessDenied", "access denied", nil), 403, "id")), ""},
+		{newWriterError(&googleapi.Error{Code: 429}), writeRetryErrorThrottle},
+		{newWriterError(&googleapi.Error{Code: 404}), ""},
+		{newWriterError(errors.New("unknown")), ""},
+	}
+	for _, ca := range cases {
+		c.Assert(classifyWriteError(ca.err), Equals, ca.class, Commentf("%v", ca.err))
+	}
+}
+
+func (s *testRetrySuite) TestDumpChunkBackoffer(c *C) {
+	conf := DefaultConfig()
+	conf.WriteRetryAttempts = 3
+	conf.WriteRetryBackoff = time.Second
+	conf.WriteRetryMaxBackoff = 3 * time.Second
+	conf.WriteRetryErrors = []string{writeRetryErrorNoSpace}
+	noSpaceErr := newWriterError(&os.PathError{Op: "write", Path: "a.sql", Err: syscall.ENOSPC})
+
+	// the attempts of the errors from database and storage are counted separately
+	b := newDumpChunkBackoffer(true, newWriteRetryPolicy(conf))
+	c.Assert(b.NextBackoff(errors.New("invalid connection")), Equals, 2*dumpChunkWaitInterval)
+	c.Assert(b.Attempt(), Equals, dumpChunkRetryTime-1)
+	c.Assert(b.NextBackoff(noSpaceErr), Equals, time.Second)
+	c.Assert(b.Attempt(), Equals, 2)
+	c.Assert(b.NextBackoff(noSpaceErr), Equals, 2*time.Second)
+	c.Assert(b.Attempt(), Equals, 1)
+	c.Assert(b.NextBackoff(errors.New("invalid connection")), Equals, 4*dumpChunkWaitInterval)
+	c.Assert(b.Attempt(), Equals, dumpChunkRetryTime-2)
+	c.Assert(b.NextBackoff(noSpaceErr), Equals, 3*time.Second)
+	c.Assert(b.Attempt(), Equals, 0)
+
+	// the errors from storage are only retried in the configured classes
+	b = newDumpChunkBackoffer(false, newWriteRetryPolicy(conf))
+	c.Assert(b.Attempt(), Equals, 1)
+	b.NextBackoff(newWriterError(io.ErrUnexpectedEOF))
+	c.Assert(b.Attempt(), Equals, 0)
+
+	conf.WriteRetryAttempts = 1
+	c.Assert(newWriteRetryPolicy(conf), IsNil)
+	b = newDumpChunkBackoffer(false, nil)
+	b.NextBackoff(noSpaceErr)
+	c.Assert(b.Attempt(), Equals, 0)
+
+	c.Assert(validateWriteRetryErrors([]string{"Network", "all"}), IsNil)
+	c.Assert(validateWriteRetryErrors([]string{"disk"}), ErrorMatches, "unknown error class disk.*")
+}
@@ -0,0 +1,361 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package export
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/parser/mysql"
+	"github.com/pingcap/parser/types"
+	router "github.com/pingcap/tidb-tools/pkg/table-router"
+)
+
+const quotedIdentifierPattern = "`(?:[^`]|``)*`"
+
+var (
+	createDatabaseNameRe = regexp.MustCompile("^(CREATE DATABASE (?:IF NOT EXISTS )?)" + quotedIdentifierPattern)
+	createTableNameRe    = regexp.MustCompile("(?m)^(CREATE TABLE (?:IF NOT EXISTS )?)" + quotedIdentifierPattern)
+	dropViewNameRe       = regexp.MustCompile("(?m)^(DROP (?:TABLE|VIEW) IF EXISTS )" + quotedIdentifierPattern + ";$")
+	createViewNameRe     = regexp.MustCompile("(?m)^(CREATE (?:OR REPLACE )?(?:ALGORITHM=\\w+ )?(?:DEFINER=(?:" +
+		quotedIdentifierPattern + "@" + quotedIdentifierPattern + "|CURRENT_USER(?:\\(\\))?) )?(?:SQL SECURITY \\w+ )?VIEW )" +
+		quotedIdentifierPattern)
+)
+
+// ParseTableRoutes parses the rules of --route, each rule is `<schema pattern>[.<table pattern>]=<target schema>[.<target table>]`,
+// in which the patterns can contain the wildcards `*` and `?`. The table keeps its name if the target table is omitted.
+// It returns nil if there is no rule
+func ParseTableRoutes(rules []string, caseSensitive bool) (*router.Table, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	tableRules := make([]*router.TableRule, 0, len(rules))
+	for _, rule := range rules {
+		i := strings.LastIndex(rule, "=")
+		if i < 0 {
+			return nil, errors.Errorf("failed to parse --route '%s': the rule should be like 'schema.table=target_schema.target_table'", rule)
+		}
+		r := &router.TableRule{}
+		r.SchemaPattern, r.TablePattern = splitRoutePart(rule[:i])
+		r.TargetSchema, r.TargetTable = splitRoutePart(rule[i+1:])
+		if r.SchemaPattern == "" || r.TargetSchema == "" {
+			return nil, errors.Errorf("failed to parse --route '%s': the schema pattern and the target schema can't be empty", rule)
+		}
+		tableRules = append(tableRules, r)
+	}
+	tr, err := router.NewTableRouter(caseSensitive, tableRules)
+	if err != nil {
+		return nil, errors.Annotate(err, "failed to parse --route")
+	}
+	return tr, nil
+}
+
+// splitRoutePart splits `schema.table` of a route rule, the table is empty if there is no dot
+func splitRoutePart(s string) (schema, table string) {
+	s = strings.TrimSpace(s)
+	if i := strings.Index(s, "."); i >= 0 {
+		return strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+1:])
+	}
+	return s, ""
+}
+
+// routedTable is the target of a dumped table routed by --route
+type routedTable struct {
+	target tableKey
+	view   bool
+	// sourceIndex numbers the tables routed to the same target table from 1, so their data files don't overwrite each other.
+	// It's 0 if the table is the only one routed to the target
+	sourceIndex int
+}
+
+// tableRoutes is the targets of the dumped databases and tables, resolved before dumping.
+// The schemas written are only accessed by the goroutine sending the meta tasks
+type tableRoutes struct {
+	databases    map[string]string
+	tables       map[tableKey]routedTable
+	sourceColumn string
+
+	createDatabaseSQLs map[string]string
+	writtenDatabases   map[string]struct{}
+	writtenTables      map[tableKey]struct{}
+}
+
+// newTableRoutes resolves the targets of all the tables to dump, the routing errors like matching multiple rules are returned here
+func newTableRoutes(conf *Config) (*tableRoutes, error) {
+	r := &tableRoutes{
+		databases:          make(map[string]string, len(conf.Tables)),
+		tables:             make(map[tableKey]routedTable),
+		sourceColumn:       conf.RouteSourceColumn,
+		createDatabaseSQLs: make(map[string]string),
+		writtenDatabases:   make(map[string]struct{}),
+		writtenTables:      make(map[tableKey]struct{}),
+	}
+	sources := make(map[tableKey][]tableKey)
+	for db, tables := range conf.Tables {
+		targetDB, _, err := conf.TableRouter.Route(db, "")
+		if err != nil {
+			return nil, errors.Annotatef(err, "failed to route database `%s`", db)
+		}
+		r.databases[db] = targetDB
+		for _, table := range tables {
+			targetDB, targetTable, err := conf.TableRouter.Route(db, table.Name)
+			if err != nil {
+				return nil, errors.Annotatef(err, "failed to route table %s", tableKey{db: db, table: table.Name})
+			}
+			source, target := tableKey{db: db, table: table.Name}, tableKey{db: targetDB, table: targetTable}
+			r.tables[source] = routedTable{target: target, view: table.Type == TableTypeView}
+			sources[target] = append(sources[target], source)
+		}
+	}
+	for _, keys := range sources {
+		if len(keys) < 2 {
+			continue
+		}
+		sortTableKeys(keys)
+		for i, source := range keys {
+			rt := r.tables[source]
+			rt.sourceIndex = i + 1
+			r.tables[source] = rt
+		}
+	}
+	return r, nil
+}
+
+// route returns the target of the table, the table isn't routed if ok is false
+func (r *tableRoutes) route(db, table string) (rt routedTable, ok bool) {
+	if r == nil {
+		return routedTable{}, false
+	}
+	rt, ok = r.tables[tableKey{db: db, table: table}]
+	if !ok || rt.target == (tableKey{db: db, table: table}) {
+		return routedTable{}, false
+	}
+	return rt, true
+}
+
+// sourceColumnField returns the select field of the source column injected into the data of the routed table, like
+// 'db.tbl' AS `source`. It's empty if the table isn't routed, or it's a view, or --route-source-column isn't set
+func (r *tableRoutes) sourceColumnField(db, table string) string {
+	if rt, ok := r.route(db, table); !ok || rt.view || r.sourceColumn == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s AS `%s`", quoteSQLString(db+"."+table), escapeString(r.sourceColumn))
+}
+
+// insertField returns the fields of INSERT statements for the select field with the injected source column,
+// the source column is the last column of the routed table
+func (r *tableRoutes) insertField(db, table, selectField string) string {
+	sourceField := r.sourceColumnField(db, table)
+	if sourceField == "" {
+		return selectField
+	}
+	field := strings.TrimSuffix(selectField, ","+sourceField)
+	if field == "*" {
+		return field
+	}
+	return fmt.Sprintf("%s,`%s`", field, escapeString(r.sourceColumn))
+}
+
+// routeTask rewrites the names in the meta task with the targets. A target database is created like the source database
+// of the first table routed to it, and the schema of a target table is written only for the first table routed to it
+func (r *tableRoutes) routeTask(task Task) (tasks []Task, err error) {
+	switch t := task.(type) {
+	case *TaskDatabaseMeta:
+		r.createDatabaseSQLs[t.DatabaseName] = t.CreateDatabaseSQL
+		return r.createDatabase(t.DatabaseName, r.databases[t.DatabaseName], tasks), nil
+	case *TaskTableMeta:
+		rt, ok := r.route(t.DatabaseName, t.TableName)
+		if !ok {
+			return append(r.createDatabase(t.DatabaseName, t.DatabaseName, tasks), task), nil
+		}
+		tasks = r.createDatabase(t.DatabaseName, rt.target.db, tasks)
+		if _, written := r.writtenTables[rt.target]; written {
+			return tasks, nil
+		}
+		r.writtenTables[rt.target] = struct{}{}
+		createSQL := t.CreateTableSQL
+		if createSQL != "" {
+			createSQL = renameCreateTable(createSQL, rt.target.table)
+			if r.sourceColumn != "" {
+				if createSQL, err = addSourceColumn(createSQL, r.sourceColumn); err != nil {
+					return nil, errors.Annotatef(err, "failed to add the source column to table %s", tableKey{db: t.DatabaseName, table: t.TableName})
+				}
+			}
+		}
+		return append(tasks, NewTaskTableMeta(rt.target.db, rt.target.table, createSQL)), nil
+	case *TaskViewMeta:
+		rt, ok := r.route(t.DatabaseName, t.ViewName)
+		if !ok {
+			return append(r.createDatabase(t.DatabaseName, t.DatabaseName, tasks), task), nil
+		}
+		tasks = r.createDatabase(t.DatabaseName, rt.target.db, tasks)
+		if _, written := r.writtenTables[rt.target]; written {
+			return tasks, nil
+		}
+		r.writtenTables[rt.target] = struct{}{}
+		return append(tasks, NewTaskViewMeta(rt.target.db, rt.target.table,
+			renameCreateTable(t.CreateTableSQL, rt.target.table), renameCreateView(t.CreateViewSQL, rt.target.table))), nil
+	}
+	return []Task{task}, nil
+}
+
+// sendMetaTaskToChan sends the meta task routed by --route. The meta tasks of --single-file are recorded in order instead
+func (d *Dumper) sendMetaTaskToChan(task Task, taskChan chan<- Task) error {
+	tasks := []Task{task}
+	if d.routes != nil {
+		var err error
+		if tasks, err = d.routes.routeTask(task); err != nil {
+			return err
+		}
+	}
+	if dialect := d.conf.translatedDialect(); dialect != "" {
+		for i, t := range tasks {
+			var err error
+			if tasks[i], err = translateMetaTask(dialect, t); err != nil {
+				return err
+			}
+		}
+	}
+	if d.singleFile != nil {
+		d.singleFile.addMeta(task, tasks, d.routes)
+		return nil
+	}
+	if d.sqlite != nil {
+		// the tables are created before their data tasks are sent, so the writers never insert into a missing table
+		for _, t := range tasks {
+			if err := d.sqlite.writeMeta(d.tctx, t); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, t := range tasks {
+		if ctxDone := d.sendTaskToChan(t, taskChan); ctxDone {
+			break
+		}
+	}
+	return nil
+}
+
+// createDatabase appends the task creating the target database like the source database if it's not written yet
+func (r *tableRoutes) createDatabase(source, target string, tasks []Task) []Task {
+	if _, written := r.writtenDatabases[target]; written {
+		return tasks
+	}
+	createSQL, ok := r.createDatabaseSQLs[source]
+	if !ok {
+		return tasks
+	}
+	r.writtenDatabases[target] = struct{}{}
+	return append(tasks, NewTaskDatabaseMeta(target, renameCreateDatabase(createSQL, target)))
+}
+
+// routeTableMeta returns the TableMeta of the data files of the routed table, it returns meta itself if the table isn't routed
+func (r *tableRoutes) routeTableMeta(meta TableMeta) TableMeta {
+	rt, ok := r.route(meta.DatabaseName(), meta.TableName())
+	if !ok {
+		return meta
+	}
+	return &routedTableMeta{TableMeta: meta, target: rt.target, sourceIndex: rt.sourceIndex}
+}
+
+// routedTableMeta is the TableMeta of the routed table, whose data files are named with the target and insert into the target
+type routedTableMeta struct {
+	TableMeta
+	target      tableKey
+	sourceIndex int
+}
+
+// DatabaseName implements TableMeta.DatabaseName
+func (m *routedTableMeta) DatabaseName() string {
+	return m.target.db
+}
+
+// TableName implements TableMeta.TableName
+func (m *routedTableMeta) TableName() string {
+	return m.target.table
+}
+
+func quoteIdentifier(name string) string {
+	return "`" + escapeString(name) + "`"
+}
+
+func renameCreateDatabase(createSQL, db string) string {
+	return replaceName(createDatabaseNameRe, createSQL, db)
+}
+
+func renameCreateTable(createSQL, table string) string {
+	return replaceName(createTableNameRe, createSQL, table)
+}
+
+func renameCreateView(createSQL, view string) string {
+	return replaceName(createViewNameRe, replaceName(dropViewNameRe, createSQL, view), view)
+}
+
+// replaceName replaces the quoted identifier following the first group of re with name
+func replaceName(re *regexp.Regexp, s, name string) string {
+	return re.ReplaceAllStringFunc(s, func(match string) string {
+		prefix := re.FindStringSubmatch(match)[1]
+		suffix := ""
+		if strings.HasSuffix(match, ";") {
+			suffix = ";"
+		}
+		return prefix + quoteIdentifier(name) + suffix
+	})
+}
+
+// addSourceColumn adds the source column after the last column of the result of SHOW CREATE TABLE,
+// whose column definitions are on separate lines like "  `a` int(11) NOT NULL,".
+// The schema restored by --schema-rewrite is in one line, so the column is added to the parsed DDL instead
+func addSourceColumn(createSQL, column string) (string, error) {
+	lines := strings.Split(createSQL, "\n")
+	last := -1
+	for i, line := range lines {
+		if strings.HasPrefix(line, "  `") {
+			last = i
+		}
+	}
+	if last < 0 {
+		return addSourceColumnDef(createSQL, column)
+	}
+	definition := fmt.Sprintf("  `%s` varchar(512) NOT NULL", escapeString(column))
+	if strings.HasSuffix(lines[last], ",") {
+		definition += ","
+	} else {
+		lines[last] += ","
+	}
+	lines = append(lines[:last+1], append([]string{definition}, lines[last+1:]...)...)
+	return strings.Join(lines, "\n"), nil
+}
+
+func addSourceColumnDef(createSQL, column string) (string, error) {
+	stmt, err := parseCreateTable(createSQL)
+	if err != nil {
+		return "", errors.Annotate(err, "can't find the column definitions")
+	}
+	tp := types.NewFieldType(mysql.TypeVarchar)
+	tp.Flen = 512
+	stmt.Cols = append(stmt.Cols, &ast.ColumnDef{
+		Name:    &ast.ColumnName{Name: model.NewCIStr(column)},
+		Tp:      tp,
+		Options: []*ast.ColumnOption{{Tp: ast.ColumnOptionNotNull}},
+	})
+	return restoreCreateTable(stmt)
+}
+
+func validateTableRoutes(conf *Config) error {
+	if conf.TableRouter == nil {
+		if conf.RouteSourceColumn != "" {
+			return errors.New("--route-source-column must be used together with --route")
+		}
+		return nil
+	}
+	if conf.SQL != "" {
+		return errors.New("can't specify both --sql and --route at the same time")
+	}
+	return nil
+}
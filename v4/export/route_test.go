@@ -0,0 +1,128 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package export
+
+import (
+	"database/sql/driver"
+	"io/ioutil"
+	"path"
+
+	. "github.com/pingcap/check"
+)
+
+var _ = Suite(&testRouteSuite{})
+
+type testRouteSuite struct{}
+
+func (s *testRouteSuite) TestParseTableRoutes(c *C) {
+	r, err := ParseTableRoutes([]string{"shard_*.orders=orders_all.orders", "shard_*.users_?=users_all", "logs_*=logs"}, false)
+	c.Assert(err, IsNil)
+	for _, t := range []struct{ db, table, targetDB, targetTable string }{
+		{"shard_03", "orders", "orders_all", "orders"},
+		{"Shard_03", "Orders", "orders_all", "orders"},
+		{"shard_03", "users_1", "users_all", "users_1"},
+		{"shard_03", "items", "shard_03", "items"},
+		{"logs_1", "t", "logs", "t"},
+		{"logs_1", "", "logs", ""},
+	} {
+		db, table, err := r.Route(t.db, t.table)
+		c.Assert(err, IsNil)
+		c.Assert([]string{db, table}, DeepEquals, []string{t.targetDB, t.targetTable}, Commentf("table %s.%s", t.db, t.table))
+	}
+
+	r, err = ParseTableRoutes(nil, false)
+	c.Assert(err, IsNil)
+	c.Assert(r, IsNil)
+	for _, rule := range []string{"shard_*.orders", "=db", ".t=db", "db=.t"} {
+		_, err = ParseTableRoutes([]string{rule}, false)
+		c.Assert(err, ErrorMatches, "failed to parse --route.*", Commentf("rule %s", rule))
+	}
+}
+
+func (s *testRouteSuite) TestRouteTasks(c *C) {
+	conf := defaultConfigForTest(c)
+	var err error
+	conf.TableRouter, err = ParseTableRoutes([]string{"shard_*.orders=orders_all.orders", "shard_*.v=orders_all.v"}, false)
+	c.Assert(err, IsNil)
+	conf.RouteSourceColumn = "source"
+	conf.Tables = NewDatabaseTables().
+		AppendTables("shard_01", "orders", "users").
+		AppendTables("shard_03", "orders").
+		AppendViews("shard_03", "v")
+	routes, err := newTableRoutes(conf)
+	c.Assert(err, IsNil)
+	c.Assert(routes.tables[tableKey{db: "shard_01", table: "orders"}].sourceIndex, Equals, 1)
+	c.Assert(routes.tables[tableKey{db: "shard_03", table: "orders"}].sourceIndex, Equals, 2)
+	_, ok := routes.route("shard_01", "users")
+	c.Assert(ok, IsFalse)
+
+	route := func(task Task) []Task {
+		tasks, err := routes.routeTask(task)
+		c.Assert(err, IsNil)
+		return tasks
+	}
+	createDatabaseSQL := "CREATE DATABASE `shard_01` /*!40100 DEFAULT CHARACTER SET utf8mb4 */"
+	c.Assert(route(NewTaskDatabaseMeta("shard_01", createDatabaseSQL)), DeepEquals,
+		[]Task{NewTaskDatabaseMeta("shard_01", createDatabaseSQL)})
+	c.Assert(route(NewTaskTableMeta("shard_01", "orders", "CREATE TABLE `orders` (\n  `id` int(11) NOT NULL,\n  PRIMARY KEY (`id`)\n) ENGINE=InnoDB")), DeepEquals, []Task{
+		NewTaskDatabaseMeta("orders_all", "CREATE DATABASE `orders_all` /*!40100 DEFAULT CHARACTER SET utf8mb4 */"),
+		NewTaskTableMeta("orders_all", "orders", "CREATE TABLE `orders` (\n  `id` int(11) NOT NULL,\n  `source` varchar(512) NOT NULL,\n  PRIMARY KEY (`id`)\n) ENGINE=InnoDB"),
+	})
+	usersTask := NewTaskTableMeta("shard_01", "users", "CREATE TABLE `users` (\n  `id` int(11) NOT NULL\n)")
+	c.Assert(route(usersTask), DeepEquals, []Task{usersTask})
+	c.Assert(route(NewTaskDatabaseMeta("shard_03", "CREATE DATABASE `shard_03`")), DeepEquals,
+		[]Task{NewTaskDatabaseMeta("shard_03", "CREATE DATABASE `shard_03`")})
+	// the schema of the target table is written only once
+	c.Assert(route(NewTaskTableMeta("shard_03", "orders", "CREATE TABLE `orders` (\n  `id` int(11) NOT NULL\n)")), HasLen, 0)
+	c.Assert(route(NewTaskViewMeta("shard_03", "v", "CREATE TABLE `v`(\n`a` int\n)ENGINE=MyISAM;\n",
+		"DROP TABLE IF EXISTS `v`;\nDROP VIEW IF EXISTS `v`;\n"+
+			"CREATE ALGORITHM=UNDEFINED DEFINER=`root`@`localhost` SQL SECURITY DEFINER VIEW `v` AS SELECT 1 AS `a`;\n")), DeepEquals, []Task{
+		NewTaskViewMeta("orders_all", "v", "CREATE TABLE `v`(\n`a` int\n)ENGINE=MyISAM;\n",
+			"DROP TABLE IF EXISTS `v`;\nDROP VIEW IF EXISTS `v`;\n"+
+				"CREATE ALGORITHM=UNDEFINED DEFINER=`root`@`localhost` SQL SECURITY DEFINER VIEW `v` AS SELECT 1 AS `a`;\n"),
+	})
+
+	_, err = addSourceColumn("CREATE TABLE `t` ()", "source")
+	c.Assert(err, ErrorMatches, "can't find the column definitions.*")
+	c.Assert(renameCreateView("DROP TABLE IF EXISTS `v`;\nDROP VIEW IF EXISTS `v`;\nCREATE VIEW `v` AS SELECT `v`.`a` FROM `t` `v`;\n", "w"), Equals,
+		"DROP TABLE IF EXISTS `w`;\nDROP VIEW IF EXISTS `w`;\nCREATE VIEW `w` AS SELECT `v`.`a` FROM `t` `v`;\n")
+
+	conf.TableRouter, err = ParseTableRoutes([]string{"shard_*=a", "shard_0*=b"}, false)
+	c.Assert(err, IsNil)
+	_, err = newTableRoutes(conf)
+	c.Assert(err, ErrorMatches, "(?s)failed to route database `shard_0[13]`.*matches 2 schema route rules.*")
+}
+
+func (s *testRouteSuite) TestRouteTableData(c *C) {
+	d := newDumperForSchemaTest(c)
+	var err error
+	d.conf.TableRouter, err = ParseTableRoutes([]string{"shard_*.orders=orders_all.orders"}, false)
+	c.Assert(err, IsNil)
+	d.conf.RouteSourceColumn = "source"
+	d.conf.Tables = NewDatabaseTables().AppendTables("shard_01", "orders").AppendTables("shard_03", "orders", "users")
+	d.routes, err = newTableRoutes(d.conf)
+	c.Assert(err, IsNil)
+	cacheTestSchemas(d, "shard_03", "orders", "users")
+
+	selectField, selectLen, err := d.buildSelectField(nil, "shard_03", "orders")
+	c.Assert(err, IsNil)
+	c.Assert(selectField, Equals, "*,'shard_03.orders' AS `source`")
+	c.Assert(selectLen, Equals, 2)
+	c.Assert(d.routes.insertField("shard_03", "orders", selectField), Equals, "*")
+	c.Assert(d.routes.insertField("shard_03", "orders", "`id`,'shard_03.orders' AS `source`"), Equals, "`id`,`source`")
+	selectField, selectLen, err = d.buildSelectField(nil, "shard_03", "users")
+	c.Assert(err, IsNil)
+	c.Assert(selectField, Equals, "*")
+	c.Assert(selectLen, Equals, 1)
+
+	dir := c.MkDir()
+	d.conf.OutputDirPath = dir
+	writer := (&testWriterSuite{}).newWriter(d.conf, c)
+	writer.routes = d.routes
+	tableIR := newMockTableIR("shard_03", "orders", [][]driver.Value{{"1", "shard_03.orders"}},
+		[]string{"/*!40101 SET NAMES binary*/;"}, []string{"INT", "VARCHAR"})
+	c.Assert(writer.handleTask(NewTaskTableData(tableIR, tableIR, 0, 1)), IsNil)
+	data, err := ioutil.ReadFile(path.Join(dir, "orders_all.orders.0002000000000.sql"))
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "/*!40101 SET NAMES binary*/;\nINSERT INTO `orders` VALUES\n(1,'shard_03.orders');\n")
+}
@@ -0,0 +1,186 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package export
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/big"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/pingcap/errors"
+	"go.uber.org/zap"
+)
+
+// defaultSampleRanges is the max number of the integer ranges a table is split into for sampling on MySQL
+const defaultSampleRanges = 100
+
+// sampling returns true if only a sample of each table is dumped
+func (conf *Config) sampling() bool {
+	return conf.SamplePercent > 0 || conf.SampleRows > 0
+}
+
+// tableSampleSeed derives the seed of a table from the seed of the dump, so the sample of a table
+// doesn't depend on the order the tables are dumped in
+func tableSampleSeed(seed int64, db, tbl string) int64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(db))
+	_, _ = h.Write([]byte{0})
+	_, _ = h.Write([]byte(tbl))
+	return seed ^ int64(h.Sum64())
+}
+
+// pickSampleRanges picks the ranges to dump out of the n ranges of a table in ascending order, and the LIMIT of each range.
+// count is the estimated rows count of the table, 0 means unknown. The LIMIT is 0 if the picked ranges are dumped entirely
+func pickSampleRanges(conf *Config, rng *rand.Rand, n int, count uint64) ([]int, uint64) {
+	var (
+		picked int
+		target uint64
+	)
+	if conf.SampleRows > 0 {
+		target = conf.SampleRows
+		picked = n
+		if count > 0 {
+			picked = int(math.Ceil(float64(n) * float64(target) / float64(count)))
+		}
+	} else {
+		target = uint64(math.Ceil(float64(count) * conf.SamplePercent / 100))
+		picked = int(math.Ceil(float64(n) * conf.SamplePercent / 100))
+	}
+	if picked > n {
+		picked = n
+	}
+	if picked < 1 {
+		picked = 1
+	}
+	ranges := rng.Perm(n)[:picked]
+	sort.Ints(ranges)
+	if target == 0 {
+		return ranges, 0
+	}
+	return ranges, (target + uint64(picked) - 1) / uint64(picked)
+}
+
+// sampleRanges splits the table into the ranges to pick for sampling. It also returns the field to estimate the rows count,
+// and the ORDER BY clause which makes the LIMIT of each range reproducible. The only range is "" if the table can't be split.
+// The integer handle of TiDB is split like MySQL too, because the region boundaries change when the regions are split or merged
+func (d *Dumper) sampleRanges(conn *sql.Conn, db, tbl string) (ranges []string, field, orderByClause string, err error) {
+	conf := d.conf
+	field, err = d.pickupPossibleField(conn, db, tbl)
+	if err != nil {
+		return nil, "", "", err
+	}
+	if field == "" && conf.ServerInfo.ServerType == ServerTypeTiDB &&
+		conf.ServerInfo.ServerVersion != nil &&
+		conf.ServerInfo.ServerVersion.Compare(*tableSampleVersion) >= 0 {
+		// the regions of the table clustered by a non-integer primary key got by TABLESAMPLE REGIONS() are the ranges
+		handleColNames, handleVals, err := d.selectTiDBTableSample(conn, db, tbl)
+		if err != nil {
+			return nil, "", "", err
+		}
+		if len(handleColNames) > 0 {
+			ranges = buildWhereClauses(handleColNames, handleVals)
+			if len(ranges) == 0 {
+				ranges = []string{""}
+			}
+			return ranges, handleColNames[0], buildOrderByClauseString(handleColNames), nil
+		}
+	}
+	if field == "" {
+		orderByClause, err = d.buildOrderByClause(conn, db, tbl)
+		return []string{""}, "", orderByClause, err
+	}
+	min, max, err := d.selectMinAndMaxIntValue(conn, db, tbl, "", field)
+	if err != nil {
+		return nil, "", "", err
+	}
+	// split [min, max] into the even ranges of the integer field
+	n := new(big.Int).Sub(max, min)
+	n.Add(n, big.NewInt(1))
+	if n.Cmp(big.NewInt(defaultSampleRanges)) > 0 {
+		n.SetInt64(defaultSampleRanges)
+	}
+	step := new(big.Int).Sub(max, min)
+	step.Div(step, n).Add(step, big.NewInt(1))
+	quotedField := fmt.Sprintf("`%s`", escapeString(field))
+	nullValueCondition := fmt.Sprintf("%s IS NULL OR ", quotedField)
+	for cutoff := new(big.Int).Set(min); max.Cmp(cutoff) >= 0; {
+		next := new(big.Int).Add(cutoff, step)
+		ranges = append(ranges, fmt.Sprintf("%s(%s >= %d AND %s < %d)", nullValueCondition, quotedField, cutoff, quotedField, next))
+		nullValueCondition = ""
+		cutoff = next
+	}
+	return ranges, field, "ORDER BY " + quotedField, nil
+}
+
+// sampleTable dumps a reproducible sample of the table. The table is split into ranges by the integer handle,
+// or by TABLESAMPLE on TiDB without one, then the ranges picked by the seeded random are dumped with a LIMIT
+func (d *Dumper) sampleTable(conn *sql.Conn, meta TableMeta, taskChan chan<- Task) error {
+	conf := d.conf
+	db, tbl := meta.DatabaseName(), meta.TableName()
+	selectField, selectLen, err := d.buildSelectField(conn, db, tbl)
+	if err != nil {
+		return err
+	}
+	ranges, field, orderByClause, err := d.sampleRanges(conn, db, tbl)
+	if err != nil {
+		return err
+	}
+	tableWhere := d.tableWhere(db, tbl)
+	count := estimateCount(d.tctx, db, tbl, "", conn, field, tableWhere)
+	rng := rand.New(rand.NewSource(tableSampleSeed(conf.SampleSeed, db, tbl))) // #nosec G404
+	picked, limit := pickSampleRanges(conf, rng, len(ranges), count)
+	d.L().Info("dump a sample of table", zap.String("database", db), zap.String("table", tbl),
+		zap.Uint64("estimateCount", count), zap.Int("ranges", len(ranges)),
+		zap.Int("pickedRanges", len(picked)), zap.Uint64("limit", limit))
+
+	if limit > 0 {
+		orderByClause = fmt.Sprintf("%s LIMIT %d", orderByClause, limit)
+	}
+	for i, r := range picked {
+		query := buildSelectQuery(db, tbl, selectField, buildWhereCondition(tableWhere, ranges[r]), orderByClause)
+		task := NewTaskTableData(meta, newTableData(query, selectLen, false), i, len(picked))
+		if ctxDone := d.sendTaskToChan(task, taskChan); ctxDone {
+			break
+		}
+	}
+	return nil
+}
+
+// writeSampleOptions writes the sampling options in metadata, with which the same sample can be dumped again
+func writeSampleOptions(buffer *bytes.Buffer, conf *Config) {
+	buffer.WriteString("SAMPLE:\n")
+	if conf.SampleRows > 0 {
+		fmt.Fprintf(buffer, "\tRows: %d\n", conf.SampleRows)
+	} else {
+		fmt.Fprintf(buffer, "\tPercent: %g\n", conf.SamplePercent)
+	}
+	fmt.Fprintf(buffer, "\tSeed: %d\n\n", conf.SampleSeed)
+}
+
+func adjustSampleOptions(conf *Config) error {
+	if conf.SamplePercent < 0 || conf.SamplePercent > 100 {
+		return errors.Errorf("--sample-percent is set to %g. It should be in (0, 100]", conf.SamplePercent)
+	}
+	if !conf.sampling() {
+		return nil
+	}
+	if conf.SamplePercent > 0 && conf.SampleRows > 0 {
+		return errors.New("can't specify both --sample-percent and --sample-rows at the same time")
+	}
+	if conf.SQL != "" {
+		return errors.New("can't specify --sql with --sample-percent or --sample-rows at the same time")
+	}
+	if conf.SplitPartitions || conf.PartitionFilter.hasAnyRule() {
+		return errors.New("can't dump a sample of tables by partitions, please remove --split-partitions and the partition rules of --filter")
+	}
+	if conf.SampleSeed == 0 {
+		conf.SampleSeed = time.Now().UnixNano()
+	}
+	return nil
+}
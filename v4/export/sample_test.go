@@ -0,0 +1,168 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package export
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"strings"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/coreos/go-semver/semver"
+	. "github.com/pingcap/check"
+)
+
+var _ = Suite(&testSampleSuite{})
+
+type testSampleSuite struct{}
+
+func (s *testSampleSuite) TestPickSampleRanges(c *C) {
+	conf := DefaultConfig()
+	conf.SamplePercent = 10
+	ranges, limit := pickSampleRanges(conf, rand.New(rand.NewSource(1)), 100, 5000)
+	c.Assert(ranges, HasLen, 10)
+	c.Assert(limit, Equals, uint64(50))
+	for i := 1; i < len(ranges); i++ {
+		c.Assert(ranges[i-1] < ranges[i], IsTrue)
+	}
+	again, _ := pickSampleRanges(conf, rand.New(rand.NewSource(1)), 100, 5000)
+	c.Assert(again, DeepEquals, ranges)
+
+	// at least one range is picked, and the picked ranges are dumped entirely if the rows count is unknown
+	ranges, limit = pickSampleRanges(conf, rand.New(rand.NewSource(1)), 3, 0)
+	c.Assert(ranges, HasLen, 1)
+	c.Assert(limit, Equals, uint64(0))
+
+	conf.SamplePercent, conf.SampleRows = 0, 100
+	ranges, limit = pickSampleRanges(conf, rand.New(rand.NewSource(1)), 100, 1000)
+	c.Assert(ranges, HasLen, 10)
+	c.Assert(limit, Equals, uint64(10))
+	ranges, limit = pickSampleRanges(conf, rand.New(rand.NewSource(1)), 100, 50)
+	c.Assert(ranges, HasLen, 100)
+	c.Assert(limit, Equals, uint64(1))
+	ranges, limit = pickSampleRanges(conf, rand.New(rand.NewSource(1)), 1, 0)
+	c.Assert(ranges, DeepEquals, []int{0})
+	c.Assert(limit, Equals, uint64(100))
+}
+
+func (s *testSampleSuite) TestTableSampleSeed(c *C) {
+	c.Assert(tableSampleSeed(1, "db", "t"), Equals, tableSampleSeed(1, "db", "t"))
+	c.Assert(tableSampleSeed(1, "db", "t"), Not(Equals), tableSampleSeed(2, "db", "t"))
+	c.Assert(tableSampleSeed(1, "db", "t1"), Not(Equals), tableSampleSeed(1, "db", "t2"))
+	c.Assert(tableSampleSeed(1, "db", "at"), Not(Equals), tableSampleSeed(1, "dba", "t"))
+}
+
+func (s *testSampleSuite) TestSampleTable(c *C) {
+	db, mock, err := sqlmock.New()
+	c.Assert(err, IsNil)
+	defer db.Close()
+	conn, err := db.Conn(context.Background())
+	c.Assert(err, IsNil)
+
+	d := newDumperForSchemaTest(c)
+	d.conf.SampleRows = 50
+	d.conf.SampleSeed = 42
+	cacheTestSchemas(d, "test", "t1")
+
+	sample := func() []string {
+		mock.ExpectQuery("SELECT MIN\\(`id`\\),MAX\\(`id`\\) FROM `test`.`t1`").
+			WillReturnRows(sqlmock.NewRows([]string{"MIN(`id`)", "MAX(`id`)"}).AddRow("1", "1000"))
+		mock.ExpectQuery("EXPLAIN SELECT `id` FROM `test`.`t1`").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "rows"}).AddRow("1", "1000"))
+		taskChan := make(chan Task, 10)
+		c.Assert(d.dumpTableData(conn, &tableMeta{database: "test", table: "t1"}, taskChan), IsNil)
+		c.Assert(mock.ExpectationsWereMet(), IsNil)
+		close(taskChan)
+		var queries []string
+		for task := range taskChan {
+			td := task.(*TaskTableData)
+			c.Assert(td.ChunkIndex, Equals, len(queries))
+			c.Assert(td.TotalChunks, Equals, 5)
+			queries = append(queries, td.Data.(*tableData).query)
+		}
+		return queries
+	}
+	queries := sample()
+	c.Assert(queries, HasLen, 5)
+	for _, q := range queries {
+		c.Assert(q, Matches, "SELECT \\* FROM `test`.`t1`  WHERE .*\\(`id` >= [0-9]+ AND `id` < [0-9]+\\) ORDER BY `id` LIMIT 10")
+	}
+	// the sample is reproducible with the same seed
+	c.Assert(sample(), DeepEquals, queries)
+	d.conf.SampleSeed = 43
+	c.Assert(sample(), Not(DeepEquals), queries)
+}
+
+func (s *testSampleSuite) TestSampleTiDBTable(c *C) {
+	db, mock, err := sqlmock.New()
+	c.Assert(err, IsNil)
+	defer db.Close()
+	conn, err := db.Conn(context.Background())
+	c.Assert(err, IsNil)
+
+	d := newDumperForSchemaTest(c)
+	d.conf.ServerInfo = ServerInfo{ServerType: ServerTypeTiDB, ServerVersion: semver.New("5.0.0")}
+	d.conf.SamplePercent = 10
+	d.conf.SampleSeed = 42
+	cacheTestSchemas(d, "test", "t1")
+
+	// the integer handle is split into the same ranges whatever the regions are, so TABLESAMPLE isn't queried
+	mock.ExpectExec("SELECT _tidb_rowid from `test`.`t1` LIMIT 0").WillReturnResult(sqlmock.NewResult(0, 0))
+	sample := func() []string {
+		mock.ExpectQuery("SELECT MIN\\(`_tidb_rowid`\\),MAX\\(`_tidb_rowid`\\) FROM `test`.`t1`").
+			WillReturnRows(sqlmock.NewRows([]string{"MIN(`_tidb_rowid`)", "MAX(`_tidb_rowid`)"}).AddRow("1", "1000"))
+		mock.ExpectQuery("EXPLAIN SELECT `_tidb_rowid` FROM `test`.`t1`").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "estRows"}).AddRow("1", "1000"))
+		taskChan := make(chan Task, 20)
+		c.Assert(d.dumpTableData(conn, &tableMeta{database: "test", table: "t1"}, taskChan), IsNil)
+		c.Assert(mock.ExpectationsWereMet(), IsNil)
+		close(taskChan)
+		var queries []string
+		for task := range taskChan {
+			queries = append(queries, task.(*TaskTableData).Data.(*tableData).query)
+		}
+		return queries
+	}
+	queries := sample()
+	c.Assert(queries, HasLen, 10)
+	for _, q := range queries {
+		c.Assert(q, Matches, "SELECT \\* FROM `test`.`t1`  WHERE .*\\(`_tidb_rowid` >= [0-9]+ AND `_tidb_rowid` < [0-9]+\\) ORDER BY `_tidb_rowid` LIMIT 10")
+	}
+	c.Assert(sample(), DeepEquals, queries)
+}
+
+func (s *testSampleSuite) TestAdjustSampleOptions(c *C) {
+	conf := DefaultConfig()
+	c.Assert(adjustSampleOptions(conf), IsNil)
+	c.Assert(conf.SampleSeed, Equals, int64(0))
+
+	conf.SamplePercent = 1.5
+	c.Assert(adjustSampleOptions(conf), IsNil)
+	c.Assert(conf.SampleSeed, Not(Equals), int64(0))
+	conf.SampleSeed = 7
+	c.Assert(adjustSampleOptions(conf), IsNil)
+	c.Assert(conf.SampleSeed, Equals, int64(7))
+
+	conf.SampleRows = 10
+	c.Assert(adjustSampleOptions(conf), ErrorMatches, "can't specify both --sample-percent and --sample-rows at the same time")
+	conf.SampleRows = 0
+	conf.SamplePercent = 101
+	c.Assert(adjustSampleOptions(conf), ErrorMatches, "--sample-percent is set to 101. It should be in \\(0, 100\\]")
+	conf.SamplePercent = 10
+	conf.SplitPartitions = true
+	c.Assert(adjustSampleOptions(conf), ErrorMatches, "can't dump a sample of tables by partitions.*")
+}
+
+func (s *testSampleSuite) TestWriteSampleOptions(c *C) {
+	conf := DefaultConfig()
+	conf.SamplePercent, conf.SampleSeed = 0.5, 42
+	var buffer bytes.Buffer
+	writeSampleOptions(&buffer, conf)
+	c.Assert(buffer.String(), Equals, "SAMPLE:\n\tPercent: 0.5\n\tSeed: 42\n\n")
+
+	buffer.Reset()
+	conf.SamplePercent, conf.SampleRows = 0, 1000
+	writeSampleOptions(&buffer, conf)
+	c.Assert(strings.Split(buffer.String(), "\n")[1], Equals, "\tRows: 1000")
+}
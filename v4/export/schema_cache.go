@@ -0,0 +1,391 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package export
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"sync"
+
+	"github.com/pingcap/errors"
+	"go.uber.org/zap"
+)
+
+// tableMetaPrefetchWindow is the max number of tables whose meta is queried ahead of dumping
+const tableMetaPrefetchWindow = 256
+
+// columnInfo is a column of a table in INFORMATION_SCHEMA.COLUMNS
+type columnInfo struct {
+	name     string
+	extra    string
+	dataType string
+	key      string
+}
+
+// tableSchema is the cached INFORMATION_SCHEMA metadata of a table
+type tableSchema struct {
+	// columns are in ordinal order
+	columns []columnInfo
+	// pkColumns are the primary key columns in ordinal order
+	pkColumns []string
+	// partitions are the partition names in ordinal order, they are only loaded if withPartitions is set
+	partitions []string
+
+	tidbRowIDOnce sync.Once
+	hasTiDBRowID  bool
+	tidbRowIDErr  error
+}
+
+// selectField returns the same result as buildSelectField
+func (s *tableSchema) selectField(completeInsert bool) (string, int) { // revive:disable-line:flag-parameter
+	fields := make([]string, 0, len(s.columns))
+	extras := make([]string, 0, len(s.columns))
+	for _, col := range s.columns {
+		fields = append(fields, col.name)
+		extras = append(extras, col.extra)
+	}
+	return buildSelectFieldFromColumns(fields, extras, completeInsert)
+}
+
+// numericIndex returns the same result as getNumericIndex
+func (s *tableSchema) numericIndex(indexType string) string {
+	for _, col := range s.columns {
+		if col.key == indexType && (col.dataType == "int" || col.dataType == "bigint") {
+			return col.name
+		}
+	}
+	return ""
+}
+
+// pkColumnsAndTypes returns the same result as GetPrimaryKeyAndColumnTypes
+func (s *tableSchema) pkColumnsAndTypes() ([]string, []string) {
+	var colNames, colTypes []string
+	for _, pk := range s.pkColumns {
+		for _, col := range s.columns {
+			if col.name == pk && col.key == "PRI" {
+				colNames = append(colNames, col.name)
+				colTypes = append(colTypes, strings.ToUpper(col.dataType))
+				break
+			}
+		}
+	}
+	return colNames, colTypes
+}
+
+// databaseSchema is the cached INFORMATION_SCHEMA metadata of all the tables in a database
+type databaseSchema struct {
+	loaded chan struct{}
+	tables map[string]*tableSchema
+	err    error
+}
+
+// schemaCache caches the INFORMATION_SCHEMA metadata of tables, which is queried once for each database
+// instead of several times for each table. It's safe for concurrent use
+type schemaCache struct {
+	mu  sync.Mutex
+	dbs map[string]*databaseSchema
+	// withPartitions loads the partitions of tables too
+	withPartitions bool
+}
+
+func newSchemaCache(withPartitions bool) *schemaCache {
+	return &schemaCache{dbs: make(map[string]*databaseSchema), withPartitions: withPartitions}
+}
+
+// table returns the cached metadata of a table, the metadata of its database is loaded by conn on the first call.
+// It returns nil if the table isn't found, then the caller should query the metadata of the table itself
+func (c *schemaCache) table(conn *sql.Conn, db, table string) (*tableSchema, error) {
+	c.mu.Lock()
+	s, ok := c.dbs[db]
+	if !ok {
+		s = &databaseSchema{loaded: make(chan struct{})}
+		c.dbs[db] = s
+		c.mu.Unlock()
+		s.tables, s.err = loadDatabaseSchema(conn, db, c.withPartitions)
+		if s.err != nil {
+			// let the next caller try again
+			c.mu.Lock()
+			delete(c.dbs, db)
+			c.mu.Unlock()
+		}
+		close(s.loaded)
+	} else {
+		c.mu.Unlock()
+		<-s.loaded
+	}
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.tables[table], nil
+}
+
+func loadDatabaseSchema(conn *sql.Conn, db string, withPartitions bool) (map[string]*tableSchema, error) {
+	const columnsQuery = "SELECT TABLE_NAME,COLUMN_NAME,EXTRA,DATA_TYPE,COLUMN_KEY FROM INFORMATION_SCHEMA.COLUMNS " +
+		"WHERE TABLE_SCHEMA = ? ORDER BY TABLE_NAME,ORDINAL_POSITION"
+	const pkQuery = "SELECT TABLE_NAME,COLUMN_NAME FROM INFORMATION_SCHEMA.KEY_COLUMN_USAGE " +
+		"WHERE TABLE_SCHEMA = ? AND CONSTRAINT_NAME = 'PRIMARY' ORDER BY TABLE_NAME,ORDINAL_POSITION"
+	tables := make(map[string]*tableSchema)
+	if err := simpleQueryWithArgs(conn, func(rows *sql.Rows) error {
+		var (
+			table string
+			col   columnInfo
+		)
+		if err := rows.Scan(&table, &col.name, &col.extra, &col.dataType, &col.key); err != nil {
+			return errors.Trace(err)
+		}
+		s, ok := tables[table]
+		if !ok {
+			s = &tableSchema{}
+			tables[table] = s
+		}
+		s.columns = append(s.columns, col)
+		return nil
+	}, columnsQuery, db); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err := simpleQueryWithArgs(conn, func(rows *sql.Rows) error {
+		var table, col string
+		if err := rows.Scan(&table, &col); err != nil {
+			return errors.Trace(err)
+		}
+		if s, ok := tables[table]; ok {
+			s.pkColumns = append(s.pkColumns, col)
+		}
+		return nil
+	}, pkQuery, db); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if !withPartitions {
+		return tables, nil
+	}
+	const partitionsQuery = "SELECT TABLE_NAME,PARTITION_NAME FROM INFORMATION_SCHEMA.PARTITIONS " +
+		"WHERE TABLE_SCHEMA = ? AND PARTITION_NAME IS NOT NULL " +
+		"GROUP BY TABLE_NAME,PARTITION_NAME ORDER BY TABLE_NAME,MIN(PARTITION_ORDINAL_POSITION)"
+	if err := simpleQueryWithArgs(conn, func(rows *sql.Rows) error {
+		var table, partition string
+		if err := rows.Scan(&table, &partition); err != nil {
+			return errors.Trace(err)
+		}
+		if s, ok := tables[table]; ok {
+			s.partitions = append(s.partitions, partition)
+		}
+		return nil
+	}, partitionsQuery, db); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return tables, nil
+}
+
+// buildSelectField is buildSelectField on the cached metadata
+func (d *Dumper) buildSelectField(conn *sql.Conn, db, tbl string) (string, int, error) {
+	s, err := d.schemas.table(conn, db, tbl)
+	if err != nil {
+		return "", 0, err
+	}
+	var (
+		selectField string
+		selectLen   int
+	)
+	if s == nil {
+		selectField, selectLen, err = buildSelectField(conn, db, tbl, d.conf.CompleteInsert)
+		if err != nil {
+			return "", 0, err
+		}
+	} else {
+		selectField, selectLen = s.selectField(d.conf.CompleteInsert)
+	}
+	// the source column of the routed table is the last column
+	if sourceField := d.routes.sourceColumnField(db, tbl); sourceField != "" && selectField != "" {
+		selectField += "," + sourceField
+		selectLen++
+	}
+	return selectField, selectLen, nil
+}
+
+// buildOrderByClause is buildOrderByClause on the cached metadata
+func (d *Dumper) buildOrderByClause(conn *sql.Conn, db, tbl string) (string, error) {
+	if !d.conf.SortByPk {
+		return "", nil
+	}
+	s, err := d.schemas.table(conn, db, tbl)
+	if err != nil {
+		return "", err
+	}
+	if s == nil {
+		return buildOrderByClause(d.conf, conn, db, tbl)
+	}
+	if d.conf.ServerInfo.ServerType == ServerTypeTiDB {
+		ok, err := d.selectTiDBRowID(conn, db, tbl)
+		if err != nil {
+			return "", errors.Trace(err)
+		}
+		if ok {
+			return "ORDER BY `_tidb_rowid`", nil
+		}
+	}
+	return buildOrderByClauseString(s.pkColumns), nil
+}
+
+// selectTiDBRowID is SelectTiDBRowID whose result is cached
+func (d *Dumper) selectTiDBRowID(conn *sql.Conn, db, tbl string) (bool, error) {
+	s, err := d.schemas.table(conn, db, tbl)
+	if err != nil {
+		return false, err
+	}
+	if s == nil {
+		return SelectTiDBRowID(conn, db, tbl)
+	}
+	s.tidbRowIDOnce.Do(func() {
+		s.hasTiDBRowID, s.tidbRowIDErr = SelectTiDBRowID(conn, db, tbl)
+	})
+	return s.hasTiDBRowID, s.tidbRowIDErr
+}
+
+// pickupPossibleField is pickupPossibleField on the cached metadata
+func (d *Dumper) pickupPossibleField(conn *sql.Conn, db, tbl string) (string, error) {
+	s, err := d.schemas.table(conn, db, tbl)
+	if err != nil {
+		return "", err
+	}
+	if s == nil {
+		return pickupPossibleField(db, tbl, conn, d.conf)
+	}
+	if d.conf.ServerInfo.ServerType == ServerTypeTiDB {
+		ok, err := d.selectTiDBRowID(conn, db, tbl)
+		if err != nil {
+			return "", nil
+		}
+		if ok {
+			return "_tidb_rowid", nil
+		}
+	}
+	if field := s.numericIndex("PRI"); field != "" {
+		return field, nil
+	}
+	return s.numericIndex("UNI"), nil
+}
+
+// selectTablePartitions is SelectTablePartitions on the cached metadata
+func (d *Dumper) selectTablePartitions(conn *sql.Conn, db, tbl string) ([]string, error) {
+	s, err := d.schemas.table(conn, db, tbl)
+	if err != nil {
+		return nil, err
+	}
+	if s == nil || !d.schemas.withPartitions {
+		return SelectTablePartitions(conn, db, tbl)
+	}
+	return s.partitions, nil
+}
+
+// getPrimaryKeyAndColumnTypes is GetPrimaryKeyAndColumnTypes on the cached metadata
+func (d *Dumper) getPrimaryKeyAndColumnTypes(conn *sql.Conn, db, tbl string) ([]string, []string, error) {
+	s, err := d.schemas.table(conn, db, tbl)
+	if err != nil {
+		return nil, nil, err
+	}
+	if s == nil {
+		return GetPrimaryKeyAndColumnTypes(conn, db, tbl)
+	}
+	colNames, colTypes := s.pkColumnsAndTypes()
+	return colNames, colTypes, nil
+}
+
+type tableMetaResult struct {
+	meta TableMeta
+	err  error
+}
+
+// tableMetaPrefetcher queries the meta of tables concurrently on several snapshot connections,
+// while the meta is still consumed in the dumping order
+type tableMetaPrefetcher struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	results []chan tableMetaResult
+	window  chan struct{}
+	wg      sync.WaitGroup
+	// query queries the meta of the i-th table when it's got if there is no connection to prefetch, it's nil otherwise
+	query func(i int) (TableMeta, error)
+}
+
+// prefetchTableMeta starts to query the meta of tables on conns. The meta of tables[i] must be got by get(i) in order,
+// and close must be called after all the meta is got or the dumping is aborted. Without conns, the meta is queried
+// on metaConn when it's got, so no more connection is opened while the consistency lock is held
+func (d *Dumper) prefetchTableMeta(metaConn *sql.Conn, conns []*sql.Conn, tables []tableToDump) *tableMetaPrefetcher {
+	ctx, cancel := context.WithCancel(d.tctx)
+	if len(conns) == 0 {
+		return &tableMetaPrefetcher{ctx: ctx, cancel: cancel, query: func(i int) (TableMeta, error) {
+			return d.dumpTableMeta(metaConn, tables[i].db, tables[i].table)
+		}}
+	}
+	p := &tableMetaPrefetcher{
+		ctx:     ctx,
+		cancel:  cancel,
+		results: make([]chan tableMetaResult, len(tables)),
+		window:  make(chan struct{}, tableMetaPrefetchWindow),
+	}
+	jobs := make(chan int)
+	for i := range tables {
+		p.results[i] = make(chan tableMetaResult, 1)
+	}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer close(jobs)
+		for i, t := range tables {
+			if t.table == nil {
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case p.window <- struct{}{}:
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case jobs <- i:
+			}
+		}
+	}()
+	for _, conn := range conns {
+		conn := conn
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for i := range jobs {
+				t := tables[i]
+				d.L().Debug("query table meta", zap.String("database", t.db), zap.String("table", t.table.Name))
+				meta, err := d.dumpTableMeta(conn, t.db, t.table)
+				p.results[i] <- tableMetaResult{meta: meta, err: err}
+			}
+		}()
+	}
+	return p
+}
+
+// get waits for the meta of the i-th table
+func (p *tableMetaPrefetcher) get(i int) (TableMeta, error) {
+	if p.query != nil {
+		return p.query(i)
+	}
+	select {
+	case <-p.ctx.Done():
+		return nil, errors.Trace(p.ctx.Err())
+	case r := <-p.results[i]:
+		<-p.window
+		return r.meta, r.err
+	}
+}
+
+// skip releases the meta of the i-th table which isn't dumped
+func (p *tableMetaPrefetcher) skip(i int) {
+	if p.query == nil {
+		_, _ = p.get(i)
+	}
+}
+
+func (p *tableMetaPrefetcher) close() {
+	p.cancel()
+	p.wg.Wait()
+}
@@ -0,0 +1,156 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package export
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	tcontext "github.com/pingcap/dumpling/v4/context"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	. "github.com/pingcap/check"
+)
+
+var _ = Suite(&testSchemaCacheSuite{})
+
+type testSchemaCacheSuite struct{}
+
+func newDumperForSchemaTest(c *C) *Dumper {
+	return &Dumper{tctx: tcontext.Background(), conf: defaultConfigForTest(c), schemas: newSchemaCache(false)}
+}
+
+func expectDatabaseSchema(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery("SELECT TABLE_NAME,COLUMN_NAME,EXTRA,DATA_TYPE,COLUMN_KEY FROM INFORMATION_SCHEMA.COLUMNS").
+		WithArgs("test").
+		WillReturnRows(sqlmock.NewRows([]string{"TABLE_NAME", "COLUMN_NAME", "EXTRA", "DATA_TYPE", "COLUMN_KEY"}).
+			AddRow("t1", "id", "", "int", "PRI").
+			AddRow("t1", "a", "", "varchar", "").
+			AddRow("t1", "g", "VIRTUAL GENERATED", "int", "").
+			AddRow("t2", "id", "", "bigint", "UNI").
+			AddRow("t2", "b", "", "text", ""))
+	mock.ExpectQuery("SELECT TABLE_NAME,COLUMN_NAME FROM INFORMATION_SCHEMA.KEY_COLUMN_USAGE").
+		WithArgs("test").
+		WillReturnRows(sqlmock.NewRows([]string{"TABLE_NAME", "COLUMN_NAME"}).AddRow("t1", "id"))
+}
+
+func (s *testSchemaCacheSuite) TestSchemaCache(c *C) {
+	db, mock, err := sqlmock.New()
+	c.Assert(err, IsNil)
+	defer db.Close()
+	conn, err := db.Conn(context.Background())
+	c.Assert(err, IsNil)
+	d := newDumperForSchemaTest(c)
+
+	// the metadata of a database is queried only once
+	expectDatabaseSchema(mock)
+	selectField, selectLen, err := d.buildSelectField(conn, "test", "t1")
+	c.Assert(err, IsNil)
+	c.Assert(selectField, Equals, "`id`,`a`")
+	c.Assert(selectLen, Equals, 2)
+	selectField, selectLen, err = d.buildSelectField(conn, "test", "t2")
+	c.Assert(err, IsNil)
+	c.Assert(selectField, Equals, "*")
+	c.Assert(selectLen, Equals, 2)
+	orderByClause, err := d.buildOrderByClause(conn, "test", "t1")
+	c.Assert(err, IsNil)
+	c.Assert(orderByClause, Equals, "ORDER BY `id`")
+	orderByClause, err = d.buildOrderByClause(conn, "test", "t2")
+	c.Assert(err, IsNil)
+	c.Assert(orderByClause, Equals, "")
+	field, err := d.pickupPossibleField(conn, "test", "t1")
+	c.Assert(err, IsNil)
+	c.Assert(field, Equals, "id")
+	field, err = d.pickupPossibleField(conn, "test", "t2")
+	c.Assert(err, IsNil)
+	c.Assert(field, Equals, "id")
+	pkCols, pkTypes, err := d.getPrimaryKeyAndColumnTypes(conn, "test", "t1")
+	c.Assert(err, IsNil)
+	c.Assert(pkCols, DeepEquals, []string{"id"})
+	c.Assert(pkTypes, DeepEquals, []string{"INT"})
+
+	// _tidb_rowid is checked once for each table
+	d.conf.ServerInfo.ServerType = ServerTypeTiDB
+	mock.ExpectExec("SELECT _tidb_rowid from `test`.`t2`").WillReturnResult(sqlmock.NewResult(0, 0))
+	for i := 0; i < 2; i++ {
+		orderByClause, err = d.buildOrderByClause(conn, "test", "t2")
+		c.Assert(err, IsNil)
+		c.Assert(orderByClause, Equals, "ORDER BY `_tidb_rowid`")
+	}
+	d.conf.ServerInfo.ServerType = ServerTypeMySQL
+
+	// fallback to query the table itself if it isn't cached
+	mock.ExpectQuery("SELECT COLUMN_NAME,EXTRA FROM INFORMATION_SCHEMA.COLUMNS").
+		WithArgs("test", "t3").
+		WillReturnRows(sqlmock.NewRows([]string{"COLUMN_NAME", "EXTRA"}).AddRow("c", ""))
+	selectField, selectLen, err = d.buildSelectField(conn, "test", "t3")
+	c.Assert(err, IsNil)
+	c.Assert(selectField, Equals, "*")
+	c.Assert(selectLen, Equals, 1)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+
+	// the failed database is queried again
+	mock.ExpectQuery("SELECT TABLE_NAME,COLUMN_NAME,EXTRA,DATA_TYPE,COLUMN_KEY FROM INFORMATION_SCHEMA.COLUMNS").
+		WithArgs("other").WillReturnError(errors.New("mock error"))
+	_, _, err = d.buildSelectField(conn, "other", "t")
+	c.Assert(err, NotNil)
+	mock.ExpectQuery("SELECT TABLE_NAME,COLUMN_NAME,EXTRA,DATA_TYPE,COLUMN_KEY FROM INFORMATION_SCHEMA.COLUMNS").
+		WithArgs("other").
+		WillReturnRows(sqlmock.NewRows([]string{"TABLE_NAME", "COLUMN_NAME", "EXTRA", "DATA_TYPE", "COLUMN_KEY"}).AddRow("t", "x", "", "int", ""))
+	mock.ExpectQuery("SELECT TABLE_NAME,COLUMN_NAME FROM INFORMATION_SCHEMA.KEY_COLUMN_USAGE").
+		WithArgs("other").
+		WillReturnRows(sqlmock.NewRows([]string{"TABLE_NAME", "COLUMN_NAME"}))
+	_, selectLen, err = d.buildSelectField(conn, "other", "t")
+	c.Assert(err, IsNil)
+	c.Assert(selectLen, Equals, 1)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
+
+func (s *testSchemaCacheSuite) TestPrefetchTableMeta(c *C) {
+	db, mock, err := sqlmock.New()
+	c.Assert(err, IsNil)
+	defer db.Close()
+	conn, err := db.Conn(context.Background())
+	c.Assert(err, IsNil)
+	d := newDumperForSchemaTest(c)
+	d.conf.NoSchemas = true
+
+	expectDatabaseSchema(mock)
+	mock.ExpectQuery("SELECT `id`,`a` FROM `test`.`t1` LIMIT 1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "a"}))
+	mock.ExpectQuery("SELECT \\* FROM `test`.`t2` LIMIT 1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "b"}))
+
+	tables := []tableToDump{
+		{db: "empty"},
+		{db: "test", table: &TableInfo{Name: "t1", Type: TableTypeBase}},
+		{db: "test", table: &TableInfo{Name: "t2", Type: TableTypeBase}},
+	}
+	metas := d.prefetchTableMeta(nil, []*sql.Conn{conn}, tables)
+	meta, err := metas.get(1)
+	c.Assert(err, IsNil)
+	c.Assert(meta.TableName(), Equals, "t1")
+	c.Assert(meta.SelectedField(), Equals, "(`id`,`a`)")
+	meta, err = metas.get(2)
+	c.Assert(err, IsNil)
+	c.Assert(meta.TableName(), Equals, "t2")
+	c.Assert(meta.ColumnNames(), DeepEquals, []string{"id", "b"})
+	metas.close()
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+
+	// without the schema connections, the meta is queried on the meta connection when it's got
+	d = newDumperForSchemaTest(c)
+	d.conf.NoSchemas = true
+	metas = d.prefetchTableMeta(conn, nil, tables)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+	expectDatabaseSchema(mock)
+	mock.ExpectQuery("SELECT `id`,`a` FROM `test`.`t1` LIMIT 1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "a"}))
+	meta, err = metas.get(1)
+	c.Assert(err, IsNil)
+	c.Assert(meta.SelectedField(), Equals, "(`id`,`a`)")
+	metas.skip(2)
+	metas.close()
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
@@ -0,0 +1,320 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package export
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/parser"
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/format"
+	"github.com/pingcap/parser/model"
+	driver "github.com/pingcap/tidb/types/parser_driver"
+)
+
+const (
+	schemaRewriteDropAutoIncrement  = "drop-auto-increment"
+	schemaRewriteEngine             = "engine"
+	schemaRewriteCharset            = "charset"
+	schemaRewriteCollation          = "collation"
+	schemaRewriteDropShardRowIDBits = "drop-shard-row-id-bits"
+	schemaRewriteDropTiDBSpecific   = "drop-tidb-specific"
+	schemaRewriteDropPartitions     = "drop-partitions"
+
+	featureIDClusteredIndex = "clustered_index"
+)
+
+var (
+	// the TiDB specific syntax in the restored DDL is wrapped in the special comments like SHOW CREATE TABLE of TiDB,
+	// so the DDL can still be executed on MySQL
+	shardRowIDPattern     = regexp.MustCompile(`(?i)\b(?:SHARD_ROW_ID_BITS|PRE_SPLIT_REGIONS) = \d+(?: (?:SHARD_ROW_ID_BITS|PRE_SPLIT_REGIONS) = \d+)?`)
+	clusteredIndexPattern = regexp.MustCompile("(PRIMARY KEY\\((?:[^()`]|`(?:[^`]|``)*`|\\([^()]*\\))*\\)) ((?:NON)?CLUSTERED)\\b")
+)
+
+func init() {
+	// SHOW CREATE TABLE of TiDB writes the clustered index in a special comment, which is ignored by the parser without registering
+	parser.SpecialCommentsController.Register(featureIDClusteredIndex)
+}
+
+// schemaTransform is a built-in transform of --schema-rewrite, apply returns false if the table isn't changed
+type schemaTransform struct {
+	name  string
+	apply func(stmt *ast.CreateTableStmt) bool
+}
+
+// SchemaRewrites is the transforms of the dumped table schemas, parsed from --schema-rewrite
+type SchemaRewrites struct {
+	transforms []schemaTransform
+}
+
+// ParseSchemaRewrites parses the transforms of --schema-rewrite, the transforms are applied in the given order
+func ParseSchemaRewrites(rules []string) (*SchemaRewrites, error) {
+	r := &SchemaRewrites{}
+	var charset, collation string
+	for _, rule := range rules {
+		name, value := strings.TrimSpace(rule), ""
+		if i := strings.Index(name, "="); i >= 0 {
+			name, value = strings.TrimSpace(name[:i]), strings.TrimSpace(name[i+1:])
+		}
+		var apply func(stmt *ast.CreateTableStmt) bool
+		switch name {
+		case schemaRewriteDropAutoIncrement:
+			apply = func(stmt *ast.CreateTableStmt) bool {
+				return removeTableOptions(stmt, ast.TableOptionAutoIncrement)
+			}
+		case schemaRewriteEngine:
+			apply = func(stmt *ast.CreateTableStmt) bool {
+				return setTableOption(stmt, ast.TableOptionEngine, value)
+			}
+		case schemaRewriteCharset:
+			charset = value
+		case schemaRewriteCollation:
+			collation = value
+		case schemaRewriteDropShardRowIDBits:
+			apply = func(stmt *ast.CreateTableStmt) bool {
+				return removeTableOptions(stmt, ast.TableOptionShardRowID, ast.TableOptionPreSplitRegion)
+			}
+		case schemaRewriteDropTiDBSpecific:
+			apply = dropTiDBSpecific
+		case schemaRewriteDropPartitions:
+			apply = func(stmt *ast.CreateTableStmt) bool {
+				changed := stmt.Partition != nil
+				stmt.Partition = nil
+				return changed
+			}
+		default:
+			return nil, errors.Errorf("failed to parse --schema-rewrite '%s': unknown transform %s", rule, name)
+		}
+		hasValue := name == schemaRewriteEngine || name == schemaRewriteCharset || name == schemaRewriteCollation
+		if hasValue != (value != "") {
+			return nil, errors.Errorf("failed to parse --schema-rewrite '%s': the transform should be like %s", rule, schemaRewriteUsage(name))
+		}
+		if apply != nil {
+			r.transforms = append(r.transforms, schemaTransform{name: strings.TrimSpace(rule), apply: apply})
+		}
+	}
+	// the charset and the collation are swapped together, because the collation depends on the charset
+	if charset != "" || collation != "" {
+		var names []string
+		if charset != "" {
+			names = append(names, schemaRewriteCharset+"="+charset)
+		}
+		if collation != "" {
+			names = append(names, schemaRewriteCollation+"="+collation)
+		}
+		name := strings.Join(names, ",")
+		r.transforms = append(r.transforms, schemaTransform{name: name, apply: func(stmt *ast.CreateTableStmt) bool {
+			return swapCharsetAndCollation(stmt, charset, collation)
+		}})
+	}
+	return r, nil
+}
+
+func schemaRewriteUsage(name string) string {
+	switch name {
+	case schemaRewriteEngine, schemaRewriteCharset, schemaRewriteCollation:
+		return name + "=<name>"
+	}
+	return name
+}
+
+func (r *SchemaRewrites) hasAnyRule() bool {
+	return r != nil && len(r.transforms) > 0
+}
+
+// rewrite applies the transforms to the result of SHOW CREATE TABLE, it returns the names of the transforms which change the table.
+// The DDL is returned as is if it isn't changed, otherwise it's restored from the parsed DDL
+func (r *SchemaRewrites) rewrite(createSQL string) (string, []string, error) {
+	stmt, err := parseCreateTable(createSQL)
+	if err != nil {
+		return "", nil, err
+	}
+	var changes []string
+	for _, t := range r.transforms {
+		if t.apply(stmt) {
+			changes = append(changes, t.name)
+		}
+	}
+	if len(changes) == 0 {
+		return createSQL, nil, nil
+	}
+	createSQL, err = restoreCreateTable(stmt)
+	return createSQL, changes, err
+}
+
+func parseCreateTable(createSQL string) (*ast.CreateTableStmt, error) {
+	stmts, _, err := parser.New().Parse(createSQL, "", "")
+	if err != nil {
+		return nil, errors.Annotate(err, "failed to parse the table schema")
+	}
+	if len(stmts) != 1 {
+		return nil, errors.Errorf("the table schema should be one CREATE TABLE statement, but got %d statements", len(stmts))
+	}
+	stmt, ok := stmts[0].(*ast.CreateTableStmt)
+	if !ok {
+		return nil, errors.Errorf("the table schema should be a CREATE TABLE statement, but got %T", stmts[0])
+	}
+	return stmt, nil
+}
+
+// restoreCreateTable restores the DDL, in which the TiDB specific syntax is wrapped in the special comments
+func restoreCreateTable(stmt *ast.CreateTableStmt) (string, error) {
+	var b strings.Builder
+	if err := stmt.Restore(format.NewRestoreCtx(format.DefaultRestoreFlags, &b)); err != nil {
+		return "", errors.Annotate(err, "failed to restore the table schema")
+	}
+	createSQL := b.String()
+	for id, pattern := range driver.FeatureIDPatterns {
+		createSQL = pattern.ReplaceAllStringFunc(createSQL, func(s string) string {
+			return fmt.Sprintf("%s %s */", driver.BuildSpecialCommentPrefix(id), strings.TrimSpace(s)) + trailingSpace(s)
+		})
+	}
+	createSQL = shardRowIDPattern.ReplaceAllString(createSQL, driver.SpecialCommentVersionPrefix+" $0 */")
+	createSQL = clusteredIndexPattern.ReplaceAllString(createSQL, "$1 /*T!["+featureIDClusteredIndex+"] $2 */")
+	return createSQL, nil
+}
+
+func trailingSpace(s string) string {
+	return s[len(strings.TrimRight(s, " ")):]
+}
+
+func removeTableOptions(stmt *ast.CreateTableStmt, tps ...ast.TableOptionType) bool {
+	options := stmt.Options[:0]
+	for _, opt := range stmt.Options {
+		removed := false
+		for _, tp := range tps {
+			removed = removed || opt.Tp == tp
+		}
+		if !removed {
+			options = append(options, opt)
+		}
+	}
+	changed := len(options) != len(stmt.Options)
+	stmt.Options = options
+	return changed
+}
+
+func setTableOption(stmt *ast.CreateTableStmt, tp ast.TableOptionType, value string) bool {
+	for _, opt := range stmt.Options {
+		if opt.Tp == tp {
+			changed := opt.StrValue != value
+			opt.StrValue = value
+			return changed
+		}
+	}
+	stmt.Options = append(stmt.Options, &ast.TableOption{Tp: tp, StrValue: value})
+	return true
+}
+
+// swapCharsetAndCollation replaces the charsets and the collations of the table and its columns. If only the charset is given,
+// the collations are removed so the default collation of the charset is used. The binary columns aren't changed
+func swapCharsetAndCollation(stmt *ast.CreateTableStmt, charset, collation string) bool {
+	changed := false
+	swap := func(value *string, to string) {
+		switch {
+		case *value == "" || strings.EqualFold(*value, "binary"):
+		case to != "":
+			changed = changed || !strings.EqualFold(*value, to)
+			*value = to
+		case charset != "":
+			// the collation of the other charset is removed
+			changed = true
+			*value = ""
+		}
+	}
+	options := stmt.Options[:0]
+	for _, opt := range stmt.Options {
+		switch opt.Tp {
+		case ast.TableOptionCharset:
+			swap(&opt.StrValue, charset)
+		case ast.TableOptionCollate:
+			swap(&opt.StrValue, collation)
+		}
+		if opt.StrValue != "" || (opt.Tp != ast.TableOptionCharset && opt.Tp != ast.TableOptionCollate) {
+			options = append(options, opt)
+		}
+	}
+	stmt.Options = options
+	for _, col := range stmt.Cols {
+		if col.Tp == nil {
+			continue
+		}
+		if strings.EqualFold(col.Tp.Charset, "binary") {
+			continue
+		}
+		swap(&col.Tp.Charset, charset)
+		swap(&col.Tp.Collate, collation)
+		colOptions := col.Options[:0]
+		for _, opt := range col.Options {
+			if opt.Tp == ast.ColumnOptionCollate {
+				if swap(&opt.StrValue, collation); opt.StrValue == "" {
+					continue
+				}
+			}
+			colOptions = append(colOptions, opt)
+		}
+		col.Options = colOptions
+	}
+	return changed
+}
+
+// dropTiDBSpecific removes the TiDB specific syntax, which are wrapped in the special comments in SHOW CREATE TABLE of TiDB
+func dropTiDBSpecific(stmt *ast.CreateTableStmt) bool {
+	changed := removeTableOptions(stmt, ast.TableOptionShardRowID, ast.TableOptionPreSplitRegion,
+		ast.TableOptionAutoIdCache, ast.TableOptionAutoRandomBase)
+	for _, col := range stmt.Cols {
+		options := col.Options[:0]
+		for _, opt := range col.Options {
+			if opt.Tp == ast.ColumnOptionAutoRandom {
+				changed = true
+				continue
+			}
+			options = append(options, opt)
+		}
+		col.Options = options
+	}
+	for _, c := range stmt.Constraints {
+		if c.Option != nil && c.Option.PrimaryKeyTp != model.PrimaryKeyTypeDefault {
+			c.Option.PrimaryKeyTp = model.PrimaryKeyTypeDefault
+			changed = true
+		}
+	}
+	return changed
+}
+
+// rewrittenSchema is a table whose schema is changed by --schema-rewrite
+type rewrittenSchema struct {
+	table   tableKey
+	changes []string
+}
+
+// rewriteSchema applies --schema-rewrite to the schema of the table before it's written
+func (d *Dumper) rewriteSchema(db, table, createSQL string) (string, error) {
+	if !d.conf.SchemaRewrites.hasAnyRule() || createSQL == "" {
+		return createSQL, nil
+	}
+	createSQL, changes, err := d.conf.SchemaRewrites.rewrite(createSQL)
+	if err != nil {
+		return "", errors.Annotatef(err, "failed to rewrite the schema of table %s", tableKey{db: db, table: table})
+	}
+	if len(changes) > 0 {
+		d.rewrittenSchemas = append(d.rewrittenSchemas, rewrittenSchema{table: tableKey{db: db, table: table}, changes: changes})
+	}
+	return createSQL, nil
+}
+
+// writeSchemaRewrites writes the tables whose schemas are changed by --schema-rewrite in metadata
+func writeSchemaRewrites(buffer *bytes.Buffer, schemas []rewrittenSchema) {
+	if len(schemas) == 0 {
+		return
+	}
+	buffer.WriteString("SCHEMA REWRITES:\n")
+	for _, s := range schemas {
+		fmt.Fprintf(buffer, "\t%s: %s\n", s.table, strings.Join(s.changes, "; "))
+	}
+	buffer.WriteString("\n")
+}
@@ -0,0 +1,132 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package export
+
+import (
+	"bytes"
+
+	. "github.com/pingcap/check"
+)
+
+var _ = Suite(&testSchemaRewriteSuite{})
+
+type testSchemaRewriteSuite struct{}
+
+const tidbCreateTableSQL = "CREATE TABLE `t` (\n" +
+	"  `id` bigint(20) NOT NULL /*T![auto_rand] AUTO_RANDOM(5) */,\n" +
+	"  `name` varchar(20) COLLATE utf8mb4_general_ci DEFAULT NULL,\n" +
+	"  `b` varbinary(10) DEFAULT NULL,\n" +
+	"  PRIMARY KEY (`id`) /*T![clustered_index] CLUSTERED */\n" +
+	") ENGINE=InnoDB AUTO_INCREMENT=30001 DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin " +
+	"/*T! SHARD_ROW_ID_BITS=4 PRE_SPLIT_REGIONS=2 */ /*T![auto_id_cache] AUTO_ID_CACHE=100 */\n" +
+	"PARTITION BY HASH(`id`) PARTITIONS 4"
+
+func (s *testSchemaRewriteSuite) TestParseSchemaRewrites(c *C) {
+	r, err := ParseSchemaRewrites(nil)
+	c.Assert(err, IsNil)
+	c.Assert(r.hasAnyRule(), IsFalse)
+
+	r, err = ParseSchemaRewrites([]string{"collation=utf8_bin", "drop-partitions", " engine = MyISAM ", "charset=utf8"})
+	c.Assert(err, IsNil)
+	names := make([]string, 0, len(r.transforms))
+	for _, t := range r.transforms {
+		names = append(names, t.name)
+	}
+	c.Assert(names, DeepEquals, []string{"drop-partitions", "engine = MyISAM", "charset=utf8,collation=utf8_bin"})
+
+	_, err = ParseSchemaRewrites([]string{"drop-foo"})
+	c.Assert(err, ErrorMatches, ".*unknown transform drop-foo")
+	_, err = ParseSchemaRewrites([]string{"engine"})
+	c.Assert(err, ErrorMatches, ".*should be like engine=<name>")
+	_, err = ParseSchemaRewrites([]string{"drop-auto-increment=1"})
+	c.Assert(err, ErrorMatches, ".*should be like drop-auto-increment")
+}
+
+func (s *testSchemaRewriteSuite) TestRewriteSchema(c *C) {
+	const (
+		columns    = "CREATE TABLE `t` (`id` BIGINT(20) NOT NULL /*T![auto_rand] AUTO_RANDOM(5) */,`name` VARCHAR(20) COLLATE utf8mb4_general_ci DEFAULT NULL,`b` VARBINARY(10) DEFAULT NULL,PRIMARY KEY(`id`) /*T![clustered_index] CLUSTERED */) "
+		tidbOption = " /*T! SHARD_ROW_ID_BITS = 4 PRE_SPLIT_REGIONS = 2 */ /*T![auto_id_cache] AUTO_ID_CACHE = 100 */"
+		partition  = " PARTITION BY HASH (`id`) PARTITIONS 4"
+	)
+	cases := []struct {
+		rules    []string
+		expected string
+	}{
+		{
+			[]string{"drop-auto-increment"},
+			columns + "ENGINE = InnoDB DEFAULT CHARACTER SET = UTF8MB4 DEFAULT COLLATE = UTF8MB4_BIN" + tidbOption + partition,
+		},
+		{
+			[]string{"engine=MyISAM"},
+			columns + "ENGINE = MyISAM AUTO_INCREMENT = 30001 DEFAULT CHARACTER SET = UTF8MB4 DEFAULT COLLATE = UTF8MB4_BIN" + tidbOption + partition,
+		},
+		{
+			// the collations are removed, and the binary column isn't changed
+			[]string{"charset=latin1"},
+			"CREATE TABLE `t` (`id` BIGINT(20) NOT NULL /*T![auto_rand] AUTO_RANDOM(5) */,`name` VARCHAR(20) DEFAULT NULL,`b` VARBINARY(10) DEFAULT NULL,PRIMARY KEY(`id`) /*T![clustered_index] CLUSTERED */) " +
+				"ENGINE = InnoDB AUTO_INCREMENT = 30001 DEFAULT CHARACTER SET = LATIN1" + tidbOption + partition,
+		},
+		{
+			[]string{"charset=utf8", "collation=utf8_bin"},
+			"CREATE TABLE `t` (`id` BIGINT(20) NOT NULL /*T![auto_rand] AUTO_RANDOM(5) */,`name` VARCHAR(20) COLLATE utf8_bin DEFAULT NULL,`b` VARBINARY(10) DEFAULT NULL,PRIMARY KEY(`id`) /*T![clustered_index] CLUSTERED */) " +
+				"ENGINE = InnoDB AUTO_INCREMENT = 30001 DEFAULT CHARACTER SET = UTF8 DEFAULT COLLATE = UTF8_BIN" + tidbOption + partition,
+		},
+		{
+			[]string{"drop-shard-row-id-bits"},
+			columns + "ENGINE = InnoDB AUTO_INCREMENT = 30001 DEFAULT CHARACTER SET = UTF8MB4 DEFAULT COLLATE = UTF8MB4_BIN /*T![auto_id_cache] AUTO_ID_CACHE = 100 */" + partition,
+		},
+		{
+			[]string{"drop-tidb-specific"},
+			"CREATE TABLE `t` (`id` BIGINT(20) NOT NULL,`name` VARCHAR(20) COLLATE utf8mb4_general_ci DEFAULT NULL,`b` VARBINARY(10) DEFAULT NULL,PRIMARY KEY(`id`) ) " +
+				"ENGINE = InnoDB AUTO_INCREMENT = 30001 DEFAULT CHARACTER SET = UTF8MB4 DEFAULT COLLATE = UTF8MB4_BIN" + partition,
+		},
+		{
+			[]string{"drop-partitions"},
+			columns + "ENGINE = InnoDB AUTO_INCREMENT = 30001 DEFAULT CHARACTER SET = UTF8MB4 DEFAULT COLLATE = UTF8MB4_BIN" + tidbOption,
+		},
+	}
+	for _, ca := range cases {
+		r, err := ParseSchemaRewrites(ca.rules)
+		c.Assert(err, IsNil)
+		createSQL, changes, err := r.rewrite(tidbCreateTableSQL)
+		c.Assert(err, IsNil)
+		c.Assert(createSQL, Equals, ca.expected, Commentf("rules %v", ca.rules))
+		c.Assert(changes, HasLen, 1)
+	}
+
+	// the schema is kept as is if nothing is changed
+	r, err := ParseSchemaRewrites([]string{"drop-auto-increment", "engine=InnoDB", "drop-partitions"})
+	c.Assert(err, IsNil)
+	createSQL := "CREATE TABLE `t` (\n  `id` int(11) NOT NULL\n) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4"
+	rewritten, changes, err := r.rewrite(createSQL)
+	c.Assert(err, IsNil)
+	c.Assert(rewritten, Equals, createSQL)
+	c.Assert(changes, HasLen, 0)
+
+	_, _, err = r.rewrite("CREATE TABLE `t` (")
+	c.Assert(err, ErrorMatches, "failed to parse the table schema.*")
+}
+
+func (s *testSchemaRewriteSuite) TestRecordSchemaRewrites(c *C) {
+	d := newDumperForSchemaTest(c)
+	var err error
+	d.conf.SchemaRewrites, err = ParseSchemaRewrites([]string{"drop-auto-increment", "engine=InnoDB"})
+	c.Assert(err, IsNil)
+
+	createSQL, err := d.rewriteSchema("test", "t1", "CREATE TABLE `t1` (\n  `id` int(11) NOT NULL\n) ENGINE=MyISAM AUTO_INCREMENT=10")
+	c.Assert(err, IsNil)
+	c.Assert(createSQL, Equals, "CREATE TABLE `t1` (`id` INT(11) NOT NULL) ENGINE = InnoDB")
+	_, err = d.rewriteSchema("test", "t2", "CREATE TABLE `t2` (\n  `id` int(11) NOT NULL\n) ENGINE=InnoDB")
+	c.Assert(err, IsNil)
+	_, err = d.rewriteSchema("test", "t3", "CREATE TABLE `t3` (")
+	c.Assert(err, ErrorMatches, "failed to rewrite the schema of table `test`.`t3`.*")
+
+	var buffer bytes.Buffer
+	writeSchemaRewrites(&buffer, d.rewrittenSchemas)
+	c.Assert(buffer.String(), Equals, "SCHEMA REWRITES:\n\t`test`.`t1`: drop-auto-increment; engine=InnoDB\n\n")
+
+	// the source column of --route-source-column is added to the restored schema
+	createSQL, err = addSourceColumn(createSQL, "source")
+	c.Assert(err, IsNil)
+	c.Assert(createSQL, Equals, "CREATE TABLE `t1` (`id` INT(11) NOT NULL,`source` VARCHAR(512) NOT NULL) ENGINE = InnoDB")
+}
@@ -0,0 +1,375 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package export
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+
+	tcontext "github.com/pingcap/dumpling/v4/context"
+
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	// singleFileName is the name of the SQL file written with --single-file
+	singleFileName = "dump.sql"
+
+	singleFileHeader = "/*!40101 SET @OLD_CHARACTER_SET_CLIENT=@@CHARACTER_SET_CLIENT */;\n" +
+		"/*!40101 SET @OLD_CHARACTER_SET_RESULTS=@@CHARACTER_SET_RESULTS */;\n" +
+		"/*!40101 SET @OLD_COLLATION_CONNECTION=@@COLLATION_CONNECTION */;\n" +
+		"/*!40101 SET NAMES binary */;\n" +
+		"/*!40014 SET @OLD_UNIQUE_CHECKS=@@UNIQUE_CHECKS, UNIQUE_CHECKS=0 */;\n" +
+		"/*!40014 SET @OLD_FOREIGN_KEY_CHECKS=@@FOREIGN_KEY_CHECKS, FOREIGN_KEY_CHECKS=0 */;\n" +
+		"/*!40101 SET @OLD_SQL_MODE=@@SQL_MODE, SQL_MODE='NO_AUTO_VALUE_ON_ZERO' */;\n" +
+		"/*!40111 SET @OLD_SQL_NOTES=@@SQL_NOTES, SQL_NOTES=0 */;\n"
+	singleFileFooter = "/*!40101 SET SQL_MODE=@OLD_SQL_MODE */;\n" +
+		"/*!40014 SET FOREIGN_KEY_CHECKS=@OLD_FOREIGN_KEY_CHECKS */;\n" +
+		"/*!40014 SET UNIQUE_CHECKS=@OLD_UNIQUE_CHECKS */;\n" +
+		"/*!40101 SET CHARACTER_SET_CLIENT=@OLD_CHARACTER_SET_CLIENT */;\n" +
+		"/*!40101 SET CHARACTER_SET_RESULTS=@OLD_CHARACTER_SET_RESULTS */;\n" +
+		"/*!40101 SET COLLATION_CONNECTION=@OLD_COLLATION_CONNECTION */;\n" +
+		"/*!40111 SET SQL_NOTES=@OLD_SQL_NOTES */;\n"
+)
+
+// singleFileEntry is a database, table or view in the single file. The entry of a database has no table
+type singleFileEntry struct {
+	source tableKey
+	// target is the table the data is written to, which is different from source if the table is routed by --route
+	target tableKey
+	view   bool
+	// metas is the meta tasks of the entry after routing
+	metas []Task
+}
+
+// spooledFile is a data file written by the writers, which is spooled under spoolName until it's concatenated into the single file
+type spooledFile struct {
+	name      string
+	spoolName string
+}
+
+// singleFileOutput collects the dump of --single-file, and writes it as one SQL file in the layout of mysqldump after all
+// the tasks are done. The meta tasks are recorded in the dumping order instead of being sent to the writers, while the data
+// chunks written by the concurrent writers are spooled in a local directory, and concatenated in the order of tables and chunks
+type singleFileOutput struct {
+	spool    storage.ExternalStorage
+	spoolDir string
+	noData   bool
+
+	mu      sync.Mutex
+	entries []*singleFileEntry
+	chunks  map[tableKey]map[int][]spooledFile
+	spooled int
+	// failed is the tables whose data chunks fail, the data of them isn't written
+	failed map[tableKey]bool
+}
+
+// newSingleFileOutput creates the spool directory in the local output directory, or in the temporary directory of the system
+// if the output isn't local
+func newSingleFileOutput(conf *Config) (*singleFileOutput, error) {
+	dir := ""
+	if conf.OutputDirPath != outputToStdout && conf.PipeCommand == "" {
+		if b, err := storage.ParseBackend(conf.OutputDirPath, &conf.BackendOptions); err == nil && b.GetLocal() != nil {
+			dir = b.GetLocal().Path
+		}
+	}
+	spoolDir, err := ioutil.TempDir(dir, ".dumpling-spool-")
+	if err != nil {
+		return nil, errors.Annotate(err, "fail to create the spool directory of --single-file")
+	}
+	spool, err := storage.NewLocalStorage(spoolDir)
+	if err != nil {
+		_ = os.RemoveAll(spoolDir)
+		return nil, errors.Trace(err)
+	}
+	return &singleFileOutput{
+		spool:    spool,
+		spoolDir: spoolDir,
+		noData:   conf.NoData,
+		chunks:   make(map[tableKey]map[int][]spooledFile),
+		failed:   make(map[tableKey]bool),
+	}, nil
+}
+
+// close removes the spooled files
+func (o *singleFileOutput) close() {
+	_ = os.RemoveAll(o.spoolDir)
+}
+
+// addMeta records the meta task in the dumping order, tasks is the meta tasks routed from it
+func (o *singleFileOutput) addMeta(task Task, tasks []Task, routes *tableRoutes) {
+	entry := &singleFileEntry{metas: tasks}
+	switch t := task.(type) {
+	case *TaskDatabaseMeta:
+		entry.source = tableKey{db: t.DatabaseName}
+	case *TaskTableMeta:
+		entry.source = tableKey{db: t.DatabaseName, table: t.TableName}
+	case *TaskViewMeta:
+		entry.source = tableKey{db: t.DatabaseName, table: t.ViewName}
+		entry.view = true
+	}
+	entry.target = entry.source
+	if rt, ok := routes.route(entry.source.db, entry.source.table); ok {
+		entry.target = rt.target
+	}
+	o.mu.Lock()
+	o.entries = append(o.entries, entry)
+	o.mu.Unlock()
+}
+
+// chunkStorage returns the storage spooling the data files of the task
+func (o *singleFileOutput) chunkStorage(task *TaskTableData) storage.ExternalStorage {
+	return &spoolStorage{
+		ExternalStorage: o.spool,
+		o:               o,
+		table:           tableKey{db: task.Meta.DatabaseName(), table: task.Meta.TableName()},
+		chunk:           task.ChunkIndex,
+	}
+}
+
+// spoolName returns the name of the spooled data file. A file written again on retrying the chunk overwrites the spooled one
+func (o *singleFileOutput) spoolName(table tableKey, chunk int, name string) string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	chunks, ok := o.chunks[table]
+	if !ok {
+		chunks = make(map[int][]spooledFile)
+		o.chunks[table] = chunks
+	}
+	for _, f := range chunks[chunk] {
+		if f.name == name {
+			return f.spoolName
+		}
+	}
+	o.spooled++
+	spoolName := fmt.Sprintf("%09d.sql", o.spooled)
+	chunks[chunk] = append(chunks[chunk], spooledFile{name: name, spoolName: spoolName})
+	return spoolName
+}
+
+// discardTable drops the data of the table of the failed task, so a partial dump doesn't contain a table missing some chunks
+func (o *singleFileOutput) discardTable(task *TaskTableData) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.failed[tableKey{db: task.Meta.DatabaseName(), table: task.Meta.TableName()}] = true
+}
+
+// spoolStorage spools the data files of a chunk under unique names in the spool directory
+type spoolStorage struct {
+	storage.ExternalStorage
+	o     *singleFileOutput
+	table tableKey
+	chunk int
+}
+
+// WriteFile implements storage.ExternalStorage.WriteFile
+func (s *spoolStorage) WriteFile(ctx context.Context, name string, data []byte) error {
+	return s.ExternalStorage.WriteFile(ctx, s.o.spoolName(s.table, s.chunk, name), data)
+}
+
+// Create implements storage.ExternalStorage.Create
+func (s *spoolStorage) Create(ctx context.Context, name string) (storage.ExternalFileWriter, error) {
+	return s.ExternalStorage.Create(ctx, s.o.spoolName(s.table, s.chunk, name))
+}
+
+// writeTo writes the single file. The views are created at last in viewOrder, and the placeholder tables are created in
+// their places like mysqldump, so the views can select from each other
+func (o *singleFileOutput) writeTo(tctx *tcontext.Context, w storage.ExternalFileWriter, compressType storage.CompressType, viewOrder []tableKey) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	sw := &singleFileWriter{tctx: tctx, w: w, spool: storage.WithCompression(o.spool, compressType)}
+	sw.write(singleFileHeader)
+	views := make(map[tableKey][]*TaskViewMeta)
+	for _, entry := range o.entries {
+		for _, task := range entry.metas {
+			switch t := task.(type) {
+			case *TaskDatabaseMeta:
+				sw.writeDatabase(t.DatabaseName, t.CreateDatabaseSQL)
+			case *TaskTableMeta:
+				sw.writeTableStructure(t.DatabaseName, t.TableName, t.CreateTableSQL)
+			case *TaskViewMeta:
+				sw.writeTemporaryView(t.DatabaseName, t.ViewName, t.CreateTableSQL)
+				views[entry.source] = append(views[entry.source], t)
+			}
+		}
+		if entry.source.table != "" && !entry.view && !o.noData && !o.failed[entry.source] {
+			sw.writeTableData(entry.target, o.sortedChunks(entry.source))
+		}
+	}
+	// the views not in viewOrder, which are only expected when the view order isn't recorded, are created in the dumping order
+	for _, entry := range o.entries {
+		if entry.view {
+			viewOrder = append(viewOrder, entry.source)
+		}
+	}
+	for _, v := range viewOrder {
+		for _, t := range views[v] {
+			sw.writeFinalView(t.DatabaseName, t.ViewName, t.CreateViewSQL)
+		}
+		delete(views, v)
+	}
+	sw.write(singleFileFooter)
+	return sw.err
+}
+
+// sortedChunks returns the spooled data files of the table in the order of chunks
+func (o *singleFileOutput) sortedChunks(table tableKey) []spooledFile {
+	chunks := o.chunks[table]
+	indexes := make([]int, 0, len(chunks))
+	for i := range chunks {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	var files []spooledFile
+	for _, i := range indexes {
+		files = append(files, chunks[i]...)
+	}
+	return files
+}
+
+// singleFileWriter writes the sections of the single file, it stops writing at the first error
+type singleFileWriter struct {
+	tctx  *tcontext.Context
+	w     storage.ExternalFileWriter
+	spool storage.ExternalStorage
+	db    string
+	err   error
+}
+
+func (sw *singleFileWriter) write(s string) {
+	if sw.err == nil {
+		sw.err = write(sw.tctx, sw.w, s)
+	}
+}
+
+func (sw *singleFileWriter) writeComment(comment string) {
+	sw.write(fmt.Sprintf("\n--\n-- %s\n--\n\n", comment))
+}
+
+// use switches the current database, because the tables in the CREATE TABLE statements and the INSERT statements aren't qualified
+func (sw *singleFileWriter) use(db string) {
+	if sw.db != db {
+		sw.db = db
+		sw.write(fmt.Sprintf("USE %s;\n", quoteIdentifier(db)))
+	}
+}
+
+func (sw *singleFileWriter) writeDatabase(db, createSQL string) {
+	sw.writeComment(fmt.Sprintf("Current Database: %s", quoteIdentifier(db)))
+	if createSQL != "" {
+		sw.write((&metaData{metaSQL: createSQL}).MetaSQL() + "\n")
+	}
+	sw.db = ""
+	sw.use(db)
+}
+
+func (sw *singleFileWriter) writeTableStructure(db, table, createSQL string) {
+	if createSQL == "" {
+		return
+	}
+	sw.use(db)
+	sw.writeComment(fmt.Sprintf("Table structure for table %s", quoteIdentifier(table)))
+	sw.write(fmt.Sprintf("DROP TABLE IF EXISTS %s;\n", quoteIdentifier(table)))
+	sw.write((&metaData{metaSQL: createSQL}).MetaSQL())
+}
+
+func (sw *singleFileWriter) writeTemporaryView(db, view, createTableSQL string) {
+	if createTableSQL == "" {
+		return
+	}
+	sw.use(db)
+	sw.writeComment(fmt.Sprintf("Temporary view structure for view %s", quoteIdentifier(view)))
+	sw.write(fmt.Sprintf("DROP TABLE IF EXISTS %s;\n", quoteIdentifier(view)))
+	sw.write((&metaData{metaSQL: createTableSQL}).MetaSQL())
+}
+
+func (sw *singleFileWriter) writeFinalView(db, view, createViewSQL string) {
+	if createViewSQL == "" {
+		return
+	}
+	sw.use(db)
+	sw.writeComment(fmt.Sprintf("Final view structure for view %s", quoteIdentifier(view)))
+	sw.write((&metaData{metaSQL: createViewSQL}).MetaSQL())
+}
+
+func (sw *singleFileWriter) writeTableData(table tableKey, files []spooledFile) {
+	sw.use(table.db)
+	name := quoteIdentifier(table.table)
+	sw.writeComment(fmt.Sprintf("Dumping data for table %s", name))
+	sw.write(fmt.Sprintf("LOCK TABLES %s WRITE;\n/*!40000 ALTER TABLE %s DISABLE KEYS */;\n", name, name))
+	for _, f := range files {
+		sw.copySpooledFile(f)
+	}
+	sw.write(fmt.Sprintf("/*!40000 ALTER TABLE %s ENABLE KEYS */;\nUNLOCK TABLES;\n", name))
+}
+
+func (sw *singleFileWriter) copySpooledFile(f spooledFile) {
+	if sw.err != nil {
+		return
+	}
+	r, err := sw.spool.Open(sw.tctx, f.spoolName)
+	if err != nil {
+		sw.err = errors.Annotatef(err, "fail to open the spooled file of %s", f.name)
+		return
+	}
+	defer r.Close()
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if _, err1 := sw.w.Write(sw.tctx, buf[:n]); err1 != nil {
+				sw.err = errors.Annotatef(err1, "fail to write %s into the single file", f.name)
+				return
+			}
+		}
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			sw.err = errors.Annotatef(err, "fail to read the spooled file of %s", f.name)
+			return
+		}
+	}
+}
+
+// writeSingleFile writes the single file of --single-file after all the tasks are done
+func (d *Dumper) writeSingleFile() error {
+	tctx, conf := d.tctx, d.conf
+	var viewOrder []tableKey
+	if d.views != nil {
+		viewOrder = d.views.order().tables
+	}
+	name := singleFileName + compressFileSuffix(conf.CompressType)
+	w, err := storage.WithCompression(d.extStore, conf.CompressType).Create(tctx, name)
+	if err != nil {
+		return errors.Annotatef(err, "fail to create %s", name)
+	}
+	err = d.singleFile.writeTo(tctx, w, conf.CompressType, viewOrder)
+	if err1 := w.Close(tctx); err == nil && err1 != nil {
+		err = errors.Annotatef(err1, "fail to close %s", name)
+	}
+	if err == nil {
+		tctx.L().Info("the single file is written", zap.String("file", name))
+	}
+	return err
+}
+
+func validateSingleFile(conf *Config) error {
+	if !conf.SingleFile {
+		return nil
+	}
+	if conf.SQL != "" {
+		return errors.New("can't specify both --sql and --single-file at the same time")
+	}
+	if conf.FileType != FileFormatSQLTextString {
+		return errors.Errorf("--single-file only supports --filetype sql, but got %s", conf.FileType)
+	}
+	return nil
+}
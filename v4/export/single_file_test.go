@@ -0,0 +1,125 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package export
+
+import (
+	"compress/gzip"
+	"context"
+	"database/sql/driver"
+	"io/ioutil"
+	"os"
+	"path"
+
+	tcontext "github.com/pingcap/dumpling/v4/context"
+
+	"github.com/pingcap/br/pkg/storage"
+	. "github.com/pingcap/check"
+)
+
+var _ = Suite(&testSingleFileSuite{})
+
+type testSingleFileSuite struct{}
+
+func (s *testSingleFileSuite) TestValidateSingleFile(c *C) {
+	conf := defaultConfigForTest(c)
+	c.Assert(validateSingleFile(conf), IsNil)
+	conf.SingleFile = true
+	c.Assert(validateSingleFile(conf), IsNil)
+	c.Assert(tableDataSpecialComments(conf), HasLen, 0)
+
+	conf.FileType = FileFormatCSVString
+	c.Assert(validateSingleFile(conf), ErrorMatches, "--single-file only supports --filetype sql.*")
+	conf.SQL = "SELECT 1"
+	c.Assert(validateSingleFile(conf), ErrorMatches, "can't specify both --sql and --single-file at the same time")
+}
+
+func (s *testSingleFileSuite) TestWriteSingleFile(c *C) {
+	dir := c.MkDir()
+	conf := defaultConfigForTest(c)
+	conf.OutputDirPath = dir
+	conf.SingleFile = true
+	conf.CompressType = storage.Gzip
+
+	b, err := storage.ParseBackend(dir, &conf.BackendOptions)
+	c.Assert(err, IsNil)
+	extStore, err := storage.Create(context.Background(), b, false)
+	c.Assert(err, IsNil)
+	d := &Dumper{tctx: tcontext.Background(), conf: conf, extStore: extStore, views: newViewOrderRecorder()}
+	d.singleFile, err = newSingleFileOutput(conf)
+	c.Assert(err, IsNil)
+
+	// the views are created at last in the order of their dependencies
+	v1 := "DROP TABLE IF EXISTS `v1`;\nDROP VIEW IF EXISTS `v1`;\nCREATE VIEW `v1` AS SELECT `id` FROM `v2`;\n"
+	v2 := "DROP TABLE IF EXISTS `v2`;\nDROP VIEW IF EXISTS `v2`;\nCREATE VIEW `v2` AS SELECT `id` FROM `t1`;\n"
+	c.Assert(d.views.record("test", "v1", v1), IsNil)
+	c.Assert(d.views.record("test", "v2", v2), IsNil)
+	for _, task := range []Task{
+		NewTaskDatabaseMeta("test", "CREATE DATABASE `test`"),
+		NewTaskTableMeta("test", "t1", "CREATE TABLE `t1` (\n  `id` int(11) NOT NULL\n)"),
+		NewTaskViewMeta("test", "v1", "CREATE TABLE `v1`(\n`id` int\n)ENGINE=MyISAM;\n", v1),
+		NewTaskDatabaseMeta("other", "CREATE DATABASE `other`"),
+		NewTaskTableMeta("other", "t2", "CREATE TABLE `t2` (\n  `id` int(11) NOT NULL\n)"),
+		NewTaskViewMeta("test", "v2", "CREATE TABLE `v2`(\n`id` int\n)ENGINE=MyISAM;\n", v2),
+	} {
+		c.Assert(d.sendMetaTaskToChan(task, nil), IsNil)
+	}
+
+	// the chunks are written by the writers out of order, the data of t1 is dropped because one of its chunks fails
+	writer := (&testWriterSuite{}).newWriter(conf, c)
+	writer.singleFile = d.singleFile
+	writerStorage := writer.extStorage
+	colTypes := []string{"INT"}
+	t1Chunk0 := newMockTableIR("test", "t1", [][]driver.Value{{"1"}, {"2"}}, nil, colTypes)
+	t1Chunk1 := newMockTableIR("test", "t1", [][]driver.Value{{"3"}}, nil, colTypes)
+	t1Chunk2 := newMockTableIR("test", "t1", [][]driver.Value{{"4"}}, nil, colTypes)
+	t2Chunk0 := newMockTableIR("other", "t2", [][]driver.Value{{"5"}}, nil, colTypes)
+	c.Assert(writer.handleTask(NewTaskTableData(t2Chunk0, t2Chunk0, 0, 1)), IsNil)
+	c.Assert(writer.handleTask(NewTaskTableData(t1Chunk1, t1Chunk1, 1, 3)), IsNil)
+	c.Assert(writer.handleTask(NewTaskTableData(t1Chunk0, t1Chunk0, 0, 3)), IsNil)
+	failedTask := NewTaskTableData(t1Chunk2, t1Chunk2, 2, 3)
+	c.Assert(writer.handleTask(failedTask), IsNil)
+	d.singleFile.discardTable(failedTask)
+	c.Assert(writer.extStorage, Equals, writerStorage)
+
+	c.Assert(d.writeSingleFile(), IsNil)
+	d.singleFile.close()
+	files, err := ioutil.ReadDir(dir)
+	c.Assert(err, IsNil)
+	c.Assert(files, HasLen, 1)
+	c.Assert(files[0].Name(), Equals, "dump.sql.gz")
+
+	f, err := os.Open(path.Join(dir, "dump.sql.gz"))
+	c.Assert(err, IsNil)
+	defer f.Close()
+	r, err := gzip.NewReader(f)
+	c.Assert(err, IsNil)
+	content, err := ioutil.ReadAll(r)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, singleFileHeader+
+		"\n--\n-- Current Database: `test`\n--\n\n"+
+		"CREATE DATABASE `test`;\n\n"+
+		"USE `test`;\n"+
+		"\n--\n-- Table structure for table `t1`\n--\n\n"+
+		"DROP TABLE IF EXISTS `t1`;\n"+
+		"CREATE TABLE `t1` (\n  `id` int(11) NOT NULL\n);\n"+
+		"\n--\n-- Temporary view structure for view `v1`\n--\n\n"+
+		"DROP TABLE IF EXISTS `v1`;\n"+
+		"CREATE TABLE `v1`(\n`id` int\n)ENGINE=MyISAM;\n"+
+		"\n--\n-- Current Database: `other`\n--\n\n"+
+		"CREATE DATABASE `other`;\n\n"+
+		"USE `other`;\n"+
+		"\n--\n-- Table structure for table `t2`\n--\n\n"+
+		"DROP TABLE IF EXISTS `t2`;\n"+
+		"CREATE TABLE `t2` (\n  `id` int(11) NOT NULL\n);\n"+
+		"\n--\n-- Dumping data for table `t2`\n--\n\n"+
+		"LOCK TABLES `t2` WRITE;\n/*!40000 ALTER TABLE `t2` DISABLE KEYS */;\n"+
+		"INSERT INTO `t2` VALUES\n(5);\n"+
+		"/*!40000 ALTER TABLE `t2` ENABLE KEYS */;\nUNLOCK TABLES;\n"+
+		"USE `test`;\n"+
+		"\n--\n-- Temporary view structure for view `v2`\n--\n\n"+
+		"DROP TABLE IF EXISTS `v2`;\n"+
+		"CREATE TABLE `v2`(\n`id` int\n)ENGINE=MyISAM;\n"+
+		"\n--\n-- Final view structure for view `v2`\n--\n\n"+v2+
+		"\n--\n-- Final view structure for view `v1`\n--\n\n"+v1+
+		singleFileFooter)
+}
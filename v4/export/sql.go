@@ -6,6 +6,7 @@ import (
 	"bytes"
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/url"
@@ -173,6 +174,38 @@ func ListAllDatabasesTables(db *sql.Conn, databaseNames []string, tableType Tabl
 	return dbTables, nil
 }
 
+// SelectTablesDataLength gets the data length of base tables in the given databases from information_schema.TABLES.
+// Only the given databases are queried, so that MySQL doesn't open all the tables on the instance
+func SelectTablesDataLength(db *sql.Conn, databaseNames []string) (map[string]map[string]uint64, error) {
+	sizes := make(map[string]map[string]uint64, len(databaseNames))
+	if len(databaseNames) == 0 {
+		return sizes, nil
+	}
+	args := make([]interface{}, 0, len(databaseNames))
+	for _, schema := range databaseNames {
+		sizes[schema] = make(map[string]uint64)
+		args = append(args, schema)
+	}
+	query := "SELECT TABLE_SCHEMA,TABLE_NAME,DATA_LENGTH FROM INFORMATION_SCHEMA.TABLES " +
+		"WHERE TABLE_TYPE = 'BASE TABLE' AND TABLE_SCHEMA IN (" + strings.TrimSuffix(strings.Repeat("?,", len(databaseNames)), ",") + ")"
+	if err := simpleQueryWithArgs(db, func(rows *sql.Rows) error {
+		var (
+			schema, table string
+			dataLength    sql.NullInt64
+		)
+		if err := rows.Scan(&schema, &table, &dataLength); err != nil {
+			return errors.Trace(err)
+		}
+		if tables, ok := sizes[schema]; ok && dataLength.Valid && dataLength.Int64 > 0 {
+			tables[table] = uint64(dataLength.Int64)
+		}
+		return nil
+	}, query, args...); err != nil {
+		return nil, errors.Annotatef(err, "sql: %s", query)
+	}
+	return sizes, nil
+}
+
 // SelectVersion gets the version information from the database server
 func SelectVersion(db *sql.DB) (string, error) {
 	var versionInfo string
@@ -196,15 +229,24 @@ func SelectAllFromTable(conf *Config, db *sql.Conn, database, table string) (Tab
 	if err != nil {
 		return nil, err
 	}
-	query := buildSelectQuery(database, table, selectedField, buildWhereCondition(conf, ""), orderByClause)
+	return selectAllFromTable(conf.Where, database, table, selectedField, selectLen, orderByClause), nil
+}
+
+func selectAllFromTable(tableWhere, database, table, selectedField string, selectLen int, orderByClause string) TableDataIR {
+	query := buildSelectQuery(database, table, selectedField, buildWhereCondition(tableWhere, ""), orderByClause)
 
 	return &tableData{
 		query:  query,
 		colLen: selectLen,
-	}, nil
+	}
 }
 
 func buildSelectQuery(database, table string, fields string, where string, orderByClause string) string {
+	return buildSelectPartitionQuery(database, table, "", fields, where, orderByClause)
+}
+
+// buildSelectPartitionQuery builds the query selecting from a partition of the table, or the whole table if partition is empty
+func buildSelectPartitionQuery(database, table, partition string, fields string, where string, orderByClause string) string {
 	var query strings.Builder
 	query.WriteString("SELECT ")
 	if fields == "" {
@@ -218,6 +260,11 @@ func buildSelectQuery(database, table string, fields string, where string, order
 	query.WriteString("`.`")
 	query.WriteString(escapeString(table))
 	query.WriteString("`")
+	if partition != "" {
+		query.WriteString(" PARTITION (`")
+		query.WriteString(escapeString(partition))
+		query.WriteString("`)")
+	}
 
 	if where != "" {
 		query.WriteString(" ")
@@ -281,6 +328,35 @@ func GetColumnTypes(db *sql.Conn, fields, database, table string) ([]*sql.Column
 	return rows.ColumnTypes()
 }
 
+// SelectTablePartitions gets the partition names of a table in ordinal order, it returns nil if the table isn't partitioned
+func SelectTablePartitions(db *sql.Conn, database, table string) ([]string, error) {
+	const query = "SELECT PARTITION_NAME FROM INFORMATION_SCHEMA.PARTITIONS " +
+		"WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ? AND PARTITION_NAME IS NOT NULL " +
+		"GROUP BY PARTITION_NAME ORDER BY MIN(PARTITION_ORDINAL_POSITION)"
+	var partitions oneStrColumnTable
+	if err := simpleQueryWithArgs(db, partitions.handleOneRow, query, database, table); err != nil {
+		return nil, errors.Annotatef(err, "sql: %s", query)
+	}
+	return partitions.data, nil
+}
+
+// SelectColumnNullableAndType gets the IS_NULLABLE and DATA_TYPE of a column, it returns an error if the table has no such column
+func SelectColumnNullableAndType(db *sql.Conn, database, table, column string) (nullable bool, dataType string, err error) {
+	const query = "SELECT IS_NULLABLE,DATA_TYPE FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ? AND COLUMN_NAME = ?"
+	var isNullable string
+	found := false
+	if err = simpleQueryWithArgs(db, func(rows *sql.Rows) error {
+		found = true
+		return errors.Trace(rows.Scan(&isNullable, &dataType))
+	}, query, database, table, column); err != nil {
+		return false, "", errors.Annotatef(err, "sql: %s", query)
+	}
+	if !found {
+		return false, "", errors.Errorf("table `%s`.`%s` has no column `%s`", escapeString(database), escapeString(table), escapeString(column))
+	}
+	return strings.EqualFold(isNullable, "YES"), dataType, nil
+}
+
 // GetPrimaryKeyAndColumnTypes gets all primary columns and their types in ordinal order
 func GetPrimaryKeyAndColumnTypes(conn *sql.Conn, database, table string) ([]string, []string, error) {
 	query :=
@@ -363,6 +439,52 @@ func FlushTableWithReadLock(ctx context.Context, db *sql.Conn) error {
 	return errors.Annotatef(err, "sql: %s", ftwrlQuery)
 }
 
+// longQuery is a query of another session caught by the long query guard
+type longQuery struct {
+	id   uint64
+	user string
+	time uint64
+	info string
+}
+
+// selectLongQueries lists the queries of other sessions which have been running for at least the given seconds
+func selectLongQueries(ctx context.Context, db *sql.Conn, seconds uint64) ([]longQuery, error) {
+	const processListQuery = "SELECT ID, USER, TIME, INFO FROM information_schema.PROCESSLIST " +
+		"WHERE COMMAND = 'Query' AND USER <> 'system user' AND TIME >= ? AND ID <> CONNECTION_ID()"
+	rows, err := db.QueryContext(ctx, processListQuery, seconds)
+	if err != nil {
+		return nil, errors.Annotatef(err, "sql: %s", processListQuery)
+	}
+	defer rows.Close()
+	var queries []longQuery
+	for rows.Next() {
+		var (
+			q    longQuery
+			info sql.NullString
+		)
+		if err = rows.Scan(&q.id, &q.user, &q.time, &info); err != nil {
+			return nil, errors.Annotatef(err, "sql: %s", processListQuery)
+		}
+		q.info = info.String
+		queries = append(queries, q)
+	}
+	return queries, errors.Annotatef(rows.Err(), "sql: %s", processListQuery)
+}
+
+// KillQuery terminates the statement the connection is executing, but leaves the connection itself intact
+func KillQuery(ctx context.Context, db *sql.Conn, id uint64) error {
+	killQuery := fmt.Sprintf("KILL QUERY %d", id)
+	_, err := db.ExecContext(ctx, killQuery)
+	return errors.Annotatef(err, "sql: %s", killQuery)
+}
+
+// SetLockWaitTimeout sets the session's lock_wait_timeout in seconds
+func SetLockWaitTimeout(ctx context.Context, db *sql.Conn, seconds uint64) error {
+	setQuery := fmt.Sprintf("SET SESSION lock_wait_timeout = %d", seconds)
+	_, err := db.ExecContext(ctx, setQuery)
+	return errors.Annotatef(err, "sql: %s", setQuery)
+}
+
 // LockTables locks table with read lock
 func LockTables(ctx context.Context, db *sql.Conn, database, table string) error {
 	lockTableQuery := fmt.Sprintf("LOCK TABLES `%s`.`%s` READ", escapeString(database), escapeString(table))
@@ -377,6 +499,60 @@ func UnlockTables(ctx context.Context, db *sql.Conn) error {
 	return errors.Annotatef(err, "sql: %s", unlockTableQuery)
 }
 
+// showLogStatus gets the binlog position and GTID set from performance_schema.log_status, which is available since MySQL 8.0.14
+//
+// mysql> SELECT LOCAL FROM performance_schema.log_status;
+// +-------------------------------------------------------------------------------------------------------------------+
+// | LOCAL                                                                                                             |
+// +-------------------------------------------------------------------------------------------------------------------+
+// | {"gtid_executed": "6ce40be3-e359-11e9-87e0-36933cb0ca5a:1-29", "binary_log_file": "binlog.000002", "binary_log_position": 7502} |
+// +-------------------------------------------------------------------------------------------------------------------+
+func showLogStatus(ctx context.Context, db *sql.Conn) (*binlogPosition, error) {
+	const logStatusQuery = "SELECT LOCAL FROM performance_schema.log_status"
+	var local string
+	if err := db.QueryRowContext(ctx, logStatusQuery).Scan(&local); err != nil {
+		return nil, errors.Annotatef(err, "sql: %s", logStatusQuery)
+	}
+	var status struct {
+		GTIDExecuted      string `json:"gtid_executed"`
+		BinaryLogFile     string `json:"binary_log_file"`
+		BinaryLogPosition uint64 `json:"binary_log_position"`
+	}
+	if err := json.Unmarshal([]byte(local), &status); err != nil {
+		return nil, errors.Annotatef(err, "fail to parse log status %s", local)
+	}
+	return &binlogPosition{
+		file:    status.BinaryLogFile,
+		pos:     strconv.FormatUint(status.BinaryLogPosition, 10),
+		gtidSet: status.GTIDExecuted,
+	}, nil
+}
+
+// showMasterStatusWithBinlogLock gets a consistent binlog position under `LOCK BINLOG FOR BACKUP` of Percona Server 5.6/5.7.
+// The binlog lock is kept on success, so that no transaction commits until it's released by unlockBinlog
+func showMasterStatusWithBinlogLock(ctx context.Context, db *sql.Conn) (*binlogPosition, error) {
+	const lockBinlogQuery = "LOCK BINLOG FOR BACKUP"
+	if _, err := db.ExecContext(ctx, lockBinlogQuery); err != nil {
+		return nil, errors.Annotatef(err, "sql: %s", lockBinlogQuery)
+	}
+	str, err := ShowMasterStatus(db)
+	if err != nil {
+		return nil, err
+	}
+	return &binlogPosition{
+		file:    getValidStr(str, fileFieldIndex),
+		pos:     getValidStr(str, posFieldIndex),
+		gtidSet: getValidStr(str, gtidSetFieldIndex),
+	}, nil
+}
+
+// unlockBinlog releases the binlog lock of Percona Server
+func unlockBinlog(ctx context.Context, db *sql.Conn) error {
+	const unlockBinlogQuery = "UNLOCK BINLOG"
+	_, err := db.ExecContext(ctx, unlockBinlogQuery)
+	return errors.Annotatef(err, "sql: %s", unlockBinlogQuery)
+}
+
 // ShowMasterStatus get SHOW MASTER STATUS result from database
 func ShowMasterStatus(db *sql.Conn) ([]string, error) {
 	var oneRow []string
@@ -535,6 +711,26 @@ func createConnWithConsistency(ctx context.Context, db *sql.DB) (*sql.Conn, erro
 	return conn, nil
 }
 
+// createConnsWithConsistency creates n connections on the same consistent snapshot. No connection is returned on error
+func createConnsWithConsistency(ctx context.Context, db *sql.DB, n int) ([]*sql.Conn, error) {
+	conns := make([]*sql.Conn, 0, n)
+	for i := 0; i < n; i++ {
+		conn, err := createConnWithConsistency(ctx, db)
+		if err != nil {
+			closeConns(conns)
+			return nil, err
+		}
+		conns = append(conns, conn)
+	}
+	return conns, nil
+}
+
+func closeConns(conns []*sql.Conn) {
+	for _, conn := range conns {
+		conn.Close()
+	}
+}
+
 // buildSelectField returns the selecting fields' string(joined by comma(`,`)),
 // and the number of writable fields.
 func buildSelectField(db *sql.Conn, dbName, tableName string, completeInsert bool) (string, int, error) { // revive:disable-line:flag-parameter
@@ -544,9 +740,9 @@ func buildSelectField(db *sql.Conn, dbName, tableName string, completeInsert boo
 		return "", 0, errors.Annotatef(err, "sql: %s", query)
 	}
 	defer rows.Close()
-	availableFields := make([]string, 0)
+	fieldNames := make([]string, 0)
+	extras := make([]string, 0)
 
-	hasGenerateColumn := false
 	var fieldName string
 	var extra string
 	for rows.Next() {
@@ -554,20 +750,32 @@ func buildSelectField(db *sql.Conn, dbName, tableName string, completeInsert boo
 		if err != nil {
 			return "", 0, errors.Annotatef(err, "sql: %s", query)
 		}
-		switch extra {
+		fieldNames = append(fieldNames, fieldName)
+		extras = append(extras, extra)
+	}
+	if err = rows.Err(); err != nil {
+		return "", 0, errors.Annotatef(err, "sql: %s", query)
+	}
+	selectField, selectLen := buildSelectFieldFromColumns(fieldNames, extras, completeInsert)
+	return selectField, selectLen, nil
+}
+
+// buildSelectFieldFromColumns is buildSelectField on the columns in ordinal order and their EXTRA in INFORMATION_SCHEMA.COLUMNS
+func buildSelectFieldFromColumns(fieldNames, extras []string, completeInsert bool) (string, int) { // revive:disable-line:flag-parameter
+	availableFields := make([]string, 0, len(fieldNames))
+	hasGenerateColumn := false
+	for i, fieldName := range fieldNames {
+		switch extras[i] {
 		case "STORED GENERATED", "VIRTUAL GENERATED":
 			hasGenerateColumn = true
 			continue
 		}
 		availableFields = append(availableFields, wrapBackTicks(escapeString(fieldName)))
 	}
-	if err = rows.Err(); err != nil {
-		return "", 0, errors.Annotatef(err, "sql: %s", query)
-	}
 	if completeInsert || hasGenerateColumn {
-		return strings.Join(availableFields, ","), len(availableFields), nil
+		return strings.Join(availableFields, ","), len(availableFields)
 	}
-	return "*", len(availableFields), nil
+	return "*", len(availableFields)
 }
 
 func buildWhereClauses(handleColNames, handleVals []string) []string {
@@ -606,7 +814,8 @@ func buildLockTablesSQL(allTables DatabaseTables, blockList map[string]map[strin
 	estimatedCap := len(allTables)*11 + 10
 	s := bytes.NewBuffer(make([]byte, 0, estimatedCap))
 	n := false
-	for dbName, tables := range allTables {
+	for _, dbName := range allTables.sortedDatabaseNames() {
+		tables := allTables[dbName]
 		escapedDBName := escapeString(dbName)
 		for _, table := range tables {
 			// Lock views will lock related tables. However, we won't dump data only the create sql of view, so we needn't lock view here.
@@ -692,13 +901,18 @@ func pickupPossibleField(dbName, tableName string, db *sql.Conn, conf *Config) (
 	return fieldName, nil
 }
 
-func estimateCount(tctx *tcontext.Context, dbName, tableName string, db *sql.Conn, field string, conf *Config) uint64 {
-	query := fmt.Sprintf("EXPLAIN SELECT `%s` FROM `%s`.`%s`", escapeString(field), escapeString(dbName), escapeString(tableName))
-
-	if conf.Where != "" {
-		query += " WHERE "
-		query += conf.Where
+// estimateCount estimates the rows count of the partition of the table by EXPLAIN, or the whole table if partition is empty.
+// All the fields are selected if field is empty
+func estimateCount(tctx *tcontext.Context, dbName, tableName, partition string, db *sql.Conn, field string, tableWhere string) uint64 {
+	selected := "*"
+	if field != "" {
+		selected = fmt.Sprintf("`%s`", escapeString(field))
+	}
+	var where string
+	if tableWhere != "" {
+		where = "WHERE " + tableWhere
 	}
+	query := "EXPLAIN " + buildSelectPartitionQuery(dbName, tableName, partition, selected, where, "")
 
 	estRows := detectEstimateRows(tctx, db, query, []string{"rows", "estRows", "count"})
 	/* tidb results field name is estRows (before 4.0.0-beta.2: count)
@@ -799,14 +1013,15 @@ func parseSnapshotToTSO(pool *sql.DB, snapshot string) (uint64, error) {
 	return (uint64(tso.Int64)<<18)*1000 + 1, nil
 }
 
-func buildWhereCondition(conf *Config, where string) string {
+// buildWhereCondition combines the condition of the whole table, i.e. --where and the watermark range, and the condition of a chunk
+func buildWhereCondition(tableWhere, where string) string {
 	var query strings.Builder
 	separator := "WHERE"
-	if conf.Where != "" {
+	if tableWhere != "" {
 		query.WriteString(" ")
 		query.WriteString(separator)
 		query.WriteString(" ")
-		query.WriteString(conf.Where)
+		query.WriteString(tableWhere)
 		separator = "AND"
 	}
 	if where != "" {
@@ -821,3 +1036,56 @@ func buildWhereCondition(conf *Config, where string) string {
 func escapeString(s string) string {
 	return strings.ReplaceAll(s, "`", "``")
 }
+
+// SelectForeignKeys gets the foreign keys between the tables to dump, the foreign keys referencing the other tables are ignored.
+// Only the dumped databases are queried, so that MySQL doesn't open all the tables on the instance
+func SelectForeignKeys(db *sql.Conn, tables DatabaseTables) ([]*foreignKey, error) {
+	databases := tables.sortedDatabaseNames()
+	if len(databases) == 0 {
+		return nil, nil
+	}
+	args := make([]interface{}, 0, len(databases))
+	for _, dbName := range databases {
+		args = append(args, dbName)
+	}
+	query := "SELECT k.CONSTRAINT_SCHEMA,k.TABLE_NAME,k.CONSTRAINT_NAME,k.COLUMN_NAME,k.REFERENCED_TABLE_SCHEMA,k.REFERENCED_TABLE_NAME,k.REFERENCED_COLUMN_NAME " +
+		"FROM INFORMATION_SCHEMA.KEY_COLUMN_USAGE k JOIN INFORMATION_SCHEMA.REFERENTIAL_CONSTRAINTS r " +
+		"ON k.CONSTRAINT_SCHEMA = r.CONSTRAINT_SCHEMA AND k.TABLE_NAME = r.TABLE_NAME AND k.CONSTRAINT_NAME = r.CONSTRAINT_NAME " +
+		"WHERE k.CONSTRAINT_SCHEMA IN (" + strings.TrimSuffix(strings.Repeat("?,", len(databases)), ",") + ") " +
+		"ORDER BY k.CONSTRAINT_SCHEMA,k.TABLE_NAME,k.CONSTRAINT_NAME,k.ORDINAL_POSITION"
+	dumped := make(map[tableKey]struct{}, calculateTableCount(tables))
+	for dbName, infos := range tables {
+		for _, info := range infos {
+			if info.Type == TableTypeBase {
+				dumped[tableKey{db: dbName, table: info.Name}] = struct{}{}
+			}
+		}
+	}
+	var (
+		fks  []*foreignKey
+		last *foreignKey
+	)
+	if err := simpleQueryWithArgs(db, func(rows *sql.Rows) error {
+		var (
+			child, parent        tableKey
+			name, column, refCol string
+		)
+		if err := rows.Scan(&child.db, &child.table, &name, &column, &parent.db, &parent.table, &refCol); err != nil {
+			return errors.Trace(err)
+		}
+		if last == nil || last.child != child || last.name != name {
+			last = &foreignKey{name: name, child: child, parent: parent}
+			_, childDumped := dumped[child]
+			_, parentDumped := dumped[parent]
+			if childDumped && parentDumped {
+				fks = append(fks, last)
+			}
+		}
+		last.columns = append(last.columns, column)
+		last.refColumns = append(last.refColumns, refCol)
+		return nil
+	}, query, args...); err != nil {
+		return nil, errors.Annotatef(err, "sql: %s", query)
+	}
+	return fks, nil
+}
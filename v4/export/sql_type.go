@@ -15,6 +15,7 @@ var (
 	quotationMark       = []byte{'\''}
 	twoQuotationMarks   = []byte{'\'', '\''}
 	doubleQuotationMark = []byte{'"'}
+	copyNullValue       = `\N`
 )
 
 func initColTypeRowReceiverMap() {
@@ -143,6 +144,31 @@ func escapeCSV(s []byte, bf *bytes.Buffer, escapeBackslash bool, opt *csvOption)
 	}
 }
 
+// escapeCopy escapes the value in the text format of PostgreSQL COPY
+func escapeCopy(s []byte, bf *bytes.Buffer) {
+	last := 0
+	for i := 0; i < len(s); i++ {
+		var escape byte
+		switch s[i] {
+		case '\\':
+			escape = '\\'
+		case '\n':
+			escape = 'n'
+		case '\r':
+			escape = 'r'
+		case '\t':
+			escape = 't'
+		default:
+			continue
+		}
+		bf.Write(s[last:i])
+		bf.WriteByte('\\')
+		bf.WriteByte(escape)
+		last = i + 1
+	}
+	bf.Write(s[last:])
+}
+
 // SQLTypeStringMaker returns a SQLTypeString
 func SQLTypeStringMaker() RowReceiverStringer {
 	return &SQLTypeString{}
@@ -213,6 +239,28 @@ func (r RowReceiverArr) WriteToBufferInCsv(bf *bytes.Buffer, escapeBackslash boo
 	}
 }
 
+// WriteToBufferInPostgres implements Stringer.WriteToBufferInPostgres
+func (r RowReceiverArr) WriteToBufferInPostgres(bf *bytes.Buffer) {
+	bf.WriteByte('(')
+	for i, receiver := range r.receivers {
+		receiver.WriteToBufferInPostgres(bf)
+		if i != len(r.receivers)-1 {
+			bf.WriteByte(',')
+		}
+	}
+	bf.WriteByte(')')
+}
+
+// WriteToBufferInCopy implements Stringer.WriteToBufferInCopy
+func (r RowReceiverArr) WriteToBufferInCopy(bf *bytes.Buffer) {
+	for i, receiver := range r.receivers {
+		receiver.WriteToBufferInCopy(bf)
+		if i != len(r.receivers)-1 {
+			bf.WriteByte('\t')
+		}
+	}
+}
+
 // SQLTypeNumber implements RowReceiverStringer which represents numeric type columns in database
 type SQLTypeNumber struct {
 	SQLTypeString
@@ -236,6 +284,20 @@ func (s SQLTypeNumber) WriteToBufferInCsv(bf *bytes.Buffer, _ bool, opt *csvOpti
 	}
 }
 
+// WriteToBufferInPostgres implements Stringer.WriteToBufferInPostgres
+func (s SQLTypeNumber) WriteToBufferInPostgres(bf *bytes.Buffer) {
+	s.WriteToBuffer(bf, false)
+}
+
+// WriteToBufferInCopy implements Stringer.WriteToBufferInCopy
+func (s SQLTypeNumber) WriteToBufferInCopy(bf *bytes.Buffer) {
+	if s.RawBytes != nil {
+		bf.Write(s.RawBytes)
+	} else {
+		bf.WriteString(copyNullValue)
+	}
+}
+
 // SQLTypeString implements RowReceiverStringer which represents string type columns in database
 type SQLTypeString struct {
 	sql.RawBytes
@@ -268,6 +330,26 @@ func (s *SQLTypeString) WriteToBufferInCsv(bf *bytes.Buffer, escapeBackslash boo
 	}
 }
 
+// WriteToBufferInPostgres implements Stringer.WriteToBufferInPostgres
+func (s *SQLTypeString) WriteToBufferInPostgres(bf *bytes.Buffer) {
+	if s.RawBytes != nil {
+		bf.Write(quotationMark)
+		escapeSQL(s.RawBytes, bf, false)
+		bf.Write(quotationMark)
+	} else {
+		bf.WriteString(nullValue)
+	}
+}
+
+// WriteToBufferInCopy implements Stringer.WriteToBufferInCopy
+func (s *SQLTypeString) WriteToBufferInCopy(bf *bytes.Buffer) {
+	if s.RawBytes != nil {
+		escapeCopy(s.RawBytes, bf)
+	} else {
+		bf.WriteString(copyNullValue)
+	}
+}
+
 // SQLTypeBytes implements RowReceiverStringer which represents bytes type columns in database
 type SQLTypeBytes struct {
 	sql.RawBytes
@@ -297,3 +379,22 @@ func (s *SQLTypeBytes) WriteToBufferInCsv(bf *bytes.Buffer, escapeBackslash bool
 		bf.WriteString(opt.nullValue)
 	}
 }
+
+// WriteToBufferInPostgres implements Stringer.WriteToBufferInPostgres
+func (s *SQLTypeBytes) WriteToBufferInPostgres(bf *bytes.Buffer) {
+	if s.RawBytes != nil {
+		fmt.Fprintf(bf, "'\\x%x'::bytea", s.RawBytes)
+	} else {
+		bf.WriteString(nullValue)
+	}
+}
+
+// WriteToBufferInCopy implements Stringer.WriteToBufferInCopy
+func (s *SQLTypeBytes) WriteToBufferInCopy(bf *bytes.Buffer) {
+	if s.RawBytes != nil {
+		// the backslash of the bytea hex format is escaped in the text format of COPY
+		fmt.Fprintf(bf, "\\\\x%x", s.RawBytes)
+	} else {
+		bf.WriteString(copyNullValue)
+	}
+}
@@ -0,0 +1,234 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package export
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/br/pkg/summary"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/parser/charset"
+	"github.com/pingcap/parser/mysql"
+	"github.com/pingcap/parser/types"
+	"go.uber.org/zap"
+
+	tcontext "github.com/pingcap/dumpling/v4/context"
+)
+
+const (
+	sqliteFileName = "dump.sqlite"
+	// sqliteCommand is the command line shell of SQLite, which is used instead of a cgo driver to keep dumpling static
+	sqliteCommand = "sqlite3"
+	// sqliteAck is printed by sqlite3 after the statements before it are executed successfully
+	sqliteAck = "dumpling-ack"
+)
+
+// sqliteOutput writes the dump into one SQLite database with --filetype sqlite.
+// All the writers share one sqlite3 process, which executes the statements serially. The tables are created by the
+// dumper before their data tasks are sent to the writers. Since the statements are read by the line reader of sqlite3,
+// the text values containing NUL bytes can't be written and fail the table, while the binary values are written in hex.
+type sqliteOutput struct {
+	path string
+
+	mu     sync.Mutex
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+	stderr bytes.Buffer
+	closed bool
+	// err is the failure of sqlite3, which exits at the first failed statement
+	err error
+}
+
+func validateSQLiteOutput(conf *Config) error {
+	if conf.FileType != FileFormatSQLiteString {
+		return nil
+	}
+	if conf.postgresDialect() {
+		return errors.New("--filetype sqlite can't be used together with --sql-dialect postgres")
+	}
+	if conf.NoSchemas {
+		return errors.New("--filetype sqlite can't be used together with --no-schemas, the tables are created from the schemas")
+	}
+	if conf.CompressType != storage.NoCompression {
+		return errors.New("--filetype sqlite can't be used together with --compress")
+	}
+	if conf.OutputDirPath == outputToStdout || conf.PipeCommand != "" {
+		return errors.New("--filetype sqlite only supports the local output directory")
+	}
+	b, err := storage.ParseBackend(conf.OutputDirPath, &conf.BackendOptions)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if b.GetLocal() == nil {
+		return errors.New("--filetype sqlite only supports the local output directory")
+	}
+	return nil
+}
+
+func newSQLiteOutput(conf *Config) (*sqliteOutput, error) {
+	b, err := storage.ParseBackend(conf.OutputDirPath, &conf.BackendOptions)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	o := &sqliteOutput{path: path.Join(b.GetLocal().Path, sqliteFileName)}
+	if _, err = os.Stat(o.path); err == nil {
+		return nil, errors.Errorf("the SQLite database %s already exists", o.path)
+	}
+	bin, err := exec.LookPath(sqliteCommand)
+	if err != nil {
+		return nil, errors.Annotatef(err, "--filetype sqlite needs the %s command", sqliteCommand)
+	}
+	o.cmd = exec.Command(bin, "-bail", "-batch", o.path)
+	o.cmd.Stderr = &o.stderr
+	if o.stdin, err = o.cmd.StdinPipe(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	stdout, err := o.cmd.StdoutPipe()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	o.stdout = bufio.NewReader(stdout)
+	if err = o.cmd.Start(); err != nil {
+		return nil, errors.Annotatef(err, "fail to start %s", sqliteCommand)
+	}
+	return o, nil
+}
+
+// exec executes the statements, and waits for them to be done
+func (o *sqliteOutput) exec(statements string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.err != nil {
+		return o.err
+	}
+	if o.closed {
+		return errors.Errorf("the SQLite database %s is closed", o.path)
+	}
+	_, err := io.WriteString(o.stdin, statements+"\n.print "+sqliteAck+"\n")
+	var line string
+	if err == nil {
+		line, err = o.stdout.ReadString('\n')
+	}
+	if err == nil && line == sqliteAck+"\n" {
+		return nil
+	}
+	o.closed = true
+	_ = o.stdin.Close()
+	_ = o.cmd.Wait()
+	o.err = errors.Errorf("fail to write the SQLite database %s: %s", o.path, strings.TrimSpace(o.stderr.String()))
+	return o.err
+}
+
+// close waits for sqlite3 to finish the statements and exit
+func (o *sqliteOutput) close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return o.err
+	}
+	o.closed = true
+	_ = o.stdin.Close()
+	if err := o.cmd.Wait(); err != nil {
+		o.err = errors.Annotatef(err, "fail to write the SQLite database %s: %s", o.path, strings.TrimSpace(o.stderr.String()))
+	}
+	return o.err
+}
+
+// writeMeta creates the table of the translated schema, the databases and the views aren't written
+func (o *sqliteOutput) writeMeta(tctx *tcontext.Context, task Task) error {
+	switch t := task.(type) {
+	case *TaskTableMeta:
+		return o.exec(t.CreateTableSQL)
+	case *TaskViewMeta:
+		tctx.L().Warn("the views aren't written into the SQLite database",
+			zap.String("database", t.DatabaseName), zap.String("view", t.ViewName))
+	}
+	return nil
+}
+
+// writeTableData inserts the rows into the table, each transaction inserts the rows up to --statement-size
+func (o *sqliteOutput) writeTableData(tctx *tcontext.Context, conf *Config, meta TableMeta, tblIR TableDataIR) error {
+	fileRowIter := tblIR.Rows()
+	if !fileRowIter.HasNext() || meta.SelectedField() == "" {
+		return nil
+	}
+
+	var (
+		bf          bytes.Buffer
+		row         = MakeRowReceiver(meta.ColumnTypes())
+		counter     uint64
+		lastCounter uint64
+		err         error
+	)
+	prefix := fmt.Sprintf("BEGIN;\nINSERT INTO %s%s VALUES\n", quotePostgresIdentifier(meta.TableName()), postgresColumnList(meta))
+	for fileRowIter.HasNext() {
+		bf.Reset()
+		bf.WriteString(prefix)
+		for fileRowIter.HasNext() {
+			if err = fileRowIter.Decode(row); err != nil {
+				tctx.L().Error("fail to scan from sql.Row", zap.Error(err))
+				return errors.Trace(err)
+			}
+			// the literals of SQLite are the same as MySQL's without the backslash escapes
+			rowStart := bf.Len()
+			row.WriteToBuffer(&bf, false)
+			if bytes.IndexByte(bf.Bytes()[rowStart:], 0) >= 0 {
+				return errors.Errorf("a text value of table %s contains a NUL byte, which can't be written into the SQLite database by %s",
+					meta.TableName(), sqliteCommand)
+			}
+			counter++
+			fileRowIter.Next()
+			if !fileRowIter.HasNext() || (conf.StatementSize != UnspecifiedSize && uint64(bf.Len()) >= conf.StatementSize) {
+				break
+			}
+			bf.WriteString(",\n")
+		}
+		bf.WriteString(";\nCOMMIT;")
+		if err = o.exec(bf.String()); err != nil {
+			return err
+		}
+		summary.CollectSuccessUnit(summary.TotalBytes, 1, uint64(bf.Len()))
+		AddCounter(finishedRowsCounter, conf.Labels, float64(counter-lastCounter))
+		lastCounter = counter
+	}
+	tctx.L().Debug("finish dumping table(chunk)",
+		zap.String("database", meta.DatabaseName()),
+		zap.String("table", meta.TableName()),
+		zap.Uint64("total rows", counter))
+	summary.CollectSuccessUnit("total rows", 1, counter)
+	return errors.Trace(fileRowIter.Error())
+}
+
+// sqliteColumnType returns the type of the column in SQLite, whose type affinity keeps the values of the MySQL type
+func sqliteColumnType(tp *types.FieldType) string {
+	binary := tp.Charset == charset.CharsetBin
+	switch tp.Tp {
+	case mysql.TypeTiny, mysql.TypeShort, mysql.TypeInt24, mysql.TypeLong, mysql.TypeLonglong, mysql.TypeYear:
+		return "INTEGER"
+	case mysql.TypeFloat, mysql.TypeDouble:
+		return "REAL"
+	case mysql.TypeNewDecimal:
+		return "NUMERIC"
+	case mysql.TypeVarchar, mysql.TypeVarString, mysql.TypeString,
+		mysql.TypeTinyBlob, mysql.TypeBlob, mysql.TypeMediumBlob, mysql.TypeLongBlob:
+		if binary {
+			return "BLOB"
+		}
+		return "TEXT"
+	case mysql.TypeBit:
+		return "BLOB"
+	default:
+		// the dates and times are kept in text like '2021-01-01 00:00:00', which are understood by the date functions
+		return "TEXT"
+	}
+}
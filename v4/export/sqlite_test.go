@@ -0,0 +1,126 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package export
+
+import (
+	"database/sql/driver"
+	"os/exec"
+	"path"
+
+	"github.com/pingcap/br/pkg/storage"
+	. "github.com/pingcap/check"
+)
+
+var _ = Suite(&testSQLiteSuite{})
+
+type testSQLiteSuite struct{}
+
+func (s *testSQLiteSuite) TestValidateSQLiteOutput(c *C) {
+	conf := defaultConfigForTest(c)
+	conf.OutputDirPath = c.MkDir()
+	c.Assert(validateSQLiteOutput(conf), IsNil)
+	conf.FileType = FileFormatSQLiteString
+	c.Assert(validateSQLiteOutput(conf), IsNil)
+	c.Assert(conf.translatedDialect(), Equals, sqlDialectSQLite)
+
+	conf.CompressType = storage.Gzip
+	c.Assert(validateSQLiteOutput(conf), ErrorMatches, "--filetype sqlite can't be used together with --compress")
+	conf.CompressType = storage.NoCompression
+	conf.OutputDirPath = "s3://bucket/dump"
+	c.Assert(validateSQLiteOutput(conf), ErrorMatches, "--filetype sqlite only supports the local output directory")
+	conf.NoSchemas = true
+	c.Assert(validateSQLiteOutput(conf), ErrorMatches, "--filetype sqlite can't be used together with --no-schemas.*")
+	conf.SQLDialect = sqlDialectPostgres
+	c.Assert(validateSQLiteOutput(conf), ErrorMatches, "--filetype sqlite can't be used together with --sql-dialect postgres")
+}
+
+func (s *testSQLiteSuite) TestTranslateCreateTable(c *C) {
+	createSQL := "CREATE TABLE `t` (\n" +
+		"  `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,\n" +
+		"  `name` varchar(64) NOT NULL DEFAULT '' COMMENT 'name',\n" +
+		"  `price` decimal(10,2) DEFAULT NULL,\n" +
+		"  `hash` varbinary(32) DEFAULT NULL,\n" +
+		"  `created` datetime(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),\n" +
+		"  PRIMARY KEY (`id`),\n" +
+		"  KEY `idx_name` (`name`(10))\n" +
+		") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='orders'"
+	task, err := translateMetaTask(sqlDialectSQLite, NewTaskTableMeta("test", "t", createSQL))
+	c.Assert(err, IsNil)
+	c.Assert(task.(*TaskTableMeta).CreateTableSQL, Equals, "CREATE TABLE \"t\" (\n"+
+		"  \"id\" INTEGER NOT NULL,\n"+
+		"  \"name\" TEXT NOT NULL DEFAULT '',\n"+
+		"  \"price\" NUMERIC DEFAULT NULL,\n"+
+		"  \"hash\" BLOB DEFAULT NULL,\n"+
+		"  \"created\" TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,\n"+
+		"  PRIMARY KEY (\"id\")\n"+
+		");\n"+
+		"CREATE INDEX \"t_idx_name\" ON \"t\" (\"name\");\n")
+
+	// the databases and the views aren't written
+	dbTask := NewTaskDatabaseMeta("test", "CREATE DATABASE `test`")
+	task, err = translateMetaTask(sqlDialectSQLite, dbTask)
+	c.Assert(err, IsNil)
+	c.Assert(task, Equals, dbTask)
+}
+
+func (s *testSQLiteSuite) TestWriteSQLite(c *C) {
+	if _, err := exec.LookPath(sqliteCommand); err != nil {
+		c.Skip("sqlite3 isn't installed")
+	}
+	dir := c.MkDir()
+	conf := defaultConfigForTest(c)
+	conf.OutputDirPath = dir
+	conf.FileType = FileFormatSQLiteString
+	conf.StatementSize = 10
+
+	o, err := newSQLiteOutput(conf)
+	c.Assert(err, IsNil)
+	defer o.close()
+	writer := (&testWriterSuite{}).newWriter(conf, c)
+	writer.sqlite = o
+
+	// the schemas are written by the dumper directly instead of the writers, so the tables exist before any data task
+	d := &Dumper{tctx: writer.tctx, conf: conf, sqlite: o}
+	c.Assert(d.sendMetaTaskToChan(NewTaskDatabaseMeta("test", "CREATE DATABASE `test`"), nil), IsNil)
+	c.Assert(d.sendMetaTaskToChan(NewTaskTableMeta("test", "t",
+		"CREATE TABLE `t` (\n  `id` int(11) NOT NULL,\n  `name` varchar(20) DEFAULT NULL,\n  `data` blob,\n  PRIMARY KEY (`id`)\n)"), nil), IsNil)
+
+	colTypes := []string{"INT", "VARCHAR", "BLOB"}
+	data := [][]driver.Value{
+		{"1", "it's", []byte{0x00, 0xff}},
+		{"2", "a\\b\n.print", nil},
+		{"3", nil, []byte("x")},
+	}
+	tableIR := newMockTableIR("test", "t", data, nil, colTypes)
+	c.Assert(writer.handleTask(NewTaskTableData(tableIR, tableIR, 0, 1)), IsNil)
+
+	// the text values containing NUL bytes would be cut by the line reader of sqlite3
+	tableIR = newMockTableIR("test", "t", [][]driver.Value{{"4", "a\x00b", nil}}, nil, colTypes)
+	c.Assert(writer.handleTask(NewTaskTableData(tableIR, tableIR, 0, 1)), ErrorMatches,
+		"a text value of table t contains a NUL byte, which can't be written into the SQLite database by sqlite3")
+	c.Assert(o.close(), IsNil)
+
+	out, err := exec.Command(sqliteCommand, path.Join(dir, sqliteFileName),
+		"SELECT id, quote(name), quote(data) FROM t ORDER BY id").CombinedOutput()
+	c.Assert(err, IsNil, Commentf("%s", out))
+	c.Assert(string(out), Equals, "1|'it''s'|X'00FF'\n2|'a\\b\n.print'|NULL\n3|NULL|X'78'\n")
+
+	// the database isn't overwritten
+	_, err = newSQLiteOutput(conf)
+	c.Assert(err, ErrorMatches, "the SQLite database .* already exists")
+}
+
+func (s *testSQLiteSuite) TestSQLiteFailure(c *C) {
+	if _, err := exec.LookPath(sqliteCommand); err != nil {
+		c.Skip("sqlite3 isn't installed")
+	}
+	conf := defaultConfigForTest(c)
+	conf.OutputDirPath = c.MkDir()
+	o, err := newSQLiteOutput(conf)
+	c.Assert(err, IsNil)
+	c.Assert(o.exec("CREATE TABLE t (a INTEGER);"), IsNil)
+	c.Assert(o.exec("INSERT INTO missing VALUES (1);"), ErrorMatches, "fail to write the SQLite database .*no such table: missing")
+	// sqlite3 exits at the first failure
+	c.Assert(o.exec("INSERT INTO t VALUES (1);"), ErrorMatches, "fail to write the SQLite database .*no such table: missing")
+	c.Assert(o.close(), ErrorMatches, "fail to write the SQLite database .*no such table: missing")
+}
@@ -0,0 +1,209 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package export
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/errors"
+)
+
+const (
+	// outputToStdout is the special output path which streams the dump to stdout
+	outputToStdout = "-"
+	// pipeCommandFileEnv is the environment variable which passes the output file name to the pipe command
+	pipeCommandFileEnv = "DUMPLING_OUTPUT_FILE"
+)
+
+// stdoutStorage is a write-only storage.ExternalStorage which concatenates all the files into one stream.
+// A file must be closed before the next one is created, so the contents of files never interleave.
+type stdoutStorage struct {
+	mu sync.Mutex
+	w  io.Writer
+	// opened is the name of the file being written, it's empty if no file is open
+	opened string
+}
+
+func newStdoutStorage(w io.Writer) *stdoutStorage {
+	return &stdoutStorage{w: w}
+}
+
+// WriteFile implements storage.ExternalStorage.WriteFile
+func (s *stdoutStorage) WriteFile(ctx context.Context, name string, data []byte) error {
+	w, err := s.Create(ctx, name)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(ctx, data)
+	if err1 := w.Close(ctx); err == nil {
+		err = err1
+	}
+	return err
+}
+
+// ReadFile implements storage.ExternalStorage.ReadFile
+func (s *stdoutStorage) ReadFile(_ context.Context, name string) ([]byte, error) {
+	return nil, errors.Errorf("can't read %s from stdout", name)
+}
+
+// FileExists implements storage.ExternalStorage.FileExists
+func (s *stdoutStorage) FileExists(_ context.Context, _ string) (bool, error) {
+	return false, nil
+}
+
+// Open implements storage.ExternalStorage.Open
+func (s *stdoutStorage) Open(_ context.Context, path string) (storage.ExternalFileReader, error) {
+	return nil, errors.Errorf("can't open %s from stdout", path)
+}
+
+// WalkDir implements storage.ExternalStorage.WalkDir
+func (s *stdoutStorage) WalkDir(_ context.Context, _ *storage.WalkOption, _ func(string, int64) error) error {
+	return errors.New("can't walk stdout")
+}
+
+// URI implements storage.ExternalStorage.URI
+func (s *stdoutStorage) URI() string {
+	return "stdout://"
+}
+
+// Create implements storage.ExternalStorage.Create. It fails if the last created file isn't closed,
+// the files are written one by one by a single writer, so the overlapping files are a bug rather than something to wait for
+func (s *stdoutStorage) Create(_ context.Context, name string) (storage.ExternalFileWriter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.opened != "" {
+		return nil, errors.Errorf("can't write %s to stdout before %s is closed", name, s.opened)
+	}
+	s.opened = name
+	return &stdoutFileWriter{s: s}, nil
+}
+
+type stdoutFileWriter struct {
+	s      *stdoutStorage
+	closed bool
+}
+
+// Write implements storage.ExternalFileWriter.Write
+func (w *stdoutFileWriter) Write(_ context.Context, p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("write to a closed file")
+	}
+	return w.s.w.Write(p)
+}
+
+// Close implements storage.ExternalFileWriter.Close
+func (w *stdoutFileWriter) Close(_ context.Context) error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	w.s.mu.Lock()
+	w.s.opened = ""
+	w.s.mu.Unlock()
+	return nil
+}
+
+// canRewriteFile returns false if the written content can't be taken back when retrying to dump a chunk.
+// The content streamed into a pipe command may be consumed already, like the rows applied by mysql
+func canRewriteFile(s storage.ExternalStorage) bool {
+	switch s.(type) {
+	case *stdoutStorage, *pipeCommandStorage:
+		return false
+	default:
+		return true
+	}
+}
+
+// pipeCommandStorage is a write-only storage.ExternalStorage which spawns a process for each file
+// and streams the file content into its stdin. The file name is passed by the DUMPLING_OUTPUT_FILE environment variable.
+type pipeCommandStorage struct {
+	command string
+}
+
+func newPipeCommandStorage(command string) *pipeCommandStorage {
+	return &pipeCommandStorage{command: command}
+}
+
+// WriteFile implements storage.ExternalStorage.WriteFile
+func (s *pipeCommandStorage) WriteFile(ctx context.Context, name string, data []byte) error {
+	w, err := s.Create(ctx, name)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(ctx, data)
+	if err1 := w.Close(ctx); err == nil {
+		err = err1
+	}
+	return err
+}
+
+// ReadFile implements storage.ExternalStorage.ReadFile
+func (s *pipeCommandStorage) ReadFile(_ context.Context, name string) ([]byte, error) {
+	return nil, errors.Errorf("can't read %s from pipe command", name)
+}
+
+// FileExists implements storage.ExternalStorage.FileExists
+func (s *pipeCommandStorage) FileExists(_ context.Context, _ string) (bool, error) {
+	return false, nil
+}
+
+// Open implements storage.ExternalStorage.Open
+func (s *pipeCommandStorage) Open(_ context.Context, path string) (storage.ExternalFileReader, error) {
+	return nil, errors.Errorf("can't open %s from pipe command", path)
+}
+
+// WalkDir implements storage.ExternalStorage.WalkDir
+func (s *pipeCommandStorage) WalkDir(_ context.Context, _ *storage.WalkOption, _ func(string, int64) error) error {
+	return errors.New("can't walk pipe command")
+}
+
+// URI implements storage.ExternalStorage.URI
+func (s *pipeCommandStorage) URI() string {
+	return "pipe://"
+}
+
+// Create implements storage.ExternalStorage.Create
+func (s *pipeCommandStorage) Create(_ context.Context, name string) (storage.ExternalFileWriter, error) {
+	// the process should outlive the context of the task, it is terminated by closing its stdin
+	cmd := exec.Command("sh", "-c", s.command) // #nosec G204
+	cmd.Env = append(os.Environ(), pipeCommandFileEnv+"="+name)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err = cmd.Start(); err != nil {
+		return nil, errors.Annotatef(err, "fail to start pipe command for %s", name)
+	}
+	return &pipeCommandFileWriter{name: name, cmd: cmd, stdin: stdin}, nil
+}
+
+type pipeCommandFileWriter struct {
+	name  string
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+}
+
+// Write implements storage.ExternalFileWriter.Write
+func (w *pipeCommandFileWriter) Write(_ context.Context, p []byte) (int, error) {
+	n, err := w.stdin.Write(p)
+	if err != nil {
+		return n, errors.Annotatef(err, "fail to write %s to pipe command", w.name)
+	}
+	return n, nil
+}
+
+// Close implements storage.ExternalFileWriter.Close. It waits for the process to exit
+func (w *pipeCommandFileWriter) Close(_ context.Context) error {
+	err := w.stdin.Close()
+	if err1 := w.cmd.Wait(); err1 != nil {
+		return errors.Annotatef(err1, "pipe command for %s failed", w.name)
+	}
+	return errors.Trace(err)
+}
@@ -0,0 +1,109 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package export
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"io/ioutil"
+	"path"
+	"time"
+
+	tcontext "github.com/pingcap/dumpling/v4/context"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/pingcap/br/pkg/storage"
+	. "github.com/pingcap/check"
+)
+
+var _ = Suite(&testStreamStorageSuite{})
+
+type testStreamStorageSuite struct{}
+
+func (s *testStreamStorageSuite) TestWriteToStdout(c *C) {
+	var out bytes.Buffer
+	config := defaultConfigForTest(c)
+	config.OutputDirPath = outputToStdout
+	config.ProducerThreads = 4
+	c.Assert(adjustOutputStream(config), IsNil)
+	c.Assert(config.Threads, Equals, 1)
+	c.Assert(config.ProducerThreads, Equals, 1)
+
+	db, _, err := sqlmock.New()
+	c.Assert(err, IsNil)
+	conn, err := db.Conn(context.Background())
+	c.Assert(err, IsNil)
+	extStore := newStdoutStorage(&out)
+	writer := NewWriter(tcontext.Background(), 0, config, conn, extStore)
+
+	c.Assert(writer.WriteDatabaseMeta("test", "CREATE DATABASE `test`"), IsNil)
+	c.Assert(writer.WriteTableMeta("test", "t", "CREATE TABLE t (a INT)"), IsNil)
+	c.Assert(out.String(), Equals, "/*!40101 SET NAMES binary*/;\nCREATE DATABASE `test`;\n"+
+		"/*!40101 SET NAMES binary*/;\nCREATE TABLE t (a INT);\n")
+	c.Assert(canRewriteFile(extStore), IsFalse)
+
+	// the next file can only be created after the last one is closed
+	ctx := context.Background()
+	w1, err := extStore.Create(ctx, "a")
+	c.Assert(err, IsNil)
+	_, err = extStore.Create(ctx, "b")
+	c.Assert(err, ErrorMatches, "can't write b to stdout before a is closed")
+	c.Assert(w1.Close(ctx), IsNil)
+	w2, err := extStore.Create(ctx, "b")
+	c.Assert(err, IsNil)
+	c.Assert(w2.Close(ctx), IsNil)
+
+	_, err = extStore.ReadFile(ctx, "a")
+	c.Assert(err, NotNil)
+}
+
+func (s *testStreamStorageSuite) TestPipeCommand(c *C) {
+	dir := c.MkDir()
+	ctx := context.Background()
+	extStore := newPipeCommandStorage(`cat > "` + dir + `/$` + pipeCommandFileEnv + `"`)
+	c.Assert(canRewriteFile(extStore), IsFalse)
+
+	fileWriter, tearDown, err := buildFileWriter(tcontext.Background(), extStore, "test.t.000000000.sql", storage.NoCompression)
+	c.Assert(err, IsNil)
+	c.Assert(write(tcontext.Background(), fileWriter, "INSERT INTO `t` VALUES (1);\n"), IsNil)
+	c.Assert(tearDown(ctx), IsNil)
+	content, err := ioutil.ReadFile(path.Join(dir, "test.t.000000000.sql"))
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "INSERT INTO `t` VALUES (1);\n")
+
+	c.Assert(extStore.WriteFile(ctx, "metadata", []byte("Started dump at: 2021-01-01 00:00:00\n")), IsNil)
+	content, err = ioutil.ReadFile(path.Join(dir, "metadata"))
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "Started dump at: 2021-01-01 00:00:00\n")
+
+	failedStore := newPipeCommandStorage("cat > /dev/null; exit 3")
+	w, err := failedStore.Create(ctx, "a.sql")
+	c.Assert(err, IsNil)
+	_, err = w.Write(ctx, []byte("abc"))
+	c.Assert(err, IsNil)
+	c.Assert(w.Close(ctx), ErrorMatches, "pipe command for a.sql failed.*exit status 3")
+}
+
+func (s *testStreamStorageSuite) TestPipeCommandNotRetried(c *C) {
+	dir := c.MkDir()
+	config := defaultConfigForTest(c)
+	config.WriteRetryErrors = []string{writeRetryErrorAll}
+	config.WriteRetryBackoff = time.Millisecond
+	config.WriteRetryMaxBackoff = time.Millisecond
+
+	db, _, err := sqlmock.New()
+	c.Assert(err, IsNil)
+	conn, err := db.Conn(context.Background())
+	c.Assert(err, IsNil)
+	// each run of the command is counted, the consumer may have applied the data before failing
+	extStore := newPipeCommandStorage(`echo run >> "` + dir + `/runs"; cat > /dev/null; exit 3`)
+	writer := NewWriter(tcontext.Background(), 0, config, conn, extStore)
+
+	data := [][]driver.Value{{"1"}, {"2"}}
+	tableIR := &restartableTableIR{newMockTableIR("test", "t", data, nil, []string{"INT"})}
+	c.Assert(writer.WriteTableData(tableIR, tableIR, 0), ErrorMatches, ".*exit status 3.*")
+	runs, err := ioutil.ReadFile(path.Join(dir, "runs"))
+	c.Assert(err, IsNil)
+	c.Assert(string(runs), Equals, "run\n")
+}
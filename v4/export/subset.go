@@ -0,0 +1,282 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package export
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/pingcap/errors"
+	filter "github.com/pingcap/tidb-tools/pkg/table-filter"
+	"go.uber.org/zap"
+)
+
+// subsetKeysPerQuery is the max number of keys in the IN list of a query in a subset dump
+const subsetKeysPerQuery = 1000
+
+// subsetRootRule is a rule of --subset-root like `db.orders:created_at > '2021-01-01'`
+type subsetRootRule struct {
+	tables    filter.Filter
+	condition string
+}
+
+// SubsetRoots is the root tables of a subset dump and the conditions of their rows, parsed from --subset-root
+type SubsetRoots struct {
+	rules []subsetRootRule
+}
+
+// ParseSubsetRoots parses the rules of --subset-root, each rule is `<table filter pattern>:<condition>`.
+// All the rows of the matched tables are roots if the condition is omitted
+func ParseSubsetRoots(rules []string, caseSensitive bool) (*SubsetRoots, error) {
+	r := &SubsetRoots{}
+	for _, rule := range rules {
+		pattern, condition := rule, ""
+		if i := strings.Index(rule, ":"); i >= 0 {
+			pattern, condition = rule[:i], strings.TrimSpace(rule[i+1:])
+		}
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			return nil, errors.Errorf("failed to parse --subset-root '%s': the rule should be like 'db.tbl:condition'", rule)
+		}
+		f, err := filter.Parse([]string{pattern})
+		if err != nil {
+			return nil, errors.Errorf("failed to parse --subset-root '%s': %s", rule, err)
+		}
+		if !caseSensitive {
+			f = filter.CaseInsensitive(f)
+		}
+		r.rules = append(r.rules, subsetRootRule{tables: f, condition: condition})
+	}
+	return r, nil
+}
+
+func (r *SubsetRoots) hasAnyRule() bool {
+	return r != nil && len(r.rules) > 0
+}
+
+// Condition returns the condition of the rows of the root table, ok is false if the table isn't a root table.
+// Like the table filter, the last matching rule wins
+func (r *SubsetRoots) Condition(db, table string) (condition string, ok bool) {
+	if r == nil {
+		return "", false
+	}
+	for i := len(r.rules) - 1; i >= 0; i-- {
+		if r.rules[i].tables.MatchTable(db, table) {
+			return r.rules[i].condition, true
+		}
+	}
+	return "", false
+}
+
+// subsetTable is the rows of a table included in a subset dump, which are identified by the primary key
+type subsetTable struct {
+	key       tableKey
+	pkColumns []string
+	pkTypes   []string
+	// keys are the row constructors of the primary key of the included rows, like "(1,'a')"
+	keys    map[string]struct{}
+	ordered []string
+	// expanded is the number of keys whose parent and child rows have been looked up
+	expanded int
+}
+
+// tableSubset is the rows of tables included in a subset dump
+type tableSubset struct {
+	tables map[tableKey]*subsetTable
+	// order is the order of tables in which the rows are first included
+	order []*subsetTable
+	// parents are the foreign keys of the child tables, children are the foreign keys referencing the parent tables
+	parents  map[tableKey][]*foreignKey
+	children map[tableKey][]*foreignKey
+}
+
+func newTableSubset(fks []*foreignKey) *tableSubset {
+	s := &tableSubset{
+		tables:   make(map[tableKey]*subsetTable),
+		parents:  make(map[tableKey][]*foreignKey),
+		children: make(map[tableKey][]*foreignKey),
+	}
+	for _, fk := range fks {
+		s.parents[fk.child] = append(s.parents[fk.child], fk)
+		s.children[fk.parent] = append(s.children[fk.parent], fk)
+	}
+	return s
+}
+
+// subsetTable returns the subset of the table, whose primary key is queried on the first call
+func (d *Dumper) subsetTable(conn *sql.Conn, s *tableSubset, key tableKey) (*subsetTable, error) {
+	if t, ok := s.tables[key]; ok {
+		return t, nil
+	}
+	pkColumns, pkTypes, err := d.getPrimaryKeyAndColumnTypes(conn, key.db, key.table)
+	if err != nil {
+		return nil, err
+	}
+	if len(pkColumns) == 0 {
+		return nil, errors.Errorf("subset dump requires the primary key of table %s to identify its rows", key)
+	}
+	t := &subsetTable{key: key, pkColumns: pkColumns, pkTypes: pkTypes, keys: make(map[string]struct{})}
+	s.tables[key] = t
+	s.order = append(s.order, t)
+	return t, nil
+}
+
+// selectSubsetKeys includes the rows of the table matching the condition
+func (d *Dumper) selectSubsetKeys(conn *sql.Conn, t *subsetTable, where string) error {
+	query := buildSelectQuery(t.key.db, t.key.table, joinColumns(t.pkColumns),
+		buildWhereCondition(where, ""), buildOrderByClauseString(t.pkColumns))
+	rows, err := conn.QueryContext(d.tctx, query)
+	if err != nil {
+		return errors.Annotatef(err, "sql: %s", query)
+	}
+	iter := newRowIter(rows, len(t.pkColumns))
+	defer iter.Close()
+	rowRec := MakeRowReceiver(t.pkTypes)
+	buf := new(bytes.Buffer)
+	for iter.HasNext() {
+		if err = iter.Decode(rowRec); err != nil {
+			return errors.Annotatef(err, "sql: %s", query)
+		}
+		rowRec.WriteToBuffer(buf, true)
+		if _, ok := t.keys[buf.String()]; !ok {
+			t.keys[buf.String()] = struct{}{}
+			t.ordered = append(t.ordered, buf.String())
+		}
+		buf.Reset()
+		iter.Next()
+	}
+	return errors.Annotatef(iter.Error(), "sql: %s", query)
+}
+
+// keysCondition returns the condition of the rows with the keys
+func (t *subsetTable) keysCondition(keys []string) string {
+	return fmt.Sprintf("%s IN (%s)", quoteColumns(t.pkColumns), strings.Join(keys, ","))
+}
+
+// relatedRowsCondition returns the condition of the rows in the target table whose columns match the columns of the source rows with the keys
+func relatedRowsCondition(columns []string, source *subsetTable, sourceColumns []string, keys []string) string {
+	return fmt.Sprintf("%s IN (SELECT %s FROM %s WHERE %s)", quoteColumns(columns),
+		joinColumns(sourceColumns), source.key, source.keysCondition(keys))
+}
+
+// buildSubset collects the keys of the rows in a subset dump on conn, which shares the same snapshot with the dumping connections
+// unless the dump is run with --consistency none, where the rows changed during the dump may break the references of the subset.
+// It starts from the rows of the root tables matching the conditions, then includes the parent rows referenced by the included rows
+// transitively, and the child rows referencing the included rows with --subset-children by fks, until no more row is included
+func (d *Dumper) buildSubset(conn *sql.Conn, fks []*foreignKey) (*tableSubset, error) {
+	conf := d.conf
+	s := newTableSubset(fks)
+	for _, t := range orderTablesToDump(conf, nil) {
+		if t.table == nil || t.table.Type != TableTypeBase {
+			continue
+		}
+		condition, ok := conf.SubsetRoots.Condition(t.db, t.table.Name)
+		if !ok {
+			continue
+		}
+		root, err := d.subsetTable(conn, s, tableKey{db: t.db, table: t.table.Name})
+		if err != nil {
+			return nil, err
+		}
+		if err = d.selectSubsetKeys(conn, root, condition); err != nil {
+			return nil, err
+		}
+	}
+
+	for expanded := true; expanded; {
+		expanded = false
+		// the tables may be appended to s.order during the iteration
+		for i := 0; i < len(s.order); i++ {
+			t := s.order[i]
+			for t.expanded < len(t.ordered) {
+				end := t.expanded + subsetKeysPerQuery
+				if end > len(t.ordered) {
+					end = len(t.ordered)
+				}
+				keys := t.ordered[t.expanded:end]
+				t.expanded, expanded = end, true
+				for _, fk := range s.parents[t.key] {
+					parent, err := d.subsetTable(conn, s, fk.parent)
+					if err != nil {
+						return nil, err
+					}
+					if err = d.selectSubsetKeys(conn, parent, relatedRowsCondition(fk.refColumns, t, fk.columns, keys)); err != nil {
+						return nil, err
+					}
+				}
+				if !conf.SubsetChildren {
+					continue
+				}
+				for _, fk := range s.children[t.key] {
+					child, err := d.subsetTable(conn, s, fk.child)
+					if err != nil {
+						return nil, err
+					}
+					if err = d.selectSubsetKeys(conn, child, relatedRowsCondition(fk.columns, t, fk.refColumns, keys)); err != nil {
+						return nil, err
+					}
+				}
+			}
+		}
+	}
+	for _, t := range s.order {
+		d.L().Info("collect rows of table in subset", zap.String("database", t.key.db), zap.String("table", t.key.table),
+			zap.Int("rows", len(t.ordered)))
+	}
+	return s, nil
+}
+
+// dumpSubsetTable dumps the rows of the table included in the subset by the queries of the keys, the other rows aren't dumped
+func (d *Dumper) dumpSubsetTable(conn *sql.Conn, meta TableMeta, taskChan chan<- Task) error {
+	db, tbl := meta.DatabaseName(), meta.TableName()
+	t, ok := d.subset.tables[tableKey{db: db, table: tbl}]
+	if !ok || len(t.ordered) == 0 {
+		d.L().Info("no rows of table in subset", zap.String("database", db), zap.String("table", tbl))
+		return nil
+	}
+	selectField, selectLen, err := d.buildSelectField(conn, db, tbl)
+	if err != nil {
+		return err
+	}
+	orderByClause := buildOrderByClauseString(t.pkColumns)
+	totalChunks := (len(t.ordered) + subsetKeysPerQuery - 1) / subsetKeysPerQuery
+	for i := 0; i < totalChunks; i++ {
+		end := (i + 1) * subsetKeysPerQuery
+		if end > len(t.ordered) {
+			end = len(t.ordered)
+		}
+		where := t.keysCondition(t.ordered[i*subsetKeysPerQuery : end])
+		query := buildSelectQuery(db, tbl, selectField, buildWhereCondition(where, ""), orderByClause)
+		task := NewTaskTableData(meta, newTableData(query, selectLen, false), i, totalChunks)
+		if ctxDone := d.sendTaskToChan(task, taskChan); ctxDone {
+			break
+		}
+	}
+	return nil
+}
+
+func validateSubsetDump(conf *Config) error {
+	if !conf.SubsetRoots.hasAnyRule() {
+		if conf.SubsetChildren {
+			return errors.New("--subset-children must be used together with --subset-root")
+		}
+		return nil
+	}
+	switch {
+	case conf.Where != "":
+		return errors.New("can't specify both --where and --subset-root at the same time. Please put the conditions into --subset-root")
+	case conf.SQL != "":
+		return errors.New("can't specify both --sql and --subset-root at the same time")
+	case conf.sampling():
+		return errors.New("can't specify --subset-root with --sample-percent or --sample-rows at the same time")
+	case conf.WatermarkColumns.hasAnyRule():
+		return errors.New("can't specify both --watermark-column and --subset-root at the same time")
+	case conf.SplitPartitions || conf.PartitionFilter.hasAnyRule():
+		return errors.New("can't dump a subset of tables by partitions, please remove --split-partitions and the partition rules of --filter")
+	case conf.NoLoadOrder:
+		return errors.New("can't specify both --no-load-order and --subset-root at the same time, the subset is collected by the foreign keys")
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package export
+
+import (
+	"context"
+	"regexp"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	. "github.com/pingcap/check"
+)
+
+var _ = Suite(&testSubsetSuite{})
+
+type testSubsetSuite struct{}
+
+func (s *testSubsetSuite) TestParseSubsetRoots(c *C) {
+	r, err := ParseSubsetRoots([]string{"db.orders:created_at > '2021-01-01 00:00:00'", "db.users", "logs.*: id < 10 "}, false)
+	c.Assert(err, IsNil)
+	c.Assert(r.hasAnyRule(), IsTrue)
+	cond, ok := r.Condition("DB", "Orders")
+	c.Assert(ok, IsTrue)
+	c.Assert(cond, Equals, "created_at > '2021-01-01 00:00:00'")
+	cond, ok = r.Condition("db", "users")
+	c.Assert(ok, IsTrue)
+	c.Assert(cond, Equals, "")
+	cond, ok = r.Condition("logs", "t")
+	c.Assert(ok, IsTrue)
+	c.Assert(cond, Equals, "id < 10")
+	_, ok = r.Condition("db", "items")
+	c.Assert(ok, IsFalse)
+
+	for _, rule := range []string{":id > 0", "db.[:id > 0"} {
+		_, err = ParseSubsetRoots([]string{rule}, false)
+		c.Assert(err, ErrorMatches, "failed to parse --subset-root.*", Commentf("rule %s", rule))
+	}
+	r = nil
+	_, ok = r.Condition("db", "users")
+	c.Assert(ok, IsFalse)
+}
+
+func expectForeignKeys(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery("SELECT k.CONSTRAINT_SCHEMA,k.TABLE_NAME,k.CONSTRAINT_NAME,k.COLUMN_NAME").
+		WillReturnRows(sqlmock.NewRows([]string{"CONSTRAINT_SCHEMA", "TABLE_NAME", "CONSTRAINT_NAME", "COLUMN_NAME",
+			"REFERENCED_TABLE_SCHEMA", "REFERENCED_TABLE_NAME", "REFERENCED_COLUMN_NAME"}).
+			AddRow("test", "items", "fk_order", "order_id", "test", "orders", "id").
+			AddRow("test", "items", "fk_other", "other_id", "other", "t", "id").
+			AddRow("test", "orders", "fk_user", "user_id", "test", "users", "id"))
+}
+
+func (s *testSubsetSuite) TestSelectForeignKeys(c *C) {
+	db, mock, err := sqlmock.New()
+	c.Assert(err, IsNil)
+	defer db.Close()
+	conn, err := db.Conn(context.Background())
+	c.Assert(err, IsNil)
+
+	// only the dumped databases are queried
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT k.CONSTRAINT_SCHEMA,k.TABLE_NAME,k.CONSTRAINT_NAME,k.COLUMN_NAME")+
+		".*"+regexp.QuoteMeta("WHERE k.CONSTRAINT_SCHEMA IN (?,?)")).
+		WithArgs("other", "test").
+		WillReturnRows(sqlmock.NewRows([]string{"CONSTRAINT_SCHEMA", "TABLE_NAME", "CONSTRAINT_NAME", "COLUMN_NAME",
+			"REFERENCED_TABLE_SCHEMA", "REFERENCED_TABLE_NAME", "REFERENCED_COLUMN_NAME"}).
+			AddRow("test", "items", "fk_order", "order_id", "test", "orders", "id").
+			AddRow("test", "items", "fk_order", "order_ver", "test", "orders", "ver").
+			AddRow("test", "items", "fk_other", "other_id", "other", "t", "id").
+			AddRow("test", "v", "fk_view", "order_id", "test", "orders", "id").
+			AddRow("test", "orders", "fk_user", "user_id", "test", "users", "id"))
+	tables := NewDatabaseTables().
+		AppendTables("test", "items", "orders", "users").
+		AppendViews("test", "v").
+		AppendTables("other", "logs")
+	fks, err := SelectForeignKeys(conn, tables)
+	c.Assert(err, IsNil)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+	c.Assert(fks, DeepEquals, []*foreignKey{
+		{
+			name: "fk_order", child: tableKey{db: "test", table: "items"}, columns: []string{"order_id", "order_ver"},
+			parent: tableKey{db: "test", table: "orders"}, refColumns: []string{"id", "ver"},
+		},
+		{
+			name: "fk_user", child: tableKey{db: "test", table: "orders"}, columns: []string{"user_id"},
+			parent: tableKey{db: "test", table: "users"}, refColumns: []string{"id"},
+		},
+	})
+}
+
+func (s *testSubsetSuite) TestBuildSubset(c *C) {
+	db, mock, err := sqlmock.New()
+	c.Assert(err, IsNil)
+	defer db.Close()
+	conn, err := db.Conn(context.Background())
+	c.Assert(err, IsNil)
+
+	d := newDumperForSchemaTest(c)
+	d.conf.Tables = NewDatabaseTables().AppendTables("test", "items", "orders", "users")
+	d.conf.SubsetRoots, err = ParseSubsetRoots([]string{"test.orders:id < 3"}, false)
+	c.Assert(err, IsNil)
+	d.conf.SubsetChildren = true
+	cacheTestSchemas(d, "test", "items", "orders", "users")
+
+	expectKeys := func(query string, keys ...string) {
+		rows := sqlmock.NewRows([]string{"id"})
+		for _, k := range keys {
+			rows.AddRow(k)
+		}
+		mock.ExpectQuery("^" + regexp.QuoteMeta(query) + "$").WillReturnRows(rows)
+	}
+	expectForeignKeys(mock)
+	fks, err := SelectForeignKeys(conn, d.conf.Tables)
+	c.Assert(err, IsNil)
+	expectKeys("SELECT `id` FROM `test`.`orders`  WHERE id < 3 ORDER BY `id`", "1", "2")
+	expectKeys("SELECT `id` FROM `test`.`users`  WHERE (`id`) IN (SELECT `user_id` FROM `test`.`orders` WHERE (`id`) IN ((1),(2))) ORDER BY `id`", "7")
+	expectKeys("SELECT `id` FROM `test`.`items`  WHERE (`order_id`) IN (SELECT `id` FROM `test`.`orders` WHERE (`id`) IN ((1),(2))) ORDER BY `id`", "10", "11")
+	// the other orders of the included users are included as the children
+	expectKeys("SELECT `id` FROM `test`.`orders`  WHERE (`user_id`) IN (SELECT `id` FROM `test`.`users` WHERE (`id`) IN ((7))) ORDER BY `id`", "1", "2", "5")
+	expectKeys("SELECT `id` FROM `test`.`orders`  WHERE (`id`) IN (SELECT `order_id` FROM `test`.`items` WHERE (`id`) IN ((10),(11))) ORDER BY `id`", "1", "2")
+	expectKeys("SELECT `id` FROM `test`.`users`  WHERE (`id`) IN (SELECT `user_id` FROM `test`.`orders` WHERE (`id`) IN ((5))) ORDER BY `id`", "7")
+	expectKeys("SELECT `id` FROM `test`.`items`  WHERE (`order_id`) IN (SELECT `id` FROM `test`.`orders` WHERE (`id`) IN ((5))) ORDER BY `id`", "12")
+	expectKeys("SELECT `id` FROM `test`.`orders`  WHERE (`id`) IN (SELECT `order_id` FROM `test`.`items` WHERE (`id`) IN ((12))) ORDER BY `id`", "5")
+
+	d.subset, err = d.buildSubset(conn, fks)
+	c.Assert(err, IsNil)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+	c.Assert(d.subset.tables[tableKey{db: "test", table: "orders"}].ordered, DeepEquals, []string{"(1)", "(2)", "(5)"})
+	c.Assert(d.subset.tables[tableKey{db: "test", table: "users"}].ordered, DeepEquals, []string{"(7)"})
+	c.Assert(d.subset.tables[tableKey{db: "test", table: "items"}].ordered, DeepEquals, []string{"(10)", "(11)", "(12)"})
+
+	taskChan := make(chan Task, 1)
+	c.Assert(d.dumpTableData(conn, &tableMeta{database: "test", table: "orders"}, taskChan), IsNil)
+	c.Assert((<-taskChan).(*TaskTableData).Data.(*tableData).query, Equals,
+		"SELECT * FROM `test`.`orders`  WHERE (`id`) IN ((1),(2),(5)) ORDER BY `id`")
+	// the table out of the subset has no data to dump
+	d.subset.tables[tableKey{db: "test", table: "items"}].ordered = nil
+	c.Assert(d.dumpTableData(conn, &tableMeta{database: "test", table: "items"}, taskChan), IsNil)
+	c.Assert(taskChan, HasLen, 0)
+
+	// only the parents are included without --subset-children
+	d.conf.SubsetChildren = false
+	expectKeys("SELECT `id` FROM `test`.`orders`  WHERE id < 3 ORDER BY `id`", "1", "2")
+	expectKeys("SELECT `id` FROM `test`.`users`  WHERE (`id`) IN (SELECT `user_id` FROM `test`.`orders` WHERE (`id`) IN ((1),(2))) ORDER BY `id`", "7")
+	subset, err := d.buildSubset(conn, fks)
+	c.Assert(err, IsNil)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+	c.Assert(subset.order, HasLen, 2)
+}
+
+func (s *testSubsetSuite) TestValidateSubsetDump(c *C) {
+	conf := DefaultConfig()
+	c.Assert(validateSubsetDump(conf), IsNil)
+	conf.SubsetChildren = true
+	c.Assert(validateSubsetDump(conf), ErrorMatches, "--subset-children must be used together with --subset-root")
+
+	var err error
+	conf.SubsetRoots, err = ParseSubsetRoots([]string{"db.orders:id < 10"}, false)
+	c.Assert(err, IsNil)
+	c.Assert(validateSubsetDump(conf), IsNil)
+	conf.Where = "id < 10"
+	c.Assert(validateSubsetDump(conf), ErrorMatches, "can't specify both --where and --subset-root at the same time.*")
+	conf.Where = ""
+	conf.SamplePercent = 10
+	c.Assert(validateSubsetDump(conf), ErrorMatches, "can't specify --subset-root with --sample-percent or --sample-rows at the same time")
+	conf.SamplePercent = 0
+	conf.NoLoadOrder = true
+	c.Assert(validateSubsetDump(conf), ErrorMatches, "can't specify both --no-load-order and --subset-root at the same time.*")
+}
@@ -0,0 +1,808 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package export
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/parser"
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/mysql"
+	_ "github.com/pingcap/tidb/types/parser_driver" // for parser driver
+)
+
+const (
+	// VerifyLevelError marks a problem which makes the dump unusable
+	VerifyLevelError = "error"
+	// VerifyLevelWarning marks a suspicious but possibly valid state of the dump
+	VerifyLevelWarning = "warning"
+
+	// checksumManifestPath is the optional manifest in `sha256sum` format, one `<hex digest>  <file>` per line
+	checksumManifestPath = "manifest.sha256"
+
+	verifyDBPlaceholder    = "DUMPLINGVERIFYDB"
+	verifyTablePlaceholder = "DUMPLINGVERIFYTABLE"
+	// verifyPartitionPlaceholder is only rendered in the names of data files
+	verifyPartitionPlaceholder = "DUMPLINGVERIFYPARTITION"
+	verifyIndexPlaceholder     = 918273645
+)
+
+// VerifyProblem is a single problem found while verifying a dump
+type VerifyProblem struct {
+	Level   string `json:"level"`
+	File    string `json:"file,omitempty"`
+	Message string `json:"message"`
+}
+
+// VerifyTableReport summarizes the files found for one dumped table or view
+type VerifyTableReport struct {
+	Database  string   `json:"database"`
+	Table     string   `json:"table"`
+	IsView    bool     `json:"is_view,omitempty"`
+	Columns   int      `json:"columns"`
+	DataFiles []string `json:"data_files,omitempty"`
+	Rows      uint64   `json:"rows"`
+}
+
+// VerifyReport is the result of verifying a dumped directory
+type VerifyReport struct {
+	Location     string               `json:"location"`
+	CheckedFiles int                  `json:"checked_files"`
+	Tables       []*VerifyTableReport `json:"tables"`
+	Problems     []*VerifyProblem     `json:"problems"`
+}
+
+// HasErrors returns true if any problem of level error is found
+func (r *VerifyReport) HasErrors() bool {
+	for _, p := range r.Problems {
+		if p.Level == VerifyLevelError {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *VerifyReport) addProblem(level, file, format string, args ...interface{}) {
+	r.Problems = append(r.Problems, &VerifyProblem{
+		Level:   level,
+		File:    file,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// VerifyDump validates a dumped directory offline. It checks that
+//  1. every table in the schema files has data files matching the output filename template
+//  2. every SQL file parses, unless the dump is in the postgres dialect recorded in metadata
+//  3. CSV column counts match the header and the schema
+//  4. gzip streams are complete
+//  5. checksums match the manifest if one exists
+//
+// Problems of the dump itself are recorded in the returned report, the error is only
+// returned when the verification can't be done at all.
+func VerifyDump(ctx context.Context, conf *Config) (*VerifyReport, error) {
+	b, err := storage.ParseBackend(conf.OutputDirPath, &conf.BackendOptions)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	s, err := storage.Create(ctx, b, false)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	matcher, err := newDumpFileMatcher(conf.OutputFileTemplate)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	v := &dumpVerifier{
+		conf:    conf,
+		s:       s,
+		matcher: matcher,
+		tables:  make(map[string]*VerifyTableReport),
+		report:  &VerifyReport{Location: s.URI()},
+	}
+	if err = v.run(ctx); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return v.report, nil
+}
+
+type dumpFileKind int
+
+const (
+	dumpFileUnknown dumpFileKind = iota
+	dumpFileSchema
+	dumpFileTable
+	dumpFileView
+	dumpFileData
+)
+
+type dumpFile struct {
+	path       string
+	kind       dumpFileKind
+	db         string
+	table      string
+	fileType   string
+	compressed bool
+}
+
+type dumpVerifier struct {
+	conf     *Config
+	s        storage.ExternalStorage
+	matcher  *dumpFileMatcher
+	manifest map[string]string
+	tables   map[string]*VerifyTableReport
+	report   *VerifyReport
+	// dialect is the SQL dialect of the dump recorded in metadata
+	dialect string
+}
+
+func (v *dumpVerifier) run(ctx context.Context) error {
+	var paths []string
+	err := v.s.WalkDir(ctx, &storage.WalkOption{}, func(path string, _ int64) error {
+		paths = append(paths, path)
+		return nil
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	sort.Strings(paths)
+
+	exists := make(map[string]struct{}, len(paths))
+	for _, path := range paths {
+		exists[path] = struct{}{}
+	}
+	v.dialect = sqlDialectMySQL
+	if _, ok := exists[metadataPath]; !ok {
+		v.report.addProblem(VerifyLevelError, metadataPath, "metadata file is missing, the dump may not be finished")
+	} else {
+		metadata, err := v.s.ReadFile(ctx, metadataPath)
+		if err != nil {
+			return errors.Annotatef(err, "fail to read %s", metadataPath)
+		}
+		v.dialect = parseSQLDialect(metadata)
+	}
+	if v.dialect != sqlDialectMySQL {
+		v.report.addProblem(VerifyLevelWarning, metadataPath,
+			"the SQL files are in %s dialect, which can't be parsed, only their compression and checksums are checked", v.dialect)
+	}
+	if _, ok := exists[checksumManifestPath]; ok {
+		if err = v.loadManifest(ctx); err != nil {
+			return err
+		}
+		missing := make([]string, 0)
+		for name := range v.manifest {
+			if _, ok := exists[name]; !ok {
+				missing = append(missing, name)
+			}
+		}
+		sort.Strings(missing)
+		for _, name := range missing {
+			v.report.addProblem(VerifyLevelError, name, "file listed in %s is missing", checksumManifestPath)
+		}
+	}
+
+	// table schemas are checked first so that we know the column count before checking data files
+	files := make([]*dumpFile, 0, len(paths))
+	for _, path := range paths {
+		if path == metadataPath || path == checksumManifestPath {
+			continue
+		}
+		if strings.HasSuffix(path, tempFileSuffix) {
+			v.report.addProblem(VerifyLevelError, path, "file is not completely written")
+			continue
+		}
+		f := v.matcher.classify(path)
+		if f.kind == dumpFileUnknown {
+			if _, ok := v.manifest[path]; !ok {
+				continue
+			}
+		}
+		files = append(files, f)
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].kind < files[j].kind
+	})
+	for _, f := range files {
+		if err = v.verifyFile(ctx, f); err != nil {
+			return err
+		}
+	}
+
+	keys := make([]string, 0, len(v.tables))
+	for key := range v.tables {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		t := v.tables[key]
+		v.report.Tables = append(v.report.Tables, t)
+		if !t.IsView && len(t.DataFiles) == 0 {
+			v.report.addProblem(VerifyLevelWarning, "", "no data file of table `%s`.`%s` is found, it's expected only if the table is empty", t.Database, t.Table)
+		}
+	}
+	return nil
+}
+
+func (v *dumpVerifier) loadManifest(ctx context.Context) error {
+	content, err := v.s.ReadFile(ctx, checksumManifestPath)
+	if err != nil {
+		return errors.Annotatef(err, "fail to read %s", checksumManifestPath)
+	}
+	v.manifest = make(map[string]string)
+	for i, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			v.report.addProblem(VerifyLevelError, checksumManifestPath, "line %d is malformed: %s", i+1, line)
+			continue
+		}
+		// sha256sum marks binary mode with a leading '*'
+		v.manifest[strings.TrimPrefix(fields[1], "*")] = strings.ToLower(fields[0])
+	}
+	return nil
+}
+
+func (v *dumpVerifier) table(f *dumpFile) *VerifyTableReport {
+	key := f.db + "." + f.table
+	t, ok := v.tables[key]
+	if !ok {
+		t = &VerifyTableReport{Database: f.db, Table: f.table, Columns: -1}
+		v.tables[key] = t
+	}
+	return t
+}
+
+func (v *dumpVerifier) verifyFile(ctx context.Context, f *dumpFile) error {
+	v.report.CheckedFiles++
+	raw, err := v.s.Open(ctx, f.path)
+	if err != nil {
+		return errors.Annotatef(err, "fail to open %s", f.path)
+	}
+	defer raw.Close()
+
+	var h hash.Hash
+	var r io.Reader = raw
+	expectedSum, inManifest := v.manifest[f.path]
+	if inManifest {
+		h = sha256.New()
+		r = io.TeeReader(r, h)
+	}
+	if f.compressed {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			v.report.addProblem(VerifyLevelError, f.path, "invalid gzip stream: %s", err)
+			return v.checkSum(f, raw, h, expectedSum, inManifest)
+		}
+		defer zr.Close()
+		r = zr
+	}
+	vr := &verifyReader{r: r}
+
+	switch {
+	case f.kind == dumpFileUnknown:
+	case f.fileType == FileFormatCSVString:
+		v.verifyCSV(f, vr)
+	case v.dialect != sqlDialectMySQL:
+		v.sqlFileTable(f)
+	default:
+		v.verifySQL(f, vr)
+	}
+	if vr.err == nil {
+		// make sure the whole stream is read so that the gzip trailer is checked
+		_, _ = io.Copy(ioutil.Discard, vr)
+	}
+	if vr.err != nil {
+		if f.compressed && (vr.err == io.ErrUnexpectedEOF || vr.err == gzip.ErrChecksum || vr.err == gzip.ErrHeader) {
+			v.report.addProblem(VerifyLevelError, f.path, "gzip stream is incomplete or corrupted: %s", vr.err)
+		} else {
+			v.report.addProblem(VerifyLevelError, f.path, "fail to read file: %s", vr.err)
+		}
+	}
+	return v.checkSum(f, raw, h, expectedSum, inManifest)
+}
+
+func (v *dumpVerifier) checkSum(f *dumpFile, raw io.Reader, h hash.Hash, expectedSum string, inManifest bool) error {
+	if v.manifest == nil {
+		return nil
+	}
+	if !inManifest {
+		v.report.addProblem(VerifyLevelWarning, f.path, "file is not listed in %s", checksumManifestPath)
+		return nil
+	}
+	if _, err := io.Copy(h, raw); err != nil {
+		return errors.Annotatef(err, "fail to read %s", f.path)
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != expectedSum {
+		v.report.addProblem(VerifyLevelError, f.path, "checksum mismatch, expected %s but got %s", expectedSum, sum)
+	}
+	return nil
+}
+
+// sqlFileTable returns the table of the SQL file, or nil if the file isn't about a table
+func (v *dumpVerifier) sqlFileTable(f *dumpFile) *VerifyTableReport {
+	var t *VerifyTableReport
+	switch f.kind {
+	case dumpFileTable, dumpFileData:
+		t = v.table(f)
+	case dumpFileView:
+		t = v.table(f)
+		t.IsView = true
+	}
+	if f.kind == dumpFileData {
+		t.DataFiles = append(t.DataFiles, f.path)
+	}
+	return t
+}
+
+func (v *dumpVerifier) verifySQL(f *dumpFile, r *verifyReader) {
+	p := parser.New()
+	if !v.conf.EscapeBackslash {
+		p.SetSQLMode(mysql.ModeNoBackslashEscapes)
+	}
+	t := v.sqlFileTable(f)
+
+	splitter := newSQLStatementSplitter(r, v.conf.EscapeBackslash)
+	for i := 1; ; i++ {
+		stmt, err := splitter.next()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			// errors of reading the file itself are reported by the caller
+			if r.err == nil {
+				v.report.addProblem(VerifyLevelError, f.path, "statement %d is incomplete: %s", i, err)
+			}
+			return
+		}
+		stmts, _, err := p.Parse(stmt, "", "")
+		if err != nil {
+			v.report.addProblem(VerifyLevelError, f.path, "statement %d can't be parsed: %s", i, err)
+			return
+		}
+		for _, node := range stmts {
+			switch n := node.(type) {
+			case *ast.CreateTableStmt:
+				if f.kind == dumpFileTable {
+					t.Columns = countNonGeneratedColumns(n)
+				}
+			case *ast.InsertStmt:
+				if t != nil {
+					t.Rows += uint64(len(n.Lists))
+				}
+			}
+		}
+	}
+}
+
+func (v *dumpVerifier) verifyCSV(f *dumpFile, r *verifyReader) {
+	t := v.table(f)
+	t.DataFiles = append(t.DataFiles, f.path)
+	expected := t.Columns
+
+	cr := newCSVRecordReader(r, []byte(v.conf.CsvSeparator), []byte(v.conf.CsvDelimiter), v.conf.EscapeBackslash)
+	for line := 1; ; line++ {
+		fields, err := cr.next()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			if r.err == nil {
+				v.report.addProblem(VerifyLevelError, f.path, "record %d is broken: %s", line, err)
+			}
+			return
+		}
+		if line == 1 && !v.conf.NoHeader {
+			if expected >= 0 && fields != expected {
+				v.report.addProblem(VerifyLevelError, f.path, "header has %d columns but table schema has %d columns", fields, expected)
+				return
+			}
+			expected = fields
+			continue
+		}
+		if expected < 0 {
+			// neither header nor schema is available, all records should have the same column count
+			expected = fields
+		}
+		if fields != expected {
+			v.report.addProblem(VerifyLevelError, f.path, "record %d has %d columns but %d columns are expected", line, fields, expected)
+			return
+		}
+		t.Rows++
+	}
+}
+
+func countNonGeneratedColumns(stmt *ast.CreateTableStmt) int {
+	cnt := 0
+	for _, col := range stmt.Cols {
+		generated := false
+		for _, opt := range col.Options {
+			if opt.Tp == ast.ColumnOptionGenerated {
+				generated = true
+				break
+			}
+		}
+		if !generated {
+			cnt++
+		}
+	}
+	return cnt
+}
+
+// verifyReader remembers the first error returned by the underlying reader,
+// which helps distinguishing broken files from broken contents
+type verifyReader struct {
+	r   io.Reader
+	err error
+}
+
+func (r *verifyReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	n, err := r.r.Read(p)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
+}
+
+// dumpFileMatcher classifies files by the regexps reversed from the output filename template
+type dumpFileMatcher struct {
+	kinds   []dumpFileKind
+	regexps []*regexp.Regexp
+}
+
+func newDumpFileMatcher(tmpl *template.Template) (*dumpFileMatcher, error) {
+	if tmpl == nil {
+		tmpl = DefaultOutputFileTemplate
+	}
+	m := &dumpFileMatcher{}
+	// more specific names are matched first
+	for _, item := range []struct {
+		kind      dumpFileKind
+		subName   string
+		partition bool
+	}{
+		{dumpFileView, outputFileTemplateView, false},
+		{dumpFileTable, outputFileTemplateTable, false},
+		{dumpFileSchema, outputFileTemplateSchema, false},
+		{dumpFileData, outputFileTemplateData, true},
+		{dumpFileData, outputFileTemplateData, false},
+	} {
+		namer := &outputFileNamer{
+			DB:         verifyDBPlaceholder,
+			Table:      verifyTablePlaceholder,
+			ChunkIndex: verifyIndexPlaceholder,
+			format:     "%[1]d",
+		}
+		// the data files of partitions are matched before the ones of whole tables
+		if item.partition {
+			namer.Partition = verifyPartitionPlaceholder
+		}
+		name, err := namer.render(tmpl, item.subName)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if item.partition && !strings.Contains(name, verifyPartitionPlaceholder) {
+			// the template doesn't name the data files with partitions
+			continue
+		}
+		pattern := regexp.QuoteMeta(name)
+		pattern = strings.ReplaceAll(pattern, verifyDBPlaceholder, `(?P<db>.+?)`)
+		pattern = strings.ReplaceAll(pattern, verifyTablePlaceholder, `(?P<table>.+?)`)
+		pattern = strings.ReplaceAll(pattern, verifyPartitionPlaceholder, `(?P<partition>.+?)`)
+		pattern = strings.ReplaceAll(pattern, strconv.Itoa(verifyIndexPlaceholder), `\d+`)
+		re, err := regexp.Compile("^" + pattern + "$")
+		if err != nil {
+			return nil, errors.Annotatef(err, "fail to reverse output filename template %s", item.subName)
+		}
+		m.kinds = append(m.kinds, item.kind)
+		m.regexps = append(m.regexps, re)
+	}
+	return m, nil
+}
+
+func (m *dumpFileMatcher) classify(path string) *dumpFile {
+	f := &dumpFile{path: path}
+	name := path
+	if strings.HasSuffix(name, ".gz") {
+		f.compressed = true
+		name = strings.TrimSuffix(name, ".gz")
+	}
+	switch {
+	case strings.HasSuffix(name, "."+FileFormatSQLTextString):
+		f.fileType = FileFormatSQLTextString
+	case strings.HasSuffix(name, "."+FileFormatCSVString):
+		f.fileType = FileFormatCSVString
+	default:
+		return f
+	}
+	name = strings.TrimSuffix(name, "."+f.fileType)
+
+	for i, re := range m.regexps {
+		match := re.FindStringSubmatch(name)
+		if match == nil {
+			continue
+		}
+		kind := m.kinds[i]
+		// only data files can be written in csv format
+		if kind != dumpFileData && f.fileType == FileFormatCSVString {
+			continue
+		}
+		f.kind = kind
+		for j, subName := range re.SubexpNames() {
+			switch subName {
+			case "db":
+				f.db = unescapeFileName(match[j])
+			case "table":
+				f.table = unescapeFileName(match[j])
+			}
+		}
+		return f
+	}
+	return f
+}
+
+// unescapeFileName reverts the escaping of the `fn` template function
+func unescapeFileName(name string) string {
+	if unescaped, err := url.PathUnescape(name); err == nil {
+		return unescaped
+	}
+	return name
+}
+
+// sqlStatementSplitter splits a stream of SQL text into statements by the `;` outside of quotes and comments
+type sqlStatementSplitter struct {
+	r               *bufio.Reader
+	escapeBackslash bool
+	buf             bytes.Buffer
+}
+
+func newSQLStatementSplitter(r io.Reader, escapeBackslash bool) *sqlStatementSplitter {
+	return &sqlStatementSplitter{
+		r:               bufio.NewReaderSize(r, 1<<16),
+		escapeBackslash: escapeBackslash,
+	}
+}
+
+// next returns the next statement, or io.EOF if there are no statements left
+func (s *sqlStatementSplitter) next() (string, error) {
+	s.buf.Reset()
+	for {
+		b, err := s.readByte()
+		if err == io.EOF {
+			if stmt := strings.TrimSpace(s.buf.String()); stmt != "" {
+				return "", errors.Errorf("missing ';' at the end of %s", abbreviate(stmt))
+			}
+			return "", io.EOF
+		}
+		if err != nil {
+			return "", err
+		}
+		switch b {
+		case ';':
+			if stmt := strings.TrimSpace(s.buf.String()); stmt != ";" {
+				return stmt, nil
+			}
+			s.buf.Reset()
+		case '\'', '"', '`':
+			if err = s.skipQuoted(b); err != nil {
+				return "", err
+			}
+		case '#':
+			if err = s.skipLine(); err != nil {
+				return "", err
+			}
+		case '-':
+			if next, _ := s.r.Peek(2); len(next) == 2 && next[0] == '-' && (next[1] == ' ' || next[1] == '\t' || next[1] == '\n') {
+				if err = s.skipLine(); err != nil {
+					return "", err
+				}
+			}
+		case '/':
+			if next, _ := s.r.Peek(1); len(next) == 1 && next[0] == '*' {
+				if err = s.skipBlockComment(); err != nil {
+					return "", err
+				}
+			}
+		}
+	}
+}
+
+func (s *sqlStatementSplitter) readByte() (byte, error) {
+	b, err := s.r.ReadByte()
+	if err == nil {
+		s.buf.WriteByte(b)
+	}
+	return b, err
+}
+
+func (s *sqlStatementSplitter) skipQuoted(quote byte) error {
+	for {
+		b, err := s.readByte()
+		if err == io.EOF {
+			return errors.Errorf("unterminated quoted string %c", quote)
+		}
+		if err != nil {
+			return err
+		}
+		switch {
+		case b == '\\' && quote != '`' && s.escapeBackslash:
+			if _, err = s.readByte(); err != nil {
+				if err == io.EOF {
+					return errors.New("unterminated escape sequence")
+				}
+				return err
+			}
+		case b == quote:
+			if next, _ := s.r.Peek(1); len(next) == 1 && next[0] == quote {
+				// doubled quote stands for the quote itself
+				_, _ = s.readByte()
+				continue
+			}
+			return nil
+		}
+	}
+}
+
+func (s *sqlStatementSplitter) skipLine() error {
+	for {
+		b, err := s.readByte()
+		if err == io.EOF || b == '\n' {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *sqlStatementSplitter) skipBlockComment() error {
+	// consume the '*' of the opening "/*"
+	if _, err := s.readByte(); err != nil {
+		return err
+	}
+	var last byte
+	for {
+		b, err := s.readByte()
+		if err == io.EOF {
+			return errors.New("unterminated comment")
+		}
+		if err != nil {
+			return err
+		}
+		if last == '*' && b == '/' {
+			return nil
+		}
+		last = b
+	}
+}
+
+func abbreviate(s string) string {
+	const maxLen = 64
+	if len(s) <= maxLen {
+		return s
+	}
+	return s[:maxLen] + "..."
+}
+
+// csvRecordReader counts the fields of CSV records written by WriteInsertInCsv
+type csvRecordReader struct {
+	r               *bufio.Reader
+	separator       []byte
+	delimiter       []byte
+	escapeBackslash bool
+}
+
+func newCSVRecordReader(r io.Reader, separator, delimiter []byte, escapeBackslash bool) *csvRecordReader {
+	return &csvRecordReader{
+		r:               bufio.NewReaderSize(r, 1<<16),
+		separator:       separator,
+		delimiter:       delimiter,
+		escapeBackslash: escapeBackslash,
+	}
+}
+
+func (c *csvRecordReader) hasPrefix(prefix []byte) bool {
+	if len(prefix) == 0 {
+		return false
+	}
+	next, _ := c.r.Peek(len(prefix))
+	return bytes.Equal(next, prefix)
+}
+
+// next returns the field count of the next record, or io.EOF if there are no records left
+func (c *csvRecordReader) next() (int, error) {
+	fields := 0
+	fieldStart := true
+	empty := true
+	for {
+		if fieldStart && c.hasPrefix(c.delimiter) {
+			_, _ = c.r.Discard(len(c.delimiter))
+			if err := c.skipQuoted(); err != nil {
+				return 0, err
+			}
+			fieldStart = false
+			empty = false
+			continue
+		}
+		if c.hasPrefix(c.separator) {
+			_, _ = c.r.Discard(len(c.separator))
+			fields++
+			fieldStart = true
+			empty = false
+			continue
+		}
+		b, err := c.r.ReadByte()
+		if err == io.EOF {
+			if empty {
+				return 0, io.EOF
+			}
+			return 0, errors.New("last record is not terminated by a line break")
+		}
+		if err != nil {
+			return 0, err
+		}
+		if b == '\n' {
+			return fields + 1, nil
+		}
+		fieldStart = false
+		empty = false
+		if b == '\\' && c.escapeBackslash {
+			if _, err = c.r.ReadByte(); err != nil {
+				if err == io.EOF {
+					return 0, errors.New("unterminated escape sequence")
+				}
+				return 0, err
+			}
+		}
+	}
+}
+
+func (c *csvRecordReader) skipQuoted() error {
+	for {
+		if c.hasPrefix(c.delimiter) {
+			_, _ = c.r.Discard(len(c.delimiter))
+			if !c.escapeBackslash && c.hasPrefix(c.delimiter) {
+				// doubled delimiter stands for the delimiter itself
+				_, _ = c.r.Discard(len(c.delimiter))
+				continue
+			}
+			return nil
+		}
+		b, err := c.r.ReadByte()
+		if err == io.EOF {
+			return errors.New("unterminated quoted field")
+		}
+		if err != nil {
+			return err
+		}
+		if b == '\\' && c.escapeBackslash {
+			if _, err = c.r.ReadByte(); err != nil {
+				if err == io.EOF {
+					return errors.New("unterminated escape sequence")
+				}
+				return err
+			}
+		}
+	}
+}
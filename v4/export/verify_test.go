@@ -0,0 +1,228 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package export
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	. "github.com/pingcap/check"
+)
+
+var _ = Suite(&testVerifySuite{})
+
+type testVerifySuite struct{}
+
+const (
+	verifyTestTableSchema = "/*!40101 SET NAMES binary*/;\nCREATE TABLE `t` (\n  `a` int(11) NOT NULL,\n  `b` varchar(20) DEFAULT NULL,\n  `c` int(11) GENERATED ALWAYS AS (`a` + 1) VIRTUAL\n);\n"
+	verifyTestSQLData     = "/*!40101 SET NAMES binary*/;\nINSERT INTO `t` VALUES\n(1,'a;b'),\n(2,'it''s \\'ok\\''),\n(3,NULL);\n"
+	verifyTestCSVData     = "\"a\",\"b\"\n1,\"x,y\"\n2,\"line\\nbreak \\\"quoted\\\"\"\n3,\\N\n"
+)
+
+func writeVerifyTestFiles(c *C, dir string, files map[string]string) {
+	for name, content := range files {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0o644)
+		c.Assert(err, IsNil)
+	}
+}
+
+func verifyTestDir(c *C, dir string, prepare func(conf *Config)) *VerifyReport {
+	conf := DefaultConfig()
+	conf.OutputDirPath = dir
+	conf.EscapeBackslash = true
+	conf.CsvSeparator, conf.CsvDelimiter = ",", "\""
+	if prepare != nil {
+		prepare(conf)
+	}
+	report, err := VerifyDump(context.Background(), conf)
+	c.Assert(err, IsNil)
+	return report
+}
+
+func problemMessages(report *VerifyReport) string {
+	msgs := make([]string, 0, len(report.Problems))
+	for _, p := range report.Problems {
+		msgs = append(msgs, fmt.Sprintf("%s %s: %s", p.Level, p.File, p.Message))
+	}
+	return strings.Join(msgs, "\n")
+}
+
+func gzipString(c *C, content string) string {
+	var bf bytes.Buffer
+	w := gzip.NewWriter(&bf)
+	_, err := w.Write([]byte(content))
+	c.Assert(err, IsNil)
+	c.Assert(w.Close(), IsNil)
+	return bf.String()
+}
+
+func (s *testVerifySuite) TestVerifyValidDump(c *C) {
+	dir := c.MkDir()
+	writeVerifyTestFiles(c, dir, map[string]string{
+		"metadata":                 "Started dump at: 2021-01-01 00:00:00\n",
+		"test-schema-create.sql":   "CREATE DATABASE `test`;\n",
+		"test.t-schema.sql":        verifyTestTableSchema,
+		"test.t.000000000.sql":     verifyTestSQLData,
+		"test.t.000000001.sql.gz":  gzipString(c, verifyTestSQLData),
+		"test.v-schema.sql":        "CREATE TABLE `v`(\n`a` int\n)ENGINE=MyISAM;\n",
+		"test.v-schema-view.sql":   "DROP TABLE IF EXISTS `v`;\nCREATE VIEW `v` AS SELECT `a` FROM `t`;\n",
+		"test.x%2Ey-schema.sql":    "CREATE TABLE `x.y` (`a` int, `b` int);\n",
+		"test.x%2Ey.000000000.csv": verifyTestCSVData,
+		"unrelated.txt":            "not a dump file",
+	})
+	report := verifyTestDir(c, dir, nil)
+	c.Assert(report.HasErrors(), IsFalse, Commentf("%s", problemMessages(report)))
+	c.Assert(report.Problems, HasLen, 0)
+	c.Assert(report.CheckedFiles, Equals, 8)
+	c.Assert(report.Tables, HasLen, 3)
+
+	t := report.Tables[0]
+	c.Assert(t.Table, Equals, "t")
+	c.Assert(t.Columns, Equals, 2)
+	c.Assert(t.DataFiles, DeepEquals, []string{"test.t.000000000.sql", "test.t.000000001.sql.gz"})
+	c.Assert(t.Rows, Equals, uint64(6))
+	c.Assert(report.Tables[1].IsView, IsTrue)
+	c.Assert(report.Tables[2].Table, Equals, "x.y")
+	c.Assert(report.Tables[2].Rows, Equals, uint64(3))
+}
+
+func (s *testVerifySuite) TestVerifyPostgresDump(c *C) {
+	dir := c.MkDir()
+	var metadata bytes.Buffer
+	metadata.WriteString("Started dump at: 2021-01-01 00:00:00\n")
+	writeSQLDialect(&metadata, sqlDialectPostgres)
+	const header = "SET client_encoding = 'UTF8';\nSET standard_conforming_strings = on;\n"
+	writeVerifyTestFiles(c, dir, map[string]string{
+		"metadata":               metadata.String(),
+		"test-schema-create.sql": "CREATE SCHEMA IF NOT EXISTS \"test\";\n",
+		"test.t-schema.sql":      "CREATE TABLE \"test\".\"t\" (\n  \"a\" integer NOT NULL,\n  \"b\" varchar(20) DEFAULT NULL\n);\n",
+		"test.t.000000000.sql":   header + "INSERT INTO \"test\".\"t\"(\"a\",\"b\") VALUES\n(1,'it''s \\ ok')\nON CONFLICT DO NOTHING;\n",
+		"test.t.000000001.sql.gz": gzipString(c, header+"COPY \"test\".\"t\"(\"a\",\"b\") FROM stdin;\n2\ta;b\n3\t\\N\n\\.\n"),
+	})
+	// the files in postgres dialect aren't parsed by the MySQL parser
+	report := verifyTestDir(c, dir, nil)
+	c.Assert(report.HasErrors(), IsFalse, Commentf("%s", problemMessages(report)))
+	c.Assert(problemMessages(report), Equals,
+		"warning metadata: the SQL files are in postgres dialect, which can't be parsed, only their compression and checksums are checked")
+	c.Assert(report.CheckedFiles, Equals, 4)
+	c.Assert(report.Tables, HasLen, 1)
+	c.Assert(report.Tables[0].DataFiles, DeepEquals, []string{"test.t.000000000.sql", "test.t.000000001.sql.gz"})
+
+	// the broken gzip stream is still found
+	writeVerifyTestFiles(c, dir, map[string]string{"test.t.000000001.sql.gz": "broken"})
+	report = verifyTestDir(c, dir, nil)
+	c.Assert(report.HasErrors(), IsTrue)
+	c.Assert(problemMessages(report), Matches, "(?s).*error test.t.000000001.sql.gz: invalid gzip stream.*")
+
+	c.Assert(parseSQLDialect(metadata.Bytes()), Equals, sqlDialectPostgres)
+	c.Assert(parseSQLDialect([]byte("Started dump at: 2021-01-01 00:00:00\n")), Equals, sqlDialectMySQL)
+}
+
+func (s *testVerifySuite) TestVerifyBrokenDump(c *C) {
+	dir := c.MkDir()
+	truncated := gzipString(c, verifyTestSQLData)
+	writeVerifyTestFiles(c, dir, map[string]string{
+		"test.t-schema.sql":       verifyTestTableSchema,
+		"test.t.000000000.sql":    "INSERT INTO `t` VALUES (1,'a'),(2,;\n",
+		"test.t.000000001.sql":    "INSERT INTO `t` VALUES (1,'a')",
+		"test.t.000000002.sql.gz": truncated[:len(truncated)-6],
+		"test.t.000000003.csv":    "\"a\",\"b\"\n1,\"x\",3\n",
+		"test.u-schema.sql":       "CREATE TABLE `u` (`a` int);\n",
+	})
+	// a file left by an interrupted dump
+	writeVerifyTestFiles(c, dir, map[string]string{"test.t.000000004.sql" + tempFileSuffix: "INSERT INTO `t` VALUES (1,"})
+	report := verifyTestDir(c, dir, nil)
+	c.Assert(report.HasErrors(), IsTrue)
+	c.Assert(report.Problems, HasLen, 7, Commentf("%s", problemMessages(report)))
+
+	expected := []struct {
+		level string
+		file  string
+		msg   string
+	}{
+		{VerifyLevelError, "metadata", "metadata file is missing"},
+		{VerifyLevelError, "test.t.000000004.sql" + tempFileSuffix, "file is not completely written"},
+		{VerifyLevelError, "test.t.000000000.sql", "statement 1 can't be parsed"},
+		{VerifyLevelError, "test.t.000000001.sql", "statement 1 is incomplete"},
+		{VerifyLevelError, "test.t.000000002.sql.gz", "gzip stream is incomplete"},
+		{VerifyLevelError, "test.t.000000003.csv", "record 2 has 3 columns but 2 columns are expected"},
+		{VerifyLevelWarning, "", "no data file of table `test`.`u` is found"},
+	}
+	for i, e := range expected {
+		p := report.Problems[i]
+		c.Assert(p.Level, Equals, e.level)
+		c.Assert(p.File, Equals, e.file)
+		c.Assert(strings.Contains(p.Message, e.msg), IsTrue, Commentf("%s", p.Message))
+	}
+}
+
+func (s *testVerifySuite) TestVerifyChecksumManifest(c *C) {
+	dir := c.MkDir()
+	sum := func(content string) string {
+		h := sha256.Sum256([]byte(content))
+		return hex.EncodeToString(h[:])
+	}
+	gzData := gzipString(c, verifyTestSQLData)
+	writeVerifyTestFiles(c, dir, map[string]string{
+		"metadata":                "Started dump at: 2021-01-01 00:00:00\n",
+		"test.t-schema.sql":       verifyTestTableSchema,
+		"test.t.000000000.sql.gz": gzData,
+		"test.t.000000001.sql":    verifyTestSQLData,
+		checksumManifestPath: fmt.Sprintf("%s  test.t-schema.sql\n%s *test.t.000000000.sql.gz\n%s  test.t.000000001.sql\n%s  test.t.000000002.sql\n",
+			sum(verifyTestTableSchema), sum(gzData), sum("something else"), sum("")),
+	})
+	report := verifyTestDir(c, dir, nil)
+	c.Assert(report.Problems, HasLen, 2, Commentf("%s", problemMessages(report)))
+	c.Assert(report.Problems[0].File, Equals, "test.t.000000002.sql")
+	c.Assert(report.Problems[0].Message, Matches, "file listed in .* is missing")
+	c.Assert(report.Problems[1].File, Equals, "test.t.000000001.sql")
+	c.Assert(report.Problems[1].Message, Matches, "checksum mismatch.*")
+}
+
+func (s *testVerifySuite) TestVerifyWithDumpOptions(c *C) {
+	dir := c.MkDir()
+	writeVerifyTestFiles(c, dir, map[string]string{
+		"metadata":     "Started dump at: 2021-01-01 00:00:00\n",
+		"t-schema.sql": "CREATE TABLE `t` (`a` int, `b` text, `c` text);\n",
+		"t.0.csv":      "1|'it''s'|'a|b'\n2|'x\ny'|\\N\n",
+		"t.0.sql":      "INSERT INTO `t` VALUES (1,'a\\','b');\n",
+	})
+	report := verifyTestDir(c, dir, func(conf *Config) {
+		conf.EscapeBackslash = false
+		conf.NoHeader = true
+		conf.CsvSeparator = "|"
+		conf.CsvDelimiter = "'"
+		var err error
+		conf.OutputFileTemplate, err = ParseOutputFileTemplate(`{{define "table"}}{{.Table}}-schema{{end}}{{define "data"}}{{.Table}}.{{.Index}}{{end}}`)
+		c.Assert(err, IsNil)
+	})
+	c.Assert(report.Problems, HasLen, 0, Commentf("%s", problemMessages(report)))
+	c.Assert(report.Tables, HasLen, 1)
+	c.Assert(report.Tables[0].Rows, Equals, uint64(3))
+}
+
+func (s *testVerifySuite) TestSQLStatementSplitter(c *C) {
+	content := "/*!40101 SET NAMES binary*/;\n-- comment;\n# another; comment\nINSERT INTO `a;b` VALUES ('x;\\';y',\"z;\");;\nSELECT 1 /* ; */;\n"
+	splitter := newSQLStatementSplitter(strings.NewReader(content), true)
+	var stmts []string
+	for {
+		stmt, err := splitter.next()
+		if err != nil {
+			c.Assert(err.Error(), Equals, "EOF")
+			break
+		}
+		stmts = append(stmts, strings.TrimSpace(stmt))
+	}
+	c.Assert(stmts, DeepEquals, []string{
+		"/*!40101 SET NAMES binary*/;",
+		"-- comment;\n# another; comment\nINSERT INTO `a;b` VALUES ('x;\\';y',\"z;\");",
+		"SELECT 1 /* ; */;",
+	})
+}
@@ -0,0 +1,161 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package export
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/parser"
+	"github.com/pingcap/parser/ast"
+)
+
+var (
+	// definerClauseRe matches the DEFINER clause in the result of SHOW CREATE VIEW, like
+	// CREATE ALGORITHM=UNDEFINED DEFINER=`root`@`localhost` SQL SECURITY DEFINER VIEW ...
+	definerClauseRe = regexp.MustCompile("(?m)^(CREATE (?:OR REPLACE )?(?:ALGORITHM=\\w+ )?)DEFINER=(?:`(?:[^`]|``)*`@`(?:[^`]|``)*`|CURRENT_USER(?:\\(\\))?) ")
+	// definerAccountRe matches the account of --definer, each part can be quoted by ', " or `
+	definerAccountRe = regexp.MustCompile("^(`(?:[^`]|``)*`|'(?:[^']|'')*'|\"(?:[^\"]|\"\")*\"|[^@'`\"]+)@(`(?:[^`]|``)*`|'(?:[^']|'')*'|\"(?:[^\"]|\"\")*\"|[^@'`\"]+)$")
+)
+
+// unquoteAccountPart removes the quotes around the user name or the host name of an account
+func unquoteAccountPart(s string) string {
+	if len(s) < 2 {
+		return s
+	}
+	switch q := s[:1]; q {
+	case "`", "'", "\"":
+		return strings.ReplaceAll(s[1:len(s)-1], q+q, q)
+	}
+	return s
+}
+
+// adjustDefiner checks --strip-definer and --definer, and quotes the account of --definer like `user`@`host`
+func adjustDefiner(conf *Config) error {
+	if conf.Definer == "" {
+		return nil
+	}
+	if conf.StripDefiner {
+		return errors.New("can't specify both --strip-definer and --definer at the same time")
+	}
+	account := strings.TrimSpace(conf.Definer)
+	if upper := strings.ToUpper(account); upper == "CURRENT_USER" || upper == "CURRENT_USER()" {
+		conf.Definer = "CURRENT_USER"
+		return nil
+	}
+	parts := definerAccountRe.FindStringSubmatch(account)
+	if parts == nil {
+		return errors.Errorf("--definer is set to %s. It should be an account like 'user'@'host' or CURRENT_USER", conf.Definer)
+	}
+	conf.Definer = fmt.Sprintf("`%s`@`%s`", escapeString(unquoteAccountPart(parts[1])), escapeString(unquoteAccountPart(parts[2])))
+	return nil
+}
+
+// rewriteDefiner strips the DEFINER clause of the view with --strip-definer, or replaces it with the account of --definer.
+// The SQL SECURITY clause is kept
+func rewriteDefiner(conf *Config, createViewSQL string) string {
+	switch {
+	case conf.StripDefiner:
+		return definerClauseRe.ReplaceAllString(createViewSQL, "${1}")
+	case conf.Definer != "":
+		return definerClauseRe.ReplaceAllString(createViewSQL, "${1}DEFINER="+strings.ReplaceAll(conf.Definer, "$", "$$")+" ")
+	}
+	return createViewSQL
+}
+
+// tableNameCollector collects the tables referenced in a statement, the tables without the database are in db
+type tableNameCollector struct {
+	db     string
+	tables []tableKey
+}
+
+func (c *tableNameCollector) Enter(in ast.Node) (ast.Node, bool) {
+	if t, ok := in.(*ast.TableName); ok {
+		db := t.Schema.O
+		if db == "" {
+			db = c.db
+		}
+		c.tables = append(c.tables, tableKey{db: db, table: t.Name.O})
+	}
+	return in, false
+}
+
+func (c *tableNameCollector) Leave(in ast.Node) (ast.Node, bool) {
+	return in, true
+}
+
+// viewDependencies parses the SQL creating the view in db, and returns the tables and views the view selects from
+func viewDependencies(db, createViewSQL string) ([]tableKey, error) {
+	stmts, _, err := parser.New().Parse(createViewSQL, "", "")
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	c := &tableNameCollector{db: db}
+	for _, stmt := range stmts {
+		if v, ok := stmt.(*ast.CreateViewStmt); ok {
+			v.Select.Accept(c)
+		}
+	}
+	return c.tables, nil
+}
+
+// viewOrderRecorder records the dumped views and the tables they depend on, to resolve the order of creating the views
+type viewOrderRecorder struct {
+	views []tableKey
+	deps  map[tableKey][]tableKey
+}
+
+func newViewOrderRecorder() *viewOrderRecorder {
+	return &viewOrderRecorder{deps: make(map[tableKey][]tableKey)}
+}
+
+// record records the view and parses its dependencies. The view doesn't depend on any view if it can't be parsed
+func (r *viewOrderRecorder) record(db, view, createViewSQL string) error {
+	key := tableKey{db: db, table: view}
+	r.views = append(r.views, key)
+	deps, err := viewDependencies(db, createViewSQL)
+	if err != nil {
+		return err
+	}
+	r.deps[key] = deps
+	return nil
+}
+
+// order returns the order of creating the views, in which every view comes after the views it selects from
+func (r *viewOrderRecorder) order() *loadOrder {
+	recorded := make(map[tableKey]struct{}, len(r.views))
+	for _, v := range r.views {
+		recorded[v] = struct{}{}
+	}
+	edges := make(map[tableKey][]tableKey)
+	for v, deps := range r.deps {
+		seen := make(map[tableKey]struct{}, len(deps))
+		for _, dep := range deps {
+			if _, ok := recorded[dep]; !ok || dep == v {
+				continue
+			}
+			if _, ok := seen[dep]; !ok {
+				seen[dep] = struct{}{}
+				edges[dep] = append(edges[dep], v)
+			}
+		}
+	}
+	views := append([]tableKey(nil), r.views...)
+	sortTableKeys(views)
+	return sortTablesTopologically(views, edges)
+}
+
+// writeViewOrder writes the order of creating the views in metadata
+func writeViewOrder(buffer *bytes.Buffer, order *loadOrder) {
+	if len(order.tables) == 0 {
+		return
+	}
+	buffer.WriteString("VIEW ORDER:\n")
+	for _, v := range order.tables {
+		fmt.Fprintf(buffer, "\t%s\n", v)
+	}
+	buffer.WriteString("\n")
+}
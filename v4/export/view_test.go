@@ -0,0 +1,94 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package export
+
+import (
+	"bytes"
+	"fmt"
+
+	. "github.com/pingcap/check"
+)
+
+var _ = Suite(&testViewSuite{})
+
+type testViewSuite struct{}
+
+// createViewSQL builds the SQL creating the view like ShowCreateView
+func createViewSQL(view, definition string) string {
+	return fmt.Sprintf("DROP TABLE IF EXISTS `%s`;\nDROP VIEW IF EXISTS `%s`;\n"+
+		"SET @PREV_CHARACTER_SET_CLIENT=@@CHARACTER_SET_CLIENT;\nSET character_set_client = utf8;\n"+
+		"CREATE ALGORITHM=UNDEFINED DEFINER=`root`@`localhost` SQL SECURITY DEFINER VIEW `%s` AS %s;\n"+
+		"SET character_set_client = @PREV_CHARACTER_SET_CLIENT;\n", view, view, view, definition)
+}
+
+func (s *testViewSuite) TestViewOrder(c *C) {
+	r := newViewOrderRecorder()
+	c.Assert(r.record("test", "v3", createViewSQL("v3", "SELECT `a` FROM `test`.`v2` JOIN `v1` USING (`a`)")), IsNil)
+	c.Assert(r.record("test", "v1", createViewSQL("v1", "SELECT `a` FROM `test`.`t`")), IsNil)
+	c.Assert(r.record("test", "v2", createViewSQL("v2", "SELECT `a` FROM (SELECT `a` FROM `v1`) AS `s` UNION SELECT `a` FROM `v0`")), IsNil)
+	c.Assert(r.record("other", "v0", createViewSQL("v0", "SELECT `a` FROM `test`.`v3` WHERE `a` IN (SELECT `a` FROM `t`)")), IsNil)
+	c.Assert(r.record("test", "v0", createViewSQL("v0", "SELECT 1 AS `a`")), IsNil)
+	// the view which can't be parsed is created without any dependency
+	c.Assert(r.record("test", "bad", "CREATE VIEW `bad` AS SELECT ("), NotNil)
+
+	order := r.order()
+	c.Assert(order.tables, DeepEquals, []tableKey{
+		{db: "test", table: "bad"}, {db: "test", table: "v0"}, {db: "test", table: "v1"},
+		{db: "test", table: "v2"},
+		{db: "test", table: "v3"},
+		{db: "other", table: "v0"},
+	})
+	c.Assert(order.cycles, HasLen, 0)
+
+	var buffer bytes.Buffer
+	writeViewOrder(&buffer, order)
+	c.Assert(buffer.String(), Equals, "VIEW ORDER:\n\t`test`.`bad`\n\t`test`.`v0`\n\t`test`.`v1`\n\t`test`.`v2`\n\t`test`.`v3`\n\t`other`.`v0`\n\n")
+	buffer.Reset()
+	writeViewOrder(&buffer, newViewOrderRecorder().order())
+	c.Assert(buffer.Len(), Equals, 0)
+}
+
+func (s *testViewSuite) TestAdjustDefiner(c *C) {
+	conf := DefaultConfig()
+	c.Assert(adjustDefiner(conf), IsNil)
+	c.Assert(conf.Definer, Equals, "")
+
+	for account, expected := range map[string]string{
+		"admin@%":              "`admin`@`%`",
+		"'admin'@'10.0.0.%'":   "`admin`@`10.0.0.%`",
+		"`ad``min`@localhost":  "`ad``min`@`localhost`",
+		"\"it's\"@'%'":         "`it's`@`%`",
+		" current_user() ":     "CURRENT_USER",
+		"'a@b'@'localhost'":    "`a@b`@`localhost`",
+		"'ad''min'@`local`` `": "`ad'min`@`local`` `",
+	} {
+		conf.Definer = account
+		c.Assert(adjustDefiner(conf), IsNil, Commentf("account %s", account))
+		c.Assert(conf.Definer, Equals, expected, Commentf("account %s", account))
+	}
+	for _, account := range []string{"admin", "a@b@c", "'admin@%"} {
+		conf.Definer = account
+		c.Assert(adjustDefiner(conf), ErrorMatches, "--definer is set to .*", Commentf("account %s", account))
+	}
+	conf.Definer = "admin@%"
+	conf.StripDefiner = true
+	c.Assert(adjustDefiner(conf), ErrorMatches, "can't specify both --strip-definer and --definer at the same time")
+}
+
+func (s *testViewSuite) TestRewriteDefiner(c *C) {
+	createSQL := createViewSQL("v", "SELECT `t`.`a` AS `a` FROM `test`.`t`")
+	conf := DefaultConfig()
+	c.Assert(rewriteDefiner(conf, createSQL), Equals, createSQL)
+
+	conf.StripDefiner = true
+	c.Assert(rewriteDefiner(conf, createSQL), Matches,
+		"(?s).*\nCREATE ALGORITHM=UNDEFINED SQL SECURITY DEFINER VIEW `v` AS SELECT `t`.`a` AS `a` FROM `test`.`t`;\n.*")
+
+	conf.StripDefiner = false
+	conf.Definer = "`$1`@`%`"
+	c.Assert(rewriteDefiner(conf, createSQL), Matches,
+		"(?s).*\nCREATE ALGORITHM=UNDEFINED DEFINER=`\\$1`@`%` SQL SECURITY DEFINER VIEW `v` AS SELECT `t`.`a` AS `a` FROM `test`.`t`;\n.*")
+	// the DEFINER in the definition of the view isn't changed
+	createSQL = createViewSQL("v", "SELECT 'DEFINER=`root`@`localhost` ' AS `a`")
+	c.Assert(rewriteDefiner(conf, createSQL), Matches, "(?s).*DEFINER=`\\$1`@`%` SQL SECURITY DEFINER VIEW `v` AS SELECT 'DEFINER=`root`@`localhost` ' AS `a`;\n.*")
+}
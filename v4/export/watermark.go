@@ -0,0 +1,342 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package export
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/errors"
+	filter "github.com/pingcap/tidb-tools/pkg/table-filter"
+	"go.uber.org/zap"
+)
+
+// watermarksSection is the header of the watermarks of tables in metadata
+const watermarksSection = "WATERMARKS:"
+
+// watermarkRule is a rule of --watermark-column like `db.orders=updated_at`
+type watermarkRule struct {
+	tables filter.Filter
+	column string
+}
+
+// WatermarkColumns picks the watermark columns of tables by the rules of --watermark-column
+type WatermarkColumns struct {
+	rules []watermarkRule
+}
+
+// ParseWatermarkColumns parses the rules of --watermark-column, each rule is `<table filter pattern>=<column>`
+func ParseWatermarkColumns(rules []string, caseSensitive bool) (*WatermarkColumns, error) {
+	w := &WatermarkColumns{}
+	for _, rule := range rules {
+		i := strings.LastIndex(rule, "=")
+		if i < 0 {
+			return nil, errors.Errorf("failed to parse --watermark-column '%s': the rule should be like 'db.tbl=column'", rule)
+		}
+		pattern, column := strings.TrimSpace(rule[:i]), strings.TrimSpace(rule[i+1:])
+		if pattern == "" || column == "" {
+			return nil, errors.Errorf("failed to parse --watermark-column '%s': the rule should be like 'db.tbl=column'", rule)
+		}
+		f, err := filter.Parse([]string{pattern})
+		if err != nil {
+			return nil, errors.Errorf("failed to parse --watermark-column '%s': %s", rule, err)
+		}
+		if !caseSensitive {
+			f = filter.CaseInsensitive(f)
+		}
+		w.rules = append(w.rules, watermarkRule{tables: f, column: column})
+	}
+	return w, nil
+}
+
+func (w *WatermarkColumns) hasAnyRule() bool {
+	return w != nil && len(w.rules) > 0
+}
+
+// Column returns the watermark column of the table, or "" if no rule matches the table. Like the table filter, the last matching rule wins
+func (w *WatermarkColumns) Column(db, table string) string {
+	if w == nil {
+		return ""
+	}
+	for i := len(w.rules) - 1; i >= 0; i-- {
+		if w.rules[i].tables.MatchTable(db, table) {
+			return w.rules[i].column
+		}
+	}
+	return ""
+}
+
+// tableWatermark is the high-water mark of the watermark column of a table reached by a dump
+type tableWatermark struct {
+	Database string `json:"database"`
+	Table    string `json:"table"`
+	Column   string `json:"column"`
+	// Type is the DATA_TYPE of the column, which decides how the value is written in the watermark range
+	Type  string `json:"type,omitempty"`
+	Value string `json:"value"`
+}
+
+// numericLiteral matches the values of the numeric columns, which are written in the watermark range without quotes
+var numericLiteral = regexp.MustCompile(`^[-+]?[0-9]+(\.[0-9]+)?([eE][-+]?[0-9]+)?$`)
+
+// literal returns the value of the watermark in SQL. A numeric value is compared without quotes, because comparing
+// a BIGINT or DECIMAL column with a string converts both to DOUBLE, which loses the precision above 2^53
+func (w tableWatermark) literal(value string) string {
+	for _, t := range dataTypeNum {
+		if strings.EqualFold(w.Type, t) && numericLiteral.MatchString(value) {
+			return value
+		}
+	}
+	return quoteSQLString(value)
+}
+
+// watermarkRecorder records the watermarks of the dumped tables, and gives the watermark range of each table
+// from the watermark of the previous dump to the current one. It's safe for concurrent use
+type watermarkRecorder struct {
+	mu       sync.Mutex
+	previous map[tableKey]tableWatermark
+	current  map[tableKey]tableWatermark
+	// dumped is the tables whose watermarks are read by the dump, including the empty tables which have no watermark
+	dumped map[tableKey]bool
+}
+
+func newWatermarkRecorder(previous []tableWatermark) *watermarkRecorder {
+	r := &watermarkRecorder{
+		previous: make(map[tableKey]tableWatermark, len(previous)),
+		current:  make(map[tableKey]tableWatermark),
+		dumped:   make(map[tableKey]bool),
+	}
+	for _, w := range previous {
+		r.previous[tableKey{db: w.Database, table: w.Table}] = w
+	}
+	return r
+}
+
+func (r *watermarkRecorder) record(w tableWatermark) {
+	k := tableKey{db: w.Database, table: w.Table}
+	r.mu.Lock()
+	r.current[k] = w
+	r.dumped[k] = true
+	r.mu.Unlock()
+}
+
+// recordEmpty records the empty table, which has no watermark in the dump
+func (r *watermarkRecorder) recordEmpty(db, table string) {
+	r.mu.Lock()
+	r.dumped[tableKey{db: db, table: table}] = true
+	r.mu.Unlock()
+}
+
+// previousWatermark returns the watermark of the table in the previous dump, which is valid only if the column isn't changed
+func (r *watermarkRecorder) previousWatermark(db, table, column string) (tableWatermark, bool) {
+	w, ok := r.previous[tableKey{db: db, table: table}]
+	return w, ok && w.Column == column
+}
+
+// condition returns the watermark range of the table, or "" if the watermark of the table isn't recorded
+func (r *watermarkRecorder) condition(db, table string) string {
+	if r == nil {
+		return ""
+	}
+	r.mu.Lock()
+	cur, ok := r.current[tableKey{db: db, table: table}]
+	r.mu.Unlock()
+	if !ok {
+		return ""
+	}
+	column := fmt.Sprintf("`%s`", escapeString(cur.Column))
+	// the upper bound keeps the range exact even if the rows are changed during a dump without a consistent snapshot
+	cond := fmt.Sprintf("%s <= %s", column, cur.literal(cur.Value))
+	if prev, ok := r.previousWatermark(db, table, cur.Column); ok {
+		// the previous value is written in the current type of the column, which may be recorded by an older dump without it
+		cond = fmt.Sprintf("%s > %s AND %s", column, cur.literal(prev.Value), cond)
+	}
+	return cond
+}
+
+// watermarks returns the watermarks to record in metadata in the order of tables.
+// The failed tables keep their previous watermarks, so that the next incremental dump starts from the same place again.
+// The tables not dumped this time, like the ones filtered out, keep their previous watermarks too
+func (r *watermarkRecorder) watermarks(failures []TableFailure) []tableWatermark {
+	failed := func(k tableKey) bool {
+		for _, f := range failures {
+			if f.Database == k.db && (f.Table == "" || f.Table == k.table) {
+				return true
+			}
+		}
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	watermarks := make([]tableWatermark, 0, len(r.current))
+	for k, w := range r.current {
+		if !failed(k) {
+			watermarks = append(watermarks, w)
+		}
+	}
+	for k, w := range r.previous {
+		if failed(k) || !r.dumped[k] {
+			watermarks = append(watermarks, w)
+		}
+	}
+	sort.Slice(watermarks, func(i, j int) bool {
+		if watermarks[i].Database != watermarks[j].Database {
+			return watermarks[i].Database < watermarks[j].Database
+		}
+		return watermarks[i].Table < watermarks[j].Table
+	})
+	return watermarks
+}
+
+func quoteSQLString(s string) string {
+	var bf bytes.Buffer
+	bf.WriteByte('\'')
+	escapeSQL([]byte(s), &bf, true)
+	bf.WriteByte('\'')
+	return bf.String()
+}
+
+// tableWhere returns the condition of --where and the watermark range of the table
+func (d *Dumper) tableWhere(db, tbl string) string {
+	cond := d.watermarks.condition(db, tbl)
+	switch {
+	case cond == "":
+		return d.conf.Where
+	case d.conf.Where == "":
+		return cond
+	default:
+		return fmt.Sprintf("(%s) AND %s", d.conf.Where, cond)
+	}
+}
+
+// recordTableWatermark reads the high-water mark of the watermark column of the table before it's split,
+// the conn shares the same snapshot with the dumping connections. It does nothing if the table has no watermark column.
+// The watermark column must be NOT NULL, because the rows with NULL never fall into any watermark range
+func (d *Dumper) recordTableWatermark(conn *sql.Conn, db, tbl string) error {
+	column := d.conf.WatermarkColumns.Column(db, tbl)
+	if d.watermarks == nil || column == "" {
+		return nil
+	}
+	nullable, dataType, err := SelectColumnNullableAndType(conn, db, tbl, column)
+	if err != nil {
+		return err
+	}
+	if nullable {
+		return errors.Errorf("the watermark column `%s` of table `%s`.`%s` is nullable, whose rows with NULL can't be dumped incrementally. "+
+			"Please use a NOT NULL column", escapeString(column), escapeString(db), escapeString(tbl))
+	}
+	query := fmt.Sprintf("SELECT MAX(`%s`) FROM `%s`.`%s`", escapeString(column), escapeString(db), escapeString(tbl))
+	var value sql.NullString
+	if err := conn.QueryRowContext(d.tctx, query).Scan(&value); err != nil {
+		return errors.Annotatef(err, "sql: %s", query)
+	}
+	if _, ok := d.watermarks.previousWatermark(db, tbl, column); !ok && d.conf.IncrementalFrom != "" {
+		d.L().Warn("no watermark of the table in the previous dump, dump the whole table",
+			zap.String("database", db), zap.String("table", tbl), zap.String("column", column))
+	}
+	if !value.Valid {
+		// the table is empty, the next incremental dump should dump the whole table
+		d.L().Info("no watermark for an empty table", zap.String("database", db), zap.String("table", tbl))
+		d.watermarks.recordEmpty(db, tbl)
+		return nil
+	}
+	d.watermarks.record(tableWatermark{Database: db, Table: tbl, Column: column, Type: dataType, Value: value.String})
+	return nil
+}
+
+// writeWatermarks writes the watermarks section of metadata, a watermark of a table per line
+func writeWatermarks(buffer *bytes.Buffer, watermarks []tableWatermark) {
+	if len(watermarks) == 0 {
+		return
+	}
+	buffer.WriteString(watermarksSection + "\n")
+	for _, w := range watermarks {
+		// marshaling a struct of strings never fails
+		line, _ := json.Marshal(w)
+		buffer.WriteString("\t")
+		buffer.Write(line)
+		buffer.WriteString("\n")
+	}
+	buffer.WriteString("\n")
+}
+
+// parseWatermarks parses the watermarks section of metadata
+func parseWatermarks(metadata []byte) ([]tableWatermark, error) {
+	var (
+		watermarks []tableWatermark
+		inSection  bool
+	)
+	scanner := bufio.NewScanner(bytes.NewReader(metadata))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !inSection {
+			inSection = line == watermarksSection
+			continue
+		}
+		if !strings.HasPrefix(line, "\t") {
+			break
+		}
+		var w tableWatermark
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "\t")), &w); err != nil {
+			return nil, errors.Annotatef(err, "invalid watermark '%s'", line)
+		}
+		watermarks = append(watermarks, w)
+	}
+	return watermarks, errors.Trace(scanner.Err())
+}
+
+// readPreviousWatermarks reads the watermarks in the metadata of the dump at --incremental-from
+func readPreviousWatermarks(ctx context.Context, conf *Config) ([]tableWatermark, error) {
+	b, err := storage.ParseBackend(conf.IncrementalFrom, &conf.BackendOptions)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	s, err := storage.Create(ctx, b, false)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	metadata, err := s.ReadFile(ctx, metadataPath)
+	if err != nil {
+		return nil, errors.Annotatef(err, "fail to read the metadata of the previous dump %s", conf.IncrementalFrom)
+	}
+	return parseWatermarks(metadata)
+}
+
+// loadPreviousWatermarks is an initialization step of Dumper.
+func loadPreviousWatermarks(d *Dumper) error {
+	conf := d.conf
+	if !conf.WatermarkColumns.hasAnyRule() {
+		return nil
+	}
+	var previous []tableWatermark
+	if conf.IncrementalFrom != "" {
+		var err error
+		previous, err = readPreviousWatermarks(d.tctx, conf)
+		if err != nil {
+			return err
+		}
+		d.L().Info("load the watermarks of the previous dump",
+			zap.String("path", conf.IncrementalFrom), zap.Int("tables", len(previous)))
+	}
+	d.watermarks = newWatermarkRecorder(previous)
+	return nil
+}
+
+func validateIncrementalDump(conf *Config) error {
+	if conf.IncrementalFrom != "" && !conf.WatermarkColumns.hasAnyRule() {
+		return errors.New("--incremental-from must be used together with --watermark-column")
+	}
+	if conf.SQL != "" && conf.WatermarkColumns.hasAnyRule() {
+		return errors.New("can't specify both --sql and --watermark-column at the same time")
+	}
+	return nil
+}
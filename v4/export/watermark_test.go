@@ -0,0 +1,195 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package export
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	. "github.com/pingcap/check"
+)
+
+var _ = Suite(&testWatermarkSuite{})
+
+type testWatermarkSuite struct{}
+
+func (s *testWatermarkSuite) TestParseWatermarkColumns(c *C) {
+	w, err := ParseWatermarkColumns([]string{"db.*=id", "db.orders=updated_at", "logs.*=ts"}, false)
+	c.Assert(err, IsNil)
+	c.Assert(w.hasAnyRule(), IsTrue)
+	c.Assert(w.Column("db", "users"), Equals, "id")
+	c.Assert(w.Column("DB", "Orders"), Equals, "updated_at")
+	c.Assert(w.Column("logs", "t"), Equals, "ts")
+	c.Assert(w.Column("other", "t"), Equals, "")
+
+	w, err = ParseWatermarkColumns([]string{"db.orders=updated_at"}, true)
+	c.Assert(err, IsNil)
+	c.Assert(w.Column("DB", "orders"), Equals, "")
+
+	for _, rule := range []string{"db.orders", "db.orders=", "=id", "db.[=id"} {
+		_, err = ParseWatermarkColumns([]string{rule}, false)
+		c.Assert(err, ErrorMatches, "failed to parse --watermark-column.*", Commentf("rule %s", rule))
+	}
+
+	w, err = ParseWatermarkColumns(nil, false)
+	c.Assert(err, IsNil)
+	c.Assert(w.hasAnyRule(), IsFalse)
+	w = nil
+	c.Assert(w.Column("db", "t"), Equals, "")
+}
+
+func (s *testWatermarkSuite) TestWatermarkRecorder(c *C) {
+	r := newWatermarkRecorder([]tableWatermark{
+		{Database: "db", Table: "t1", Column: "id", Value: "10"},
+		{Database: "db", Table: "t2", Column: "ts", Value: "2021-01-01 00:00:00"},
+		{Database: "db", Table: "t3", Column: "id", Value: "it's"},
+	})
+	r.record(tableWatermark{Database: "db", Table: "t1", Column: "id", Value: "20"})
+	r.record(tableWatermark{Database: "db", Table: "t2", Column: "updated_at", Value: "2021-02-01 00:00:00"})
+	r.record(tableWatermark{Database: "db", Table: "t4", Column: "id", Value: "5"})
+
+	c.Assert(r.condition("db", "t1"), Equals, "`id` > '10' AND `id` <= '20'")
+	// the numeric values are compared without quotes, so a BIGINT above 2^53 isn't converted to DOUBLE
+	r.record(tableWatermark{Database: "db", Table: "t1", Column: "id", Type: "bigint", Value: "9007199254740993"})
+	c.Assert(r.condition("db", "t1"), Equals, "`id` > 10 AND `id` <= 9007199254740993")
+	r.record(tableWatermark{Database: "db", Table: "t1", Column: "id", Type: "DECIMAL", Value: "-12.50"})
+	c.Assert(r.condition("db", "t1"), Equals, "`id` > 10 AND `id` <= -12.50")
+	r.record(tableWatermark{Database: "db", Table: "t1", Column: "id", Type: "bigint", Value: "1 OR 1=1"})
+	c.Assert(r.condition("db", "t1"), Equals, "`id` > 10 AND `id` <= '1 OR 1=1'")
+	r.record(tableWatermark{Database: "db", Table: "t2", Column: "updated_at", Type: "datetime", Value: "2021-02-01 00:00:00"})
+	// the previous watermark is ignored if the column is changed
+	c.Assert(r.condition("db", "t2"), Equals, "`updated_at` <= '2021-02-01 00:00:00'")
+	c.Assert(r.condition("db", "t3"), Equals, "")
+	c.Assert(r.condition("db", "t4"), Equals, "`id` <= '5'")
+	r = nil
+	c.Assert(r.condition("db", "t1"), Equals, "")
+
+	r = newWatermarkRecorder([]tableWatermark{
+		{Database: "db", Table: "t1", Column: "id", Value: "10"},
+		{Database: "db", Table: "t3", Column: "id", Value: "it's"},
+		{Database: "db2", Table: "t", Column: "id", Value: "1"},
+		{Database: "db2", Table: "empty", Column: "id", Value: "3"},
+	})
+	r.record(tableWatermark{Database: "db", Table: "t1", Column: "id", Value: "20"})
+	r.record(tableWatermark{Database: "db", Table: "t4", Column: "id", Value: "5"})
+	r.record(tableWatermark{Database: "db2", Table: "t", Column: "id", Value: "2"})
+	r.recordEmpty("db2", "empty")
+	// the tables not dumped this time keep the previous watermarks, while the empty tables have no watermark
+	c.Assert(r.watermarks(nil), DeepEquals, []tableWatermark{
+		{Database: "db", Table: "t1", Column: "id", Value: "20"},
+		{Database: "db", Table: "t3", Column: "id", Value: "it's"},
+		{Database: "db", Table: "t4", Column: "id", Value: "5"},
+		{Database: "db2", Table: "t", Column: "id", Value: "2"},
+	})
+	// the failed tables keep the previous watermarks
+	c.Assert(r.watermarks([]TableFailure{
+		{Database: "db", Table: "t1", Chunk: 0},
+		{Database: "db", Table: "t3", Chunk: -1},
+		{Database: "db2", Chunk: -1},
+	}), DeepEquals, []tableWatermark{
+		{Database: "db", Table: "t1", Column: "id", Value: "10"},
+		{Database: "db", Table: "t3", Column: "id", Value: "it's"},
+		{Database: "db", Table: "t4", Column: "id", Value: "5"},
+		{Database: "db2", Table: "empty", Column: "id", Value: "3"},
+		{Database: "db2", Table: "t", Column: "id", Value: "1"},
+	})
+}
+
+func (s *testWatermarkSuite) TestWriteAndParseWatermarks(c *C) {
+	watermarks := []tableWatermark{
+		{Database: "db", Table: "t1", Column: "id", Value: "10"},
+		{Database: "db", Table: "t\n2", Column: "ts", Type: "timestamp", Value: "2021-01-01 00:00:00"},
+	}
+	var buffer bytes.Buffer
+	buffer.WriteString("Started dump at: 2021-03-01 00:00:00\nSHOW MASTER STATUS:\n\tLog: ON.000001\n\tPos: 7502\n\tGTID:\n\n")
+	writeWatermarks(&buffer, watermarks)
+	buffer.WriteString("Finished dump at: 2021-03-01 00:00:01\n")
+	c.Assert(buffer.String(), Equals, "Started dump at: 2021-03-01 00:00:00\n"+
+		"SHOW MASTER STATUS:\n\tLog: ON.000001\n\tPos: 7502\n\tGTID:\n\n"+
+		"WATERMARKS:\n"+
+		"\t{\"database\":\"db\",\"table\":\"t1\",\"column\":\"id\",\"value\":\"10\"}\n"+
+		"\t{\"database\":\"db\",\"table\":\"t\\n2\",\"column\":\"ts\",\"type\":\"timestamp\",\"value\":\"2021-01-01 00:00:00\"}\n\n"+
+		"Finished dump at: 2021-03-01 00:00:01\n")
+
+	parsed, err := parseWatermarks(buffer.Bytes())
+	c.Assert(err, IsNil)
+	c.Assert(parsed, DeepEquals, watermarks)
+
+	parsed, err = parseWatermarks([]byte("Started dump at: 2021-03-01 00:00:00\nFinished dump at: 2021-03-01 00:00:01\n"))
+	c.Assert(err, IsNil)
+	c.Assert(parsed, HasLen, 0)
+
+	_, err = parseWatermarks([]byte("WATERMARKS:\n\t{bad json}\n"))
+	c.Assert(err, ErrorMatches, "invalid watermark.*")
+
+	buffer.Reset()
+	writeWatermarks(&buffer, nil)
+	c.Assert(buffer.Len(), Equals, 0)
+}
+
+func (s *testWatermarkSuite) TestIncrementalDumpTable(c *C) {
+	db, mock, err := sqlmock.New()
+	c.Assert(err, IsNil)
+	defer db.Close()
+	conn, err := db.Conn(context.Background())
+	c.Assert(err, IsNil)
+
+	d := newDumperForSchemaTest(c)
+	d.conf.Where = "a > 0 OR b > 0"
+	d.conf.WatermarkColumns, err = ParseWatermarkColumns([]string{"test.t1=id"}, false)
+	c.Assert(err, IsNil)
+	d.watermarks = newWatermarkRecorder([]tableWatermark{{Database: "test", Table: "t1", Column: "id", Value: "10"}})
+	cacheTestSchemas(d, "test", "t1", "t2")
+
+	mock.ExpectQuery("SELECT IS_NULLABLE,DATA_TYPE FROM INFORMATION_SCHEMA.COLUMNS").WithArgs("test", "t1", "id").
+		WillReturnRows(sqlmock.NewRows([]string{"IS_NULLABLE", "DATA_TYPE"}).AddRow("NO", "int"))
+	mock.ExpectQuery("SELECT MAX\\(`id`\\) FROM `test`.`t1`").
+		WillReturnRows(sqlmock.NewRows([]string{"MAX(`id`)"}).AddRow("20"))
+	taskChan := make(chan Task, 2)
+	c.Assert(d.dumpTableData(conn, &tableMeta{database: "test", table: "t1"}, taskChan), IsNil)
+	c.Assert(d.dumpTableData(conn, &tableMeta{database: "test", table: "t2"}, taskChan), IsNil)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+	c.Assert((<-taskChan).(*TaskTableData).Data.(*tableData).query, Equals,
+		"SELECT * FROM `test`.`t1`  WHERE (a > 0 OR b > 0) AND `id` > 10 AND `id` <= 20 ORDER BY `id`")
+	c.Assert((<-taskChan).(*TaskTableData).Data.(*tableData).query, Equals,
+		"SELECT * FROM `test`.`t2`  WHERE a > 0 OR b > 0 ORDER BY `id`")
+	c.Assert(d.watermarks.watermarks(nil), DeepEquals, []tableWatermark{{Database: "test", Table: "t1", Column: "id", Type: "int", Value: "20"}})
+
+	// an empty table has no watermark
+	mock.ExpectQuery("SELECT IS_NULLABLE,DATA_TYPE FROM INFORMATION_SCHEMA.COLUMNS").WithArgs("test", "t1", "id").
+		WillReturnRows(sqlmock.NewRows([]string{"IS_NULLABLE", "DATA_TYPE"}).AddRow("NO", "int"))
+	mock.ExpectQuery("SELECT MAX\\(`id`\\) FROM `test`.`t1`").
+		WillReturnRows(sqlmock.NewRows([]string{"MAX(`id`)"}).AddRow(nil))
+	d.watermarks = newWatermarkRecorder(nil)
+	c.Assert(d.dumpTableData(conn, &tableMeta{database: "test", table: "t1"}, taskChan), IsNil)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+	c.Assert(d.watermarks.watermarks(nil), HasLen, 0)
+	c.Assert((<-taskChan).(*TaskTableData).Data.(*tableData).query, Equals,
+		"SELECT * FROM `test`.`t1`  WHERE a > 0 OR b > 0 ORDER BY `id`")
+
+	// the rows with NULL never fall into any watermark range, so a nullable watermark column is rejected
+	mock.ExpectQuery("SELECT IS_NULLABLE,DATA_TYPE FROM INFORMATION_SCHEMA.COLUMNS").WithArgs("test", "t1", "id").
+		WillReturnRows(sqlmock.NewRows([]string{"IS_NULLABLE", "DATA_TYPE"}).AddRow("YES", "int"))
+	err = d.dumpTableData(conn, &tableMeta{database: "test", table: "t1"}, taskChan)
+	c.Assert(err, ErrorMatches, "the watermark column `id` of table `test`.`t1` is nullable.*")
+	mock.ExpectQuery("SELECT IS_NULLABLE,DATA_TYPE FROM INFORMATION_SCHEMA.COLUMNS").WithArgs("test", "t1", "id").
+		WillReturnRows(sqlmock.NewRows([]string{"IS_NULLABLE", "DATA_TYPE"}))
+	err = d.dumpTableData(conn, &tableMeta{database: "test", table: "t1"}, taskChan)
+	c.Assert(err, ErrorMatches, "table `test`.`t1` has no column `id`")
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
+
+func (s *testWatermarkSuite) TestValidateIncrementalDump(c *C) {
+	conf := DefaultConfig()
+	c.Assert(validateIncrementalDump(conf), IsNil)
+	conf.IncrementalFrom = "/tmp/last"
+	c.Assert(validateIncrementalDump(conf), ErrorMatches, "--incremental-from must be used together with --watermark-column")
+
+	var err error
+	conf.WatermarkColumns, err = ParseWatermarkColumns([]string{"*.*=id"}, false)
+	c.Assert(err, IsNil)
+	c.Assert(validateIncrementalDump(conf), IsNil)
+	conf.SQL = "SELECT 1"
+	c.Assert(validateIncrementalDump(conf), ErrorMatches, "can't specify both --sql and --watermark-column at the same time")
+}
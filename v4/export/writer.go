@@ -29,9 +29,22 @@ type Writer struct {
 
 	receivedTaskCount int
 
+	// routes is the targets of the tables routed by --route, the data files of the routed tables are written as the targets
+	routes *tableRoutes
+	// singleFile spools the data files of --single-file
+	singleFile *singleFileOutput
+	// sqlite is the SQLite database written instead of the files with --filetype sqlite
+	sqlite *sqliteOutput
+	// loadData records the CSV files written for --load-data-scripts
+	loadData *loadDataScripts
+	// failures records the data files written with --continue-on-error, which are removed if their tables fail
+	failures *failureRecorder
+
 	rebuildConnFn       func(*sql.Conn) (*sql.Conn, error)
 	finishTaskCallBack  func(Task)
 	finishTableCallBack func(Task)
+	// taskFailedCallBack is set if the failed tasks are skipped instead of stopping the writer
+	taskFailedCallBack func(Task, error)
 }
 
 // NewWriter returns a new Writer with given configurations
@@ -50,6 +63,8 @@ func NewWriter(tctx *tcontext.Context, id int64, config *Config, conn *sql.Conn,
 		sw.fileFmt = FileFormatSQLText
 	case FileFormatCSVString:
 		sw.fileFmt = FileFormatCSV
+	case FileFormatPostgresCopyString:
+		sw.fileFmt = FileFormatPostgresCopy
 	}
 	return sw
 }
@@ -62,6 +77,10 @@ func (w *Writer) setFinishTableCallBack(fn func(Task)) {
 	w.finishTableCallBack = fn
 }
 
+func (w *Writer) setTaskFailedCallBack(fn func(Task, error)) {
+	w.taskFailedCallBack = fn
+}
+
 func countTotalTask(writers []*Writer) int {
 	sum := 0
 	for _, w := range writers {
@@ -84,7 +103,10 @@ func (w *Writer) run(taskStream <-chan Task) error {
 			w.receivedTaskCount++
 			err := w.handleTask(task)
 			if err != nil {
-				return err
+				if w.taskFailedCallBack == nil || w.tctx.Err() != nil || isConnectionError(err) {
+					return err
+				}
+				w.taskFailedCallBack(task, err)
 			}
 			w.finishTaskCallBack(task)
 		}
@@ -100,11 +122,25 @@ func (w *Writer) handleTask(task Task) error {
 	case *TaskViewMeta:
 		return w.WriteViewMeta(t.DatabaseName, t.ViewName, t.CreateTableSQL, t.CreateViewSQL)
 	case *TaskTableData:
-		err := w.WriteTableData(t.Meta, t.Data, t.ChunkIndex)
+		meta := t.Meta
+		if w.routes != nil {
+			meta = w.routes.routeTableMeta(meta)
+		}
+		if w.singleFile != nil {
+			// the data files are spooled, and concatenated into the single file in order after all the tasks are done
+			extStorage := w.extStorage
+			w.extStorage = w.singleFile.chunkStorage(t)
+			defer func() { w.extStorage = extStorage }()
+		}
+		err := w.WriteTableData(meta, t.Data, t.ChunkIndex)
 		if err != nil {
+			if w.singleFile != nil {
+				w.singleFile.discardTable(t)
+			}
 			return err
 		}
-		if t.ChunkIndex+1 == t.TotalChunks {
+		// the chunks are numbered within each partition, so only the last partition finishes the table
+		if p, ok := t.Meta.(*partitionMeta); t.ChunkIndex+1 == t.TotalChunks && (!ok || p.last) {
 			w.finishTableCallBack(task)
 		}
 		return nil
@@ -121,7 +157,8 @@ func (w *Writer) WriteDatabaseMeta(db, createSQL string) error {
 	if err != nil {
 		return err
 	}
-	return writeMetaToFile(tctx, db, createSQL, w.extStorage, fileName+".sql", conf.CompressType)
+	w.addSchemaFile(db, "", fileName+".sql")
+	return writeMetaToFile(tctx, db, createSQL, metaSpecialComments(conf), w.extStorage, fileName+".sql", conf.CompressType)
 }
 
 // WriteTableMeta writes table meta to a file
@@ -131,7 +168,8 @@ func (w *Writer) WriteTableMeta(db, table, createSQL string) error {
 	if err != nil {
 		return err
 	}
-	return writeMetaToFile(tctx, db, createSQL, w.extStorage, fileName+".sql", conf.CompressType)
+	w.addSchemaFile(db, table, fileName+".sql")
+	return writeMetaToFile(tctx, db, createSQL, metaSpecialComments(conf), w.extStorage, fileName+".sql", conf.CompressType)
 }
 
 // WriteViewMeta writes view meta to a file
@@ -145,11 +183,21 @@ func (w *Writer) WriteViewMeta(db, view, createTableSQL, createViewSQL string) e
 	if err != nil {
 		return err
 	}
-	err = writeMetaToFile(tctx, db, createTableSQL, w.extStorage, fileNameTable+".sql", conf.CompressType)
+	w.addSchemaFile(db, view, fileNameTable+".sql")
+	w.addSchemaFile(db, view, fileNameView+".sql")
+	err = writeMetaToFile(tctx, db, createTableSQL, metaSpecialComments(conf), w.extStorage, fileNameTable+".sql", conf.CompressType)
 	if err != nil {
 		return err
 	}
-	return writeMetaToFile(tctx, db, createViewSQL, w.extStorage, fileNameView+".sql", conf.CompressType)
+	return writeMetaToFile(tctx, db, createViewSQL, metaSpecialComments(conf), w.extStorage, fileNameView+".sql", conf.CompressType)
+}
+
+// addSchemaFile records the schema file with --continue-on-error, so it's removed if the table or database fails later.
+// The schema files routed by --route are shared by the source tables, so they're kept
+func (w *Writer) addSchemaFile(db, table, name string) {
+	if w.failures != nil && w.singleFile == nil && w.routes == nil {
+		w.failures.addTableFile(db, table, name)
+	}
 }
 
 // WriteTableData writes table data to a file with retry
@@ -167,8 +215,9 @@ func (w *Writer) WriteTableData(meta TableMeta, ir TableDataIR, currentChunk int
 		retryTime++
 		tctx.L().Debug("trying to dump table chunk", zap.Int("retryTime", retryTime), zap.String("db", meta.DatabaseName()),
 			zap.String("table", meta.TableName()), zap.Int("chunkIndex", currentChunk), zap.NamedError("lastError", lastErr))
-		// don't rebuild connection when dump for the first time
-		if retryTime > 1 {
+		// don't rebuild connection when dump for the first time.
+		// the connection is still fine if the last failure is from storage, query the chunk again on the same snapshot
+		if retryTime > 1 && !isWriterError(lastErr) {
 			conn, err = w.rebuildConnFn(conn)
 			w.conn = conn
 			if err != nil {
@@ -186,8 +235,20 @@ func (w *Writer) WriteTableData(meta TableMeta, ir TableDataIR, currentChunk int
 			}
 		}
 		defer ir.Close()
+		if w.sqlite != nil {
+			return w.sqlite.writeTableData(tctx, conf, meta, ir)
+		}
 		return w.tryToWriteTableData(tctx, meta, ir, currentChunk)
-	}, newDumpChunkBackoffer(canRebuildConn(conf.Consistency, conf.TransactionalConsistency)))
+	}, w.newDumpChunkBackoffer())
+}
+
+func (w *Writer) newDumpChunkBackoffer() *dumpChunkBackoffer {
+	conf := w.conf
+	// the rows committed into SQLite can't be rolled back, so the chunk isn't retried
+	if !canRewriteFile(w.extStorage) || w.sqlite != nil {
+		return newDumpChunkBackoffer(false, nil)
+	}
+	return newDumpChunkBackoffer(canRebuildConn(conf.Consistency, conf.TransactionalConsistency), newWriteRetryPolicy(conf))
 }
 
 func (w *Writer) tryToWriteTableData(tctx *tcontext.Context, meta TableMeta, ir TableDataIR, curChkIdx int) error {
@@ -198,17 +259,28 @@ func (w *Writer) tryToWriteTableData(tctx *tcontext.Context, meta TableMeta, ir
 		return err
 	}
 
+	var files []string
 	for {
 		fileWriter, tearDown := buildInterceptFileWriter(tctx, w.extStorage, fileName, conf.CompressType)
 		err = format.WriteInsert(tctx, conf, meta, ir, fileWriter)
-		tearDown(tctx)
+		// the data may be flushed or uploaded on closing, so the closing error fails the file too
+		if err1 := tearDown(tctx); err == nil {
+			err = err1
+		}
+		written := true
+		if interceptWriter, ok := fileWriter.(*InterceptFileWriter); ok && !interceptWriter.SomethingIsWritten {
+			written = false
+		}
+		if (written || err != nil) && w.failures != nil && w.singleFile == nil {
+			w.failures.addFile(meta, fileName)
+		}
 		if err != nil {
 			return err
 		}
-
-		if w, ok := fileWriter.(*InterceptFileWriter); ok && !w.SomethingIsWritten {
+		if !written {
 			break
 		}
+		files = append(files, fileName)
 
 		if conf.FileSize == UnspecifiedSize {
 			break
@@ -218,23 +290,35 @@ func (w *Writer) tryToWriteTableData(tctx *tcontext.Context, meta TableMeta, ir
 			return err
 		}
 	}
+	if w.loadData != nil {
+		w.loadData.addFiles(meta, files)
+	}
 	return nil
 }
 
-func writeMetaToFile(tctx *tcontext.Context, target, metaSQL string, s storage.ExternalStorage, path string, compressType storage.CompressType) error {
+func writeMetaToFile(tctx *tcontext.Context, target, metaSQL string, specCmts []string, s storage.ExternalStorage, path string, compressType storage.CompressType) error {
 	fileWriter, tearDown, err := buildFileWriter(tctx, s, path, compressType)
 	if err != nil {
 		return errors.Trace(err)
 	}
-	defer tearDown(tctx)
-
-	return WriteMeta(tctx, &metaData{
-		target:  target,
-		metaSQL: metaSQL,
-		specCmts: []string{
-			"/*!40101 SET NAMES binary*/;",
-		},
+	err = WriteMeta(tctx, &metaData{
+		target:   target,
+		metaSQL:  metaSQL,
+		specCmts: specCmts,
 	}, fileWriter)
+	// the file is only published when it's closed, so the close error can't be ignored
+	if err1 := tearDown(tctx); err == nil {
+		err = err1
+	}
+	return err
+}
+
+// metaSpecialComments returns the special comments at the head of the schema files
+func metaSpecialComments(conf *Config) []string {
+	if conf.postgresDialect() {
+		return postgresSpecialComments()
+	}
+	return []string{"/*!40101 SET NAMES binary*/;"}
 }
 
 type outputFileNamer struct {
@@ -242,7 +326,11 @@ type outputFileNamer struct {
 	FileIndex  int
 	DB         string
 	Table      string
-	format     string
+	// Partition is the partition name of the data file, it's empty if the table isn't dumped by partitions
+	Partition string
+	// sourceIndex is the sourceIndex of the routed table, which is prepended to the index of the data files
+	sourceIndex int
+	format      string
 }
 
 type csvOption struct {
@@ -256,6 +344,13 @@ func newOutputFileNamer(meta TableMeta, chunkIdx int, rows, fileSize bool) *outp
 		DB:    meta.DatabaseName(),
 		Table: meta.TableName(),
 	}
+	if r, ok := meta.(*routedTableMeta); ok {
+		o.sourceIndex = r.sourceIndex
+		meta = r.TableMeta
+	}
+	if p, ok := meta.(partitionNameProvider); ok {
+		o.Partition = p.PartitionName()
+	}
 	o.ChunkIndex = chunkIdx
 	o.FileIndex = 0
 	switch {
@@ -278,6 +373,9 @@ func (namer *outputFileNamer) render(tmpl *template.Template, subName string) (s
 }
 
 func (namer *outputFileNamer) Index() string {
+	if namer.sourceIndex > 0 {
+		return fmt.Sprintf("%04d", namer.sourceIndex) + fmt.Sprintf(namer.format, namer.ChunkIndex, namer.FileIndex)
+	}
 	return fmt.Sprintf(namer.format, namer.ChunkIndex, namer.FileIndex)
 }
 
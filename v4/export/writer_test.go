@@ -4,10 +4,13 @@ package export
 
 import (
 	"context"
+	"database/sql"
 	"database/sql/driver"
 	"io/ioutil"
 	"os"
 	"path"
+	"syscall"
+	"time"
 
 	tcontext "github.com/pingcap/dumpling/v4/context"
 
@@ -329,3 +332,79 @@ func (s *testWriterSuite) TestWriteTableDataWithStatementSize(c *C) {
 		c.Assert(string(bytes), Equals, expected)
 	}
 }
+
+// restartableTableIR queries the data again when it's restarted, like the real TableDataIR does
+type restartableTableIR struct {
+	*mockTableIR
+}
+
+func (m *restartableTableIR) Start(_ context.Context, _ *sql.Conn) error {
+	m.SQLRowIter = nil
+	return nil
+}
+
+// failingStorage fails to close the first failCount files it creates
+type failingStorage struct {
+	storage.ExternalStorage
+	failCount int
+	created   int
+}
+
+func (s *failingStorage) Create(ctx context.Context, name string) (storage.ExternalFileWriter, error) {
+	s.created++
+	w, err := s.ExternalStorage.Create(ctx, name)
+	if err != nil || s.failCount <= 0 {
+		return w, err
+	}
+	s.failCount--
+	return &failingFileWriter{ExternalFileWriter: w, name: name}, nil
+}
+
+type failingFileWriter struct {
+	storage.ExternalFileWriter
+	name string
+}
+
+func (w *failingFileWriter) Close(ctx context.Context) error {
+	_ = w.ExternalFileWriter.Close(ctx)
+	return &os.PathError{Op: "close", Path: w.name, Err: syscall.ENOSPC}
+}
+
+func (s *testWriterSuite) TestWriteTableDataRetry(c *C) {
+	dir := c.MkDir()
+
+	config := defaultConfigForTest(c)
+	config.OutputDirPath = dir
+	config.WriteRetryBackoff = time.Millisecond
+	config.WriteRetryMaxBackoff = time.Millisecond
+
+	writer := s.newWriter(config, c)
+	extStore := &failingStorage{ExternalStorage: writer.extStorage, failCount: 2}
+	writer.extStorage = extStore
+
+	data := [][]driver.Value{
+		{"1", "male", "bob@mail.com", "020-1234", nil},
+		{"2", "female", "sarah@mail.com", "020-1253", "healthy"},
+	}
+	colTypes := []string{"INT", "SET", "VARCHAR", "VARCHAR", "TEXT"}
+	tableIR := &restartableTableIR{newMockTableIR("test", "employee", data, nil, colTypes)}
+	c.Assert(writer.WriteTableData(tableIR, tableIR, 0), IsNil)
+	c.Assert(extStore.created, Equals, 3)
+
+	bytes, err := ioutil.ReadFile(path.Join(dir, "test.employee.000000000.sql"))
+	c.Assert(err, IsNil)
+	c.Assert(string(bytes), Equals, "INSERT INTO `employee` VALUES\n"+
+		"(1,'male','bob@mail.com','020-1234',NULL),\n"+
+		"(2,'female','sarah@mail.com','020-1253','healthy');\n")
+
+	// the attempts are exhausted
+	extStore.failCount, extStore.created = 5, 0
+	c.Assert(writer.WriteTableData(tableIR, tableIR, 0), ErrorMatches, ".*no space left on device.*")
+	c.Assert(extStore.created, Equals, 3)
+
+	// the error class isn't retryable
+	config.WriteRetryErrors = []string{writeRetryErrorNetwork}
+	extStore.failCount, extStore.created = 5, 0
+	c.Assert(writer.WriteTableData(tableIR, tableIR, 0), NotNil)
+	c.Assert(extStore.created, Equals, 1)
+}
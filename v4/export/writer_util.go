@@ -168,30 +168,23 @@ func WriteInsert(pCtx *tcontext.Context, cfg *Config, meta TableMeta, tblIR Tabl
 	wp.currentFileSize += uint64(bf.Len())
 
 	var (
-		insertStatementPrefix string
-		row                   = MakeRowReceiver(meta.ColumnTypes())
-		counter               uint64
-		lastCounter           uint64
-		escapeBackslash       = cfg.EscapeBackslash
-		err                   error
+		row             = MakeRowReceiver(meta.ColumnTypes())
+		counter         uint64
+		lastCounter     uint64
+		escapeBackslash = cfg.EscapeBackslash
+		postgres        = cfg.postgresDialect()
+		err             error
 	)
 
 	selectedField := meta.SelectedField()
-
-	// if has generated column
-	if selectedField != "" && selectedField != "*" {
-		insertStatementPrefix = fmt.Sprintf("INSERT INTO %s %s VALUES\n",
-			wrapBackTicks(escapeString(meta.TableName())), selectedField)
-	} else {
-		insertStatementPrefix = fmt.Sprintf("INSERT INTO %s VALUES\n",
-			wrapBackTicks(escapeString(meta.TableName())))
-	}
-	insertStatementPrefixLen := uint64(len(insertStatementPrefix))
+	insertStatementPrefix, insertStatementSuffix := insertStatementPrefixAndSuffix(cfg, meta)
+	// the suffix is counted at the beginning of the statement, so the statement size includes it before it's written
+	insertStatementAffixLen := uint64(len(insertStatementPrefix) + len(insertStatementSuffix))
 
 	for fileRowIter.HasNext() {
 		wp.currentStatementSize = 0
 		bf.WriteString(insertStatementPrefix)
-		wp.AddFileSize(insertStatementPrefixLen)
+		wp.AddFileSize(insertStatementAffixLen)
 
 		for fileRowIter.HasNext() {
 			lastBfSize := bf.Len()
@@ -200,7 +193,11 @@ func WriteInsert(pCtx *tcontext.Context, cfg *Config, meta TableMeta, tblIR Tabl
 					pCtx.L().Error("fail to scan from sql.Row", zap.Error(err))
 					return errors.Trace(err)
 				}
-				row.WriteToBuffer(bf, escapeBackslash)
+				if postgres {
+					row.WriteToBufferInPostgres(bf)
+				} else {
+					row.WriteToBuffer(bf, escapeBackslash)
+				}
 			} else {
 				bf.WriteString("()")
 			}
@@ -215,6 +212,7 @@ func WriteInsert(pCtx *tcontext.Context, cfg *Config, meta TableMeta, tblIR Tabl
 			if fileRowIter.HasNext() && !shouldSwitch {
 				bf.WriteString(",\n")
 			} else {
+				bf.WriteString(insertStatementSuffix)
 				bf.WriteString(";\n")
 			}
 			if bf.Len() >= lengthLimit {
@@ -259,6 +257,68 @@ func WriteInsert(pCtx *tcontext.Context, cfg *Config, meta TableMeta, tblIR Tabl
 	return wp.Error()
 }
 
+const (
+	// insertModeInsert writes INSERT statements, which fail on the duplicate keys
+	insertModeInsert = "insert"
+	// insertModeReplace writes REPLACE statements, which replace the rows with the duplicate keys
+	insertModeReplace = "replace"
+	// insertModeIgnore writes INSERT IGNORE statements, which skip the rows with the duplicate keys
+	insertModeIgnore = "ignore"
+	// insertModeOnDuplicateUpdate writes INSERT ... ON DUPLICATE KEY UPDATE statements, which update the rows with the duplicate keys
+	insertModeOnDuplicateUpdate = "on-duplicate-update"
+)
+
+func validateInsertMode(conf *Config) error {
+	switch conf.InsertMode {
+	case "", insertModeInsert:
+		return nil
+	case insertModeReplace, insertModeIgnore, insertModeOnDuplicateUpdate:
+		if conf.FileType != FileFormatSQLTextString {
+			return errors.Errorf("--insert-mode %s only applies to --filetype sql", conf.InsertMode)
+		}
+		return nil
+	default:
+		return errors.Errorf("--insert-mode is set to '%s'. It should be one of {%s|%s|%s|%s}", conf.InsertMode,
+			insertModeInsert, insertModeReplace, insertModeIgnore, insertModeOnDuplicateUpdate)
+	}
+}
+
+// insertStatementPrefixAndSuffix returns the head of the INSERT statements before the rows, and the tail after the rows
+// before the semicolon, according to --insert-mode
+func insertStatementPrefixAndSuffix(cfg *Config, meta TableMeta) (string, string) {
+	if cfg.postgresDialect() {
+		prefix := fmt.Sprintf("INSERT INTO %s%s VALUES\n", postgresTableName(meta.DatabaseName(), meta.TableName()), postgresColumnList(meta))
+		if cfg.InsertMode == insertModeIgnore {
+			return prefix, "\nON CONFLICT DO NOTHING"
+		}
+		return prefix, ""
+	}
+	verb := "INSERT INTO"
+	switch cfg.InsertMode {
+	case insertModeReplace:
+		verb = "REPLACE INTO"
+	case insertModeIgnore:
+		verb = "INSERT IGNORE INTO"
+	}
+	table := wrapBackTicks(escapeString(meta.TableName()))
+	var prefix string
+	// if has generated column
+	if selectedField := meta.SelectedField(); selectedField != "" && selectedField != "*" {
+		prefix = fmt.Sprintf("%s %s %s VALUES\n", verb, table, selectedField)
+	} else {
+		prefix = fmt.Sprintf("%s %s VALUES\n", verb, table)
+	}
+	if cfg.InsertMode != insertModeOnDuplicateUpdate || len(meta.ColumnNames()) == 0 {
+		return prefix, ""
+	}
+	updates := make([]string, 0, len(meta.ColumnNames()))
+	for _, col := range meta.ColumnNames() {
+		name := wrapBackTicks(escapeString(col))
+		updates = append(updates, fmt.Sprintf("%s=VALUES(%s)", name, name))
+	}
+	return prefix, "\nON DUPLICATE KEY UPDATE " + strings.Join(updates, ",")
+}
+
 // WriteInsertInCsv writes TableDataIR to a storage.ExternalFileWriter in csv type
 func WriteInsertInCsv(pCtx *tcontext.Context, cfg *Config, meta TableMeta, tblIR TableDataIR, w storage.ExternalFileWriter) error {
 	fileRowIter := tblIR.Rows()
@@ -366,6 +426,102 @@ func WriteInsertInCsv(pCtx *tcontext.Context, cfg *Config, meta TableMeta, tblIR
 	return wp.Error()
 }
 
+// WriteInsertInPostgresCopy writes TableDataIR to a storage.ExternalFileWriter in the COPY statements of PostgreSQL
+func WriteInsertInPostgresCopy(pCtx *tcontext.Context, cfg *Config, meta TableMeta, tblIR TableDataIR, w storage.ExternalFileWriter) error {
+	fileRowIter := tblIR.Rows()
+	if !fileRowIter.HasNext() {
+		return nil
+	}
+
+	bf := pool.Get().(*bytes.Buffer)
+	if bfCap := bf.Cap(); bfCap < lengthLimit {
+		bf.Grow(lengthLimit - bfCap)
+	}
+
+	wp := newWriterPipe(w, cfg.FileSize, UnspecifiedSize, cfg.Labels)
+
+	// use context.Background here to make sure writerPipe can deplete all the chunks in pipeline
+	ctx, cancel := tcontext.Background().WithLogger(pCtx.L()).WithCancel()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		wp.Run(ctx)
+		wg.Done()
+	}()
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	specCmtIter := meta.SpecialComments()
+	for specCmtIter.HasNext() {
+		bf.WriteString(specCmtIter.Next())
+		bf.WriteByte('\n')
+	}
+	fmt.Fprintf(bf, "COPY %s%s FROM stdin;\n", postgresTableName(meta.DatabaseName(), meta.TableName()), postgresColumnList(meta))
+	wp.currentFileSize += uint64(bf.Len()) + uint64(len(copyEndOfData))
+
+	var (
+		row            = MakeRowReceiver(meta.ColumnTypes())
+		counter        uint64
+		lastCounter    uint64
+		selectedFields = meta.SelectedField()
+		err            error
+	)
+
+	for fileRowIter.HasNext() {
+		lastBfSize := bf.Len()
+		if selectedFields != "" {
+			if err = fileRowIter.Decode(row); err != nil {
+				pCtx.L().Error("fail to scan from sql.Row", zap.Error(err))
+				return errors.Trace(err)
+			}
+			row.WriteToBufferInCopy(bf)
+		}
+		counter++
+		wp.currentFileSize += uint64(bf.Len()-lastBfSize) + 1 // 1 is for "\n"
+
+		bf.WriteByte('\n')
+		if bf.Len() >= lengthLimit {
+			select {
+			case <-pCtx.Done():
+				return pCtx.Err()
+			case err = <-wp.errCh:
+				return err
+			case wp.input <- bf:
+				bf = pool.Get().(*bytes.Buffer)
+				if bfCap := bf.Cap(); bfCap < lengthLimit {
+					bf.Grow(lengthLimit - bfCap)
+				}
+				AddCounter(finishedRowsCounter, cfg.Labels, float64(counter-lastCounter))
+				lastCounter = counter
+			}
+		}
+
+		fileRowIter.Next()
+		if wp.ShouldSwitchFile() {
+			break
+		}
+	}
+	// every file is a complete COPY statement, so that the files can be loaded in parallel
+	bf.WriteString(copyEndOfData)
+
+	pCtx.L().Debug("finish dumping table(chunk)",
+		zap.String("database", meta.DatabaseName()),
+		zap.String("table", meta.TableName()),
+		zap.Uint64("total rows", counter))
+	wp.input <- bf
+	close(wp.input)
+	<-wp.closed
+	summary.CollectSuccessUnit(summary.TotalBytes, 1, wp.finishedFileSize)
+	summary.CollectSuccessUnit("total rows", 1, counter)
+	AddCounter(finishedRowsCounter, cfg.Labels, float64(counter-lastCounter))
+	if err = fileRowIter.Error(); err != nil {
+		return errors.Trace(err)
+	}
+	return wp.Error()
+}
+
 func write(tctx *tcontext.Context, writer storage.ExternalFileWriter, str string) error {
 	_, err := writer.Write(tctx, []byte(str))
 	if err != nil {
@@ -397,7 +553,7 @@ func writeBytes(tctx *tcontext.Context, writer storage.ExternalFileWriter, p []b
 	return errors.Trace(err)
 }
 
-func buildFileWriter(tctx *tcontext.Context, s storage.ExternalStorage, fileName string, compressType storage.CompressType) (storage.ExternalFileWriter, func(ctx context.Context), error) {
+func buildFileWriter(tctx *tcontext.Context, s storage.ExternalStorage, fileName string, compressType storage.CompressType) (storage.ExternalFileWriter, func(ctx context.Context) error, error) {
 	fileName += compressFileSuffix(compressType)
 	fullPath := path.Join(s.URI(), fileName)
 	writer, err := storage.WithCompression(s, compressType).Create(tctx, fileName)
@@ -408,20 +564,21 @@ func buildFileWriter(tctx *tcontext.Context, s storage.ExternalStorage, fileName
 		return nil, nil, errors.Trace(err)
 	}
 	tctx.L().Debug("opened file", zap.String("path", fullPath))
-	tearDownRoutine := func(ctx context.Context) {
+	tearDownRoutine := func(ctx context.Context) error {
 		err := writer.Close(ctx)
 		if err == nil {
-			return
+			return nil
 		}
 		err = errors.Trace(err)
 		tctx.L().Error("close file failed",
 			zap.String("path", fullPath),
 			zap.Error(err))
+		return err
 	}
 	return writer, tearDownRoutine, nil
 }
 
-func buildInterceptFileWriter(pCtx *tcontext.Context, s storage.ExternalStorage, fileName string, compressType storage.CompressType) (storage.ExternalFileWriter, func(context.Context)) {
+func buildInterceptFileWriter(pCtx *tcontext.Context, s storage.ExternalStorage, fileName string, compressType storage.CompressType) (storage.ExternalFileWriter, func(context.Context) error) {
 	fileName += compressFileSuffix(compressType)
 	var writer storage.ExternalFileWriter
 	fullPath := path.Join(s.URI(), fileName)
@@ -443,9 +600,9 @@ func buildInterceptFileWriter(pCtx *tcontext.Context, s storage.ExternalStorage,
 	}
 	fileWriter.initRoutine = initRoutine
 
-	tearDownRoutine := func(ctx context.Context) {
+	tearDownRoutine := func(ctx context.Context) error {
 		if writer == nil {
-			return
+			return nil
 		}
 		pCtx.L().Debug("tear down lazy file writer...", zap.String("path", fullPath))
 		err := writer.Close(ctx)
@@ -454,6 +611,7 @@ func buildInterceptFileWriter(pCtx *tcontext.Context, s storage.ExternalStorage,
 				zap.String("path", fullPath),
 				zap.Error(err))
 		}
+		return newWriterError(err)
 	}
 	return fileWriter, tearDownRoutine
 }
@@ -484,6 +642,11 @@ func (e *writerError) Error() string {
 	return e.error.Error()
 }
 
+func isWriterError(err error) bool {
+	_, ok := errors.Cause(err).(*writerError)
+	return ok
+}
+
 func newWriterError(err error) error {
 	if err == nil {
 		return nil
@@ -552,6 +715,8 @@ const (
 	FileFormatSQLText
 	// FileFormatCSV indicates the given file type is csv type
 	FileFormatCSV
+	// FileFormatPostgresCopy indicates the given file type is the sql type with the COPY statements of PostgreSQL
+	FileFormatPostgresCopy
 )
 
 const (
@@ -559,6 +724,13 @@ const (
 	FileFormatSQLTextString = "sql"
 	// FileFormatCSVString indicates the string/suffix of csv type file
 	FileFormatCSVString = "csv"
+	// FileFormatPostgresCopyString indicates the string of the sql type file with the COPY statements of PostgreSQL
+	FileFormatPostgresCopyString = "copy"
+	// FileFormatSQLiteString indicates the whole dump is written into one SQLite database instead of the files
+	FileFormatSQLiteString = "sqlite"
+
+	// copyEndOfData ends the data of the COPY statement
+	copyEndOfData = "\\.\n"
 )
 
 // String implement Stringer.String method.
@@ -568,6 +740,8 @@ func (f FileFormat) String() string {
 		return strings.ToUpper(FileFormatSQLTextString)
 	case FileFormatCSV:
 		return strings.ToUpper(FileFormatCSVString)
+	case FileFormatPostgresCopy:
+		return strings.ToUpper(FileFormatPostgresCopyString)
 	default:
 		return "unknown"
 	}
@@ -576,9 +750,10 @@ func (f FileFormat) String() string {
 // Extension returns the extension for specific format.
 //  text -> "sql"
 //  csv  -> "csv"
+//  copy -> "sql"
 func (f FileFormat) Extension() string {
 	switch f {
-	case FileFormatSQLText:
+	case FileFormatSQLText, FileFormatPostgresCopy:
 		return FileFormatSQLTextString
 	case FileFormatCSV:
 		return FileFormatCSVString
@@ -594,6 +769,8 @@ func (f FileFormat) WriteInsert(pCtx *tcontext.Context, cfg *Config, meta TableM
 		return WriteInsert(pCtx, cfg, meta, tblIR, w)
 	case FileFormatCSV:
 		return WriteInsertInCsv(pCtx, cfg, meta, tblIR, w)
+	case FileFormatPostgresCopy:
+		return WriteInsertInPostgresCopy(pCtx, cfg, meta, tblIR, w)
 	default:
 		return errors.Errorf("unknown file format")
 	}
@@ -107,6 +107,69 @@ func (s *testUtilSuite) TestWriteInsertReturnsError(c *C) {
 	c.Assert(bf.String(), Equals, expected)
 }
 
+func (s *testUtilSuite) TestWriteInsertModes(c *C) {
+	data := [][]driver.Value{
+		{"1", "bob"},
+		{"2", "sarah"},
+		{"3", "john"},
+	}
+	colTypes := []string{"INT", "VARCHAR"}
+	cases := []struct {
+		mode     string
+		expected string
+	}{
+		{
+			insertModeReplace,
+			"REPLACE INTO `employee` VALUES\n(1,'bob'),\n(2,'sarah'),\n(3,'john');\n",
+		},
+		{
+			insertModeIgnore,
+			"INSERT IGNORE INTO `employee` VALUES\n(1,'bob'),\n(2,'sarah'),\n(3,'john');\n",
+		},
+		{
+			insertModeOnDuplicateUpdate,
+			"INSERT INTO `employee` VALUES\n(1,'bob'),\n(2,'sarah'),\n(3,'john')\n" +
+				"ON DUPLICATE KEY UPDATE `id`=VALUES(`id`),`na``me`=VALUES(`na``me`);\n",
+		},
+	}
+	for _, ca := range cases {
+		tableIR := newMockTableIR("test", "employee", data, nil, colTypes)
+		tableIR.colNames = []string{"id", "na`me"}
+		bf := storage.NewBufferWriter()
+		conf := configForWriteSQL(UnspecifiedSize, UnspecifiedSize)
+		conf.InsertMode = ca.mode
+		c.Assert(WriteInsert(tcontext.Background(), conf, tableIR, tableIR, bf), IsNil)
+		c.Assert(bf.String(), Equals, ca.expected)
+	}
+
+	// the statement size includes the prefix and the suffix, the first statement is switched after the first row
+	// with the long suffix but after the second row without it
+	prefix := "INSERT INTO `employee` VALUES\n"
+	suffix := "\nON DUPLICATE KEY UPDATE `id`=VALUES(`id`),`na``me`=VALUES(`na``me`)"
+	rowSize := uint64(len("(1,'bob')") + 2)
+	for _, mode := range []string{insertModeInsert, insertModeOnDuplicateUpdate} {
+		tableIR := newMockTableIR("test", "employee", data, nil, colTypes)
+		tableIR.colNames = []string{"id", "na`me"}
+		bf := storage.NewBufferWriter()
+		conf := configForWriteSQL(UnspecifiedSize, uint64(len(prefix))+rowSize*2)
+		conf.InsertMode = mode
+		c.Assert(WriteInsert(tcontext.Background(), conf, tableIR, tableIR, bf), IsNil)
+		if mode == insertModeInsert {
+			c.Assert(bf.String(), Equals, prefix+"(1,'bob'),\n(2,'sarah');\n"+prefix+"(3,'john');\n")
+		} else {
+			c.Assert(bf.String(), Equals, prefix+"(1,'bob')"+suffix+";\n"+prefix+"(2,'sarah')"+suffix+";\n"+prefix+"(3,'john')"+suffix+";\n")
+		}
+	}
+
+	conf := defaultConfigForTest(c)
+	c.Assert(validateInsertMode(conf), IsNil)
+	conf.InsertMode = "upsert"
+	c.Assert(validateInsertMode(conf), ErrorMatches, "--insert-mode is set to 'upsert'.*")
+	conf.InsertMode = insertModeReplace
+	conf.FileType = FileFormatCSVString
+	c.Assert(validateInsertMode(conf), ErrorMatches, "--insert-mode replace only applies to --filetype sql")
+}
+
 func (s *testUtilSuite) TestWriteInsertInCsv(c *C) {
 	data := [][]driver.Value{
 		{"1", "male", "bob@mail.com", "020-1234", nil},
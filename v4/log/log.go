@@ -3,9 +3,12 @@
 package log
 
 import (
+	"os"
+
 	"github.com/pingcap/errors"
 	pclog "github.com/pingcap/log"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 var appLogger = Logger{zap.NewNop()}
@@ -35,11 +38,13 @@ type Config struct {
 	FileMaxBackups int `toml:"max-backups" json:"max-backups"`
 	// Format of the log, one of `text`, `json` or `console`.
 	Format string `toml:"format" json:"format"`
+	// Stderr writes the console log to stderr instead of stdout, used when stdout is occupied by the dumped data.
+	Stderr bool `toml:"stderr" json:"stderr"`
 }
 
 // InitAppLogger inits the wrapped logger from config.
 func InitAppLogger(cfg *Config) (Logger, error) {
-	logger, _, err := pclog.InitLogger(&pclog.Config{
+	pcCfg := &pclog.Config{
 		Level: cfg.Level,
 		File: pclog.FileLogConfig{
 			Filename:   cfg.File,
@@ -48,7 +53,16 @@ func InitAppLogger(cfg *Config) (Logger, error) {
 			MaxBackups: cfg.FileMaxBackups,
 		},
 		Format: cfg.Format,
-	})
+	}
+	var (
+		logger *zap.Logger
+		err    error
+	)
+	if len(cfg.File) == 0 && cfg.Stderr {
+		logger, _, err = pclog.InitLoggerWithWriteSyncer(pcCfg, zapcore.Lock(os.Stderr))
+	} else {
+		logger, _, err = pclog.InitLogger(pcCfg)
+	}
 	if err != nil {
 		return appLogger, errors.Trace(err)
 	}